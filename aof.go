@@ -0,0 +1,912 @@
+package cmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/fwhezfwhez/errorx"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FsyncPolicy decides when appended records are flushed to disk, like redis appendfsync.
+type FsyncPolicy int
+
+const (
+	FSYNC_EVERYSEC FsyncPolicy = 0 // fsync once a second, at most one second of writes is lost on power failure
+	FSYNC_ALWAYS   FsyncPolicy = 1 // fsync after every write, slowest and safest
+	FSYNC_NEVER    FsyncPolicy = 2 // never fsync, leave it to operating system
+)
+
+// AOF file layout:
+//
+//	"CAOF" | version(uint16, big endian)
+//	record: length(uvarint) | crc32(uint32, big endian, IEEE, of payload) | payload
+//	...
+//
+// payload is op(byte) | key | arguments, arguments are encoded the same as snapshot entries:
+//
+//	aofOpSet:   exp(varint, unixnano, -1 means no time limit) | kind(byte) | value
+//	aofOpDel:   nothing
+//	aofOpRPush: count | elements
+//	aofOpLPop:  nothing
+//	aofOpLPush: count | elements
+//	aofOpRPop:  nothing
+//	aofOpHSet:  count | field-values
+//	aofOpHDel:  count | fields
+//	aofOpSAdd:  count | members
+//	aofOpSRem:  count | members
+//	aofOpZAdd:  count | members with scores
+//	aofOpZRem:  count | members
+//	aofOpDelayPush: count | items with due time
+//	aofOpDelayPop:  nothing
+//
+// Records log the result of a write rather than the command, so Incr replays to the same value.
+// A compound value made by a write is logged whole by aofOpSet, later changes of it are logged by ops,
+// so an op meeting no value on replay belongs to a value expired since, and is skipped. Rewrite logs values whole.
+const (
+	aofMagic   = "CAOF"
+	aofVersion = 1
+
+	aofOpSet   = 1
+	aofOpDel   = 2
+	aofOpRPush = 3
+	aofOpLPop  = 4
+	aofOpLPush = 5
+	aofOpRPop  = 6
+
+	aofOpHSet      = 7
+	aofOpHDel      = 8
+	aofOpSAdd      = 9
+	aofOpSRem      = 10
+	aofOpZAdd      = 11
+	aofOpZRem      = 12
+	aofOpDelayPush = 13
+	aofOpDelayPop  = 14
+
+	// writes of a key are serialized by one of stripes, so records of a key are appended in order
+	aofStripes = 64
+)
+
+// AOFOption configures an AOF when calling OpenAOF.
+type AOFOption func(*AOF)
+
+// WithAOFAutoRewrite rewrites the log in background when it grows by percentage since last rewrite
+// and is bigger than minSize bytes, like redis auto-aof-rewrite-percentage and auto-aof-rewrite-min-size.
+// percentage <= 0 disables auto rewriting, which is the default.
+func WithAOFAutoRewrite(percentage int, minSize int64) AOFOption {
+	return func(a *AOF) {
+		a.rewritePercentage = percentage
+		a.rewriteMinSize = minSize
+	}
+}
+
+// AOF is an append-only log of writes of a Map or MapV2.
+//
+//	aof, e := cmap.OpenAOF("cmap.aof", cmap.FSYNC_EVERYSEC)
+//	m := cmap.NewMap()
+//	e = m.UseAOF(aof) // replay then log
+//	defer aof.Close()
+type AOF struct {
+	path   string
+	policy FsyncPolicy
+
+	rewritePercentage int
+	rewriteMinSize    int64
+
+	stripes [aofStripes]sync.Mutex
+
+	// protects f, size, dirty, rewriteBuf and err
+	l *sync.Mutex
+	f *os.File
+	// bytes of file, and of file after last rewrite
+	size     int64
+	baseSize int64
+	// appended but not fsynced
+	dirty bool
+	// records appended while rewriting, they are appended to the new file before it replaces the old one
+	rewriteBuf *bytes.Buffer
+	// first write error, writes after it are dropped
+	err error
+
+	// serializes rewriting
+	rewritel *sync.Mutex
+	// 1 when a background rewrite is running
+	rewriting int32
+
+	codec Codec
+	maps  []*Map
+
+	closeOnce *sync.Once
+	closed    chan struct{}
+	stopped   chan struct{}
+}
+
+// OpenAOF opens or creates the log at path.
+// A background goroutine fsyncs the log and checks auto rewriting every second until Close.
+func OpenAOF(path string, policy FsyncPolicy, opts ...AOFOption) (*AOF, error) {
+	f, e := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if e != nil {
+		return nil, errorx.Wrap(e)
+	}
+
+	info, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, errorx.Wrap(e)
+	}
+
+	a := &AOF{
+		path:      path,
+		policy:    policy,
+		l:         &sync.Mutex{},
+		f:         f,
+		size:      info.Size(),
+		baseSize:  info.Size(),
+		rewritel:  &sync.Mutex{},
+		codec:     GobCodec{},
+		closeOnce: &sync.Once{},
+		closed:    make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if a.size == 0 {
+		if e := a.writeHeader(f); e != nil {
+			f.Close()
+			return nil, errorx.Wrap(e)
+		}
+		a.size = int64(len(aofMagic) + 2)
+		a.baseSize = a.size
+	}
+
+	go a.daemon()
+	return a, nil
+}
+
+func (a *AOF) writeHeader(f *os.File) error {
+	_, e := f.Write([]byte{aofMagic[0], aofMagic[1], aofMagic[2], aofMagic[3], aofVersion >> 8, aofVersion & 0xff})
+	if e != nil {
+		return errorx.Wrap(e)
+	}
+	return f.Sync()
+}
+
+// Err returns the first error of appending, records after it are dropped.
+func (a *AOF) Err() error {
+	a.l.Lock()
+	defer a.l.Unlock()
+	return a.err
+}
+
+// Size returns bytes of the log.
+func (a *AOF) Size() int64 {
+	a.l.Lock()
+	defer a.l.Unlock()
+	return a.size
+}
+
+// Close stops the background goroutine, fsyncs and closes the log.
+func (a *AOF) Close() error {
+	var e error
+	a.closeOnce.Do(func() {
+		close(a.closed)
+		<-a.stopped
+
+		// wait for running rewrite
+		a.rewritel.Lock()
+		defer a.rewritel.Unlock()
+
+		a.l.Lock()
+		defer a.l.Unlock()
+
+		if err := a.f.Sync(); err != nil {
+			e = errorx.Wrap(err)
+		}
+		if err := a.f.Close(); err != nil && e == nil {
+			e = errorx.Wrap(err)
+		}
+		if a.err == nil {
+			a.err = errorx.NewFromStringf("aof '%s' is closed", a.path)
+		}
+	})
+	return e
+}
+
+func (a *AOF) daemon() {
+	defer close(a.stopped)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.closed:
+			return
+		case <-ticker.C:
+			if a.policy == FSYNC_EVERYSEC {
+				a.sync()
+			}
+			if a.shouldRewrite() && atomic.CompareAndSwapInt32(&a.rewriting, 0, 1) {
+				go func() {
+					defer atomic.StoreInt32(&a.rewriting, 0)
+					a.Rewrite()
+				}()
+			}
+		}
+	}
+}
+
+func (a *AOF) sync() {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if !a.dirty || a.err != nil {
+		return
+	}
+	if e := a.f.Sync(); e != nil {
+		a.err = errorx.Wrap(e)
+	}
+	a.dirty = false
+}
+
+func (a *AOF) shouldRewrite() bool {
+	if a.rewritePercentage <= 0 {
+		return false
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if a.err != nil || len(a.maps) == 0 || a.size < a.rewriteMinSize {
+		return false
+	}
+	return a.size-a.baseSize >= a.baseSize*int64(a.rewritePercentage)/100
+}
+
+// lockKey serializes writes of key, so records of a key are in the same order as they are applied
+func (a *AOF) lockKey(key string) func() {
+	l := &a.stripes[UsMBCRC16([]byte(key))%aofStripes]
+	l.Lock()
+	return l.Unlock
+}
+
+// frame makes a record of payload
+func frame(payload []byte) []byte {
+	var head [binary.MaxVarintLen64 + 4]byte
+	n := binary.PutUvarint(head[:], uint64(len(payload)))
+	binary.BigEndian.PutUint32(head[n:], crc32.ChecksumIEEE(payload))
+	return append(head[:n+4:n+4], payload...)
+}
+
+// append writes payload as a record
+func (a *AOF) append(payload []byte) {
+	record := frame(payload)
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if a.err != nil {
+		return
+	}
+	if _, e := a.f.Write(record); e != nil {
+		a.err = errorx.Wrap(e)
+		return
+	}
+	a.size += int64(len(record))
+	if a.rewriteBuf != nil {
+		a.rewriteBuf.Write(record)
+	}
+
+	if a.policy == FSYNC_ALWAYS {
+		if e := a.f.Sync(); e != nil {
+			a.err = errorx.Wrap(e)
+		}
+		return
+	}
+	a.dirty = true
+}
+
+func (a *AOF) setPayload(key string, exp int64, kind ValueKind, value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	sw := &snapshotWriter{w: &buf}
+	sw.write([]byte{aofOpSet})
+	sw.writeBytes([]byte(key))
+	sw.writeVarint(exp)
+	sw.write([]byte{byte(kind)})
+	if e := sw.writeValue(a.codec, key, kind, value); e != nil {
+		return nil, errorx.Wrap(e)
+	}
+	return buf.Bytes(), nil
+}
+
+func (a *AOF) delPayload(key string) []byte {
+	var buf bytes.Buffer
+	sw := &snapshotWriter{w: &buf}
+	sw.write([]byte{aofOpDel})
+	sw.writeBytes([]byte(key))
+	return buf.Bytes()
+}
+
+// pushPayload makes a record of aofOpRPush or aofOpLPush
+func (a *AOF) pushPayload(op byte, key string, elems []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	sw := &snapshotWriter{w: &buf}
+	sw.write([]byte{op})
+	sw.writeBytes([]byte(key))
+	if e := sw.writeElems(a.codec, elems); e != nil {
+		return nil, errorx.Wrap(e)
+	}
+	return buf.Bytes(), nil
+}
+
+// opRecord is a record of an op changing a compound value, args writes arguments of op
+type opRecord struct {
+	op   byte
+	args func(sw *snapshotWriter, codec Codec) error
+}
+
+func fieldsRecord(op byte, fields map[string]interface{}) *opRecord {
+	return &opRecord{op: op, args: func(sw *snapshotWriter, codec Codec) error {
+		return sw.writeFields(codec, fields)
+	}}
+}
+
+func membersRecord(op byte, members []string) *opRecord {
+	return &opRecord{op: op, args: func(sw *snapshotWriter, codec Codec) error {
+		sw.writeMembers(members)
+		return nil
+	}}
+}
+
+func zsRecord(op byte, zs []Z) *opRecord {
+	return &opRecord{op: op, args: func(sw *snapshotWriter, codec Codec) error {
+		sw.writeZs(zs)
+		return nil
+	}}
+}
+
+func delaysRecord(op byte, items []DelayItem) *opRecord {
+	return &opRecord{op: op, args: func(sw *snapshotWriter, codec Codec) error {
+		return sw.writeDelays(codec, items)
+	}}
+}
+
+func (a *AOF) opPayload(key string, r *opRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	sw := &snapshotWriter{w: &buf}
+	sw.write([]byte{r.op})
+	sw.writeBytes([]byte(key))
+	if r.args != nil {
+		if e := r.args(sw, a.codec); e != nil {
+			return nil, errorx.Wrap(e)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// logState appends current state of key, a set record if key exists, otherwise a del record.
+// Caller must hold lock of key by lockKey.
+func (a *AOF) logState(m *Map, key string) {
+	v, exist := m.peek(key)
+	if !exist {
+		a.append(a.delPayload(key))
+		return
+	}
+
+	kind, value := exportValue(v.v)
+	payload, e := a.setPayload(key, v.exp, kind, value)
+	if e != nil {
+		a.fail(e)
+		return
+	}
+	a.append(payload)
+}
+
+func (a *AOF) fail(e error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	if a.err == nil {
+		a.err = errorx.Wrap(e)
+	}
+}
+
+// attach replays the log into maps and starts logging their writes.
+// slotOf routes a key to the map it belongs to.
+func (a *AOF) attach(codec Codec, maps []*Map, slotOf func(key string) *Map) error {
+	a.l.Lock()
+	if len(a.maps) > 0 {
+		a.l.Unlock()
+		return errorx.NewFromStringf("aof '%s' is already in use", a.path)
+	}
+	a.codec = codec
+	a.maps = maps
+	a.l.Unlock()
+
+	if e := a.replay(slotOf); e != nil {
+		a.l.Lock()
+		a.maps = nil
+		a.l.Unlock()
+		return errorx.Wrap(e)
+	}
+
+	for _, m := range maps {
+		m.aof.Store(a)
+	}
+	return nil
+}
+
+// replay applies all records of the log.
+// A broken record at the end, which is left by a crash while appending, is truncated.
+func (a *AOF) replay(slotOf func(key string) *Map) error {
+	a.l.Lock()
+	data, e := os.ReadFile(a.path)
+	a.l.Unlock()
+	if e != nil {
+		return errorx.Wrap(e)
+	}
+
+	if len(data) < len(aofMagic)+2 || string(data[:len(aofMagic)]) != aofMagic {
+		return errorx.NewFromStringf("'%s' is not a cmap aof", a.path)
+	}
+	if version := int(data[4])<<8 | int(data[5]); version != aofVersion {
+		return errorx.NewFromStringf("unsupported aof version %d", version)
+	}
+
+	// maps are not attached yet, applying records won't append them again
+	var pos = len(aofMagic) + 2
+	for pos < len(data) {
+		length, n := binary.Uvarint(data[pos:])
+		if n <= 0 || uint64(len(data)-pos-n) < length+4 {
+			// torn record at the end
+			break
+		}
+		sum := binary.BigEndian.Uint32(data[pos+n:])
+		end := pos + n + 4 + int(length)
+		payload := data[pos+n+4 : end]
+
+		if crc32.ChecksumIEEE(payload) != sum {
+			if end == len(data) {
+				break
+			}
+			return errorx.NewFromStringf("aof '%s' is corrupted at byte %d", a.path, pos)
+		}
+
+		if e := a.apply(payload, slotOf); e != nil {
+			return errorx.NewFromStringf("aof '%s' record at byte %d: %s", a.path, pos, e.Error())
+		}
+		pos = end
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if pos < len(data) {
+		if e := a.f.Truncate(int64(pos)); e != nil {
+			return errorx.Wrap(e)
+		}
+	}
+	a.size = int64(pos)
+	a.baseSize = a.size
+	return nil
+}
+
+func (a *AOF) apply(payload []byte, slotOf func(key string) *Map) error {
+	sr := &snapshotReader{r: bytes.NewReader(payload), crc: crc32.NewIEEE()}
+
+	op, e := sr.ReadByte()
+	if e != nil {
+		return e
+	}
+	k, e := sr.readBytes()
+	if e != nil {
+		return e
+	}
+	key := string(k)
+	m := slotOf(key)
+
+	switch op {
+	case aofOpSet:
+		exp, e := binary.ReadVarint(sr)
+		if e != nil {
+			return e
+		}
+		kind, e := sr.ReadByte()
+		if e != nil {
+			return e
+		}
+		value, e := sr.readValue(a.codec, key, ValueKind(kind))
+		if e != nil {
+			return e
+		}
+		if exp != -1 && exp <= time.Now().UnixNano() {
+			m.Delete(key)
+			return nil
+		}
+		m.set(key, importValue(ValueKind(kind), value), exp, false)
+	case aofOpDel:
+		m.Delete(key)
+	case aofOpRPush, aofOpLPush:
+		elems, e := sr.readElems(a.codec)
+		if e != nil {
+			return e
+		}
+		// list is expired or replaced
+		if v, _ := m.Get(key); v != nil {
+			if list, ok := v.(*clist); ok {
+				if op == aofOpRPush {
+					list.RPushN(elems...)
+				} else {
+					list.LPushN(elems...)
+				}
+			}
+		}
+	case aofOpLPop, aofOpRPop:
+		if v, _ := m.Get(key); v != nil {
+			if list, ok := v.(*clist); ok {
+				if op == aofOpLPop {
+					list.LPop()
+				} else {
+					list.RPop()
+				}
+			}
+		}
+	case aofOpHSet:
+		fields, e := sr.readFields(a.codec)
+		if e != nil {
+			return e
+		}
+		replayCompound(m, key, TYPE_HASH, func(h *chash) {
+			for field, v := range fields {
+				h.set(field, v)
+			}
+		})
+	case aofOpHDel:
+		fields, e := sr.readMembers()
+		if e != nil {
+			return e
+		}
+		replayCompound(m, key, TYPE_HASH, func(h *chash) {
+			for _, field := range fields {
+				h.del(field)
+			}
+		})
+	case aofOpSAdd, aofOpSRem:
+		members, e := sr.readMembers()
+		if e != nil {
+			return e
+		}
+		replayCompound(m, key, TYPE_SET, func(s *cset) {
+			for _, member := range members {
+				if op == aofOpSAdd {
+					s.add(member)
+				} else {
+					s.rem(member)
+				}
+			}
+		})
+	case aofOpZAdd:
+		zs, e := sr.readZs()
+		if e != nil {
+			return e
+		}
+		replayCompound(m, key, TYPE_ZSET, func(z *czset) {
+			for _, member := range zs {
+				z.add(member.Member, member.Score, 0, false)
+			}
+		})
+	case aofOpZRem:
+		members, e := sr.readMembers()
+		if e != nil {
+			return e
+		}
+		replayCompound(m, key, TYPE_ZSET, func(z *czset) {
+			for _, member := range members {
+				z.rem(member)
+			}
+		})
+	case aofOpDelayPush:
+		items, e := sr.readDelays(a.codec)
+		if e != nil {
+			return e
+		}
+		replayCompound(m, key, TYPE_DELAY, func(d *cdelay) {
+			for _, item := range items {
+				d.push(item.Value, item.Due.UnixNano())
+			}
+		})
+	case aofOpDelayPop:
+		// items are ordered the same as when they were pushed, the first one is the one polled
+		replayCompound(m, key, TYPE_DELAY, func(d *cdelay) {
+			d.poll(math.MaxInt64)
+		})
+	default:
+		return errorx.NewFromStringf("unknown op %d", op)
+	}
+	return nil
+}
+
+// replayCompound applies an op to compound value of key, the op is skipped if key is expired or replaced since
+func replayCompound[T compound](m *Map, key string, typ string, f func(c T)) {
+	updateCompound(m, key, "replay", typ, nil, func(c T) bool {
+		f(c)
+		return true
+	}, nil)
+}
+
+// Rewrite replaces the log with a minimal one made from current state of maps.
+// Writes are blocked only while copying the state, records appended during rewriting are kept.
+func (a *AOF) Rewrite() error {
+	a.rewritel.Lock()
+	defer a.rewritel.Unlock()
+
+	a.l.Lock()
+	if a.err != nil {
+		e := a.err
+		a.l.Unlock()
+		return e
+	}
+	maps := a.maps
+	a.l.Unlock()
+
+	if len(maps) == 0 {
+		return errorx.NewFromStringf("aof '%s' is not used by any map", a.path)
+	}
+
+	// no write is half-applied while all stripes are held, so state and rewriteBuf don't overlap
+	for i := range a.stripes {
+		a.stripes[i].Lock()
+	}
+	now := time.Now().UnixNano()
+	var entries []SnapshotEntry
+	for _, m := range maps {
+		m.modl.RLock()
+		l, mp := m.readableWrapedBymodl()
+		l.RLock()
+		entries = append(entries, copyEntries(mp, now)...)
+		l.RUnlock()
+		m.modl.RUnlock()
+	}
+	a.l.Lock()
+	a.rewriteBuf = &bytes.Buffer{}
+	a.l.Unlock()
+	for i := range a.stripes {
+		a.stripes[i].Unlock()
+	}
+
+	tmp := a.path + ".rewrite"
+	f, size, e := a.writeRewrite(tmp, entries, now)
+	if e != nil {
+		a.l.Lock()
+		a.rewriteBuf = nil
+		a.l.Unlock()
+		os.Remove(tmp)
+		return errorx.Wrap(e)
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	buffered := a.rewriteBuf.Bytes()
+	a.rewriteBuf = nil
+
+	if e := func() error {
+		if _, e := f.Write(buffered); e != nil {
+			return e
+		}
+		if e := f.Sync(); e != nil {
+			return e
+		}
+		return os.Rename(tmp, a.path)
+	}(); e != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errorx.Wrap(e)
+	}
+	syncDir(filepath.Dir(a.path))
+
+	a.f.Close()
+	a.f = f
+	a.size = size + int64(len(buffered))
+	a.baseSize = a.size
+	a.dirty = false
+	return nil
+}
+
+// writeRewrite writes header and set records of entries into a new file at path
+func (a *AOF) writeRewrite(path string, entries []SnapshotEntry, now int64) (*os.File, int64, error) {
+	f, e := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_APPEND, 0644)
+	if e != nil {
+		return nil, 0, errorx.Wrap(e)
+	}
+	if e := a.writeHeader(f); e != nil {
+		f.Close()
+		return nil, 0, errorx.Wrap(e)
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		var exp int64 = -1
+		if entry.TTL >= 0 {
+			exp = now + int64(entry.TTL)
+		}
+
+		payload, e := a.setPayload(entry.Key, exp, entry.Kind, entry.Value)
+		if e != nil {
+			f.Close()
+			return nil, 0, errorx.Wrap(e)
+		}
+		buf.Write(frame(payload))
+	}
+
+	if _, e := f.Write(buf.Bytes()); e != nil {
+		f.Close()
+		return nil, 0, errorx.Wrap(e)
+	}
+	return f, int64(len(aofMagic)+2) + int64(buf.Len()), nil
+}
+
+func syncDir(dir string) {
+	d, e := os.Open(dir)
+	if e != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// UseAOF replays aof into m, and then appends every write of m to aof.
+// It should be called before m serves traffic.
+func (m *Map) UseAOF(aof *AOF) error {
+	return aof.attach(m.conf.codec, []*Map{m}, func(string) *Map {
+		return m
+	})
+}
+
+// UseAOF replays aof into all slots, and then appends every write of mv2 to aof.
+func (mv2 *MapV2) UseAOF(aof *AOF) error {
+	return aof.attach(mv2.slots[0].conf.codec, mv2.slots, mv2.getslot)
+}
+
+// logged runs f, which writes key and returns whether key changed, and logs the result to aof if any.
+func (m *Map) logged(key string, f func() bool) {
+	m.loggedOp(key, f, nil)
+}
+
+// loggedOp works as logged, while the change is logged by the record record returns after f.
+// The whole value of key is logged if record is nil or returns nil.
+func (m *Map) loggedOp(key string, f func() bool, record func() *opRecord) {
+	a := m.aof.Load()
+	if a == nil {
+		f()
+		return
+	}
+
+	unlock := a.lockKey(key)
+	defer unlock()
+
+	if !f() {
+		return
+	}
+	var r *opRecord
+	if record != nil {
+		r = record()
+	}
+	if r == nil {
+		a.logState(m, key)
+		return
+	}
+	payload, e := a.opPayload(key, r)
+	if e != nil {
+		a.fail(e)
+		return
+	}
+	a.append(payload)
+}
+
+// loggedPush pushes elems to head of list of key if left is true, otherwise to tail, and logs them to aof if any.
+// Caller must hold m.listLock.
+func (m *Map) loggedPush(key string, list *clist, left bool, elems ...interface{}) {
+	push, op := list.RPushN, byte(aofOpRPush)
+	if left {
+		push, op = list.LPushN, aofOpLPush
+	}
+
+	a := m.aof.Load()
+	if a == nil {
+		push(elems...)
+		return
+	}
+
+	unlock := a.lockKey(key)
+	defer unlock()
+
+	push(elems...)
+
+	// list might be replaced by Set after it's got, pushing to it changes nothing of map
+	if v, exist := m.peek(key); !exist || v.v != list {
+		return
+	}
+	payload, e := a.pushPayload(op, key, elems)
+	if e != nil {
+		a.fail(e)
+		return
+	}
+	a.append(payload)
+}
+
+// loggedPop pops the first element of list of key if left is true, otherwise the last, and logs it to aof if any.
+// Caller must hold m.listLock.
+func (m *Map) loggedPop(key string, list *clist, left bool) (interface{}, bool) {
+	pop, op := list.RPop, byte(aofOpRPop)
+	if left {
+		pop, op = list.LPop, aofOpLPop
+	}
+
+	a := m.aof.Load()
+	if a == nil {
+		return pop()
+	}
+
+	unlock := a.lockKey(key)
+	defer unlock()
+
+	elem, ok := pop()
+	if !ok {
+		return nil, false
+	}
+	if v, exist := m.peek(key); !exist || v.v != list {
+		return elem, ok
+	}
+
+	var buf bytes.Buffer
+	sw := &snapshotWriter{w: &buf}
+	sw.write([]byte{op})
+	sw.writeBytes([]byte(key))
+	a.append(buf.Bytes())
+	return elem, ok
+}
+
+// loggedList runs f, which changes list of key and returns whether it's changed, and logs the whole list to aof if any.
+// It's for commands changing the middle of list, which are rare.
+// Caller must hold m.listLock.
+func (m *Map) loggedList(key string, list *clist, f func() bool) {
+	a := m.aof.Load()
+	if a == nil {
+		f()
+		return
+	}
+
+	unlock := a.lockKey(key)
+	defer unlock()
+
+	if !f() {
+		return
+	}
+	if v, exist := m.peek(key); !exist || v.v != list {
+		return
+	}
+	a.logState(m, key)
+}
+
+// peek returns the unexpired value of key in the readable register, without any side effect.
+func (m *Map) peek(key string) (Value, bool) {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	l, mp := m.readableWrapedBymodl()
+	l.RLock()
+	defer l.RUnlock()
+
+	v, exist := mp[key]
+	if !exist || v.isExpire() {
+		return Value{}, false
+	}
+	return v, true
+}
@@ -0,0 +1,344 @@
+package cmap
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestAOF(t *testing.T, path string, policy FsyncPolicy, opts ...AOFOption) *AOF {
+	aof, e := OpenAOF(path, policy, opts...)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return aof
+}
+
+func TestAOF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmap.aof")
+
+	aof := openTestAOF(t, path, FSYNC_ALWAYS)
+	m := NewMap()
+	if e := m.UseAOF(aof); e != nil {
+		t.Fatal(e)
+	}
+
+	m.Set("name", "cmap")
+	m.SetEx("session", "abc", 100)
+	m.SetTTL("expired", "abc", time.Millisecond)
+	m.Set("deleted", 1)
+	m.Delete("deleted")
+	m.IncrBy("count", 5)
+	m.IncrBy("count", 3)
+	m.RPushEX("list", "a", 100)
+	m.RPushNEX("list", 100, "b", "c")
+	m.Set("persist", 1)
+	m.Expire("persist", 100)
+	m.Persist("persist")
+
+	if e := m.UseAOF(aof); e == nil {
+		t.Fatalf("aof should not be used twice")
+	}
+	if e := aof.Close(); e != nil {
+		t.Fatal(e)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+
+	aof2 := openTestAOF(t, path, FSYNC_EVERYSEC)
+	defer aof2.Close()
+	m2 := NewMap()
+	if e := m2.UseAOF(aof2); e != nil {
+		t.Fatal(e)
+	}
+
+	if v, _ := m2.Get("name"); v != "cmap" {
+		t.Fatalf("want cmap but got %v", v)
+	}
+	if ttl := m2.TTL("session"); ttl <= 0 || ttl > 100 {
+		t.Fatalf("ttl of session should be kept but got %d", ttl)
+	}
+	if _, ok := m2.Get("expired"); ok {
+		t.Fatalf("expired key should not be replayed")
+	}
+	if _, ok := m2.Get("deleted"); ok {
+		t.Fatalf("deleted key should not be replayed")
+	}
+	if v, _ := m2.Get("count"); v != 8 {
+		t.Fatalf("want 8 but got %v", v)
+	}
+	if ttl := m2.TTL("persist"); ttl != TTL_NO_EXPIRE {
+		t.Fatalf("persist should have no time limit but got %d", ttl)
+	}
+	v, _ := m2.Get("list")
+	list, ok := v.(*clist)
+	if !ok {
+		t.Fatalf("list should be replayed as list but got %T", v)
+	}
+	if elems := list.LRange(0, 10); len(elems) != 3 || elems[0] != "a" || elems[2] != "c" {
+		t.Fatalf("want [a b c] but got %v", elems)
+	}
+
+	// replayed map keeps logging
+	m2.Set("name", "cmap2")
+	aof2.Close()
+
+	aof3 := openTestAOF(t, path, FSYNC_NEVER)
+	defer aof3.Close()
+	m3 := NewMap()
+	if e := m3.UseAOF(aof3); e != nil {
+		t.Fatal(e)
+	}
+	if v, _ := m3.Get("name"); v != "cmap2" {
+		t.Fatalf("want cmap2 but got %v", v)
+	}
+}
+
+func TestAOFTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmap.aof")
+
+	aof := openTestAOF(t, path, FSYNC_ALWAYS)
+	m := NewMap()
+	if e := m.UseAOF(aof); e != nil {
+		t.Fatal(e)
+	}
+	m.Set("a", 1)
+	m.Set("b", 2)
+	aof.Close()
+
+	// crash while appending leaves a torn record
+	info, _ := os.Stat(path)
+	if e := os.Truncate(path, info.Size()-3); e != nil {
+		t.Fatal(e)
+	}
+
+	aof2 := openTestAOF(t, path, FSYNC_ALWAYS)
+	defer aof2.Close()
+	m2 := NewMap()
+	if e := m2.UseAOF(aof2); e != nil {
+		t.Fatal(e)
+	}
+	if v, _ := m2.Get("a"); v != 1 {
+		t.Fatalf("want 1 but got %v", v)
+	}
+	if _, ok := m2.Get("b"); ok {
+		t.Fatalf("torn record should be dropped")
+	}
+
+	m2.Set("c", 3)
+	aof2.Close()
+
+	aof3 := openTestAOF(t, path, FSYNC_ALWAYS)
+	defer aof3.Close()
+	m3 := NewMap()
+	if e := m3.UseAOF(aof3); e != nil {
+		t.Fatal(e)
+	}
+	if v, _ := m3.Get("c"); v != 3 {
+		t.Fatalf("record after truncating should be replayed, want 3 but got %v", v)
+	}
+}
+
+func TestAOFRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmap.aof")
+
+	aof := openTestAOF(t, path, FSYNC_NEVER)
+	m := NewMap()
+	if e := m.UseAOF(aof); e != nil {
+		t.Fatal(e)
+	}
+
+	for i := 0; i < 1000; i++ {
+		m.Set(strconv.Itoa(i%10), i)
+	}
+	before := aof.Size()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			m.Incr("counter")
+		}
+	}()
+	if e := aof.Rewrite(); e != nil {
+		t.Fatal(e)
+	}
+	wg.Wait()
+
+	if aof.Size() >= before {
+		t.Fatalf("log should be smaller after rewriting, %d >= %d", aof.Size(), before)
+	}
+	m.Set("after", 1)
+	aof.Close()
+
+	aof2 := openTestAOF(t, path, FSYNC_NEVER)
+	defer aof2.Close()
+	m2 := NewMap()
+	if e := m2.UseAOF(aof2); e != nil {
+		t.Fatal(e)
+	}
+	for i := 0; i < 10; i++ {
+		if v, _ := m2.Get(strconv.Itoa(i)); v != 990+i {
+			t.Fatalf("want %d but got %v", 990+i, v)
+		}
+	}
+	if v, _ := m2.Get("counter"); v != 100 {
+		t.Fatalf("writes during rewriting should be kept, want 100 but got %v", v)
+	}
+	if v, _ := m2.Get("after"); v != 1 {
+		t.Fatalf("writes after rewriting should be kept")
+	}
+}
+
+func TestAOFAutoRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmap.aof")
+
+	aof := openTestAOF(t, path, FSYNC_EVERYSEC, WithAOFAutoRewrite(100, 1024))
+	defer aof.Close()
+	m := NewMap()
+	if e := m.UseAOF(aof); e != nil {
+		t.Fatal(e)
+	}
+
+	for i := 0; i < 1000; i++ {
+		m.Set("key", i)
+	}
+	before := aof.Size()
+
+	time.Sleep(1500 * time.Millisecond)
+	if aof.Size() >= before {
+		t.Fatalf("log should be rewritten automatically, %d >= %d", aof.Size(), before)
+	}
+}
+
+func TestMapV2AOF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmap.aof")
+
+	aof := openTestAOF(t, path, FSYNC_EVERYSEC)
+	m := NewMapV2(nil, 8, 5*time.Minute)
+	defer m.Clear()
+	if e := m.UseAOF(aof); e != nil {
+		t.Fatal(e)
+	}
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	m.Delete("0")
+	if e := aof.Rewrite(); e != nil {
+		t.Fatal(e)
+	}
+	aof.Close()
+
+	aof2 := openTestAOF(t, path, FSYNC_EVERYSEC)
+	defer aof2.Close()
+	m2 := NewMapV2(nil, 3, 5*time.Minute)
+	defer m2.Clear()
+	if e := m2.UseAOF(aof2); e != nil {
+		t.Fatal(e)
+	}
+	if _, ok := m2.Get("0"); ok {
+		t.Fatalf("deleted key should not be replayed")
+	}
+	for i := 1; i < 100; i++ {
+		if v, ok := m2.Get(strconv.Itoa(i)); !ok || v != i {
+			t.Fatalf("want %d but got %v", i, v)
+		}
+	}
+}
+
+func TestAOFList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmap.aof")
+
+	aof := openTestAOF(t, path, FSYNC_ALWAYS)
+	m := NewMap()
+	if e := m.UseAOF(aof); e != nil {
+		t.Fatal(e)
+	}
+	m.RPush("list", "a", "b", "c")
+	m.LPop("list")
+	m.RPush("popped", "a")
+	m.LPop("popped")
+	aof.Close()
+
+	aof2 := openTestAOF(t, path, FSYNC_ALWAYS)
+	defer aof2.Close()
+	m2 := NewMap()
+	if e := m2.UseAOF(aof2); e != nil {
+		t.Fatal(e)
+	}
+	if elems, _ := m2.LRange("list", 0, -1); len(elems) != 2 || elems[0] != "b" {
+		t.Fatalf("want [b c] but got %v", elems)
+	}
+	if m2.Type("popped") != TYPE_NONE {
+		t.Fatalf("empty list should be deleted")
+	}
+}
+
+func TestAOFCompoundOps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmap.aof")
+
+	aof := openTestAOF(t, path, FSYNC_ALWAYS)
+	m := NewMap()
+	if e := m.UseAOF(aof); e != nil {
+		t.Fatal(e)
+	}
+
+	// a change of a big value logs the op, not the whole value
+	var fields = make(map[string]interface{})
+	for i := 0; i < 1000; i++ {
+		fields["field:"+strconv.Itoa(i)] = i
+	}
+	m.HMSet("big", fields)
+	size := aof.Size()
+	m.HSet("big", "one-more", 1)
+	if grown := aof.Size() - size; grown > 100 {
+		t.Fatalf("HSet should log one field, but log grows %d bytes", grown)
+	}
+
+	m.HSet("hash", "name", "tom")
+	m.HSetNX("hash", "name", "jerry")
+	m.HIncrBy("hash", "age", 18)
+	m.HDel("hash", "name")
+	m.SAdd("set", "a", "b", "c")
+	m.SRem("set", "a")
+	m.SPop("set")
+	m.ZAdd("zset", 0, Z{Member: "a", Score: 1}, Z{Member: "b", Score: 2}, Z{Member: "c", Score: 3})
+	m.ZAdd("zset", ZADD_GT, Z{Member: "a", Score: 5}, Z{Member: "b", Score: 0})
+	m.ZIncrBy("zset", 10, "c")
+	m.ZRem("zset", "b")
+	m.ZPopMin("zset", 1)
+	m.DelayPush("delay", time.Now().Add(-time.Second), "a", "b")
+	m.DelayPush("delay", time.Now().Add(time.Hour), "later")
+	m.Poll("delay")
+	m.HSet("emptied", "x", 1)
+	m.HDel("emptied", "x")
+	m.SAdd("emptied", "x")
+	m.SRem("emptied", "x")
+
+	// ops of a value expired before replay are skipped
+	m.HSet("volatile", "a", 1)
+	m.PExpire("volatile", 50)
+	m.HSet("volatile", "b", 2)
+	aof.Close()
+	time.Sleep(60 * time.Millisecond)
+
+	aof2 := openTestAOF(t, path, FSYNC_ALWAYS)
+	defer aof2.Close()
+	m2 := NewMap()
+	if e := m2.UseAOF(aof2); e != nil {
+		t.Fatal(e)
+	}
+	if d := m.Snapshot().Diff(m2.Snapshot()); !d.Empty() {
+		t.Fatalf("replayed map differs: %+v", d)
+	}
+	if m2.Type("volatile") != TYPE_NONE || m2.Type("emptied") != TYPE_NONE {
+		t.Fatalf("expired and emptied values should not be replayed")
+	}
+	if items, _ := m2.DelayItems("delay"); len(items) != 2 || items[0].Value != "b" {
+		t.Fatalf("unexpected delay items %v", items)
+	}
+}
@@ -0,0 +1,289 @@
+package cmap
+
+import (
+	"context"
+	"errors"
+	"github.com/fwhezfwhez/errorx"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrMapClosed is returned by blocking commands like BLPop after the map is closed.
+var ErrMapClosed = errors.New("cmap: map is closed")
+
+// blockResult is an element handed to a blocked caller
+type blockResult struct {
+	key  string
+	elem interface{}
+}
+
+// blockWaiter is a caller blocked on some keys.
+// It's served at most once, by whoever claims it first: a push to one of its keys, or itself on timeout, cancellation and close.
+type blockWaiter struct {
+	left bool
+
+	// buffered, a claimed waiter receives exactly one result
+	ch chan blockResult
+
+	l       *sync.Mutex
+	claimed bool
+
+	// removes waiter from queues of all its keys
+	detach func()
+}
+
+func (w *blockWaiter) claim() bool {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	if w.claimed {
+		return false
+	}
+	w.claimed = true
+	return true
+}
+
+// blockQueue keeps waiters of keys in FIFO order
+type blockQueue struct {
+	l       *sync.Mutex
+	waiters map[string][]*blockWaiter
+
+	closed bool
+	done   chan struct{}
+}
+
+func newBlockQueue() *blockQueue {
+	return &blockQueue{
+		l:       &sync.Mutex{},
+		waiters: make(map[string][]*blockWaiter),
+		done:    make(chan struct{}),
+	}
+}
+
+func (q *blockQueue) add(key string, w *blockWaiter) {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	q.waiters[key] = append(q.waiters[key], w)
+}
+
+func (q *blockQueue) remove(key string, w *blockWaiter) {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	ws := q.waiters[key]
+	for i, _ := range ws {
+		if ws[i] == w {
+			ws = append(ws[:i:i], ws[i+1:]...)
+			break
+		}
+	}
+	if len(ws) == 0 {
+		delete(q.waiters, key)
+		return
+	}
+	q.waiters[key] = ws
+}
+
+// first removes and returns the longest waiting waiter of key
+func (q *blockQueue) first(key string) (*blockWaiter, bool) {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	ws := q.waiters[key]
+	if len(ws) == 0 {
+		return nil, false
+	}
+	w := ws[0]
+	if len(ws) == 1 {
+		delete(q.waiters, key)
+	} else {
+		q.waiters[key] = ws[1:]
+	}
+	return w, true
+}
+
+func (q *blockQueue) isClosed() bool {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	return q.closed
+}
+
+func (q *blockQueue) close() {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.done)
+}
+
+// serveBlocked hands elements of list of key to its waiters in FIFO order, until the list is empty or no one waits.
+// It's called after elements are pushed, caller must hold m.listLock.
+func (m *Map) serveBlocked(key string) {
+	for {
+		if n, _ := m.LLen(key); n == 0 {
+			return
+		}
+		w, ok := m.blocked.first(key)
+		if !ok {
+			return
+		}
+		// cancelled or served by another key
+		if !w.claim() {
+			continue
+		}
+		w.detach()
+
+		elem, _, _ := m.pop(key, "BLPop", w.left)
+		w.ch <- blockResult{key: key, elem: elem}
+	}
+}
+
+// Close wakes up callers blocked by BLPop, BRPop and BPoll with ErrMapClosed, later blocking calls fail immediately.
+// Other commands still work after Close.
+func (m *Map) Close() {
+	m.blocked.close()
+}
+
+// BLPop pops the first element of the first non-empty list of keys, like redis BLPOP.
+// If all lists are empty, it blocks until an element is pushed to one of keys, timeout elapses, ctx is done or map is closed.
+// Blocked callers are served in FIFO order. timeout <= 0 blocks without time limit.
+// It returns the key popped from and the element, false on timeout.
+func (m *Map) BLPop(ctx context.Context, timeout time.Duration, keys ...string) (string, interface{}, bool, error) {
+	return blockPop(ctx, timeout, true, "BLPop", keys, m.slotOf, m.lockLists)
+}
+
+// BRPop is BLPop popping the last element of lists.
+func (m *Map) BRPop(ctx context.Context, timeout time.Duration, keys ...string) (string, interface{}, bool, error) {
+	return blockPop(ctx, timeout, false, "BRPop", keys, m.slotOf, m.lockLists)
+}
+
+// BLPop pops the first element of lists of keys, keys can be in different slots, see Map.BLPop.
+func (mv2 *MapV2) BLPop(ctx context.Context, timeout time.Duration, keys ...string) (string, interface{}, bool, error) {
+	return blockPop(ctx, timeout, true, "BLPop", keys, mv2.getslot, mv2.lockLists)
+}
+
+// BRPop pops the last element of lists of keys, keys can be in different slots, see Map.BLPop.
+func (mv2 *MapV2) BRPop(ctx context.Context, timeout time.Duration, keys ...string) (string, interface{}, bool, error) {
+	return blockPop(ctx, timeout, false, "BRPop", keys, mv2.getslot, mv2.lockLists)
+}
+
+// Close wakes up blocked callers of all slots, closes subscriptions, and stops clearing expired keys.
+func (mv2 *MapV2) Close() {
+	for i, _ := range mv2.slots {
+		mv2.slots[i].Close()
+	}
+	mv2.ps.close()
+	select {
+	case mv2.clear <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Map) slotOf(key string) *Map {
+	return m
+}
+
+func (m *Map) lockLists(keys ...string) func() {
+	m.listLock.Lock()
+	return m.listLock.Unlock
+}
+
+// lockLists locks list locks of slots of keys in order of slot index, so callers locking overlapping slots don't deadlock.
+func (mv2 *MapV2) lockLists(keys ...string) func() {
+	var indexes = make([]int, 0, len(keys))
+	var seen = make(map[int]bool, len(keys))
+	for _, key := range keys {
+		i := int(mv2.hash(key) % int64(mv2.len))
+		if !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+
+	for _, i := range indexes {
+		mv2.slots[i].listLock.Lock()
+	}
+	return func() {
+		for j := len(indexes) - 1; j >= 0; j-- {
+			mv2.slots[indexes[j]].listLock.Unlock()
+		}
+	}
+}
+
+// blockPop pops from the first non-empty list of keys, or waits in queues of keys.
+// slotOf returns the map holding a key, lockLists locks lists of all keys.
+func blockPop(ctx context.Context, timeout time.Duration, left bool, command string, keys []string,
+	slotOf func(key string) *Map, lockLists func(keys ...string) func()) (string, interface{}, bool, error) {
+	if len(keys) == 0 {
+		return "", nil, false, errorx.NewFromStringf("cmap.Map.%s needs at least one key", command)
+	}
+	if e := ctx.Err(); e != nil {
+		return "", nil, false, e
+	}
+
+	unlock := lockLists(keys...)
+	done := slotOf(keys[0]).blocked.done
+	if slotOf(keys[0]).blocked.isClosed() {
+		unlock()
+		return "", nil, false, ErrMapClosed
+	}
+	for _, key := range keys {
+		elem, ok, e := slotOf(key).pop(key, command, left)
+		if e != nil {
+			unlock()
+			return "", nil, false, e
+		}
+		if ok {
+			unlock()
+			return key, elem, true, nil
+		}
+	}
+
+	w := &blockWaiter{
+		left: left,
+		ch:   make(chan blockResult, 1),
+		l:    &sync.Mutex{},
+	}
+	w.detach = func() {
+		for _, key := range keys {
+			slotOf(key).blocked.remove(key, w)
+		}
+	}
+	for _, key := range keys {
+		slotOf(key).blocked.add(key, w)
+	}
+	unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var e error
+	select {
+	case r := <-w.ch:
+		return r.key, r.elem, true, nil
+	case <-ctx.Done():
+		e = ctx.Err()
+	case <-done:
+		e = ErrMapClosed
+	case <-expired:
+	}
+
+	if !w.claim() {
+		// an element has been popped for w, don't lose it
+		r := <-w.ch
+		return r.key, r.elem, true, nil
+	}
+	w.detach()
+	return "", nil, false, e
+}
@@ -0,0 +1,189 @@
+package cmap
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitBlocked waits until n callers are blocked on key
+func waitBlocked(t *testing.T, m *Map, key string, n int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		m.blocked.l.Lock()
+		got := len(m.blocked.waiters[key])
+		m.blocked.l.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("want %d callers blocked on %s", n, key)
+}
+
+func TestBLPop(t *testing.T) {
+	m := NewMap()
+	ctx := context.Background()
+
+	m.RPush("b", "x", "y")
+	if key, elem, ok, _ := m.BLPop(ctx, time.Second, "a", "b"); !ok || key != "b" || elem != "x" {
+		t.Fatalf("want b x but got %s %v", key, elem)
+	}
+	if key, elem, ok, _ := m.BRPop(ctx, time.Second, "a", "b"); !ok || key != "b" || elem != "y" {
+		t.Fatalf("want b y but got %s %v", key, elem)
+	}
+
+	type result struct {
+		key  string
+		elem interface{}
+		ok   bool
+	}
+	results := make(chan result, 1)
+	go func() {
+		key, elem, ok, _ := m.BLPop(ctx, 0, "a", "b")
+		results <- result{key, elem, ok}
+	}()
+	waitBlocked(t, m, "b", 1)
+
+	m.LPush("b", "z")
+	if r := <-results; !r.ok || r.key != "b" || r.elem != "z" {
+		t.Fatalf("want b z but got %v", r)
+	}
+	// element is handed to the caller, not left in list
+	if m.Type("b") != TYPE_NONE {
+		t.Fatalf("list should be empty")
+	}
+	// caller is removed from all its keys
+	waitBlocked(t, m, "a", 0)
+
+	start := time.Now()
+	if _, _, ok, e := m.BLPop(ctx, 20*time.Millisecond, "a"); ok || e != nil {
+		t.Fatalf("want timeout but got %v %v", ok, e)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("should block until timeout")
+	}
+
+	m.Set("name", "cmap")
+	if _, _, _, e := m.BLPop(ctx, time.Second, "name"); e == nil {
+		t.Fatalf("BLPop on string should fail")
+	}
+	if _, _, _, e := m.BLPop(ctx, time.Second); e == nil {
+		t.Fatalf("BLPop without key should fail")
+	}
+}
+
+func TestBLPopFIFO(t *testing.T) {
+	m := NewMap()
+
+	var results = make([]chan interface{}, 5)
+	for i, _ := range results {
+		results[i] = make(chan interface{}, 1)
+		go func(i int) {
+			_, elem, _, _ := m.BLPop(context.Background(), 0, "queue")
+			results[i] <- elem
+		}(i)
+		waitBlocked(t, m, "queue", i+1)
+	}
+
+	m.RPush("queue", 0, 1, 2, 3, 4)
+	for i, _ := range results {
+		if elem := <-results[i]; elem != i {
+			t.Fatalf("caller %d should get %d but got %v", i, i, elem)
+		}
+	}
+}
+
+func TestBLPopCancel(t *testing.T) {
+	m := NewMap()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, _, _, e := m.BLPop(ctx, 0, "queue")
+		errs <- e
+	}()
+	waitBlocked(t, m, "queue", 1)
+	cancel()
+	if e := <-errs; e != context.Canceled {
+		t.Fatalf("want context.Canceled but got %v", e)
+	}
+	waitBlocked(t, m, "queue", 0)
+
+	// elements are not taken by cancelled callers
+	m.RPush("queue", "a")
+	if n, _ := m.LLen("queue"); n != 1 {
+		t.Fatalf("want 1 but got %d", n)
+	}
+
+	go func() {
+		_, _, _, e := m.BRPop(context.Background(), 0, "other")
+		errs <- e
+	}()
+	waitBlocked(t, m, "other", 1)
+	m.Close()
+	if e := <-errs; e != ErrMapClosed {
+		t.Fatalf("want ErrMapClosed but got %v", e)
+	}
+	if _, _, _, e := m.BLPop(context.Background(), 0, "other"); e != ErrMapClosed {
+		t.Fatalf("want ErrMapClosed but got %v", e)
+	}
+}
+
+func TestMapV2BLPop(t *testing.T) {
+	m := NewMapV2(nil, 8, 5*time.Minute)
+	defer m.Close()
+
+	var keys []string
+	for i := 0; i < 8; i++ {
+		keys = append(keys, "queue:"+strconv.Itoa(i))
+	}
+
+	// consumers cancelled at random never lose elements
+	const total = 1000
+	var popped int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+				_, _, ok, e := m.BRPop(ctx, 0, keys...)
+				cancel()
+				if ok {
+					atomic.AddInt64(&popped, 1)
+				}
+				if e == ErrMapClosed {
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < total; i++ {
+		m.LPush(keys[i%len(keys)], i)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var left int
+		for _, key := range keys {
+			n, _ := m.LLen(key)
+			left += n
+		}
+		if left == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	m.Close()
+	wg.Wait()
+
+	if popped != total {
+		t.Fatalf("want %d popped but got %d", total, popped)
+	}
+}
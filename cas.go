@@ -0,0 +1,273 @@
+package cmap
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"time"
+)
+
+// ErrNotInteger is returned by IncrByInt64 if value of key is not an integer or out of range of int64.
+var ErrNotInteger = errors.New("cmap: value is not an integer or out of range")
+
+// ErrIncrOverflow is returned by IncrByInt64 if the result overflows int64.
+var ErrIncrOverflow = errors.New("cmap: increment or decrement would overflow")
+
+// equal compares a and b by ==, values of incomparable types like slices and maps are never equal
+func equal(a interface{}, b interface{}) bool {
+	if a != nil && !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	if b != nil && !reflect.TypeOf(b).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// Update reads, modifies and writes key atomically in all modes.
+// f gets the current value and whether key exists, and returns the new value and whether to keep key.
+// If keep is false, key is deleted. Time limit of an existing key is kept.
+// Update returns the value saved and whether key exists after update.
+// f is called under lock of map, it must not operate the map.
+func (m *Map) Update(key string, f func(old interface{}, exists bool) (interface{}, bool)) (interface{}, bool) {
+	var rs interface{}
+	var kept bool
+	m.modify(key, func(old Value, exist bool) (Value, int) {
+		v, keep := f(old.v, exist)
+		if !keep {
+			if !exist {
+				return old, update_keep
+			}
+			return old, update_delete
+		}
+
+		rs, kept = v, true
+		if !exist {
+			old.exp = -1
+		}
+		old.v = v
+		return old, update_set
+	})
+	return rs, kept
+}
+
+// CompareAndSwap saves new if value of key is equal to old, time limit of key is kept.
+// It returns false if key doesn't exist or its value is not old.
+func (m *Map) CompareAndSwap(key string, old interface{}, new interface{}) bool {
+	_, _, action := m.modify(key, func(v Value, exist bool) (Value, int) {
+		if !exist || !equal(v.v, old) {
+			return v, update_keep
+		}
+		v.v = new
+		return v, update_set
+	})
+	return action == update_set
+}
+
+// CompareAndDelete deletes key if its value is equal to old.
+// It returns false if key doesn't exist or its value is not old.
+func (m *Map) CompareAndDelete(key string, old interface{}) bool {
+	_, _, action := m.update(key, func(v Value, exist bool) (Value, int) {
+		if !exist || !equal(v.v, old) {
+			return v, update_keep
+		}
+		return v, update_delete
+	})
+	return action == update_delete
+}
+
+// GetSet saves value without time limit, and returns the old value and whether it existed.
+func (m *Map) GetSet(key string, value interface{}) (interface{}, bool) {
+	old, exist, _ := m.getSet(key, value, "")
+	return old, exist
+}
+
+// GetDel deletes key, and returns its value and whether it existed.
+func (m *Map) GetDel(key string) (interface{}, bool) {
+	old, exist, _ := m.getDel(key, "")
+	return old, exist
+}
+
+// GetEx returns value of key and sets its time limit to ttl.
+// A negative ttl removes time limit like Persist, a zero ttl deletes the key after reading.
+func (m *Map) GetEx(key string, ttl time.Duration) (interface{}, bool) {
+	old, exist, _ := m.getEx(key, ttl, "")
+	return old, exist
+}
+
+// GetSetString works as GetSet like redis GETSET, but returns an error and changes nothing
+// if key holds a list, hash, set, zset or delay queue.
+func (m *Map) GetSetString(key string, value interface{}) (interface{}, bool, error) {
+	return m.getSet(key, value, "GetSetString")
+}
+
+// GetDelString works as GetDel like redis GETDEL, but returns an error and deletes nothing
+// if key holds a list, hash, set, zset or delay queue.
+func (m *Map) GetDelString(key string) (interface{}, bool, error) {
+	return m.getDel(key, "GetDelString")
+}
+
+// GetExString works as GetEx like redis GETEX, but returns an error and changes nothing
+// if key holds a list, hash, set, zset or delay queue.
+func (m *Map) GetExString(key string, ttl time.Duration) (interface{}, bool, error) {
+	return m.getEx(key, ttl, "GetExString")
+}
+
+// stringOnly returns the error of command if v is not a string, command is empty if any value is allowed
+func stringOnly(key string, command string, v interface{}) error {
+	if command == "" || TypeOf(v) == TYPE_STRING {
+		return nil
+	}
+	return errWrongType(key, command, TYPE_STRING)
+}
+
+func (m *Map) getSet(key string, value interface{}, command string) (interface{}, bool, error) {
+	var err error
+	old, exist, _ := m.modify(key, func(v Value, exist bool) (Value, int) {
+		if exist {
+			if err = stringOnly(key, command, v.v); err != nil {
+				return v, update_keep
+			}
+		}
+		return Value{v: value, exp: -1}, update_set
+	})
+	if err != nil || !exist {
+		return nil, false, err
+	}
+	return old.v, true, nil
+}
+
+func (m *Map) getDel(key string, command string) (interface{}, bool, error) {
+	var err error
+	old, exist, _ := m.update(key, func(v Value, exist bool) (Value, int) {
+		if !exist {
+			return v, update_keep
+		}
+		if err = stringOnly(key, command, v.v); err != nil {
+			return v, update_keep
+		}
+		return v, update_delete
+	})
+	if err != nil || !exist {
+		return nil, false, err
+	}
+	return old.v, true, nil
+}
+
+func (m *Map) getEx(key string, ttl time.Duration, command string) (interface{}, bool, error) {
+	exp := expOfDuration(ttl)
+	var err error
+	old, exist, _ := m.update(key, func(v Value, exist bool) (Value, int) {
+		if !exist {
+			return v, update_keep
+		}
+		if err = stringOnly(key, command, v.v); err != nil {
+			return v, update_keep
+		}
+		if exp != -1 && exp <= time.Now().UnixNano() {
+			return v, update_delete
+		}
+		v.exp = exp
+		return v, update_set
+	})
+	if err != nil || !exist {
+		return nil, false, err
+	}
+	return old.v, true, nil
+}
+
+// int64Of converts an integer value to int64, false if v is not an integer or out of range of int64
+func int64Of(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), uint64(v) <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	}
+	return 0, false
+}
+
+// IncrByInt64 adds delta to integer value of key atomically and returns the result, like redis INCRBY.
+// A missing key counts as 0 and is saved without time limit, time limit of an existing key is kept.
+// The result is saved as int64, whatever integer type the old value has.
+// Unlike IncrBy, nothing is changed on failure: it returns ErrNotInteger if the value isn't an integer,
+// ErrIncrOverflow if the result overflows int64, and an error if key holds a list, hash, set, zset or delay queue.
+func (m *Map) IncrByInt64(key string, delta int64) (int64, error) {
+	var rs int64
+	var err error
+	m.modify(key, func(old Value, exist bool) (Value, int) {
+		var n int64
+		if exist {
+			if TypeOf(old.v) != TYPE_STRING {
+				err = errWrongType(key, "IncrByInt64", TYPE_STRING)
+				return old, update_keep
+			}
+			var ok bool
+			if n, ok = int64Of(old.v); !ok {
+				err = ErrNotInteger
+				return old, update_keep
+			}
+		} else {
+			old.exp = -1
+		}
+
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			err = ErrIncrOverflow
+			return old, update_keep
+		}
+		rs = n + delta
+		old.v = rs
+		return old, update_set
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rs, nil
+}
+
+func (mv2 *MapV2) Update(key string, f func(old interface{}, exists bool) (interface{}, bool)) (interface{}, bool) {
+	return mv2.getslot(key).Update(key, f)
+}
+func (mv2 *MapV2) CompareAndSwap(key string, old interface{}, new interface{}) bool {
+	return mv2.getslot(key).CompareAndSwap(key, old, new)
+}
+func (mv2 *MapV2) CompareAndDelete(key string, old interface{}) bool {
+	return mv2.getslot(key).CompareAndDelete(key, old)
+}
+func (mv2 *MapV2) GetSet(key string, value interface{}) (interface{}, bool) {
+	return mv2.getslot(key).GetSet(key, value)
+}
+func (mv2 *MapV2) GetDel(key string) (interface{}, bool) {
+	return mv2.getslot(key).GetDel(key)
+}
+func (mv2 *MapV2) IncrByInt64(key string, delta int64) (int64, error) {
+	return mv2.getslot(key).IncrByInt64(key, delta)
+}
+func (mv2 *MapV2) GetEx(key string, ttl time.Duration) (interface{}, bool) {
+	return mv2.getslot(key).GetEx(key, ttl)
+}
+func (mv2 *MapV2) GetSetString(key string, value interface{}) (interface{}, bool, error) {
+	return mv2.getslot(key).GetSetString(key, value)
+}
+func (mv2 *MapV2) GetDelString(key string) (interface{}, bool, error) {
+	return mv2.getslot(key).GetDelString(key)
+}
+func (mv2 *MapV2) GetExString(key string, ttl time.Duration) (interface{}, bool, error) {
+	return mv2.getslot(key).GetExString(key, ttl)
+}
@@ -0,0 +1,266 @@
+package cmap
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	m := NewMap()
+
+	if m.CompareAndSwap("a", nil, 1) {
+		t.Fatalf("swap of missing key should fail")
+	}
+	m.SetEx("a", 1, 100)
+	if m.CompareAndSwap("a", 2, 3) {
+		t.Fatalf("swap of unequal value should fail")
+	}
+	if !m.CompareAndSwap("a", 1, 2) {
+		t.Fatalf("swap of equal value should succeed")
+	}
+	if v, _ := m.Get("a"); v != 2 {
+		t.Fatalf("want 2 but got %v", v)
+	}
+	if ttl := m.TTL("a"); ttl != 100 {
+		t.Fatalf("swap should keep ttl, got %d", ttl)
+	}
+
+	// incomparable values are never equal
+	m.Set("slice", []int{1})
+	if m.CompareAndSwap("slice", []int{1}, 1) || m.CompareAndDelete("slice", []int{1}) {
+		t.Fatalf("incomparable value should not be equal")
+	}
+
+	if m.CompareAndDelete("a", 1) || !m.CompareAndDelete("a", 2) {
+		t.Fatalf("unexpected CompareAndDelete")
+	}
+	if _, ok := m.Get("a"); ok {
+		t.Fatalf("a should be deleted")
+	}
+}
+
+func TestGetSetDelEx(t *testing.T) {
+	m := NewMap()
+
+	if _, ok := m.GetSet("a", 1); ok {
+		t.Fatalf("a should not exist before")
+	}
+	m.Expire("a", 100)
+	if old, ok := m.GetSet("a", 2); !ok || old != 1 {
+		t.Fatalf("want 1 but got %v", old)
+	}
+	if ttl := m.TTL("a"); ttl != TTL_NO_EXPIRE {
+		t.Fatalf("GetSet should remove ttl, got %d", ttl)
+	}
+
+	if v, ok := m.GetEx("a", 10*time.Second); !ok || v != 2 || m.TTL("a") != 10 {
+		t.Fatalf("GetEx should set ttl, got %v %d", v, m.TTL("a"))
+	}
+	if _, ok := m.GetEx("a", -1); !ok || m.TTL("a") != TTL_NO_EXPIRE {
+		t.Fatalf("GetEx with negative ttl should persist")
+	}
+	if v, ok := m.GetEx("a", 0); !ok || v != 2 {
+		t.Fatalf("GetEx with zero ttl should return value")
+	}
+	if _, ok := m.Get("a"); ok {
+		t.Fatalf("GetEx with zero ttl should delete key")
+	}
+
+	m.Set("b", "x")
+	if v, ok := m.GetDel("b"); !ok || v != "x" {
+		t.Fatalf("want x but got %v", v)
+	}
+	if _, ok := m.GetDel("b"); ok {
+		t.Fatalf("b should be deleted")
+	}
+
+	m.SetTTL("expired", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := m.GetSet("expired", 2); ok {
+		t.Fatalf("expired key should not exist")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	m := NewMap()
+
+	push := func(old interface{}, exists bool) (interface{}, bool) {
+		if !exists {
+			return []string{"a"}, true
+		}
+		return append(old.([]string), "a"), true
+	}
+	m.Update("k", push)
+	if v, ok := m.Update("k", push); !ok || len(v.([]string)) != 2 {
+		t.Fatalf("unexpected update %v", v)
+	}
+
+	if _, ok := m.Update("k", func(old interface{}, exists bool) (interface{}, bool) {
+		return nil, false
+	}); ok {
+		t.Fatalf("key should be deleted")
+	}
+	if _, ok := m.Get("k"); ok {
+		t.Fatalf("key should be deleted")
+	}
+
+	// events are the same as Set and Delete
+	events, cancel := m.Watch("*")
+	defer cancel()
+	m.Update("w", func(old interface{}, exists bool) (interface{}, bool) { return 1, true })
+	m.CompareAndDelete("w", 1)
+	if e := recvEvent(t, events); e.Type != EVENT_SET || e.Value != 1 {
+		t.Fatalf("want set event but got %v", e)
+	}
+	if e := recvEvent(t, events); e.Type != EVENT_DEL {
+		t.Fatalf("want del event but got %v", e)
+	}
+}
+
+// counters built on CompareAndSwap and Update never lose increments when map switches modes
+func TestIncrByInt64(t *testing.T) {
+	m := NewMap()
+
+	if n, e := m.IncrByInt64("n", 5); n != 5 || e != nil {
+		t.Fatalf("want 5 but got %d %v", n, e)
+	}
+	m.SetEx("small", int8(3), 100)
+	if n, _ := m.IncrByInt64("small", -5); n != -2 {
+		t.Fatalf("want -2 but got %d", n)
+	}
+	if ttl := m.TTL("small"); ttl != 100 {
+		t.Fatalf("IncrByInt64 should keep ttl, got %d", ttl)
+	}
+
+	m.Set("max", int64(math.MaxInt64-1))
+	if n, e := m.IncrByInt64("max", 1); n != math.MaxInt64 || e != nil {
+		t.Fatalf("want MaxInt64 but got %d %v", n, e)
+	}
+	if _, e := m.IncrByInt64("max", 1); e != ErrIncrOverflow {
+		t.Fatalf("want ErrIncrOverflow but got %v", e)
+	}
+	m.Set("min", int64(math.MinInt64))
+	if _, e := m.IncrByInt64("min", -1); e != ErrIncrOverflow {
+		t.Fatalf("want ErrIncrOverflow but got %v", e)
+	}
+	if v, _ := m.Get("max"); v != int64(math.MaxInt64) {
+		t.Fatalf("failed increment should change nothing, got %v", v)
+	}
+
+	m.Set("name", "cmap")
+	m.Set("big", uint64(math.MaxUint64))
+	for _, key := range []string{"name", "big"} {
+		if _, e := m.IncrByInt64(key, 1); e != ErrNotInteger {
+			t.Fatalf("%s: want ErrNotInteger but got %v", key, e)
+		}
+	}
+	m.RPush("list", 1)
+	if _, e := m.IncrByInt64("list", 1); e == nil || e == ErrNotInteger {
+		t.Fatalf("want wrong type error but got %v", e)
+	}
+}
+
+func TestGetSetDelExString(t *testing.T) {
+	m := NewMap()
+
+	if old, ok, e := m.GetSetString("name", "cmap"); ok || e != nil {
+		t.Fatalf("want nothing but got %v %v %v", old, ok, e)
+	}
+	if old, ok, _ := m.GetExString("name", time.Minute); !ok || old != "cmap" || m.TTL("name") != 60 {
+		t.Fatalf("want cmap with ttl but got %v %v", old, m.TTL("name"))
+	}
+	if old, ok, _ := m.GetDelString("name"); !ok || old != "cmap" {
+		t.Fatalf("want cmap but got %v", old)
+	}
+
+	// a list is neither read nor changed
+	m.RPush("list", 1)
+	if _, _, e := m.GetSetString("list", "x"); e == nil {
+		t.Fatalf("GetSetString on list should fail")
+	}
+	if _, _, e := m.GetExString("list", time.Minute); e == nil {
+		t.Fatalf("GetExString on list should fail")
+	}
+	if _, _, e := m.GetDelString("list"); e == nil {
+		t.Fatalf("GetDelString on list should fail")
+	}
+	if n, _ := m.LLen("list"); n != 1 || m.TTL("list") != TTL_NO_EXPIRE {
+		t.Fatalf("list should be untouched, got len %d ttl %d", n, m.TTL("list"))
+	}
+}
+
+func TestCompareAndSwapInBusyMode(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 10000; i++ {
+		m.SetTTL("expire-"+strconv.Itoa(i), i, time.Millisecond)
+	}
+	m.Set("cas", 0)
+
+	stop := make(chan struct{})
+	var clearing sync.WaitGroup
+	clearing.Add(1)
+	go func() {
+		defer clearing.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.ClearExpireKeys()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				for {
+					v, _ := m.Get("cas")
+					if m.CompareAndSwap("cas", v, v.(int)+1) {
+						break
+					}
+				}
+				m.Update("update", func(old interface{}, exists bool) (interface{}, bool) {
+					if !exists {
+						return 1, true
+					}
+					return old.(int) + 1, true
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	clearing.Wait()
+
+	// read from m after a whole switch
+	m.ClearExpireKeys()
+	if v, _ := m.Get("cas"); v != 4000 {
+		t.Fatalf("want 4000 but got %v", v)
+	}
+	if v, _ := m.Get("update"); v != 4000 {
+		t.Fatalf("want 4000 but got %v", v)
+	}
+}
+
+func TestMapV2CompareAndSwap(t *testing.T) {
+	m := NewMapV2(nil, 8, time.Minute)
+	defer m.Clear()
+
+	m.Set("a", "x")
+	if !m.CompareAndSwap("a", "x", "y") || m.CompareAndSwap("a", "x", "z") {
+		t.Fatalf("unexpected CompareAndSwap")
+	}
+	if old, _ := m.GetSet("a", "z"); old != "y" {
+		t.Fatalf("want y but got %v", old)
+	}
+	if v, _ := m.GetDel("a"); v != "z" {
+		t.Fatalf("want z but got %v", v)
+	}
+}
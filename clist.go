@@ -2,14 +2,22 @@ package cmap
 
 import (
 	"fmt"
+	"iter"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// clist is the list type made by RPush and LPush.
+// Elements are saved in a ring buffer, so pushing and popping at both ends are O(1), and popped slots are cleared and reused.
+// The buffer grows when it's full and shrinks when it's mostly empty.
 type clist struct {
-	arr []interface{}
-	l   *sync.RWMutex
+	// ring buffer, elements are arr[head], arr[head+1], ... arr[head+n-1] modulo len(arr)
+	arr  []interface{}
+	head int
+	n    int
+
+	l *sync.RWMutex
 
 	rpushTimes  int64
 	rpushnTimes int64
@@ -19,9 +27,13 @@ type clist struct {
 	llenTimes   int64
 }
 
+const (
+	clistMinCap = 8
+)
+
 func newclist() *clist {
 	return &clist{
-		arr: make([]interface{}, 0, 10),
+		arr: make([]interface{}, clistMinCap),
 		l:   &sync.RWMutex{},
 	}
 }
@@ -31,7 +43,7 @@ func (m *clist) String() string {
 	defer m.l.RUnlock()
 	return fmt.Sprintf("%s clist len=%d rpush_times=%d rpushn_times=%d lpop_times=%d lpopn_times=%d lrange_times=%d llen_times=%d",
 		time.Now().Format("2006-01-02 15:04:05"),
-		len(m.arr),
+		m.n,
 		m.rpushTimes,
 		m.rpushnTimes,
 		m.lpopTimes,
@@ -41,19 +53,114 @@ func (m *clist) String() string {
 	)
 }
 
+// at returns position of i-th element in arr
+func (m *clist) at(i int) int {
+	return (m.head + i) % len(m.arr)
+}
+
+// resize moves elements to a new buffer of size c in order
+func (m *clist) resize(c int) {
+	arr := make([]interface{}, c)
+	for i := 0; i < m.n; i++ {
+		arr[i] = m.arr[m.at(i)]
+	}
+	m.arr, m.head = arr, 0
+}
+
+func (m *clist) grow() {
+	if m.n == len(m.arr) {
+		m.resize(2 * len(m.arr))
+	}
+}
+
+// shrink halves the buffer when a quarter of it is used, so popped elements don't keep a big buffer
+func (m *clist) shrink() {
+	if len(m.arr) > clistMinCap && m.n <= len(m.arr)/4 {
+		m.resize(len(m.arr) / 2)
+	}
+}
+
+func (m *clist) pushBack(value interface{}) {
+	m.grow()
+	m.arr[m.at(m.n)] = value
+	m.n++
+}
+
+func (m *clist) pushFront(value interface{}) {
+	m.grow()
+	m.head = (m.head - 1 + len(m.arr)) % len(m.arr)
+	m.arr[m.head] = value
+	m.n++
+}
+
+func (m *clist) popFront() (interface{}, bool) {
+	if m.n == 0 {
+		return nil, false
+	}
+	rs := m.arr[m.head]
+	m.arr[m.head] = nil
+	m.head = m.at(1)
+	m.n--
+	m.shrink()
+	return rs, true
+}
+
+func (m *clist) popBack() (interface{}, bool) {
+	if m.n == 0 {
+		return nil, false
+	}
+	i := m.at(m.n - 1)
+	rs := m.arr[i]
+	m.arr[i] = nil
+	m.n--
+	m.shrink()
+	return rs, true
+}
+
+// index turns a negative index counting from the end to a normal one, and reports whether it's in range
+func (m *clist) index(i int) (int, bool) {
+	if i < 0 {
+		i += m.n
+	}
+	return i, i >= 0 && i < m.n
+}
+
+// rebuild replaces elements by elems
+func (m *clist) rebuild(elems []interface{}) {
+	c := clistMinCap
+	for c < len(elems) {
+		c *= 2
+	}
+	m.arr = make([]interface{}, c)
+	copy(m.arr, elems)
+	m.head, m.n = 0, len(elems)
+}
+
 func (m *clist) RPush(value interface{}) {
 	m.l.Lock()
 	defer m.l.Unlock()
 
 	atomic.AddInt64(&m.rpushTimes, 1)
-	m.arr = append(m.arr, value)
+	m.pushBack(value)
 }
 func (m *clist) RPushN(values ...interface{}) {
 	m.l.Lock()
 	defer m.l.Unlock()
 	atomic.AddInt64(&m.rpushnTimes, 1)
 
-	m.arr = append(m.arr, values...)
+	for _, v := range values {
+		m.pushBack(v)
+	}
+}
+
+// LPushN inserts values at head one by one like redis LPUSH, so the last value becomes the first element.
+func (m *clist) LPushN(values ...interface{}) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	for _, v := range values {
+		m.pushFront(v)
+	}
 }
 
 func (m *clist) LPop() (interface{}, bool) {
@@ -61,14 +168,14 @@ func (m *clist) LPop() (interface{}, bool) {
 	defer m.l.Unlock()
 	atomic.AddInt64(&m.lpopTimes, 1)
 
-	if len(m.arr) == 0 {
-		return nil, false
-	}
-	rs := m.arr[0]
+	return m.popFront()
+}
 
-	m.arr = m.arr[1:]
+func (m *clist) RPop() (interface{}, bool) {
+	m.l.Lock()
+	defer m.l.Unlock()
 
-	return rs, true
+	return m.popBack()
 }
 
 func (m *clist) LPopN(n int) []interface{} {
@@ -80,83 +187,202 @@ func (m *clist) LPopN(n int) []interface{} {
 	defer m.l.Unlock()
 	atomic.AddInt64(&m.lpopnTimes, 1)
 
-	if len(m.arr) == 0 {
-		return []interface{}{}
-	}
-
-	if n >= len(m.arr) {
-
-		rs := copySlice(m.arr)
-		m.arr = []interface{}{}
-		return rs
+	var rs = make([]interface{}, 0, 10)
+	for ; n > 0; n-- {
+		v, ok := m.popFront()
+		if !ok {
+			break
+		}
+		rs = append(rs, v)
 	}
-
-	rs := copySlice(m.arr[0:n])
-	m.arr = m.arr[n:]
 	return rs
 }
+
+// LRange returns copy of elements from start to end, both inclusive, indexes out of range are clamped.
 func (m *clist) LRange(start int, end int) []interface{} {
+	m.l.RLock()
+	defer m.l.RUnlock()
+
+	atomic.AddInt64(&m.lrangeTimes, 1)
+
+	if start < 0 {
+		start = 0
+	}
+	if end > m.n-1 {
+		end = m.n - 1
+	}
 	if start > end {
 		return []interface{}{}
 	}
 
+	var rs = make([]interface{}, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		rs = append(rs, m.arr[m.at(i)])
+	}
+	return rs
+}
+
+func (m *clist) LLen() int {
 	m.l.RLock()
 	defer m.l.RUnlock()
 
-	atomic.AddInt64(&m.lrangeTimes, 1)
+	atomic.AddInt64(&m.llenTimes, 1)
 
-	//                                start**********end
-	//   arr[0]------------arr[len-1]
-	if start > len(m.arr)-1 {
-		return []interface{}{}
+	return m.n
+}
+
+// size estimates bytes of elements, 16 per element for the interface in the ring buffer
+func (m *clist) size() int64 {
+	m.l.RLock()
+	defer m.l.RUnlock()
+	return 16 * int64(m.n)
+}
+
+// LIndex returns element of index, negative index counts from the end.
+func (m *clist) LIndex(i int) (interface{}, bool) {
+	m.l.RLock()
+	defer m.l.RUnlock()
+
+	i, ok := m.index(i)
+	if !ok {
+		return nil, false
 	}
+	return m.arr[m.at(i)], true
+}
 
-	// start**********end
-	//                    arr[0]------------
-	if end < 0 {
-		return []interface{}{}
+// LSet sets element of index, it returns false if index is out of range.
+func (m *clist) LSet(i int, value interface{}) bool {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	i, ok := m.index(i)
+	if !ok {
+		return false
 	}
+	m.arr[m.at(i)] = value
+	return true
+}
 
-	if end-start >= len(m.arr) {
-		return copySlice(m.arr)
+// LTrim keeps elements from start to stop only, negative indexes count from the end.
+func (m *clist) LTrim(start int, stop int) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	if start < 0 {
+		start += m.n
+	}
+	if stop < 0 {
+		stop += m.n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop > m.n-1 {
+		stop = m.n - 1
 	}
 
-	if len(m.arr) == 0 {
-		return []interface{}{}
+	var elems = make([]interface{}, 0, 10)
+	for i := start; i <= stop; i++ {
+		elems = append(elems, m.arr[m.at(i)])
 	}
+	m.rebuild(elems)
+}
 
-	// start**********end
-	//         arr[0]------------len-1
-	if start <= 0 && 0 <= end && end <= len(m.arr)-1 {
-		return copySlice(m.arr[0 : end+1])
+// LRem removes elements equal to value like redis LREM, count > 0 removes the first count ones from head,
+// count < 0 removes the first -count ones from tail, count = 0 removes all. It returns number of removed elements.
+func (m *clist) LRem(count int, value interface{}) int {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	var remove = make([]bool, m.n)
+	var removed int
+	if count >= 0 {
+		for i := 0; i < m.n && (count == 0 || removed < count); i++ {
+			if equal(m.arr[m.at(i)], value) {
+				remove[i] = true
+				removed++
+			}
+		}
+	} else {
+		for i := m.n - 1; i >= 0 && removed < -count; i-- {
+			if equal(m.arr[m.at(i)], value) {
+				remove[i] = true
+				removed++
+			}
+		}
+	}
+	if removed == 0 {
+		return 0
 	}
 
-	//           start**********end
-	// arr[0]------------arr[len-1]
-	if start <= len(m.arr)-1 && len(m.arr)-1 <= end {
-		return copySlice(m.arr[start:len(m.arr)])
+	var elems = make([]interface{}, 0, m.n-removed)
+	for i := 0; i < m.n; i++ {
+		if !remove[i] {
+			elems = append(elems, m.arr[m.at(i)])
+		}
 	}
+	m.rebuild(elems)
+	return removed
+}
 
-	//           start**********end
-	//       arr[0]------------arr[len-1]
+// LInsert inserts value before or after the first element equal to pivot.
+// It returns length of list, or -1 if pivot is not found.
+func (m *clist) LInsert(before bool, pivot interface{}, value interface{}) int {
+	m.l.Lock()
+	defer m.l.Unlock()
 
-	return copySlice(m.arr[start : end+1])
+	for i := 0; i < m.n; i++ {
+		if !equal(m.arr[m.at(i)], pivot) {
+			continue
+		}
+		if !before {
+			i++
+		}
+
+		var elems = make([]interface{}, 0, m.n+1)
+		for j := 0; j < i; j++ {
+			elems = append(elems, m.arr[m.at(j)])
+		}
+		elems = append(elems, value)
+		for j := i; j < m.n; j++ {
+			elems = append(elems, m.arr[m.at(j)])
+		}
+		m.rebuild(elems)
+		return m.n
+	}
+	return -1
 }
 
-func (m *clist) LLen() int {
+// values copies all elements
+func (m *clist) values() []interface{} {
 	m.l.RLock()
 	defer m.l.RUnlock()
 
-	atomic.AddInt64(&m.llenTimes, 1)
-
-	return len(m.arr)
+	var rs = make([]interface{}, 0, m.n)
+	for i := 0; i < m.n; i++ {
+		rs = append(rs, m.arr[m.at(i)])
+	}
+	return rs
 }
 
-func copySlice(arr []interface{}) []interface{} {
-	var rs = make([]interface{}, 0, 10)
+// All returns an iterator over index-elements, elements are copied when iterating starts.
+func (m *clist) All() iter.Seq2[int, interface{}] {
+	return func(yield func(int, interface{}) bool) {
+		for i, v := range m.values() {
+			if !yield(i, v) {
+				return
+			}
+		}
+	}
+}
 
-	for _, v := range arr {
-		rs = append(rs, v)
+// Values returns an iterator over elements, see All.
+func (m *clist) Values() iter.Seq[interface{}] {
+	return func(yield func(interface{}) bool) {
+		for _, v := range m.values() {
+			if !yield(v) {
+				return
+			}
+		}
 	}
-	return rs
 }
@@ -11,32 +11,116 @@ func TestClist(t *testing.T) {
 	list.RPush(1)
 	list.RPushN(2, 3, 4, 5, 6, 7)
 
-	fmt.Println(list.arr) //1-7
+	fmt.Println(list.values()) //1-7
 
-	fmt.Println(list.LPop()) // 1 true
-	fmt.Println(list.arr)    // 2-7
+	fmt.Println(list.LPop())   // 1 true
+	fmt.Println(list.values()) // 2-7
 
-	fmt.Println(list.LPop()) // 2 true
-	fmt.Println(list.arr)    // 3-7
+	fmt.Println(list.LPop())   // 2 true
+	fmt.Println(list.values()) // 3-7
 
 	fmt.Println(list.LPopN(3)) // 3,4,5
-	fmt.Println(list.arr)      // 6,7
+	fmt.Println(list.values()) // 6,7
 
 	fmt.Println(list.LRange(0, 0))        // 6
 	fmt.Println(list.LRange(1, 1))        // 7
 	fmt.Println(list.LRange(0, 5000))     // 6,7
 	fmt.Println(list.LRange(-10, 1))      // 6,7
 	fmt.Println(list.LRange(-10, 10))     // 6,7
-	fmt.Println(list.LRange(-10, 0))      // 6,7
+	fmt.Println(list.LRange(-10, 0))      // 6
 	fmt.Println(list.LRange(-10, -13))    // []
 	fmt.Println(list.LRange(6000, 10000)) // []
 
 	fmt.Println(list.LPopN(10)) // 6,7
-	fmt.Println(list.arr)       //[]
+	fmt.Println(list.values())  //[]
 
 	list.RPushN(8, 9, 10)
 }
 
+func TestClistDeque(t *testing.T) {
+	list := newclist()
+
+	// wrap around the ring buffer
+	for i := 0; i <= 100; i++ {
+		list.RPush(i)
+		list.LPushN(-i)
+		if i%3 == 0 {
+			list.LPop()
+			list.RPop()
+		}
+	}
+	values := list.values()
+	if len(values) != list.LLen() {
+		t.Fatalf("want %d values but got %d", list.LLen(), len(values))
+	}
+	if v, _ := list.LIndex(0); v != -100 {
+		t.Fatalf("want -100 but got %v", v)
+	}
+	if v, _ := list.LIndex(-1); v != 100 {
+		t.Fatalf("want 100 but got %v", v)
+	}
+
+	for list.LLen() > 0 {
+		list.LPop()
+	}
+	if len(list.arr) != clistMinCap {
+		t.Fatalf("buffer should shrink when empty, got cap %d", len(list.arr))
+	}
+
+	list.LPushN(1, 2, 3)
+	if fmt.Sprint(list.values()) != "[3 2 1]" {
+		t.Fatalf("LPushN should insert one by one, got %v", list.values())
+	}
+	list.RPushN(1, 2, 1)
+	if n := list.LRem(-2, 1); n != 2 || fmt.Sprint(list.values()) != "[3 2 1 2]" {
+		t.Fatalf("unexpected LRem %d %v", n, list.values())
+	}
+	if n := list.LInsert(true, 2, "x"); n != 5 || fmt.Sprint(list.values()) != "[3 x 2 1 2]" {
+		t.Fatalf("unexpected LInsert %d %v", n, list.values())
+	}
+	if n := list.LInsert(false, "missing", "x"); n != -1 {
+		t.Fatalf("want -1 but got %d", n)
+	}
+	if !list.LSet(-1, "y") || list.LSet(5, "z") {
+		t.Fatalf("unexpected LSet")
+	}
+	list.LTrim(1, -2)
+	if fmt.Sprint(list.values()) != "[x 2 1]" {
+		t.Fatalf("unexpected LTrim %v", list.values())
+	}
+	if fmt.Sprint(list.LRange(1, 100)) != "[2 1]" {
+		t.Fatalf("unexpected LRange %v", list.LRange(1, 100))
+	}
+}
+
+// clist.LRange clamps indexes out of range, a negative stop selects nothing.
+func TestClistLRange(t *testing.T) {
+	list := newclist()
+	list.RPushN(6, 7)
+
+	var cases = []struct {
+		start, stop int
+		want        string
+	}{
+		{0, 0, "[6]"},
+		{1, 1, "[7]"},
+		{0, 5000, "[6 7]"},
+		{-10, 1, "[6 7]"},
+		{-10, 10, "[6 7]"},
+		{-10, 0, "[6]"},
+		{0, -1, "[]"},
+		{-10, -13, "[]"},
+		{1, 0, "[]"},
+		{2, 5, "[]"},
+		{6000, 10000, "[]"},
+	}
+	for _, c := range cases {
+		if got := fmt.Sprint(list.LRange(c.start, c.stop)); got != c.want {
+			t.Fatalf("LRange(%d, %d) want %s but got %s", c.start, c.stop, c.want, got)
+		}
+	}
+}
+
 func TestConrurency(t *testing.T) {
 	list := newclist()
 	go func() {
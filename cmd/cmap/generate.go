@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/fwhezfwhez/cmap"
+	"io"
+	"os"
+	"strings"
+)
+
+type generateOptions struct {
+	typ     string
+	name    string
+	integer bool
+	key     string
+	sharded bool
+	dir     string
+	pkg     string
+	output  string
+}
+
+func generateFlags(o *generateOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet("cmap", flag.ContinueOnError)
+	fs.StringVar(&o.typ, "type", "", "value type, required, like Teacher, *Teacher, int64")
+	fs.StringVar(&o.name, "name", "", "prefix of generated names, default name of -type")
+	fs.BoolVar(&o.integer, "integer", false, "generate Incr/Decr methods, implied by builtin integer types")
+	fs.StringVar(&o.key, "key", "string", "key type, builtin types or types declared in -dir")
+	fs.BoolVar(&o.sharded, "sharded", false, "also generate a sharded <name>MapV2")
+	fs.StringVar(&o.dir, "dir", ".", "directory of package declaring key type")
+	fs.StringVar(&o.pkg, "package", "", "package of generated file, default $GOPACKAGE set by go generate, or 'model'")
+	fs.StringVar(&o.output, "output", "", "output file, default <type>_map.go, '-' for stdout")
+	return fs
+}
+
+func generate(args []string, stdout io.Writer, stderr io.Writer) int {
+	var o generateOptions
+	fs := generateFlags(&o)
+	fs.SetOutput(stderr)
+	if e := fs.Parse(args); e != nil {
+		return 2
+	}
+	if o.typ == "" || fs.NArg() > 0 {
+		usage(stderr)
+		return 2
+	}
+
+	if o.pkg == "" {
+		o.pkg = os.Getenv("GOPACKAGE")
+	}
+	if o.pkg == "" {
+		o.pkg = "model"
+	}
+	if o.output == "" {
+		name := o.name
+		if name == "" {
+			name = strings.TrimLeft(o.typ, "*")
+			name = name[strings.LastIndex(name, ".")+1:]
+		}
+		o.output = strings.ToLower(name) + "_map.go"
+	}
+
+	base, fields, e := resolveKey(o.dir, o.key)
+	if e != nil {
+		fmt.Fprintf(stderr, "cmap: %v\n", e)
+		return 1
+	}
+
+	src, e := cmap.Generate(cmap.GenerateConfig{
+		Package:   o.pkg,
+		Type:      o.typ,
+		Name:      o.name,
+		Integer:   o.integer,
+		Key:       o.key,
+		KeyBase:   base,
+		KeyFields: fields,
+		Sharded:   o.sharded,
+	})
+	if e != nil {
+		fmt.Fprintf(stderr, "cmap: %v\n", e)
+		return 1
+	}
+	code := append([]byte("// Code generated by github.com/fwhezfwhez/cmap/cmd/cmap. DO NOT EDIT.\n\n"), src...)
+
+	if o.output == "-" {
+		stdout.Write(code)
+		return 0
+	}
+	if e := os.WriteFile(o.output, code, 0644); e != nil {
+		fmt.Fprintf(stderr, "cmap: %v\n", e)
+		return 1
+	}
+	return 0
+}
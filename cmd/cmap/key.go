@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fwhezfwhez/cmap"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isBasic tells whether name is a builtin type like int64, string
+func isBasic(name string) bool {
+	obj, ok := types.Universe.Lookup(name).(*types.TypeName)
+	if !ok {
+		return false
+	}
+	_, ok = obj.Type().(*types.Basic)
+	return ok
+}
+
+// resolveKey finds declaration of key type in go files of dir,
+// and returns the builtin type underlying it, or its fields when it's a struct.
+// Builtin key types need nothing to resolve.
+func resolveKey(dir string, key string) (string, []cmap.KeyField, error) {
+	if key == "" || isBasic(key) {
+		return "", nil, nil
+	}
+
+	entries, e := os.ReadDir(dir)
+	if e != nil {
+		return "", nil, e
+	}
+
+	fset := token.NewFileSet()
+	var specs = make(map[string]ast.Expr)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, e := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if e != nil {
+			return "", nil, e
+		}
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				specs[ts.Name.Name] = ts.Type
+			}
+		}
+	}
+
+	// basicOf returns builtin type underlying expr, following named types declared in dir
+	var basicOf func(expr ast.Expr, depth int) (string, bool)
+	basicOf = func(expr ast.Expr, depth int) (string, bool) {
+		ident, ok := expr.(*ast.Ident)
+		if !ok || depth > 10 {
+			return "", false
+		}
+		if isBasic(ident.Name) {
+			return ident.Name, true
+		}
+		next, ok := specs[ident.Name]
+		if !ok {
+			return "", false
+		}
+		return basicOf(next, depth+1)
+	}
+
+	typ, ok := specs[key]
+	if !ok {
+		return "", nil, fmt.Errorf("key type %s is not declared in %s", key, dir)
+	}
+
+	st, ok := typ.(*ast.StructType)
+	if !ok {
+		base, ok := basicOf(typ, 0)
+		if !ok {
+			return "", nil, fmt.Errorf("key type %s should be a struct or based on a builtin type", key)
+		}
+		return base, nil, nil
+	}
+
+	var fields []cmap.KeyField
+	for _, field := range st.Fields.List {
+		base, ok := basicOf(field.Type, 0)
+		if !ok || len(field.Names) == 0 {
+			return "", nil, fmt.Errorf("field %s of key type %s should be a named field of builtin type", types.ExprString(field.Type), key)
+		}
+		for _, name := range field.Names {
+			if name.Name == "_" {
+				continue
+			}
+			fields = append(fields, cmap.KeyField{Name: name.Name, Type: base})
+		}
+	}
+	return "", fields, nil
+}
@@ -0,0 +1,62 @@
+// Command cmap generates typed maps and inspects snapshot files of cmap.
+//
+// Generate a concurrently safe map of a type, usually by go:generate:
+//
+//	//go:generate go run github.com/fwhezfwhez/cmap/cmd/cmap -type Teacher
+//	cmap -type Teacher -package model -output teacher_map.go
+//
+// Inspect a snapshot saved by Map.SaveSnapshot or MapV2.SaveSnapshot:
+//
+//	cmap inspect cmap.snapshot
+//
+// Diff two snapshots:
+//
+//	cmap diff old.snapshot new.snapshot
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes command line args and returns exit code
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "inspect":
+			return inspect(args[1:], stdout, stderr)
+		case "diff":
+			return diff(args[1:], stdout, stderr)
+		case "help", "-h", "-help", "--help":
+			usage(stdout)
+			return 0
+		}
+	}
+	return generate(args, stdout, stderr)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, `Usage:
+  cmap -type T [-package p] [-output file]   generate a typed map of T
+  cmap inspect [-values=false] file            print entries of a snapshot
+  cmap diff old new                            print keys added, removed and changed between snapshots
+
+Flags of generating:
+`)
+	fs := generateFlags(new(generateOptions))
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+// newFlagSet returns a flag set printing errors to stderr
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
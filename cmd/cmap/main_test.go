@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"github.com/fwhezfwhez/cmap"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "teacher_map.go")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-type", "Teacher", "-package", "model", "-output", output}, &stdout, &stderr); code != 0 {
+		t.Fatalf("want exit 0 but got %d, %s", code, stderr.String())
+	}
+	src, e := os.ReadFile(output)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !strings.HasPrefix(string(src), "// Code generated") || !strings.Contains(string(src), "package model") || !strings.Contains(string(src), "type TeacherMap struct") {
+		t.Fatalf("unexpected generated code:\n%s", src)
+	}
+
+	t.Setenv("GOPACKAGE", "school")
+	stdout.Reset()
+	if code := run([]string{"-type", "Teacher", "-output", "-"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("want exit 0 but got %d, %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "package school") {
+		t.Fatalf("package should default to $GOPACKAGE")
+	}
+
+	if code := run(nil, &stdout, &stderr); code != 2 {
+		t.Fatalf("missing -type should exit 2 but got %d", code)
+	}
+}
+
+func saveSnapshot(t *testing.T, path string, m *cmap.Map) {
+	f, e := os.Create(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer f.Close()
+	if e := m.SaveSnapshot(f); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestInspectAndDiff(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, newPath := filepath.Join(dir, "old.snapshot"), filepath.Join(dir, "new.snapshot")
+
+	m := cmap.NewMap()
+	m.Set("name", "cmap")
+	m.SetEx("session", 1, 100)
+	m.Set("removed", true)
+	m.RPush("list", "a", "b")
+	m.HSet("user", "age", 18)
+	m.SAdd("tags", "go", "cmap")
+	m.ZAdd("rank", 0, cmap.Z{Member: "tom", Score: 1.5})
+	m.DelayPush("jobs", time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC), "send")
+	saveSnapshot(t, oldPath, m)
+
+	m.Set("name", "cmap2")
+	m.HSet("user", "age", 19)
+	m.Delete("removed")
+	m.Set("added", 1)
+	saveSnapshot(t, newPath, m)
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"inspect", oldPath}, &stdout, &stderr); code != 0 {
+		t.Fatalf("want exit 0 but got %d, %s", code, stderr.String())
+	}
+	out := stdout.String()
+	for _, want := range []string{`name`, `"cmap"`, `list`, `["a", "b"]`, `hash`, `{"age": 18}`, `("cmap", "go")`, `zset`, `["tom": 1.5]`, `delay`, `[2030-01-02T03:04:05Z: "send"]`, `1m40s`, `8 keys`} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("inspect output should contain %s:\n%s", want, out)
+		}
+	}
+
+	stdout.Reset()
+	if code := run([]string{"diff", oldPath, newPath}, &stdout, &stderr); code != 1 {
+		t.Fatalf("different snapshots should exit 1 but got %d, %s", code, stderr.String())
+	}
+	out = stdout.String()
+	for _, want := range []string{`+ added 1`, `- removed true`, `~ name "cmap" -> "cmap2"`, `~ user {"age": 18} -> {"age": 19}`, `1 added, 1 removed, 2 changed`} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("diff output should contain %s:\n%s", want, out)
+		}
+	}
+
+	stdout.Reset()
+	if code := run([]string{"diff", newPath, newPath}, &stdout, &stderr); code != 0 {
+		t.Fatalf("same snapshots should exit 0 but got %d", code)
+	}
+
+	os.WriteFile(oldPath, []byte("broken"), 0644)
+	if code := run([]string{"inspect", oldPath}, &stdout, &stderr); code != 1 {
+		t.Fatalf("broken snapshot should exit 1 but got %d", code)
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "model.go"), []byte(`package model
+
+type UserId int64
+
+type Id = UserId
+
+type CourseKey struct {
+	School string
+	Id     Id
+	A, B   bool
+}
+
+type BadKey struct {
+	Tags []string
+}
+`), 0644)
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-type", "Course", "-key", "CourseKey", "-sharded", "-dir", dir, "-output", "-"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("want exit 0 but got %d, %s", code, stderr.String())
+	}
+	for _, want := range []string{
+		"type CourseMapV2 struct",
+		"h = courseHashString(h, string(key.School))",
+		"h = courseHashUint64(h, uint64(key.Id))",
+		"h = courseHashBool(h, bool(key.B))",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Fatalf("generated code should contain %s", want)
+		}
+	}
+
+	stdout.Reset()
+	if code := run([]string{"-type", "string", "-name", "Name", "-key", "UserId", "-sharded", "-dir", dir, "-output", "-"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("want exit 0 but got %d, %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "h = nameHashUint64(h, uint64(key))") {
+		t.Fatalf("named key type should be hashed by its underlying type")
+	}
+
+	for _, key := range []string{"BadKey", "Missing"} {
+		stderr.Reset()
+		if code := run([]string{"-type", "Course", "-key", key, "-dir", dir, "-output", "-"}, &stdout, &stderr); code != 1 {
+			t.Fatalf("key %s should fail but got %d", key, code)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fwhezfwhez/cmap"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// rawCodec keeps encoded values, so snapshots with values of types unknown to this tool can still be read
+type rawCodec struct{}
+
+func (rawCodec) Encode(value interface{}) ([]byte, error) {
+	return value.([]byte), nil
+}
+
+func (rawCodec) Decode(data []byte) (interface{}, error) {
+	return data, nil
+}
+
+func readSnapshot(path string) ([]cmap.SnapshotEntry, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	entries, e := cmap.ReadSnapshot(f, rawCodec{})
+	if e != nil {
+		return nil, fmt.Errorf("%s: %v", path, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+// display decodes an encoded value by gob, values of types not registered are shown by size
+func display(data []byte) string {
+	v, e := cmap.GobCodec{}.Decode(data)
+	if e != nil {
+		return fmt.Sprintf("<%d bytes>", len(data))
+	}
+	return fmt.Sprintf("%#v", v)
+}
+
+func displayEntry(entry cmap.SnapshotEntry) string {
+	switch entry.Kind {
+	case cmap.KIND_LIST:
+		elems := entry.Value.([]interface{})
+		var shown = make([]string, 0, len(elems))
+		for _, elem := range elems {
+			shown = append(shown, display(elem.([]byte)))
+		}
+		return "[" + strings.Join(shown, ", ") + "]"
+	case cmap.KIND_HASH:
+		fields := entry.Value.(map[string]interface{})
+		var shown = make([]string, 0, len(fields))
+		for field, v := range fields {
+			shown = append(shown, fmt.Sprintf("%q: %s", field, display(v.([]byte))))
+		}
+		sort.Strings(shown)
+		return "{" + strings.Join(shown, ", ") + "}"
+	case cmap.KIND_SET:
+		var shown []string
+		for _, member := range entry.Value.([]string) {
+			shown = append(shown, fmt.Sprintf("%q", member))
+		}
+		return "(" + strings.Join(shown, ", ") + ")"
+	case cmap.KIND_ZSET:
+		var shown []string
+		for _, z := range entry.Value.([]cmap.Z) {
+			shown = append(shown, fmt.Sprintf("%q: %v", z.Member, z.Score))
+		}
+		return "[" + strings.Join(shown, ", ") + "]"
+	case cmap.KIND_DELAY:
+		var shown []string
+		for _, item := range entry.Value.([]cmap.DelayItem) {
+			shown = append(shown, fmt.Sprintf("%s: %s", item.Due.UTC().Format(time.RFC3339Nano), display(item.Value.([]byte))))
+		}
+		return "[" + strings.Join(shown, ", ") + "]"
+	}
+	return display(entry.Value.([]byte))
+}
+
+func kindOf(kind cmap.ValueKind) string {
+	switch kind {
+	case cmap.KIND_VALUE:
+		return "value"
+	case cmap.KIND_LIST:
+		return "list"
+	case cmap.KIND_HASH:
+		return "hash"
+	case cmap.KIND_SET:
+		return "set"
+	case cmap.KIND_ZSET:
+		return "zset"
+	case cmap.KIND_DELAY:
+		return "delay"
+	}
+	return fmt.Sprintf("kind(%d)", kind)
+}
+
+func ttlOf(ttl time.Duration) string {
+	if ttl < 0 {
+		return "-"
+	}
+	return ttl.Round(time.Millisecond).String()
+}
+
+func inspect(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := newFlagSet("inspect", stderr)
+	values := fs.Bool("values", true, "print values")
+	if e := fs.Parse(args); e != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: cmap inspect [-values=false] file")
+		return 2
+	}
+
+	entries, e := readSnapshot(fs.Arg(0))
+	if e != nil {
+		fmt.Fprintf(stderr, "cmap: %v\n", e)
+		return 1
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	if *values {
+		fmt.Fprintln(tw, "KEY\tKIND\tTTL\tOFFSET\tVALUE")
+	} else {
+		fmt.Fprintln(tw, "KEY\tKIND\tTTL\tOFFSET")
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d", entry.Key, kindOf(entry.Kind), ttlOf(entry.TTL), entry.Offset)
+		if *values {
+			fmt.Fprintf(tw, "\t%s", displayEntry(entry))
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	fmt.Fprintf(stdout, "%d keys\n", len(entries))
+	return 0
+}
+
+// sameValue compares encoded values of two entries
+func sameValue(a cmap.SnapshotEntry, b cmap.SnapshotEntry) bool {
+	if a.Kind != b.Kind {
+		return false
+	}
+
+	switch a.Kind {
+	case cmap.KIND_LIST:
+		ea, eb := a.Value.([]interface{}), b.Value.([]interface{})
+		if len(ea) != len(eb) {
+			return false
+		}
+		for i := range ea {
+			if !bytes.Equal(ea[i].([]byte), eb[i].([]byte)) {
+				return false
+			}
+		}
+		return true
+	case cmap.KIND_HASH:
+		fa, fb := a.Value.(map[string]interface{}), b.Value.(map[string]interface{})
+		if len(fa) != len(fb) {
+			return false
+		}
+		for field, v := range fa {
+			if w, ok := fb[field]; !ok || !bytes.Equal(v.([]byte), w.([]byte)) {
+				return false
+			}
+		}
+		return true
+	case cmap.KIND_SET:
+		// members are saved sorted
+		return slices.Equal(a.Value.([]string), b.Value.([]string))
+	case cmap.KIND_ZSET:
+		return slices.Equal(a.Value.([]cmap.Z), b.Value.([]cmap.Z))
+	case cmap.KIND_DELAY:
+		return slices.EqualFunc(a.Value.([]cmap.DelayItem), b.Value.([]cmap.DelayItem), func(x, y cmap.DelayItem) bool {
+			return x.Due.Equal(y.Due) && bytes.Equal(x.Value.([]byte), y.Value.([]byte))
+		})
+	}
+	return bytes.Equal(a.Value.([]byte), b.Value.([]byte))
+}
+
+// diff prints '+' for keys only in new, '-' for keys only in old and '~' for keys with changed values.
+// It exits with 1 when snapshots differ, like diff(1).
+func diff(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := newFlagSet("diff", stderr)
+	if e := fs.Parse(args); e != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(stderr, "usage: cmap diff old new")
+		return 2
+	}
+
+	olds, e := readSnapshot(fs.Arg(0))
+	if e != nil {
+		fmt.Fprintf(stderr, "cmap: %v\n", e)
+		return 2
+	}
+	news, e := readSnapshot(fs.Arg(1))
+	if e != nil {
+		fmt.Fprintf(stderr, "cmap: %v\n", e)
+		return 2
+	}
+
+	var added, removed, changed int
+	var i, j int
+	for i < len(olds) || j < len(news) {
+		switch {
+		case j >= len(news) || (i < len(olds) && olds[i].Key < news[j].Key):
+			fmt.Fprintf(stdout, "- %s %s\n", olds[i].Key, displayEntry(olds[i]))
+			removed++
+			i++
+		case i >= len(olds) || news[j].Key < olds[i].Key:
+			fmt.Fprintf(stdout, "+ %s %s\n", news[j].Key, displayEntry(news[j]))
+			added++
+			j++
+		default:
+			if !sameValue(olds[i], news[j]) {
+				fmt.Fprintf(stdout, "~ %s %s -> %s\n", news[j].Key, displayEntry(olds[i]), displayEntry(news[j]))
+				changed++
+			}
+			i++
+			j++
+		}
+	}
+
+	fmt.Fprintf(stdout, "%d added, %d removed, %d changed\n", added, removed, changed)
+	if added+removed+changed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package cmap
+
+import (
+	"fmt"
+	"github.com/fwhezfwhez/errorx"
+)
+
+// compound is a type saving many elements under a key, like hash and set.
+// It's changed only in updateCompound, so a change and the aof record of it are atomic.
+type compound interface {
+	len() int
+}
+
+func errWrongType(key string, command string, typ string) error {
+	return errorx.NewServiceError(fmt.Sprintf("cmap.Map.%s key=%s is not %s elem, cannot execute %s", command, key, typ, command), 1)
+}
+
+// compoundOf returns value of key as T, an error of typ if key holds another type.
+func compoundOf[T compound](m *Map, key string, command string, typ string) (T, bool, error) {
+	var zero T
+	rsi, exist := m.Get(key)
+	if !exist {
+		return zero, false, nil
+	}
+
+	c, ok := rsi.(T)
+	if !ok {
+		return zero, false, errWrongType(key, command, typ)
+	}
+	return c, true, nil
+}
+
+// updateCompound runs f with value of key atomically in all modes, f returns whether the value is changed.
+// If key doesn't exist, a value without time limit is made by create, or nothing is done when create is nil.
+// Key is deleted if the value becomes empty.
+//
+// record makes the aof record of what f changed, it's called after f only when aof is used.
+// A value made by create is logged whole instead, so records always find their value when replayed.
+func updateCompound[T compound](m *Map, key string, command string, typ string, create func() T, f func(c T) bool, record func() *opRecord) error {
+	var err error
+	var created bool
+	m.updateAs(key, func(old Value, exist bool) (Value, int) {
+		var c T
+		if exist {
+			var ok bool
+			if c, ok = old.v.(T); !ok {
+				err = errWrongType(key, command, typ)
+				return old, update_keep
+			}
+		} else {
+			if create == nil {
+				return old, update_keep
+			}
+			c = create()
+			old = Value{v: c, exp: -1}
+			created = true
+		}
+
+		if !f(c) {
+			return old, update_keep
+		}
+		if c.len() == 0 {
+			return old, update_delete
+		}
+		return old, update_set
+	}, false, func() *opRecord {
+		if created || record == nil {
+			return nil
+		}
+		return record()
+	})
+	return err
+}
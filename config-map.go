@@ -26,6 +26,16 @@ func (cm *ConfigMap) SetEx(key string, value interface{}, seconds int) {
 	cm.realtimeMap.SetEx(key, value, seconds)
 }
 
+func (cm *ConfigMap) SetTTL(key string, value interface{}, ttl time.Duration) {
+	cm.refreshHistory(key, value)
+	cm.realtimeMap.SetTTL(key, value, ttl)
+}
+
+func (cm *ConfigMap) SetExpireAt(key string, value interface{}, deadline time.Time) {
+	cm.refreshHistory(key, value)
+	cm.realtimeMap.SetExpireAt(key, value, deadline)
+}
+
 func (cm *ConfigMap) refreshHistory(key string, value interface{}) {
 	cm.historyMap.Set(key, value)
 	cm.historyMap.Delete(fmt.Sprintf("cmap:key_using_history_start_timeunix:%s", key))
@@ -0,0 +1,276 @@
+package cmap
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// DelayItem is an item of a delay queue with the time it's due.
+type DelayItem struct {
+	Value interface{}
+	Due   time.Time
+}
+
+// delayEntry is an item in cdelay, entries due at the same time are ordered by seq
+type delayEntry struct {
+	value interface{}
+	due   int64
+	seq   int64
+}
+
+// delayHeap orders entries by due time, then by push order
+type delayHeap []delayEntry
+
+func (h delayHeap) Len() int { return len(h) }
+func (h delayHeap) Less(i, j int) bool {
+	if h[i].due != h[j].due {
+		return h[i].due < h[j].due
+	}
+	return h[i].seq < h[j].seq
+}
+func (h delayHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *delayHeap) Push(x interface{}) {
+	*h = append(*h, x.(delayEntry))
+}
+func (h *delayHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = delayEntry{}
+	*h = old[:len(old)-1]
+	return e
+}
+
+// cdelay is the delay queue type made by DelayPush, items are polled in order of due time.
+type cdelay struct {
+	h   delayHeap
+	seq int64
+	l   *sync.RWMutex
+}
+
+func newcdelay() *cdelay {
+	return &cdelay{
+		l: &sync.RWMutex{},
+	}
+}
+
+func (d *cdelay) push(value interface{}, due int64) {
+	d.l.Lock()
+	defer d.l.Unlock()
+	d.seq++
+	heap.Push(&d.h, delayEntry{value: value, due: due, seq: d.seq})
+}
+
+// poll pops the first item if it's due at now
+func (d *cdelay) poll(now int64) (interface{}, bool) {
+	d.l.Lock()
+	defer d.l.Unlock()
+	if len(d.h) == 0 || d.h[0].due > now {
+		return nil, false
+	}
+	return heap.Pop(&d.h).(delayEntry).value, true
+}
+
+// next returns due time of the first item
+func (d *cdelay) next() (int64, bool) {
+	d.l.RLock()
+	defer d.l.RUnlock()
+	if len(d.h) == 0 {
+		return 0, false
+	}
+	return d.h[0].due, true
+}
+
+func (d *cdelay) len() int {
+	d.l.RLock()
+	defer d.l.RUnlock()
+	return len(d.h)
+}
+
+// size estimates bytes of items, 40 per item
+func (d *cdelay) size() int64 {
+	return 40 * int64(d.len())
+}
+
+// all returns items in order of due time
+func (d *cdelay) all() []DelayItem {
+	d.l.RLock()
+	var entries = make(delayHeap, len(d.h))
+	copy(entries, d.h)
+	d.l.RUnlock()
+
+	var items = make([]DelayItem, 0, len(entries))
+	for len(entries) > 0 {
+		e := heap.Pop(&entries).(delayEntry)
+		items = append(items, DelayItem{Value: e.value, Due: time.Unix(0, e.due)})
+	}
+	return items
+}
+
+// pollNotifier wakes up BPoll callers when items are pushed to their keys
+type pollNotifier struct {
+	l     *sync.Mutex
+	waits map[string]chan struct{}
+}
+
+func newPollNotifier() *pollNotifier {
+	return &pollNotifier{
+		l:     &sync.Mutex{},
+		waits: make(map[string]chan struct{}),
+	}
+}
+
+// wait returns a channel closed by the next notify of key
+func (n *pollNotifier) wait(key string) <-chan struct{} {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	ch, exist := n.waits[key]
+	if !exist {
+		ch = make(chan struct{})
+		n.waits[key] = ch
+	}
+	return ch
+}
+
+func (n *pollNotifier) notify(key string) {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	if ch, exist := n.waits[key]; exist {
+		close(ch)
+		delete(n.waits, key)
+	}
+}
+
+// delayOf returns delay queue of key, an error if key holds another type.
+func (m *Map) delayOf(key string, command string) (*cdelay, bool, error) {
+	return compoundOf[*cdelay](m, key, command, TYPE_DELAY)
+}
+
+// DelayPush adds values to delay queue of key, they are polled after due. The delay queue is made if key doesn't exist.
+// It returns number of items in the delay queue.
+func (m *Map) DelayPush(key string, due time.Time, values ...interface{}) (int, error) {
+	var n int
+	e := updateCompound(m, key, "DelayPush", TYPE_DELAY, newcdelay, func(d *cdelay) bool {
+		for _, value := range values {
+			d.push(value, due.UnixNano())
+		}
+		n = d.len()
+		return len(values) > 0
+	}, func() *opRecord {
+		var items = make([]DelayItem, len(values))
+		for i, value := range values {
+			items[i] = DelayItem{Value: value, Due: due}
+		}
+		return delaysRecord(aofOpDelayPush, items)
+	})
+	if e != nil {
+		return 0, e
+	}
+	m.polls.notify(key)
+	return n, nil
+}
+
+// Poll pops the item due earliest from delay queue of key, if its time has come.
+// Items due at the same time are polled in order of pushing. Key is deleted when the delay queue becomes empty.
+func (m *Map) Poll(key string) (interface{}, bool, error) {
+	var value interface{}
+	var ok bool
+	e := updateCompound(m, key, "Poll", TYPE_DELAY, nil, func(d *cdelay) bool {
+		value, ok = d.poll(time.Now().UnixNano())
+		return ok
+	}, func() *opRecord {
+		return &opRecord{op: aofOpDelayPop}
+	})
+	return value, ok, e
+}
+
+// BPoll is Poll blocking until an item of delay queue of key is due, timeout elapses, ctx is done or map is closed.
+// timeout <= 0 blocks without time limit. It returns false on timeout.
+func (m *Map) BPoll(ctx context.Context, timeout time.Duration, key string) (interface{}, bool, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		if e := ctx.Err(); e != nil {
+			return nil, false, e
+		}
+		if m.blocked.isClosed() {
+			return nil, false, ErrMapClosed
+		}
+
+		// wait before polling, so a push between them isn't missed
+		pushed := m.polls.wait(key)
+		value, ok, e := m.Poll(key)
+		if e != nil || ok {
+			return value, ok, e
+		}
+
+		d, _, e := m.delayOf(key, "BPoll")
+		if e != nil {
+			return nil, false, e
+		}
+		// wake up when the first item is due
+		var due = time.NewTimer(time.Hour)
+		if d != nil {
+			if next, ok := d.next(); ok {
+				due.Reset(time.Duration(next - time.Now().UnixNano()))
+			}
+		}
+
+		select {
+		case <-pushed:
+		case <-due.C:
+		case <-deadline:
+			due.Stop()
+			return nil, false, nil
+		case <-ctx.Done():
+			due.Stop()
+			return nil, false, ctx.Err()
+		case <-m.blocked.done:
+			due.Stop()
+			return nil, false, ErrMapClosed
+		}
+		due.Stop()
+	}
+}
+
+// DelayLen returns number of items in delay queue of key, due or not.
+func (m *Map) DelayLen(key string) (int, error) {
+	d, exist, e := m.delayOf(key, "DelayLen")
+	if e != nil || !exist {
+		return 0, e
+	}
+	return d.len(), nil
+}
+
+// DelayItems returns items of delay queue of key in order of due time, due or not.
+func (m *Map) DelayItems(key string) ([]DelayItem, error) {
+	d, exist, e := m.delayOf(key, "DelayItems")
+	if e != nil || !exist {
+		return []DelayItem{}, e
+	}
+	return d.all(), nil
+}
+
+func (mv2 *MapV2) DelayPush(key string, due time.Time, values ...interface{}) (int, error) {
+	return mv2.getslot(key).DelayPush(key, due, values...)
+}
+func (mv2 *MapV2) Poll(key string) (interface{}, bool, error) {
+	return mv2.getslot(key).Poll(key)
+}
+func (mv2 *MapV2) BPoll(ctx context.Context, timeout time.Duration, key string) (interface{}, bool, error) {
+	return mv2.getslot(key).BPoll(ctx, timeout, key)
+}
+func (mv2 *MapV2) DelayLen(key string) (int, error) {
+	return mv2.getslot(key).DelayLen(key)
+}
+func (mv2 *MapV2) DelayItems(key string) ([]DelayItem, error) {
+	return mv2.getslot(key).DelayItems(key)
+}
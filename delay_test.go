@@ -0,0 +1,190 @@
+package cmap
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDelayQueue(t *testing.T) {
+	m := NewMap()
+	now := time.Now()
+
+	m.DelayPush("jobs", now.Add(time.Hour), "later")
+	m.DelayPush("jobs", now.Add(-time.Second), "b", "c")
+	if n, _ := m.DelayPush("jobs", now.Add(-time.Minute), "a"); n != 4 {
+		t.Fatalf("want 4 but got %d", n)
+	}
+	if m.Type("jobs") != TYPE_DELAY {
+		t.Fatalf("want delay but got %s", m.Type("jobs"))
+	}
+
+	// in order of due time, then of pushing
+	for _, want := range []string{"a", "b", "c"} {
+		if v, ok, _ := m.Poll("jobs"); !ok || v != want {
+			t.Fatalf("want %s but got %v", want, v)
+		}
+	}
+	if v, ok, _ := m.Poll("jobs"); ok {
+		t.Fatalf("item not due should not be polled but got %v", v)
+	}
+	if items, _ := m.DelayItems("jobs"); len(items) != 1 || items[0].Value != "later" || !items[0].Due.Equal(now.Add(time.Hour)) {
+		t.Fatalf("unexpected items %v", items)
+	}
+
+	m.DelayPush("once", now, "x")
+	m.Poll("once")
+	if m.Type("once") != TYPE_NONE {
+		t.Fatalf("empty delay queue should be deleted")
+	}
+
+	m.Set("name", "cmap")
+	if _, e := m.DelayPush("name", now, "x"); e == nil {
+		t.Fatalf("DelayPush on string should fail")
+	}
+	if _, _, e := m.Poll("name"); e == nil {
+		t.Fatalf("Poll on string should fail")
+	}
+	if _, e := m.RPush("jobs", "x"); e == nil {
+		t.Fatalf("RPush on delay queue should fail")
+	}
+}
+
+func TestBPoll(t *testing.T) {
+	m := NewMap()
+	ctx := context.Background()
+
+	// wakes up when the item is due
+	m.DelayPush("jobs", time.Now().Add(30*time.Millisecond), "a")
+	start := time.Now()
+	if v, ok, _ := m.BPoll(ctx, time.Second, "jobs"); !ok || v != "a" {
+		t.Fatalf("want a but got %v", v)
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Fatalf("item should not be polled before due")
+	}
+
+	// an item pushed due earlier wakes up the caller
+	m.DelayPush("jobs", time.Now().Add(time.Hour), "later")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.DelayPush("jobs", time.Now(), "now")
+	}()
+	if v, ok, _ := m.BPoll(ctx, time.Second, "jobs"); !ok || v != "now" {
+		t.Fatalf("want now but got %v", v)
+	}
+
+	if _, ok, e := m.BPoll(ctx, 20*time.Millisecond, "jobs"); ok || e != nil {
+		t.Fatalf("want timeout but got %v %v", ok, e)
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, _, e := m.BPoll(cctx, 0, "jobs"); e != context.DeadlineExceeded {
+		t.Fatalf("want context.DeadlineExceeded but got %v", e)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.Close()
+	}()
+	if _, _, e := m.BPoll(ctx, 0, "jobs"); e != ErrMapClosed {
+		t.Fatalf("want ErrMapClosed but got %v", e)
+	}
+}
+
+func TestDelayQueueInBusyMode(t *testing.T) {
+	m := NewMap()
+
+	var stop = make(chan struct{})
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.ClearExpireKeys()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var polled = make(chan interface{}, 4000)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				m.DelayPush("jobs", time.Now(), i*500+j)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				if v, ok, e := m.BPoll(context.Background(), 5*time.Second, "jobs"); ok && e == nil {
+					polled <- v
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-done
+	close(polled)
+
+	var seen = make(map[interface{}]bool)
+	for v := range polled {
+		if seen[v] {
+			t.Fatalf("%v is polled twice", v)
+		}
+		seen[v] = true
+	}
+	if len(seen) != 4000 {
+		t.Fatalf("want 4000 polled but got %d", len(seen))
+	}
+}
+
+func TestDelayQueuePersistence(t *testing.T) {
+	due := time.Now().Add(time.Hour)
+	m := NewMap()
+	m.DelayPush("jobs", due, "b")
+	m.DelayPush("jobs", time.Now(), "a")
+
+	var buf bytes.Buffer
+	if e := m.SaveSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+	m2 := NewMap()
+	if e := m2.LoadSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+	if items, _ := m2.DelayItems("jobs"); len(items) != 2 || items[0].Value != "a" || !items[1].Due.Equal(due) {
+		t.Fatalf("unexpected items %v", items)
+	}
+
+	path := filepath.Join(t.TempDir(), "cmap.aof")
+	aof := openTestAOF(t, path, FSYNC_ALWAYS)
+	m3 := NewMap()
+	if e := m3.UseAOF(aof); e != nil {
+		t.Fatal(e)
+	}
+	m3.DelayPush("jobs", due, "b")
+	m3.DelayPush("jobs", time.Now(), "a")
+	m3.Poll("jobs")
+	aof.Close()
+
+	aof2 := openTestAOF(t, path, FSYNC_ALWAYS)
+	defer aof2.Close()
+	m4 := NewMap()
+	if e := m4.UseAOF(aof2); e != nil {
+		t.Fatal(e)
+	}
+	if items, _ := m4.DelayItems("jobs"); len(items) != 1 || items[0].Value != "b" {
+		t.Fatalf("unexpected items %v", items)
+	}
+}
@@ -0,0 +1,293 @@
+package cmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EvictionPolicy decides which key will be evicted when a bounded map is full.
+// They mirror redis maxmemory-policy options.
+//
+// Unlike redis refusing writes, a map under EVICT_VOLATILE_* policies with no key with time limit left to evict
+// evicts keys without time limit, by the same kind of policy, so the map never grows past its caps.
+// EVICT_VOLATILE_TTL evicts them at random.
+type EvictionPolicy int
+
+const (
+	EVICT_ALLKEYS_LRU     EvictionPolicy = iota // evict the least recently used key
+	EVICT_ALLKEYS_LFU                           // evict the least frequently used key
+	EVICT_ALLKEYS_RANDOM                        // evict a random key
+	EVICT_VOLATILE_LRU                          // evict the least recently used key among keys with time limit
+	EVICT_VOLATILE_LFU                          // evict the least frequently used key among keys with time limit
+	EVICT_VOLATILE_RANDOM                       // evict a random key among keys with time limit
+	EVICT_VOLATILE_TTL                          // evict the key with the nearest expire time
+)
+
+// default number of keys sampled to choose a victim, the same as redis maxmemory-samples
+const defaultEvictionSamples = 5
+
+// WithMaxEntries caps the number of keys.
+// For MapV2, the cap is shared equally by slots.
+func WithMaxEntries(n int) MapOption {
+	return func(c *mapConfig) {
+		c.maxEntries = n
+	}
+}
+
+// WithMaxBytes caps the estimated bytes of keys and values.
+// For MapV2, the cap is shared equally by slots.
+func WithMaxBytes(n int64) MapOption {
+	return func(c *mapConfig) {
+		c.maxBytes = n
+	}
+}
+
+// WithEvictionPolicy sets the eviction policy, default EVICT_ALLKEYS_LRU.
+func WithEvictionPolicy(policy EvictionPolicy) MapOption {
+	return func(c *mapConfig) {
+		c.policy = policy
+	}
+}
+
+// WithEvictionSamples sets how many keys are sampled to choose a victim, default 5.
+// Larger samples make eviction more accurate and slower.
+func WithEvictionSamples(n int) MapOption {
+	return func(c *mapConfig) {
+		c.samples = n
+	}
+}
+
+// WithSizer sets the function estimating bytes of a key-value, used by WithMaxBytes.
+func WithSizer(sizer func(key string, value interface{}) int64) MapOption {
+	return func(c *mapConfig) {
+		c.sizer = sizer
+	}
+}
+
+// sized is implemented by values estimating their own bytes for the default sizer.
+// It's called on every write of a bounded map, so it must not walk elements.
+type sized interface {
+	size() int64
+}
+
+// estimateSize is the default sizer
+func estimateSize(key string, value interface{}) int64 {
+	// Value struct and map bucket overhead
+	var size int64 = 48 + int64(len(key))
+
+	switch v := value.(type) {
+	case string:
+		size += int64(len(v))
+	case []byte:
+		size += int64(len(v))
+	case bool, int8, uint8:
+		size += 1
+	case int16, uint16:
+		size += 2
+	case int32, uint32, float32:
+		size += 4
+	case int, int64, uint, uint64, float64:
+		size += 8
+	case sized:
+		size += v.size()
+	default:
+		size += 16
+	}
+	return size
+}
+
+// entryStat records what eviction needs of a key
+type entryStat struct {
+	size int64
+	exp  int64
+
+	// unixnano of last access, accessed atomically
+	access int64
+	// access times, accessed atomically
+	hits int64
+}
+
+// evictor tracks keys of a bounded map and chooses victims.
+// Stats are approximate, they are updated after map registers are written.
+type evictor struct {
+	c mapConfig
+
+	l       *sync.RWMutex
+	bytes   int64
+	entries map[string]*entryStat
+}
+
+func newEvictor(c mapConfig) *evictor {
+	return &evictor{
+		c:       c,
+		l:       &sync.RWMutex{},
+		entries: make(map[string]*entryStat),
+	}
+}
+
+func (e *evictor) onWrite(key string, value interface{}, exp int64) {
+	size := e.c.sizer(key, value)
+	now := time.Now().UnixNano()
+
+	e.l.Lock()
+	defer e.l.Unlock()
+
+	stat, exist := e.entries[key]
+	if !exist {
+		e.entries[key] = &entryStat{
+			size:   size,
+			exp:    exp,
+			access: now,
+			hits:   1,
+		}
+		e.bytes += size
+		return
+	}
+
+	e.bytes += size - stat.size
+	stat.size = size
+	stat.exp = exp
+	atomic.StoreInt64(&stat.access, now)
+	atomic.AddInt64(&stat.hits, 1)
+}
+
+func (e *evictor) onRead(key string) {
+	e.l.RLock()
+	stat, exist := e.entries[key]
+	e.l.RUnlock()
+
+	if !exist {
+		return
+	}
+	atomic.StoreInt64(&stat.access, time.Now().UnixNano())
+	atomic.AddInt64(&stat.hits, 1)
+}
+
+func (e *evictor) onDelete(key string) {
+	e.l.Lock()
+	defer e.l.Unlock()
+
+	stat, exist := e.entries[key]
+	if !exist {
+		return
+	}
+	e.bytes -= stat.size
+	delete(e.entries, key)
+}
+
+// clearExpired drops stats of all expired keys
+func (e *evictor) clearExpired() {
+	now := time.Now().UnixNano()
+
+	e.l.Lock()
+	defer e.l.Unlock()
+
+	for k, stat := range e.entries {
+		if stat.exp != -1 && stat.exp <= now {
+			e.bytes -= stat.size
+			delete(e.entries, k)
+		}
+	}
+}
+
+func (e *evictor) overflow() bool {
+	e.l.RLock()
+	defer e.l.RUnlock()
+
+	if e.c.maxEntries > 0 && len(e.entries) > e.c.maxEntries {
+		return true
+	}
+	if e.c.maxBytes > 0 && e.bytes > e.c.maxBytes {
+		return true
+	}
+	return false
+}
+
+// pick samples keys and returns the victim by policy, except is never chosen.
+// Expired keys are chosen first. It returns false if no key can be evicted.
+func (e *evictor) pick(except string) (string, bool) {
+	e.l.RLock()
+	defer e.l.RUnlock()
+
+	volatile := e.c.policy == EVICT_VOLATILE_LRU ||
+		e.c.policy == EVICT_VOLATILE_LFU ||
+		e.c.policy == EVICT_VOLATILE_RANDOM ||
+		e.c.policy == EVICT_VOLATILE_TTL
+
+	victim, ok := e.sample(except, volatile)
+	if !ok && volatile {
+		// no key with time limit, see EvictionPolicy
+		victim, ok = e.sample(except, false)
+	}
+	return victim, ok
+}
+
+// sample returns the victim of sampled keys, only keys with time limit are sampled if volatile is true.
+// Caller must hold e.l.
+func (e *evictor) sample(except string, volatile bool) (string, bool) {
+	now := time.Now().UnixNano()
+
+	var victim string
+	var victimStat *entryStat
+	var sampled int
+
+	// map ranging starts at a random position
+	for k, stat := range e.entries {
+		if k == except {
+			continue
+		}
+		if stat.exp != -1 && stat.exp <= now {
+			return k, true
+		}
+		if volatile && stat.exp == -1 {
+			continue
+		}
+
+		if victimStat == nil || e.better(stat, victimStat) {
+			victim, victimStat = k, stat
+		}
+
+		sampled++
+		if sampled >= e.c.samples {
+			break
+		}
+	}
+
+	return victim, victimStat != nil
+}
+
+// better returns whether a is more proper to be evicted than b
+func (e *evictor) better(a, b *entryStat) bool {
+	switch e.c.policy {
+	case EVICT_ALLKEYS_LRU, EVICT_VOLATILE_LRU:
+		return atomic.LoadInt64(&a.access) < atomic.LoadInt64(&b.access)
+	case EVICT_ALLKEYS_LFU, EVICT_VOLATILE_LFU:
+		ha, hb := atomic.LoadInt64(&a.hits), atomic.LoadInt64(&b.hits)
+		if ha != hb {
+			return ha < hb
+		}
+		return atomic.LoadInt64(&a.access) < atomic.LoadInt64(&b.access)
+	case EVICT_VOLATILE_TTL:
+		return a.exp < b.exp
+	}
+	// random policies take the first sampled one
+	return false
+}
+
+// evictIfNeeded evicts keys until the map is under its caps.
+// It must be called without holding any lock of m, since eviction goes through m.remove so that
+// in M_BUSY mode the deletion is appended to del and won't be resurrected by write replay.
+func (m *Map) evictIfNeeded(except string) {
+	if m.evict == nil {
+		return
+	}
+
+	for m.evict.overflow() {
+		victim, ok := m.evict.pick(except)
+		if !ok {
+			return
+		}
+		m.remove(victim, REASON_EVICTED)
+	}
+}
@@ -0,0 +1,175 @@
+package cmap
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEvictLRU(t *testing.T) {
+	m := NewMap(WithMaxEntries(100), WithEvictionPolicy(EVICT_ALLKEYS_LRU), WithEvictionSamples(1000))
+
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	// touch first 10 keys
+	time.Sleep(time.Millisecond)
+	for i := 0; i < 10; i++ {
+		m.Get(strconv.Itoa(i))
+	}
+
+	for i := 100; i < 150; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	if m.Len() != 100 {
+		t.Fatalf("want 100 keys but got %d", m.Len())
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok := m.Get(strconv.Itoa(i)); !ok {
+			t.Fatalf("recently used key %d should not be evicted", i)
+		}
+	}
+	for i := 100; i < 150; i++ {
+		if _, ok := m.Get(strconv.Itoa(i)); !ok {
+			t.Fatalf("new key %d should not be evicted", i)
+		}
+	}
+}
+
+func TestEvictVolatileTTL(t *testing.T) {
+	m := NewMap(WithMaxEntries(10), WithEvictionPolicy(EVICT_VOLATILE_TTL), WithEvictionSamples(100))
+
+	for i := 0; i < 5; i++ {
+		m.Set("persist-"+strconv.Itoa(i), i)
+	}
+	for i := 0; i < 5; i++ {
+		m.SetEx("volatile-"+strconv.Itoa(i), i, 100+i)
+	}
+
+	m.Set("new", 1)
+
+	if _, ok := m.Get("volatile-0"); ok {
+		t.Fatalf("key with the nearest expire time should be evicted")
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok := m.Get("persist-" + strconv.Itoa(i)); !ok {
+			t.Fatalf("key without time limit should not be evicted")
+		}
+	}
+}
+
+func TestEvictVolatileWithoutTTLKeys(t *testing.T) {
+	for _, policy := range []EvictionPolicy{EVICT_VOLATILE_LRU, EVICT_VOLATILE_LFU, EVICT_VOLATILE_RANDOM, EVICT_VOLATILE_TTL} {
+		m := NewMap(WithMaxEntries(10), WithEvictionPolicy(policy))
+		for i := 0; i < 100; i++ {
+			m.Set("persist-"+strconv.Itoa(i), i)
+		}
+		if n := m.Len(); n != 10 {
+			t.Fatalf("policy %d: keys without time limit should be evicted when none has, want 10 keys but got %d", policy, n)
+		}
+		if _, ok := m.Get("persist-99"); !ok {
+			t.Fatalf("policy %d: the key written should not be evicted", policy)
+		}
+	}
+}
+
+func TestEvictMaxBytes(t *testing.T) {
+	m := NewMap(WithMaxBytes(1000), WithSizer(func(key string, value interface{}) int64 {
+		return 100
+	}))
+
+	for i := 0; i < 20; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	if m.Len() != 10 {
+		t.Fatalf("want 10 keys but got %d", m.Len())
+	}
+}
+
+func TestEstimateSizeOfCompound(t *testing.T) {
+	m := NewMap()
+	m.RPush("list", 1)
+	m.HSet("hash", "f", 1)
+	m.SAdd("set", "a")
+	m.ZAdd("zset", 0, Z{Member: "a", Score: 1})
+	m.DelayPush("delay", time.Now(), 1)
+
+	// adds 10 elements to v
+	var grow = map[string]func(v interface{}){
+		"list": func(v interface{}) {
+			for i := 0; i < 10; i++ {
+				v.(*clist).RPush(i)
+			}
+		},
+		"hash": func(v interface{}) {
+			for i := 0; i < 10; i++ {
+				v.(*chash).set(strconv.Itoa(i), i)
+			}
+		},
+		"set": func(v interface{}) {
+			for i := 0; i < 10; i++ {
+				v.(*cset).add(strconv.Itoa(i))
+			}
+		},
+		"zset": func(v interface{}) {
+			for i := 0; i < 10; i++ {
+				v.(*czset).add(strconv.Itoa(i), float64(i), 0, false)
+			}
+		},
+		"delay": func(v interface{}) {
+			for i := 0; i < 10; i++ {
+				v.(*cdelay).push(i, 0)
+			}
+		},
+	}
+	for key, f := range grow {
+		v, _ := m.Get(key)
+		small := estimateSize(key, v)
+		f(v)
+		if big := estimateSize(key, v); big <= small {
+			t.Fatalf("size of %s should grow with elements, %d -> %d", key, small, big)
+		}
+	}
+}
+
+func TestEvictInBusyMode(t *testing.T) {
+	m := NewMap(WithMaxEntries(10), WithEvictionSamples(100))
+
+	for i := 0; i < 10; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	m.setBusy()
+	for i := 10; i < 20; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	// migrate write and del into m
+	m.ClearExpireKeys()
+
+	if m.Len() != 10 {
+		t.Fatalf("evicted keys are resurrected, want 10 keys but got %d", m.Len())
+	}
+	for i := 10; i < 20; i++ {
+		if _, ok := m.Get(strconv.Itoa(i)); !ok {
+			t.Fatalf("key %d written in busy mode is lost", i)
+		}
+	}
+}
+
+func TestMapV2Evict(t *testing.T) {
+	m := NewMapV2(nil, 4, 5*time.Minute, WithMaxEntries(40), WithEvictionPolicy(EVICT_ALLKEYS_RANDOM))
+	defer m.Clear()
+
+	for i := 0; i < 1000; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	var n int
+	for i, _ := range m.slots {
+		n += m.slots[i].Len()
+	}
+	if n > 40 {
+		t.Fatalf("want at most 40 keys but got %d", n)
+	}
+}
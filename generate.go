@@ -1,16 +1,251 @@
 package cmap
 
-import "strings"
+import (
+	"bytes"
+	"fmt"
+	"github.com/fwhezfwhez/errorx"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// GenerateConfig configures the typed map generated by Generate.
+type GenerateConfig struct {
+	// package of the generated file, default 'model'
+	Package string
+	// value type of the map, any go type expression, like Teacher, *Teacher, int64, []byte
+	Type string
+	// prefix of generated names, like TeacherMap, NewTeacherMap.
+	// Default is Type without '*' and package qualifier, capitalized. It's required when Type is not a name, like []byte.
+	Name string
+	// generate Incr, IncrBy, Decr... methods, value type must be an integer type.
+	// It's turned on automatically when Type is a builtin integer type.
+	Integer bool
+
+	// key type of the map, default string. It must be comparable.
+	// Keys of builtin types are hashed directly, named types need KeyBase, struct types need KeyFields.
+	Key string
+	// builtin type underlying Key, when Key is a named type like `type UserId int64`
+	KeyBase string
+	// fields of Key, when Key is a struct type. Fields are hashed in order.
+	KeyFields []KeyField
+
+	// also generate ${Name}MapV2, a combination of <hash, ${Name}Map> like MapV2
+	Sharded bool
+}
+
+// KeyField is a field of struct key type.
+type KeyField struct {
+	Name string
+	// builtin type of the field, or builtin type underlying it
+	Type string
+}
+
+// builtin integer types enable GenerateConfig.Integer automatically
+var integerTypes = map[string]bool{
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"byte": true, "rune": true,
+}
+
+// how builtin types are hashed, %s is the expression to hash
+var hashOfTypes = map[string]string{
+	"string": "HashString(h, string(%s))",
+	"bool":   "HashBool(h, bool(%s))",
+
+	"float32": "HashUint64(h, math.Float64bits(float64(%s)))",
+	"float64": "HashUint64(h, math.Float64bits(float64(%s)))",
+
+	"int": "HashUint64(h, uint64(%s))", "int8": "HashUint64(h, uint64(%s))", "int16": "HashUint64(h, uint64(%s))",
+	"int32": "HashUint64(h, uint64(%s))", "int64": "HashUint64(h, uint64(%s))",
+	"uint": "HashUint64(h, uint64(%s))", "uint8": "HashUint64(h, uint64(%s))", "uint16": "HashUint64(h, uint64(%s))",
+	"uint32": "HashUint64(h, uint64(%s))", "uint64": "HashUint64(h, uint64(%s))", "uintptr": "HashUint64(h, uint64(%s))",
+	"byte": "HashUint64(h, uint64(%s))", "rune": "HashUint64(h, uint64(%s))",
+}
+
+type generateData struct {
+	Package string
+	Type    string
+	Name    string
+	Lower   string
+	Upper   string
+	Integer bool
+
+	Key     string
+	Sharded bool
+	// statements hashing key into h
+	Hash []string
+}
+
+func (c GenerateConfig) data() (generateData, error) {
+	d := generateData{
+		Package: c.Package,
+		Type:    strings.TrimSpace(c.Type),
+		Name:    c.Name,
+		Integer: c.Integer || integerTypes[strings.TrimSpace(c.Type)],
+	}
+
+	if d.Package == "" {
+		d.Package = "model"
+	}
+	if !token.IsIdentifier(d.Package) {
+		return d, errorx.NewFromStringf("cmap.Generate: invalid package name '%s'", d.Package)
+	}
+
+	if d.Type == "" {
+		return d, errorx.NewFromString("cmap.Generate: Type is required")
+	}
+	if _, e := parser.ParseExpr(d.Type); e != nil {
+		return d, errorx.NewFromStringf("cmap.Generate: invalid type '%s': %s", d.Type, e.Error())
+	}
+
+	if d.Name == "" {
+		name := strings.TrimLeft(d.Type, "*")
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+		d.Name = name
+	}
+	if !token.IsIdentifier(d.Name) {
+		return d, errorx.NewFromStringf("cmap.Generate: can't name the map of type '%s', Name is required", d.Type)
+	}
+	r := []rune(d.Name)
+	r[0] = unicode.ToUpper(r[0])
+	d.Name = string(r)
+
+	r[0] = unicode.ToLower(r[0])
+	d.Lower = string(r)
+	d.Upper = strings.ToUpper(d.Name)
+
+	if e := c.key(&d); e != nil {
+		return d, e
+	}
+	return d, nil
+}
+
+// key validates key type and generates statements hashing it
+func (c GenerateConfig) key(d *generateData) error {
+	d.Key = strings.TrimSpace(c.Key)
+	d.Sharded = c.Sharded
+	if d.Key == "" {
+		d.Key = "string"
+	}
+	if _, e := parser.ParseExpr(d.Key); e != nil {
+		return errorx.NewFromStringf("cmap.Generate: invalid key type '%s': %s", d.Key, e.Error())
+	}
+
+	hashOf := func(typ string, expr string) (string, error) {
+		format, ok := hashOfTypes[strings.TrimSpace(typ)]
+		if !ok {
+			return "", errorx.NewFromStringf("cmap.Generate: can't hash '%s' of type '%s', only builtin types are supported", expr, typ)
+		}
+		return "h = " + d.Lower + fmt.Sprintf(format, expr), nil
+	}
+
+	if len(c.KeyFields) > 0 {
+		for _, f := range c.KeyFields {
+			if !token.IsIdentifier(f.Name) {
+				return errorx.NewFromStringf("cmap.Generate: invalid field name '%s' of key type '%s'", f.Name, d.Key)
+			}
+			stmt, e := hashOf(f.Type, "key."+f.Name)
+			if e != nil {
+				return e
+			}
+			d.Hash = append(d.Hash, stmt)
+		}
+		return nil
+	}
+
+	base := c.KeyBase
+	if base == "" {
+		base = d.Key
+	}
+	if _, ok := hashOfTypes[strings.TrimSpace(base)]; !ok {
+		return errorx.NewFromStringf("cmap.Generate: KeyBase or KeyFields is required to hash key type '%s'", d.Key)
+	}
+	stmt, e := hashOf(base, "key")
+	if e != nil {
+		return e
+	}
+	d.Hash = append(d.Hash, stmt)
+	return nil
+}
+
+// Generate returns gofmt'ed source of a concurrently safe map of c.Type.
+// The generated map has the same registers, modes and api as Map, without assertion of interface{}.
+func Generate(c GenerateConfig) ([]byte, error) {
+	d, e := c.data()
+	if e != nil {
+		return nil, e
+	}
+	return d.generate()
+}
+
+func (d generateData) generate() ([]byte, error) {
+	var buf bytes.Buffer
+	if e := typedMapTemplate.Execute(&buf, d); e != nil {
+		return nil, errorx.Wrap(e)
+	}
+	if d.Sharded {
+		if e := typedMapV2Template.Execute(&buf, d); e != nil {
+			return nil, errorx.Wrap(e)
+		}
+	}
 
+	src, e := format.Source(buf.Bytes())
+	if e != nil {
+		return nil, errorx.NewFromStringf("cmap.Generate: generated code of type '%s' is invalid: %s", d.Type, e.Error())
+	}
+	return src, nil
+}
+
+// GenerateTypeSyncMap generates a map of Model, args["${package_name}"] is package of the generated file, default 'model'.
+// args["${model}"] and args["${MODEL}"] prefix unexported names and constants, other args replace text of the generated file.
+// It returns an empty string if Model is not a valid type, use GenerateTypeSyncMapE to get the error.
 func GenerateTypeSyncMap(Model string, args map[string]string) string {
-	args["${Model}"] = Model
-	initArgs(args)
+	src, _ := GenerateTypeSyncMapE(Model, args)
+	return src
+}
+
+// GenerateTypeSyncMapE works as GenerateTypeSyncMap, and returns the error if Model or args make invalid code.
+func GenerateTypeSyncMapE(Model string, args map[string]string) (string, error) {
+	d, e := GenerateConfig{Package: args["${package_name}"], Type: Model}.data()
+	if e != nil {
+		return "", e
+	}
+	if args["${model}"] != "" {
+		d.Lower = args["${model}"]
+	}
+	if args["${MODEL}"] != "" {
+		d.Upper = args["${MODEL}"]
+	}
+
+	src, e := d.generate()
+	if e != nil {
+		return "", e
+	}
+	return replace(string(src), args), nil
+}
+
+// replace replaces every key of args in format by its value
+func replace(format string, args map[string]string) string {
+	var rs = format
+	// rs = strings.Replace(format, "${package_name}", "model", -1)
+	for k, v := range args {
+		rs = strings.Replace(rs, k, v, -1)
+	}
+	return rs
+}
 
-	format := `
-package ${package_name}
+var typedMapTemplate = template.Must(template.New("typed-map").Parse(`
+package {{.Package}}
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"sync"
@@ -18,35 +253,58 @@ import (
 	"time"
 )
 
-// Auto-generate by github.com/fwhezfwhez/cmap.GenerateTypeSyncMap()
-// Note:
-// You might put this auto-genereted file to the package where ${Model}{} is defined.
-// How to use this auto-generate concurrently-safe ${Model}Map?
+// Generated by github.com/fwhezfwhez/cmap.Generate.
+// {{.Name}}Map works the same as cmap.Map, values are {{.Type}}, so no assertion is needed.
 /*
-    var ${model} ${Model}
-    m := New${Model}Map()
-    m.Set(fmt.Sprintf("%d", ${model}.${Model}Id), ${model})
-    _ = m.Get(fmt.Sprintf("%d", ${model}.${Model}Id))
-    m.Delete(fmt.Sprintf("%d", ${model}.${Model}Id))
-    m.SetEx(fmt.Sprintf("%d", ${model}.${Model}Id), ${model}, 3*24*60*60)
+    m := New{{.Name}}Map()
+    m.SetEx(key, value, 60)
+    v, exist := m.Get(key)
+    m.Delete(key)
 */
-// And you can supervisor it by:
+// Expired keys are deleted when read, or by ClearExpireKeys, which should be called periodically:
 /*
-    go func(){
-		for {
-			m.ClearExpireKeys()
-			time.Sleep(24 * time.Hour)
-		}
-	}()
+    go func() {
+        for {
+            time.Sleep(time.Minute)
+            m.ClearExpireKeys()
+        }
+    }()
 */
+
+// {{.Upper}}_M_FREE2, {{.Upper}}_M_BUSY and {{.Upper}}_M_FREE1 are modes of {{.Name}}Map, the same as cmap.M_FREE2, cmap.M_BUSY and cmap.M_FREE1.
+const (
+	{{.Upper}}_M_FREE2 = 0 // m is readable and writable, dirty mirrors m
+	{{.Upper}}_M_BUSY  = 1 // m is clearing expired keys, dirty is readable, writes go to dirty and write, deletions go to dirty and del
+	{{.Upper}}_M_FREE1 = 2 // write and del have been synchronized to m, dirty is readable, m and dirty are writable
+)
+
+// TTL and PTTL return {{.Upper}}_TTL_NOT_EXIST when key doesn't exist,
+// return {{.Upper}}_TTL_NO_EXPIRE when key exists but has no time limit.
+const (
+	{{.Upper}}_TTL_NOT_EXIST = -2
+	{{.Upper}}_TTL_NO_EXPIRE = -1
+)
+
+// actions returned by update function
+const (
+	{{.Lower}}_update_keep   = 0 // do nothing
+	{{.Lower}}_update_set    = 1 // save the returned value
+	{{.Lower}}_update_delete = 2 // delete the key
+)
+
+// Err{{.Name}}WrongType is returned when operating a list command against a key holding a value, or the opposite.
+var Err{{.Name}}WrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
 // value saved
-type ${Model}Value struct {
+type {{.Name}}Value struct {
 	// value
-	v ${Model}
-	// value will be expired in exp seconds, -1 means no time limit
+	v {{.Type}}
+	// not nil when key holds a list
+	list *{{.Lower}}List
+	// unixnano the value will be expired at, -1 means no time limit
 	exp int64
 
-	// Map's offset, when map exec set/delete, offset++
+	// map's offset, when map exec set/delete, offset++
 	offset int64
 	// generated time when a value is set, unixnano
 	execAt int64
@@ -54,7 +312,7 @@ type ${Model}Value struct {
 
 // v is latter than v2 in time
 // LatterThan helps judge set/del/sync make sense or not
-func (v ${Model}Value) LatterThan(v2 ${Model}Value) bool {
+func (v {{.Name}}Value) LatterThan(v2 {{.Name}}Value) bool {
 	if v.execAt > v2.execAt {
 		return true
 	}
@@ -67,7 +325,7 @@ func (v ${Model}Value) LatterThan(v2 ${Model}Value) bool {
 }
 
 // v is former than v2 in time
-func (v ${Model}Value) FormerThan(v2 ${Model}Value) bool {
+func (v {{.Name}}Value) FormerThan(v2 {{.Name}}Value) bool {
 	if v.execAt < v2.execAt {
 		return true
 	}
@@ -77,60 +335,65 @@ func (v ${Model}Value) FormerThan(v2 ${Model}Value) bool {
 	return v.offset < v2.offset
 }
 
+func (v {{.Name}}Value) isExpire() bool {
+	if v.exp == -1 {
+		return false
+	}
+
+	return time.Now().UnixNano() >= v.exp
+}
+
 // make value readable
-func (v ${Model}Value) detail() map[string]interface{} {
+func (v {{.Name}}Value) detail() map[string]interface{} {
 	m := map[string]interface{}{
 		"v":       v.v,
 		"exp":     v.exp,
 		"offset":  v.offset,
 		"exec_at": v.execAt,
 	}
+	if v.list != nil {
+		// elements are modified in place under lock of the readable register
+		m["v"] = "list"
+	}
 	return m
 }
 
-// M_FREE and M_BUSY are signal of map.m state.
-// When map.mode == M_FREE, data are writable and readable using map.m.
-// When map.mode == M_BUSY, data are writable and readble using map.dirty, writable map.write and writable map.del
-const (
-	${MODEL}_M_FREE = 0
-	${MODEL}_M_BUSY = 1
-)
+// {{.Lower}}List is shared by registers, it's modified only under lock of the readable register
+type {{.Lower}}List struct {
+	elems []{{.Type}}
+}
+
+// {{.Name}}Map is concurrently safe, it consists of m, dirty, write, del, these 4 data registers.
+// In {{.Upper}}_M_FREE2 mode, m is readable and writable, dirty mirrors m.
+// When calling ClearExpireKeys(), map changes into {{.Upper}}_M_BUSY mode, m is unreadable and unwritable, users read data from dirty,
+// writing operation writes data to dirty and write, deleting operation deletes from dirty and writes to del.
+// As soon as clearing job done, write and del are synchronized to m in {{.Upper}}_M_FREE1 mode, and then m returns to job in {{.Upper}}_M_FREE2.
+type {{.Name}}Map struct {
+	clearing int32
 
-// Map is concurrently safe, and faster than sync.Map.
-// Map consist of m, dirty, write, del, these 4 data register.
-// In M_FREE mode, map.m is writable/readable to users.
-// When calling map.ClearExpireKeys(), map will change mode into M_BUSY.
-// In B_BUSY mode, map.m is unreadable and unwritable. users read data from dirty.
-// At this moment,
-//    writing operation will write data to map.dirty, map.write
-//    deleting operation will write to map.del
-// As soon as clearing job done, mode set to M_FREE,
-// At this moment,
-//    map.m is return to job, writed and read
-//    map.write change to read-only and data in m.write will be sync to map.m then cleared
-//    map.del change to read-only and data in m.del will sync to map.m then cleared
-//    map.dirty are first be cleared and then synchronizing data from map.m and block new write request, after sync job done, finish all writing request.
-type ${Model}Map struct {
+	deltal *sync.RWMutex
+
+	modl *sync.RWMutex
 	mode int
 
 	l *sync.RWMutex
-	m map[string]${Model}Value
+	m map[{{.Key}}]{{.Name}}Value
 
 	dl    *sync.RWMutex
-	dirty map[string]${Model}Value
+	dirty map[{{.Key}}]{{.Name}}Value
 
 	wl    *sync.RWMutex
-	write map[string]${Model}Value
+	write map[{{.Key}}]{{.Name}}Value
 
 	dll    *sync.RWMutex
 	offset int64
-	del    map[string]${Model}Value
+	del    map[{{.Key}}]{{.Name}}Value
 }
 
 // Help viewing map's detail.
 //   b, e:= json.MarshalIndent(m.Detail(), "", "  ")
 //   fmt.Println(string(b))
-type ${model}MapView struct {
+type {{.Lower}}MapView struct {
 	Mode int
 
 	M map[string]interface{}
@@ -143,399 +406,605 @@ type ${model}MapView struct {
 	Del    map[string]interface{}
 }
 
-func new${Model}Map() *${Model}Map {
-	return &${Model}Map{
-		mode: ${MODEL}_M_FREE,
+// new a concurrent map
+func New{{.Name}}Map() *{{.Name}}Map {
+	return &{{.Name}}Map{
+		deltal: &sync.RWMutex{},
+		modl:   &sync.RWMutex{},
+		mode:   {{.Upper}}_M_FREE2,
 
 		l: &sync.RWMutex{},
-		m: make(map[string]${Model}Value),
+		m: make(map[{{.Key}}]{{.Name}}Value),
 
 		dl:    &sync.RWMutex{},
-		dirty: make(map[string]${Model}Value),
+		dirty: make(map[{{.Key}}]{{.Name}}Value),
 
 		wl:    &sync.RWMutex{},
-		write: make(map[string]${Model}Value),
+		write: make(map[{{.Key}}]{{.Name}}Value),
 
 		dll: &sync.RWMutex{},
-		del: make(map[string]${Model}Value),
+		del: make(map[{{.Key}}]{{.Name}}Value),
 	}
 }
 
-// new a concurrent map
-func New${Model}Map() *${Model}Map {
-	return new${Model}Map()
-}
+func (m *{{.Name}}Map) IsBusy() bool {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
 
-// Pause the world
-// gLock and gUnlock must run in pair
-func (m *${Model}Map) gLock() {
-	m.l.Lock()
-	m.wl.Lock()
-	m.dl.Lock()
-	m.dll.Lock()
+	return m.mode == {{.Upper}}_M_BUSY
 }
 
-// Continue
-func (m *${Model}Map) gUnlock() {
-	m.l.Unlock()
-	m.wl.Unlock()
-	m.dl.Unlock()
-	m.dll.Unlock()
-}
+func (m *{{.Name}}Map) IsFree1() bool {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
 
-func (m *${Model}Map) gRLock() {
-	m.l.RLock()
-	m.wl.RLock()
-	m.dl.RLock()
-	m.dll.RLock()
+	return m.mode == {{.Upper}}_M_FREE1
 }
-func (m *${Model}Map) gRUnlock() {
-	m.dll.RUnlock()
-	m.dl.RUnlock()
-	m.wl.RUnlock()
-	m.l.RUnlock()
+
+func (m *{{.Name}}Map) IsFree2() bool {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	return m.mode == {{.Upper}}_M_FREE2
 }
 
-func (m *${Model}Map) IsBusy() bool {
-	m.gRLock()
+// set,del,setnx,setex will increase map.offset.
+// When offset reaches max int64 value, will be back to 0
+// So to judege values former or latter, should compare v.execAt first and then comapre offset.
+func (m *{{.Name}}Map) offsetIncr() int64 {
+	atomic.CompareAndSwapInt64(&m.offset, math.MaxInt64-10000, 0)
+	return atomic.AddInt64(&m.offset, 1)
+}
 
-	defer m.gRUnlock()
+// expOfSeconds converts seconds to exp, -1 means no time limit
+func {{.Lower}}ExpOfSeconds(seconds int) int64 {
+	if seconds == -1 {
+		return -1
+	}
+	return time.Now().Add(time.Duration(seconds) * time.Second).UnixNano()
+}
 
-	return m.mode == ${MODEL}_M_BUSY
+// readable returns the register serving reads, caller should hold modl
+func (m *{{.Name}}Map) readable() (*sync.RWMutex, map[{{.Key}}]{{.Name}}Value) {
+	if m.mode == {{.Upper}}_M_FREE2 {
+		return m.l, m.m
+	}
+	return m.dl, m.dirty
 }
 
-// map.Set
-func (m *${Model}Map) Set(key string, value ${Model}) {
-	ext := time.Now().UnixNano()
-	offset := m.offsetIncr()
+// update reads, modifies and writes key atomically.
+// f is executed once, under lock of the readable register(m in M_FREE2, dirty otherwise), with the unexpired old value.
+// The result is then synchronized to the other registers of current mode while the readable register is still locked,
+// so registers always see writes of a key in the same order.
+// In M_BUSY, new value is appended to write and deletion is appended to del, so that they survive the migration.
+// update returns the old value, whether it existed, and the action f made.
+func (m *{{.Name}}Map) update(key {{.Key}}, f func(old {{.Name}}Value, exist bool) ({{.Name}}Value, int)) ({{.Name}}Value, bool, int) {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	l, mp := m.readable()
+	l.Lock()
+	defer l.Unlock()
+
+	old, exist := mp[key]
+	if exist && old.isExpire() {
+		// drop expired value, m will be cleared by ClearExpireKeys
+		delete(mp, key)
+		old, exist = {{.Name}}Value{}, false
+	}
 
-	// only when not busy, m is writable
-	if !m.IsBusy() {
-		m.l.Lock()
+	nv, action := f(old, exist)
+	if action == {{.Lower}}_update_keep {
+		return old, exist, action
+	}
 
-		m.m[key] = ${Model}Value{
-			v:   value,
-			exp: -1,
+	nv.execAt = time.Now().UnixNano()
+	nv.offset = m.offsetIncr()
+	if action == {{.Lower}}_update_set {
+		mp[key] = nv
+	} else {
+		delete(mp, key)
+	}
 
-			execAt: ext,
-			offset: offset,
+	switch m.mode {
+	case {{.Upper}}_M_FREE2:
+		{{.Lower}}Syncm(m.dl, m.dirty, key, nv, action)
+	case {{.Upper}}_M_FREE1:
+		m.deltal.RLock()
+		{{.Lower}}Syncm(m.l, m.m, key, nv, action)
+		m.deltal.RUnlock()
+	default:
+		if action == {{.Lower}}_update_set {
+			{{.Lower}}Syncm(m.wl, m.write, key, nv, action)
+		} else {
+			// deletion is saved as a value in del
+			{{.Lower}}Syncm(m.dll, m.del, key, {{.Name}}Value{exp: -1, execAt: nv.execAt, offset: nv.offset}, {{.Lower}}_update_set)
 		}
-		m.l.Unlock()
-
 	}
+	return old, exist, action
+}
+
+// {{.Lower}}Syncm saves or deletes key in register m
+func {{.Lower}}Syncm(l *sync.RWMutex, m map[{{.Key}}]{{.Name}}Value, key {{.Key}}, v {{.Name}}Value, action int) {
+	l.Lock()
+	defer l.Unlock()
 
-	m.dl.Lock()
-	m.dirty[key] = ${Model}Value{
-		v:      value,
-		exp:    -1,
-		execAt: ext,
-		offset: offset,
+	if action == {{.Lower}}_update_set {
+		m[key] = v
+		return
 	}
-	m.dl.Unlock()
+	delete(m, key)
+}
 
-	// only when m is busy, write map is writable
-	if m.IsBusy() {
-		m.wl.Lock()
-		m.write[key] = ${Model}Value{
-			v:   value,
-			exp: -1,
+// view runs f with the unexpired value of key under read lock of the readable register
+func (m *{{.Name}}Map) view(key {{.Key}}, f func(v {{.Name}}Value, exist bool)) {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
 
-			execAt: ext,
-			offset: offset,
-		}
-		m.wl.Unlock()
+	l, mp := m.readable()
+	l.RLock()
+	defer l.RUnlock()
+
+	v, exist := mp[key]
+	if exist && v.isExpire() {
+		v, exist = {{.Name}}Value{}, false
 	}
+	f(v, exist)
 }
 
-// set,del,setnx,setex will increase map.offset.
-// When offset reaches max int64 value, will be back to 0
-// So to judege values former or latter, should compare v.execAt first and then comapre offset.
-func (m *${Model}Map) offsetIncr() int64 {
-	atomic.CompareAndSwapInt64(&m.offset, math.MaxInt64, 0)
+func (m *{{.Name}}Map) set(key {{.Key}}, value {{.Type}}, exp int64, nx bool) bool {
+	_, _, action := m.update(key, func(old {{.Name}}Value, exist bool) ({{.Name}}Value, int) {
+		if nx && exist {
+			return old, {{.Lower}}_update_keep
+		}
+		return {{.Name}}Value{v: value, exp: exp}, {{.Lower}}_update_set
+	})
+	return action == {{.Lower}}_update_set
+}
 
-	atomic.AddInt64(&m.offset, 1)
-	return m.offset
+// map.Set
+func (m *{{.Name}}Map) Set(key {{.Key}}, value {{.Type}}) {
+	m.set(key, value, -1, false)
 }
 
-// map.SetEX
+// map.SetEx
 // key-value will be put with expired time limit.
 // If seconds are set -1, value will not be expired
 // expired keys will be deleted as soon as calling m.Get(key), or calling m.ClearExpireKeys()
-func (m *${Model}Map) SetEx(key string, value ${Model}, seconds int) {
-	ext := time.Now().UnixNano()
-	offset := m.offsetIncr()
+func (m *{{.Name}}Map) SetEx(key {{.Key}}, value {{.Type}}, seconds int) {
+	m.set(key, value, {{.Lower}}ExpOfSeconds(seconds), false)
+}
+
+// map.SetNx
+// If key exist, do nothing, otherwise set key,value into map
+func (m *{{.Name}}Map) SetNx(key {{.Key}}, value {{.Type}}) {
+	m.set(key, value, -1, true)
+}
 
-	// only when not busy, m is writable
-	if !m.IsBusy() {
-		m.l.Lock()
+// map.SetExNx
+// If key exist, do nothing, otherwise set key,value into map with expired time limit
+func (m *{{.Name}}Map) SetExNx(key {{.Key}}, value {{.Type}}, seconds int) {
+	m.set(key, value, {{.Lower}}ExpOfSeconds(seconds), true)
+}
 
-		m.m[key] = ${Model}Value{
-			v:   value,
-			exp: time.Now().Add(time.Duration(seconds) * time.Second).UnixNano(),
+// map.SetTTL
+// key-value will be put with expired time limit in nanosecond precision.
+// If ttl < 0, value will not be expired.
+func (m *{{.Name}}Map) SetTTL(key {{.Key}}, value {{.Type}}, ttl time.Duration) {
+	if ttl < 0 {
+		m.set(key, value, -1, false)
+		return
+	}
+	m.set(key, value, time.Now().Add(ttl).UnixNano(), false)
+}
 
-			execAt: ext,
-			offset: offset,
-		}
-		m.l.Unlock()
+// map.SetNxTTL
+// If key exist, do nothing and return false, otherwise set key,value into map with ttl and return true.
+func (m *{{.Name}}Map) SetNxTTL(key {{.Key}}, value {{.Type}}, ttl time.Duration) bool {
+	if ttl < 0 {
+		return m.set(key, value, -1, true)
+	}
+	return m.set(key, value, time.Now().Add(ttl).UnixNano(), true)
+}
+
+// map.SetExpireAt
+// key-value will be expired at the deadline. If deadline is zero, value will not be expired.
+func (m *{{.Name}}Map) SetExpireAt(key {{.Key}}, value {{.Type}}, deadline time.Time) {
+	if deadline.IsZero() {
+		m.set(key, value, -1, false)
+		return
+	}
+	m.set(key, value, deadline.UnixNano(), false)
+}
 
+// map.SetNxExpireAt
+// If key exist, do nothing and return false, otherwise set key,value into map expired at deadline and return true.
+func (m *{{.Name}}Map) SetNxExpireAt(key {{.Key}}, value {{.Type}}, deadline time.Time) bool {
+	if deadline.IsZero() {
+		return m.set(key, value, -1, true)
 	}
+	return m.set(key, value, deadline.UnixNano(), true)
+}
 
-	m.dl.Lock()
+// If key is expired, not existed or holds a list, return zero value and false
+func (m *{{.Name}}Map) Get(key {{.Key}}) ({{.Type}}, bool) {
+	var v {{.Type}}
+	var ok bool
+	var expired bool
 
-	m.dirty[key] = ${Model}Value{
-		v:      value,
-		exp:    time.Now().Add(time.Duration(seconds) * time.Second).UnixNano(),
-		execAt: ext,
-		offset: offset,
+	m.modl.RLock()
+	l, mp := m.readable()
+	l.RLock()
+	value, exist := mp[key]
+	l.RUnlock()
+	m.modl.RUnlock()
+
+	if exist && value.isExpire() {
+		expired = true
+	}
+	if exist && !expired && value.list == nil {
+		v, ok = value.v, true
 	}
-	m.dl.Unlock()
 
-	// only when m is busy, write map is writable
-	if m.IsBusy() {
-		m.wl.Lock()
-		m.write[key] = ${Model}Value{
-			v:      value,
-			exp:    time.Now().Add(time.Duration(seconds) * time.Second).UnixNano(),
-			execAt: ext,
-			offset: offset,
+	if expired {
+		// delete expired key by update
+		m.update(key, func(old {{.Name}}Value, exist bool) ({{.Name}}Value, int) {
+			return old, {{.Lower}}_update_keep
+		})
+	}
+	return v, ok
+}
+
+// Delete deletes key
+func (m *{{.Name}}Map) Delete(key {{.Key}}) {
+	m.update(key, func(old {{.Name}}Value, exist bool) ({{.Name}}Value, int) {
+		if !exist {
+			return old, {{.Lower}}_update_keep
 		}
-		m.wl.Unlock()
+		return old, {{.Lower}}_update_delete
+	})
+}
 
-	}
+// Expire sets a timeout of seconds on key. It returns false if key doesn't exist.
+// A non-positive seconds deletes the key.
+func (m *{{.Name}}Map) Expire(key {{.Key}}, seconds int) bool {
+	return m.expire(key, time.Now().Add(time.Duration(seconds)*time.Second).UnixNano())
 }
 
-// map.SetNx
-// If key exist, do nothing, otherwise set key,value into map
-func (m *${Model}Map) SetNx(key string, value ${Model}) {
-	m.SetExNx(key, value, -1)
+// PExpire works like Expire, but the timeout is in milliseconds.
+func (m *{{.Name}}Map) PExpire(key {{.Key}}, milliseconds int64) bool {
+	return m.expire(key, time.Now().Add(time.Duration(milliseconds)*time.Millisecond).UnixNano())
 }
 
-// map.SetEXNX
-// If key exist, do nothing, otherwise set key,value into map
-func (m *${Model}Map) SetExNx(key string, value ${Model}, seconds int) {
-	if m.IsBusy() {
-		m.dl.RLock()
-		_, exist := m.dirty[key]
-		m.dl.RUnlock()
-		if exist {
-			return
-		} else {
-			m.SetEx(key, value, seconds)
-			return
+// ExpireAt sets key expired at the deadline. It returns false if key doesn't exist.
+// A deadline in the past deletes the key.
+func (m *{{.Name}}Map) ExpireAt(key {{.Key}}, deadline time.Time) bool {
+	return m.expire(key, deadline.UnixNano())
+}
+
+func (m *{{.Name}}Map) expire(key {{.Key}}, exp int64) bool {
+	_, exist, _ := m.update(key, func(old {{.Name}}Value, exist bool) ({{.Name}}Value, int) {
+		if !exist {
+			return old, {{.Lower}}_update_keep
 		}
-	} else {
-		m.l.RLock()
-		_, exist := m.m[key]
-		m.l.RUnlock()
-		if exist {
-			return
-		} else {
-			m.SetEx(key, value, seconds)
-			return
+		if exp <= time.Now().UnixNano() {
+			return old, {{.Lower}}_update_delete
 		}
-	}
+		old.exp = exp
+		return old, {{.Lower}}_update_set
+	})
+	return exist
 }
 
-// If key is expired or not existed, return nil
-func (m *${Model}Map) Get(key string) ${Model} {
-	if !m.IsBusy() {
-		return ${model}GetFrom(m.l, m.m, key)
-	} else {
-		return ${model}GetFrom(m.dl, m.dirty, key)
+// Persist removes the time limit of key.
+// It returns false if key doesn't exist or key has no time limit.
+func (m *{{.Name}}Map) Persist(key {{.Key}}) bool {
+	_, _, action := m.update(key, func(old {{.Name}}Value, exist bool) ({{.Name}}Value, int) {
+		if !exist || old.exp == -1 {
+			return old, {{.Lower}}_update_keep
+		}
+		old.exp = -1
+		return old, {{.Lower}}_update_set
+	})
+	return action == {{.Lower}}_update_set
+}
+
+// TTL returns the remaining seconds of key, or {{.Upper}}_TTL_NOT_EXIST, {{.Upper}}_TTL_NO_EXPIRE.
+func (m *{{.Name}}Map) TTL(key {{.Key}}) int64 {
+	pttl := m.pttl(key)
+	if pttl < 0 {
+		return pttl
 	}
+	// round like redis does
+	return (pttl + int64(time.Second)/2) / int64(time.Second)
 }
 
-// Delete
-func (m *${Model}Map) Delete(key string) {
-	offset := m.offsetIncr()
-	ext := time.Now().UnixNano()
-	if !m.IsBusy() {
-		m.l.RLock()
-		_, ok := m.m[key]
-		m.l.RUnlock()
+// PTTL returns the remaining milliseconds of key, or {{.Upper}}_TTL_NOT_EXIST, {{.Upper}}_TTL_NO_EXPIRE.
+func (m *{{.Name}}Map) PTTL(key {{.Key}}) int64 {
+	pttl := m.pttl(key)
+	if pttl < 0 {
+		return pttl
+	}
+	return pttl / int64(time.Millisecond)
+}
 
-		if ok {
-			m.l.Lock()
-			delete(m.m, key)
-			m.l.Unlock()
+// pttl returns the remaining nanoseconds of key
+func (m *{{.Name}}Map) pttl(key {{.Key}}) int64 {
+	var rs int64
+	m.view(key, func(v {{.Name}}Value, exist bool) {
+		switch {
+		case !exist:
+			rs = {{.Upper}}_TTL_NOT_EXIST
+		case v.exp == -1:
+			rs = {{.Upper}}_TTL_NO_EXPIRE
+		default:
+			rs = v.exp - time.Now().UnixNano()
+		}
+	})
+	return rs
+}
+{{if .Integer}}
+// incrBy adds n to key, or subtracts n when decr. A missing key starts from 0, a key holding a list is not changed and returns 0.
+// When exp is nil, time limit of key is kept.
+func (m *{{.Name}}Map) incrBy(key {{.Key}}, n {{.Type}}, decr bool, exp *int64) {{.Type}} {
+	var rs {{.Type}}
+	m.update(key, func(old {{.Name}}Value, exist bool) ({{.Name}}Value, int) {
+		if old.list != nil {
+			return old, {{.Lower}}_update_keep
+		}
+		nv := {{.Name}}Value{v: old.v + n, exp: -1}
+		if decr {
+			nv.v = old.v - n
+		}
+		if exist {
+			nv.exp = old.exp
+		}
+		if exp != nil {
+			nv.exp = *exp
 		}
+		rs = nv.v
+		return nv, {{.Lower}}_update_set
+	})
+	return rs
+}
+
+// expOfTTL converts ttl to exp, a negative ttl means no time limit
+func {{.Lower}}ExpOfTTL(ttl time.Duration) *int64 {
+	var exp int64 = -1
+	if ttl >= 0 {
+		exp = time.Now().Add(ttl).UnixNano()
 	}
+	return &exp
+}
 
-	m.dl.RLock()
-	_, ok2 := m.dirty[key]
-	m.dl.RUnlock()
+// increase key by 1, time limit of key is kept.
+func (m *{{.Name}}Map) Incr(key {{.Key}}) {{.Type}} {
+	return m.incrBy(key, 1, false, nil)
+}
 
-	if ok2 {
-		m.dl.Lock()
-		delete(m.dirty, key)
-		m.dl.Unlock()
-	}
+// increase key by one with expire seconds
+func (m *{{.Name}}Map) IncrEx(key {{.Key}}, seconds int) {{.Type}} {
+	exp := {{.Lower}}ExpOfSeconds(seconds)
+	return m.incrBy(key, 1, false, &exp)
+}
 
-	if m.IsBusy() {
-		m.dll.Lock()
-		m.del[key] = ${Model}Value{
-			exp: 0,
-			v:   ${Model}{},
+// increase key by n, time limit of key is kept.
+func (m *{{.Name}}Map) IncrBy(key {{.Key}}, n {{.Type}}) {{.Type}} {
+	return m.incrBy(key, n, false, nil)
+}
 
-			execAt: ext,
-			offset: offset,
-		}
-		m.dll.Unlock()
-	}
+// increase key by n with expire seconds
+func (m *{{.Name}}Map) IncrByEx(key {{.Key}}, n {{.Type}}, seconds int) {{.Type}} {
+	exp := {{.Lower}}ExpOfSeconds(seconds)
+	return m.incrBy(key, n, false, &exp)
 }
 
-func (m *${Model}Map) Detail() ${model}MapView {
-	var listMaxNum = 10
-	var flag = 0
-
-	mv := ${model}MapView{
-		M:     make(map[string]interface{}),
-		Dirty: make(map[string]interface{}),
-		Del:   make(map[string]interface{}),
-		Write: make(map[string]interface{}),
-	}
-	mv.Offset = m.offset
-
-	m.l.RLock()
-	for k, _ := range m.m {
-		flag++
-		mv.M[k] = m.m[k].detail()
-		if flag > listMaxNum {
-			mv.M["reach-max-detail"] = ${Model}{}
-			break
-		}
-	}
-	m.l.RUnlock()
+// increase key by n with ttl, a negative ttl means no time limit
+func (m *{{.Name}}Map) IncrByTTL(key {{.Key}}, n {{.Type}}, ttl time.Duration) {{.Type}} {
+	return m.incrBy(key, n, false, {{.Lower}}ExpOfTTL(ttl))
+}
 
-	m.dl.RLock()
-	flag = 0
-	for k, _ := range m.dirty {
-		mv.Dirty[k] = m.dirty[k].detail()
-		flag++
-		if flag > listMaxNum {
-			mv.Dirty["reach-max-detail"] = ${Model}{}
-			break
-		}
-	}
-	m.dl.RUnlock()
+// decrease key by one, time limit of key is kept.
+func (m *{{.Name}}Map) Decr(key {{.Key}}) {{.Type}} {
+	return m.incrBy(key, 1, true, nil)
+}
 
-	m.wl.RLock()
-	flag = 0
-	for k, _ := range m.write {
-		mv.Write[k] = m.write[k].detail()
-		flag++
-		if flag > listMaxNum {
-			mv.Write["reach-max-detail"] =${Model}{}
-			break
-		}
-	}
-	m.wl.RUnlock()
+// decrease key by one with expire seconds
+func (m *{{.Name}}Map) DecrEx(key {{.Key}}, seconds int) {{.Type}} {
+	exp := {{.Lower}}ExpOfSeconds(seconds)
+	return m.incrBy(key, 1, true, &exp)
+}
 
-	flag = 0
-	m.dll.RLock()
-	for k, _ := range m.del {
-		mv.Del[k] = m.del[k].detail()
-		flag++
-		if flag > listMaxNum {
-			mv.Del["reach-max-detail"] = ${Model}{}
-			break
-		}
-	}
-	m.dll.RUnlock()
-	return mv
+// decrease key by n, time limit of key is kept.
+func (m *{{.Name}}Map) DecrBy(key {{.Key}}, n {{.Type}}) {{.Type}} {
+	return m.incrBy(key, n, true, nil)
 }
 
-func (m *${Model}Map) PrintDetail() string {
-	b, e := json.MarshalIndent(m.Detail(), "", "  ")
-	if e != nil {
-		fmt.Println(e.Error())
-		return ""
-	}
-	fmt.Println(string(b))
-	return string(b)
+// decrease key by n with expire seconds
+func (m *{{.Name}}Map) DecrByEx(key {{.Key}}, n {{.Type}}, seconds int) {{.Type}} {
+	exp := {{.Lower}}ExpOfSeconds(seconds)
+	return m.incrBy(key, n, true, &exp)
 }
 
-func ${model}GetFrom(l *sync.RWMutex, m map[string]${Model}Value, key string) ${Model} {
-	l.RLock()
-	value, ok := m[key]
-	l.RUnlock()
+// decrease key by n with ttl, a negative ttl means no time limit
+func (m *{{.Name}}Map) DecrByTTL(key {{.Key}}, n {{.Type}}, ttl time.Duration) {{.Type}} {
+	return m.incrBy(key, n, true, {{.Lower}}ExpOfTTL(ttl))
+}
+{{end}}
+// RPush appends elems to list of key, and returns length of the list.
+// A missing key is created as an empty list without time limit.
+func (m *{{.Name}}Map) RPush(key {{.Key}}, elems ...{{.Type}}) (int, error) {
+	var n int
+	var e error
+	m.update(key, func(old {{.Name}}Value, exist bool) ({{.Name}}Value, int) {
+		if !exist && len(elems) == 0 {
+			return old, {{.Lower}}_update_keep
+		}
+		if !exist {
+			list := &{{.Lower}}List{elems: append([]{{.Type}}(nil), elems...)}
+			n = len(list.elems)
+			return {{.Name}}Value{list: list, exp: -1}, {{.Lower}}_update_set
+		}
+		if old.list == nil {
+			e = Err{{.Name}}WrongType
+			return old, {{.Lower}}_update_keep
+		}
+		// list is shared by registers, modify it in place
+		old.list.elems = append(old.list.elems, elems...)
+		n = len(old.list.elems)
+		return old, {{.Lower}}_update_keep
+	})
+	return n, e
+}
 
-	if !ok {
-		return ${Model}{}
-	}
+// LPop removes and returns the first element of list of key, the key is deleted when list becomes empty.
+func (m *{{.Name}}Map) LPop(key {{.Key}}) ({{.Type}}, bool, error) {
+	var v {{.Type}}
+	var ok bool
+	var e error
+	m.update(key, func(old {{.Name}}Value, exist bool) ({{.Name}}Value, int) {
+		if !exist {
+			return old, {{.Lower}}_update_keep
+		}
+		if old.list == nil {
+			e = Err{{.Name}}WrongType
+			return old, {{.Lower}}_update_keep
+		}
 
-	if value.exp == -1 {
-		return value.v
-	}
+		var zero {{.Type}}
+		v, ok = old.list.elems[0], true
+		// release reference of the popped element
+		old.list.elems[0] = zero
+		old.list.elems = old.list.elems[1:]
 
-	if time.Now().UnixNano() >= value.exp {
-		l.Lock()
-		delete(m, key)
-		l.Unlock()
-		return ${Model}{}
-	}
+		if len(old.list.elems) == 0 {
+			return old, {{.Lower}}_update_delete
+		}
+		return old, {{.Lower}}_update_keep
+	})
+	return v, ok, e
+}
 
-	return value.v
+// LRange returns elements of list of key between start and stop, both inclusive.
+// Negative index counts from the end of list, -1 is the last element.
+func (m *{{.Name}}Map) LRange(key {{.Key}}, start int, stop int) ([]{{.Type}}, error) {
+	var rs []{{.Type}}
+	var e error
+	m.view(key, func(v {{.Name}}Value, exist bool) {
+		if !exist {
+			return
+		}
+		if v.list == nil {
+			e = Err{{.Name}}WrongType
+			return
+		}
+
+		n := len(v.list.elems)
+		if start < 0 {
+			start += n
+		}
+		if stop < 0 {
+			stop += n
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= n {
+			stop = n - 1
+		}
+		if start > stop {
+			return
+		}
+		rs = append([]{{.Type}}(nil), v.list.elems[start:stop+1]...)
+	})
+	return rs, e
 }
 
-func (m *${Model}Map) setBusy() {
-	m.gLock()
-	m.mode = ${MODEL}_M_BUSY
-	m.gUnlock()
+// LLen returns length of list of key, 0 if key doesn't exist.
+func (m *{{.Name}}Map) LLen(key {{.Key}}) (int, error) {
+	var n int
+	var e error
+	m.view(key, func(v {{.Name}}Value, exist bool) {
+		if exist && v.list == nil {
+			e = Err{{.Name}}WrongType
+			return
+		}
+		if exist {
+			n = len(v.list.elems)
+		}
+	})
+	return n, e
 }
 
-func (m *${Model}Map) setFree() {
-	m.gLock()
-	m.mode = ${MODEL}_M_FREE
-	m.gUnlock()
+// Returns length of the readable register, lists and expired keys not cleared are included.
+func (m *{{.Name}}Map) Len() int {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	l, mp := m.readable()
+	l.RLock()
+	defer l.RUnlock()
+	return len(mp)
 }
 
-// Returns Map.m real length, not m.dirty or m.write.
-func (m *${Model}Map) Len() int {
-	m.l.RLock()
-	length := len(m.m)
-	m.l.RUnlock()
-	return length
+// range function returns bool value
+// if false,  will stop range process.
+// Lists and expired keys are skipped. f must not write the map.
+func (m *{{.Name}}Map) Range(f func(key {{.Key}}, value {{.Type}}) bool) {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	l, mp := m.readable()
+	l.RLock()
+	defer l.RUnlock()
+
+	for k, v := range mp {
+		if v.list != nil || v.isExpire() {
+			continue
+		}
+		if !f(k, v.v) {
+			break
+		}
+	}
 }
 
 // ClearExpireKeys clear expired keys, and it will not influence map write and read.
-// When call m.ClearExpireKeys(), first will set m.mode=M_BUSY.
-// At this moment, operation of write to Map.m is denied and instead data will be writen to Map.write which will sync to Map.m after clear job done.
-// operation of read will use Map.dirty.
-// After clear job has been done, Map.dirty will be cleared and copy from Map.m, Map.write will be unwritenable and data in Map.write will sync to Map.m.
-func (m *${Model}Map) ClearExpireKeys() int {
-	if m.IsBusy() {
-		// on clearing job, another clear job do nothing
+// When call m.ClearExpireKeys(), first will set m.mode={{.Upper}}_M_BUSY.
+// At this moment, operation of write to m is denied and instead data will be writen to write which will sync to m after clear job done.
+// operation of read will use dirty.
+// After clear job has been done, write and del are synchronized to m, and dirty drops expired keys.
+func (m *{{.Name}}Map) ClearExpireKeys() int {
+	// 利用atomic，确保高并发下，只会有一个ClearExpireKeys被执行
+	v := atomic.AddInt32(&m.clearing, 1)
+	defer atomic.AddInt32(&m.clearing, -1)
+
+	if v != 1 {
 		return 0
 	}
-	// clear Map.m expired keys
-	n := m.clearExpireKeys()
 
+	m.setMode({{.Upper}}_M_BUSY)
+
+	n := {{.Lower}}ClearExpire(m.l, m.m)
+
+	m.modl.Lock()
+	m.mode = {{.Upper}}_M_FREE1
+
+	m.deltal.Lock()
 	m.l.Lock()
 
+	// sync written operation from write
 	m.wl.RLock()
 	for k, v := range m.write {
 		v2, ok := m.m[k]
-		if !ok {
-			m.m[k] = v
-		} else {
-			if v2.LatterThan(v) {
-				continue
-			}
+		if ok && v2.LatterThan(v) {
+			continue
 		}
+		m.m[k] = v
 	}
 	m.wl.RUnlock()
 
-	m.wl.Lock()
-	m.write = make(map[string]${Model}Value)
-	m.wl.Unlock()
-
-	m.l.Unlock()
-
-	// sync deleted operation from Map.del
-	m.l.Lock()
-
-	m.dll.Lock()
+	// sync deleted operation from del
+	m.dll.RLock()
 	for k, v := range m.del {
 		v2, ok := m.m[k]
 		if !ok {
@@ -545,96 +1014,318 @@ func (m *${Model}Map) ClearExpireKeys() int {
 			delete(m.m, k)
 		}
 	}
-
-	m.del = make(map[string]${Model}Value)
-	m.dll.Unlock()
+	m.dll.RUnlock()
 
 	m.l.Unlock()
+	m.deltal.Unlock()
+	m.modl.Unlock()
 
-	// When migrating data from m to drity, since while tmp is coping from m.m, m.m and m.dirty are still writable, tmp is relatively old.
-	// So make sure data from old m.m's copied tmp will not influence latest writened data in dirty.
-	m.l.RLock()
+	m.setMode({{.Upper}}_M_FREE2)
 
-	m.dl.Lock()
-	m.dirty = make(map[string]${Model}Value)
-	for k, v := range m.m {
-		v2, ok := m.dirty[k]
-		if ok && v2.LatterThan(v) {
-			// make sure existed latest writen data will not be replaced by the old
-			continue
-		} else {
-			// replace old data
-			m.dirty[k] = v
-		}
-	}
-	m.dl.Unlock()
+	// 进入free2时，清理write和del,dir
+	m.dll.Lock()
+	m.del = make(map[{{.Key}}]{{.Name}}Value)
+	m.dll.Unlock()
+
+	m.wl.Lock()
+	m.write = make(map[{{.Key}}]{{.Name}}Value)
+	m.wl.Unlock()
+
+	{{.Lower}}ClearExpire(m.dl, m.dirty)
 
-	m.l.RUnlock()
 	return n
 }
 
-// Change to busy mode, now dirty provides read, write provides write, del provides delete.
-// After clear expired keys in m, m will change into free auto..ly.
-// in free mode, m.del and m.write will not provides read nor write, m.dirty will not read.
-func (m *${Model}Map) clearExpireKeys() int {
-	m.setBusy()
-	defer m.setFree()
-	var num int
-	var shouldDelete = make([]string, 0, len(m.m))
+func (m *{{.Name}}Map) setMode(mode int) {
+	m.modl.Lock()
+	defer m.modl.Unlock()
+	m.mode = mode
+}
 
-	m.l.RLock()
+func {{.Lower}}ClearExpire(l *sync.RWMutex, m map[{{.Key}}]{{.Name}}Value) int {
+	var shouldDelete = make([]{{.Key}}, 0, 10)
 
-	for k, v := range m.m {
-		if v.exp == -1 {
-			continue
-		}
-		if v.exp < time.Now().UnixNano() {
+	l.RLock()
+	for k, v := range m {
+		if v.isExpire() {
 			shouldDelete = append(shouldDelete, k)
 		}
 	}
-	m.l.RUnlock()
+	l.RUnlock()
 
-	m.l.Lock()
-	for _, v := range shouldDelete {
-		num++
-		delete(m.m, v)
+	var n int
+	l.Lock()
+	for _, k := range shouldDelete {
+		// re-check, the key might be refreshed after scanning
+		if v, ok := m[k]; ok && v.isExpire() {
+			delete(m, k)
+			n++
+		}
 	}
+	l.Unlock()
 
-	m.l.Unlock()
-	return num
+	return n
 }
 
-	`
-	return replace(format, args)
+func (m *{{.Name}}Map) Detail() {{.Lower}}MapView {
+	var listMaxNum = 10
+
+	m.modl.RLock()
+	mv := {{.Lower}}MapView{
+		Mode:   m.mode,
+		Offset: atomic.LoadInt64(&m.offset),
+	}
+	m.modl.RUnlock()
+
+	view := func(l *sync.RWMutex, mp map[{{.Key}}]{{.Name}}Value) map[string]interface{} {
+		var rs = make(map[string]interface{})
+		var flag = 0
+
+		l.RLock()
+		defer l.RUnlock()
+		for k, v := range mp {
+			rs[fmt.Sprint(k)] = v.detail()
+			flag++
+			if flag > listMaxNum {
+				rs["reach-max-detail"] = "end"
+				break
+			}
+		}
+		return rs
+	}
+	mv.M = view(m.l, m.m)
+	mv.Dirty = view(m.dl, m.dirty)
+	mv.Write = view(m.wl, m.write)
+	mv.Del = view(m.dll, m.del)
+	return mv
 }
 
-func initArgs(args map[string]string) {
-	if len(args) == 0 {
-		args = map[string]string{}
+func (m *{{.Name}}Map) PrintDetail() string {
+	b, e := json.MarshalIndent(m.Detail(), "", "  ")
+	if e != nil {
+		fmt.Println(e.Error())
+		return ""
 	}
+	fmt.Println(string(b))
+	return string(b)
+}
+`))
+
+// typedMapV2Template is appended to typedMapTemplate in sharded mode
+var typedMapV2Template = template.Must(template.New("typed-mapv2").Parse(`
+// {{.Name}}MapV2 is a combination of <hash, {{.Name}}Map> like cmap.MapV2.
+// Keys will first get hashed and then decide to read/write which slot, irrelevant keys don't share a lock.
+type {{.Name}}MapV2 struct {
+	hash  func({{.Key}}) int64 // default hash is {{.Lower}}Hash
+	slots []*{{.Name}}Map
+	len   int
+
+	clear chan struct{} // close mapv2 will send clear to finish mapd goroutine
+}
 
-	if args["${package_name}"] == "" {
-		args["${package_name}"] = "model"
+// New{{.Name}}MapV2 new a mapv2 with slotNum slots, expired keys of each slot will be cleared every intervald.
+// If hash is nil, keys will be hashed by FNV-1a without allocation. hash must return a non-negative number.
+func New{{.Name}}MapV2(hash func({{.Key}}) int64, slotNum int, intervald time.Duration) *{{.Name}}MapV2 {
+	var mv2 = &{{.Name}}MapV2{
+		hash:  hash,
+		slots: make([]*{{.Name}}Map, slotNum, slotNum),
+		len:   slotNum,
+		clear: make(chan struct{}, 1),
 	}
 
-	if args["${Model}"] == "" {
-		args["${Model}"] = "Model"
+	for i, _ := range mv2.slots {
+		mv2.slots[i] = New{{.Name}}Map()
 	}
 
-	if args["${MODEL}"] == "" {
-		args["${MODEL}"] = strings.ToUpper(args["${Model}"])
+	if mv2.hash == nil {
+		mv2.hash = {{.Lower}}Hash
 	}
-	if args["${model}"] == "" {
-		args["${model}"] = strings.ToLower(args["${Model}"])
+
+	mv2.mapd(intervald)
+	return mv2
+}
+
+// {{.Lower}}Hash is FNV-1a of key
+func {{.Lower}}Hash(key {{.Key}}) int64 {
+	var h uint64 = 14695981039346656037
+{{range .Hash}}	{{.}}
+{{end}}	return int64(h >> 1)
+}
+
+func {{.Lower}}HashString(h uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
 	}
+	return h
+}
 
+func {{.Lower}}HashUint64(h uint64, n uint64) uint64 {
+	for i := 0; i < 8; i++ {
+		h ^= n & 0xff
+		h *= 1099511628211
+		n >>= 8
+	}
+	return h
 }
 
-func replace(format string, args map[string]string) string {
-	var rs = format
-	// rs = strings.Replace(format, "${package_name}", "model", -1)
-	for k, v := range args {
-		rs = strings.Replace(rs, k, v, -1)
+func {{.Lower}}HashBool(h uint64, b bool) uint64 {
+	if b {
+		return {{.Lower}}HashUint64(h, 1)
 	}
-	return rs
+	return {{.Lower}}HashUint64(h, 0)
+}
+
+// Clear stops clearing expired keys in background
+func (mv2 *{{.Name}}MapV2) Clear() {
+	mv2.clear <- struct{}{}
+}
+
+func (mv2 *{{.Name}}MapV2) getslot(key {{.Key}}) *{{.Name}}Map {
+	return mv2.slots[mv2.hash(key)%int64(mv2.len)]
+}
+
+func (mv2 *{{.Name}}MapV2) Set(key {{.Key}}, value {{.Type}}) {
+	mv2.getslot(key).Set(key, value)
+}
+func (mv2 *{{.Name}}MapV2) SetEx(key {{.Key}}, value {{.Type}}, seconds int) {
+	mv2.getslot(key).SetEx(key, value, seconds)
+}
+func (mv2 *{{.Name}}MapV2) SetNx(key {{.Key}}, value {{.Type}}) {
+	mv2.getslot(key).SetNx(key, value)
+}
+func (mv2 *{{.Name}}MapV2) SetExNx(key {{.Key}}, value {{.Type}}, seconds int) {
+	mv2.getslot(key).SetExNx(key, value, seconds)
+}
+func (mv2 *{{.Name}}MapV2) SetTTL(key {{.Key}}, value {{.Type}}, ttl time.Duration) {
+	mv2.getslot(key).SetTTL(key, value, ttl)
+}
+func (mv2 *{{.Name}}MapV2) SetNxTTL(key {{.Key}}, value {{.Type}}, ttl time.Duration) bool {
+	return mv2.getslot(key).SetNxTTL(key, value, ttl)
+}
+func (mv2 *{{.Name}}MapV2) SetExpireAt(key {{.Key}}, value {{.Type}}, deadline time.Time) {
+	mv2.getslot(key).SetExpireAt(key, value, deadline)
+}
+func (mv2 *{{.Name}}MapV2) SetNxExpireAt(key {{.Key}}, value {{.Type}}, deadline time.Time) bool {
+	return mv2.getslot(key).SetNxExpireAt(key, value, deadline)
+}
+func (mv2 *{{.Name}}MapV2) Get(key {{.Key}}) ({{.Type}}, bool) {
+	return mv2.getslot(key).Get(key)
+}
+func (mv2 *{{.Name}}MapV2) Delete(key {{.Key}}) {
+	mv2.getslot(key).Delete(key)
+}
+func (mv2 *{{.Name}}MapV2) Expire(key {{.Key}}, seconds int) bool {
+	return mv2.getslot(key).Expire(key, seconds)
+}
+func (mv2 *{{.Name}}MapV2) PExpire(key {{.Key}}, milliseconds int64) bool {
+	return mv2.getslot(key).PExpire(key, milliseconds)
+}
+func (mv2 *{{.Name}}MapV2) ExpireAt(key {{.Key}}, deadline time.Time) bool {
+	return mv2.getslot(key).ExpireAt(key, deadline)
+}
+func (mv2 *{{.Name}}MapV2) Persist(key {{.Key}}) bool {
+	return mv2.getslot(key).Persist(key)
+}
+func (mv2 *{{.Name}}MapV2) TTL(key {{.Key}}) int64 {
+	return mv2.getslot(key).TTL(key)
+}
+func (mv2 *{{.Name}}MapV2) PTTL(key {{.Key}}) int64 {
+	return mv2.getslot(key).PTTL(key)
+}
+{{if .Integer}}
+func (mv2 *{{.Name}}MapV2) Incr(key {{.Key}}) {{.Type}} {
+	return mv2.getslot(key).Incr(key)
+}
+func (mv2 *{{.Name}}MapV2) IncrEx(key {{.Key}}, seconds int) {{.Type}} {
+	return mv2.getslot(key).IncrEx(key, seconds)
+}
+func (mv2 *{{.Name}}MapV2) IncrBy(key {{.Key}}, n {{.Type}}) {{.Type}} {
+	return mv2.getslot(key).IncrBy(key, n)
+}
+func (mv2 *{{.Name}}MapV2) IncrByEx(key {{.Key}}, n {{.Type}}, seconds int) {{.Type}} {
+	return mv2.getslot(key).IncrByEx(key, n, seconds)
+}
+func (mv2 *{{.Name}}MapV2) IncrByTTL(key {{.Key}}, n {{.Type}}, ttl time.Duration) {{.Type}} {
+	return mv2.getslot(key).IncrByTTL(key, n, ttl)
+}
+func (mv2 *{{.Name}}MapV2) Decr(key {{.Key}}) {{.Type}} {
+	return mv2.getslot(key).Decr(key)
+}
+func (mv2 *{{.Name}}MapV2) DecrEx(key {{.Key}}, seconds int) {{.Type}} {
+	return mv2.getslot(key).DecrEx(key, seconds)
+}
+func (mv2 *{{.Name}}MapV2) DecrBy(key {{.Key}}, n {{.Type}}) {{.Type}} {
+	return mv2.getslot(key).DecrBy(key, n)
+}
+func (mv2 *{{.Name}}MapV2) DecrByEx(key {{.Key}}, n {{.Type}}, seconds int) {{.Type}} {
+	return mv2.getslot(key).DecrByEx(key, n, seconds)
+}
+func (mv2 *{{.Name}}MapV2) DecrByTTL(key {{.Key}}, n {{.Type}}, ttl time.Duration) {{.Type}} {
+	return mv2.getslot(key).DecrByTTL(key, n, ttl)
+}
+{{end}}
+func (mv2 *{{.Name}}MapV2) RPush(key {{.Key}}, elems ...{{.Type}}) (int, error) {
+	return mv2.getslot(key).RPush(key, elems...)
+}
+func (mv2 *{{.Name}}MapV2) LPop(key {{.Key}}) ({{.Type}}, bool, error) {
+	return mv2.getslot(key).LPop(key)
+}
+func (mv2 *{{.Name}}MapV2) LRange(key {{.Key}}, start int, stop int) ([]{{.Type}}, error) {
+	return mv2.getslot(key).LRange(key, start, stop)
+}
+func (mv2 *{{.Name}}MapV2) LLen(key {{.Key}}) (int, error) {
+	return mv2.getslot(key).LLen(key)
+}
+
+// Len returns sum of Len of slots
+func (mv2 *{{.Name}}MapV2) Len() int {
+	var n int
+	for i, _ := range mv2.slots {
+		n += mv2.slots[i].Len()
+	}
+	return n
+}
+
+// range all slots, if f returns false, will stop range process
+func (mv2 *{{.Name}}MapV2) Range(f func(key {{.Key}}, value {{.Type}}) bool) {
+	var stop bool
+	for i, _ := range mv2.slots {
+		mv2.slots[i].Range(func(key {{.Key}}, value {{.Type}}) bool {
+			if !f(key, value) {
+				stop = true
+				return false
+			}
+			return true
+		})
+		if stop {
+			return
+		}
+	}
+}
+
+// ClearExpireKeys clears expired keys of all slots
+func (mv2 *{{.Name}}MapV2) ClearExpireKeys() int {
+	var n int
+	for i, _ := range mv2.slots {
+		n += mv2.slots[i].ClearExpireKeys()
+	}
+	return n
+}
+
+// keep
+func (mv2 *{{.Name}}MapV2) mapd(interval time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-time.After(interval):
+				for i, _ := range mv2.slots {
+					mv2.slots[i].ClearExpireKeys()
+				}
+			case <-mv2.clear:
+				return
+			}
+		}
+	}()
 }
+`))
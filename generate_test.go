@@ -1,7 +1,12 @@
 package cmap
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -12,6 +17,31 @@ func TestGenerateTypeSyncMap(t *testing.T) {
 	}))
 }
 
+func TestGenerateTypeSyncMapArgs(t *testing.T) {
+	src := GenerateTypeSyncMap("Teacher", map[string]string{
+		"${package_name}": "school",
+		"${model}":        "tch",
+		"${MODEL}":        "TCH",
+		"cmap.Generate":   "cmap.GenerateTypeSyncMap",
+	})
+	for _, want := range []string{"package school", "tch_update_keep", "TCH_M_FREE2", "cmap.GenerateTypeSyncMap."} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("generated code should contain %q", want)
+		}
+	}
+
+	// invalid types don't panic
+	if src := GenerateTypeSyncMap("[", nil); src != "" {
+		t.Fatalf("want empty code of invalid type but got %d bytes", len(src))
+	}
+	if _, e := GenerateTypeSyncMapE("[", nil); e == nil {
+		t.Fatalf("want error of invalid type")
+	}
+	if _, e := GenerateTypeSyncMapE("Teacher", map[string]string{"${model}": "not valid"}); e == nil {
+		t.Fatalf("want error of invalid ${model}")
+	}
+}
+
 func TestReplace(t *testing.T) {
 	var r = `
 	   ${package_name}
@@ -28,3 +58,101 @@ func TestReplace(t *testing.T) {
 		"${MODEL}":        "USER",
 	}))
 }
+
+var update = flag.Bool("update", false, "update golden files of generated code")
+
+var goldens = []struct {
+	file string
+	c    GenerateConfig
+}{
+	{"teacher_map.golden", GenerateConfig{Package: "model", Type: "Teacher"}},
+	{"int64_map.golden", GenerateConfig{Package: "model", Type: "int64"}},
+	{"teacher_by_id_map.golden", GenerateConfig{Package: "model", Type: "*Teacher", Name: "TeacherById", Key: "TeacherId", KeyBase: "int64", Sharded: true}},
+	{"course_map.golden", GenerateConfig{Package: "model", Type: "Course", Key: "CourseKey", Sharded: true, KeyFields: []KeyField{
+		{Name: "School", Type: "string"},
+		{Name: "Id", Type: "int64"},
+		{Name: "Open", Type: "bool"},
+		{Name: "Score", Type: "float64"},
+	}}},
+}
+
+func TestGenerate(t *testing.T) {
+	for _, g := range goldens {
+		src, e := Generate(g.c)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		path := filepath.Join("testdata", g.file)
+		if *update {
+			if e := os.WriteFile(path, src, 0644); e != nil {
+				t.Fatal(e)
+			}
+			continue
+		}
+
+		want, e := os.ReadFile(path)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !bytes.Equal(src, want) {
+			t.Fatalf("generated code of %s differs from %s, run 'go test -run TestGenerate -update' if it's expected", g.c.Type, path)
+		}
+	}
+
+	var invalids = []GenerateConfig{
+		{Type: ""},
+		{Type: "Teacher", Package: "a-b"},
+		{Type: "[]byte"},
+		{Type: "map[string"},
+		{Type: "Teacher", Key: "TeacherId"},
+		{Type: "Teacher", Key: "CourseKey", KeyFields: []KeyField{{Name: "Tags", Type: "[]string"}}},
+	}
+	for _, c := range invalids {
+		if _, e := Generate(c); e == nil {
+			t.Fatalf("%+v should be invalid", c)
+		}
+	}
+	if _, e := Generate(GenerateConfig{Type: "[]byte", Name: "bytes"}); e != nil {
+		t.Fatalf("Name should name a map of []byte, %v", e)
+	}
+}
+
+// TestGeneratedCode compiles golden files and runs tests of them
+func TestGeneratedCode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip building generated code in short mode")
+	}
+	gobin, e := exec.LookPath("go")
+	if e != nil {
+		t.Skip("go command not found")
+	}
+
+	dir := t.TempDir()
+	var files = map[string]string{
+		"go.mod":               "testdata/generated.mod.txt",
+		"model_test.go":        "testdata/generated_test.go.txt",
+		"teacher_map.go":       "testdata/teacher_map.golden",
+		"int64_map.go":         "testdata/int64_map.golden",
+		"teacher_by_id_map.go": "testdata/teacher_by_id_map.golden",
+		"course_map.go":        "testdata/course_map.golden",
+	}
+	for name, from := range files {
+		b, e := os.ReadFile(from)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if e := os.WriteFile(filepath.Join(dir, name), b, 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	for _, args := range [][]string{{"vet", "."}, {"test", "-count=1", "."}} {
+		cmd := exec.Command(gobin, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+		if out, e := cmd.CombinedOutput(); e != nil {
+			t.Fatalf("go %s: %v\n%s", strings.Join(args, " "), e, out)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 module github.com/fwhezfwhez/cmap
 
-go 1.20
+go 1.23
 
 require github.com/fwhezfwhez/errorx v1.1.0
+
+require github.com/gofrs/uuid v4.0.0+incompatible // indirect
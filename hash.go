@@ -0,0 +1,273 @@
+package cmap
+
+import (
+	"fmt"
+	"github.com/fwhezfwhez/errorx"
+	"sync"
+)
+
+// chash is the hash type made by HSet, a map of field-values saved under a key.
+type chash struct {
+	m map[string]interface{}
+	l *sync.RWMutex
+}
+
+func newchash() *chash {
+	return &chash{
+		m: make(map[string]interface{}),
+		l: &sync.RWMutex{},
+	}
+}
+
+func (h *chash) get(field string) (interface{}, bool) {
+	h.l.RLock()
+	defer h.l.RUnlock()
+	v, ok := h.m[field]
+	return v, ok
+}
+
+// set returns whether field is new
+func (h *chash) set(field string, value interface{}) bool {
+	h.l.Lock()
+	defer h.l.Unlock()
+	_, exist := h.m[field]
+	h.m[field] = value
+	return !exist
+}
+
+func (h *chash) del(field string) bool {
+	h.l.Lock()
+	defer h.l.Unlock()
+	_, exist := h.m[field]
+	delete(h.m, field)
+	return exist
+}
+
+func (h *chash) len() int {
+	h.l.RLock()
+	defer h.l.RUnlock()
+	return len(h.m)
+}
+
+// size estimates bytes of fields and values, 32 per field
+func (h *chash) size() int64 {
+	return 32 * int64(h.len())
+}
+
+func (h *chash) keys() []string {
+	h.l.RLock()
+	defer h.l.RUnlock()
+	var keys = make([]string, 0, len(h.m))
+	for field, _ := range h.m {
+		keys = append(keys, field)
+	}
+	return keys
+}
+
+// getAll copies all field-values
+func (h *chash) getAll() map[string]interface{} {
+	h.l.RLock()
+	defer h.l.RUnlock()
+	var rs = make(map[string]interface{}, len(h.m))
+	for field, v := range h.m {
+		rs[field] = v
+	}
+	return rs
+}
+
+func (h *chash) String() string {
+	return fmt.Sprintf("chash len=%d", h.len())
+}
+
+// hashOf returns hash of key, an error if key holds another type.
+func (m *Map) hashOf(key string, command string) (*chash, bool, error) {
+	return compoundOf[*chash](m, key, command, TYPE_HASH)
+}
+
+// updateHash runs f with hash of key atomically, see updateCompound.
+// A hash is made if key doesn't exist and create is true.
+func (m *Map) updateHash(key string, command string, create bool, f func(h *chash) bool, record func() *opRecord) error {
+	if !create {
+		return updateCompound(m, key, command, TYPE_HASH, nil, f, record)
+	}
+	return updateCompound(m, key, command, TYPE_HASH, newchash, f, record)
+}
+
+// HSet sets field of hash of key to value, the hash is made if key doesn't exist.
+// It returns true if field is new.
+func (m *Map) HSet(key string, field string, value interface{}) (bool, error) {
+	var added bool
+	e := m.updateHash(key, "HSet", true, func(h *chash) bool {
+		added = h.set(field, value)
+		return true
+	}, func() *opRecord {
+		return fieldsRecord(aofOpHSet, map[string]interface{}{field: value})
+	})
+	return added, e
+}
+
+// HMSet sets fields of hash of key, it returns number of new fields.
+func (m *Map) HMSet(key string, fields map[string]interface{}) (int, error) {
+	var added int
+	e := m.updateHash(key, "HMSet", len(fields) > 0, func(h *chash) bool {
+		for field, v := range fields {
+			if h.set(field, v) {
+				added++
+			}
+		}
+		return len(fields) > 0
+	}, func() *opRecord {
+		return fieldsRecord(aofOpHSet, fields)
+	})
+	return added, e
+}
+
+// HSetNX sets field of hash of key only if field doesn't exist, it returns whether field is set.
+func (m *Map) HSetNX(key string, field string, value interface{}) (bool, error) {
+	var added bool
+	e := m.updateHash(key, "HSetNX", true, func(h *chash) bool {
+		if _, exist := h.get(field); exist {
+			return false
+		}
+		added = h.set(field, value)
+		return true
+	}, func() *opRecord {
+		return fieldsRecord(aofOpHSet, map[string]interface{}{field: value})
+	})
+	return added, e
+}
+
+// HGet returns value of field of hash of key.
+func (m *Map) HGet(key string, field string) (interface{}, bool, error) {
+	h, exist, e := m.hashOf(key, "HGet")
+	if e != nil || !exist {
+		return nil, false, e
+	}
+	v, ok := h.get(field)
+	return v, ok, nil
+}
+
+// HMGet returns values of fields of hash of key, nil for fields not existing.
+func (m *Map) HMGet(key string, fields ...string) ([]interface{}, error) {
+	var rs = make([]interface{}, len(fields))
+	h, exist, e := m.hashOf(key, "HMGet")
+	if e != nil || !exist {
+		return rs, e
+	}
+	for i, field := range fields {
+		rs[i], _ = h.get(field)
+	}
+	return rs, nil
+}
+
+// HDel deletes fields of hash of key and returns number of deleted ones, key is deleted when the hash becomes empty.
+func (m *Map) HDel(key string, fields ...string) (int, error) {
+	var deleted int
+	e := m.updateHash(key, "HDel", false, func(h *chash) bool {
+		for _, field := range fields {
+			if h.del(field) {
+				deleted++
+			}
+		}
+		return deleted > 0
+	}, func() *opRecord {
+		return membersRecord(aofOpHDel, fields)
+	})
+	return deleted, e
+}
+
+// HExists reports whether field exists in hash of key.
+func (m *Map) HExists(key string, field string) (bool, error) {
+	_, ok, e := m.HGet(key, field)
+	return ok, e
+}
+
+// HLen returns number of fields of hash of key.
+func (m *Map) HLen(key string) (int, error) {
+	h, exist, e := m.hashOf(key, "HLen")
+	if e != nil || !exist {
+		return 0, e
+	}
+	return h.len(), nil
+}
+
+// HKeys returns fields of hash of key in no particular order.
+func (m *Map) HKeys(key string) ([]string, error) {
+	h, exist, e := m.hashOf(key, "HKeys")
+	if e != nil || !exist {
+		return []string{}, e
+	}
+	return h.keys(), nil
+}
+
+// HGetAll returns a copy of field-values of hash of key.
+func (m *Map) HGetAll(key string) (map[string]interface{}, error) {
+	h, exist, e := m.hashOf(key, "HGetAll")
+	if e != nil || !exist {
+		return map[string]interface{}{}, e
+	}
+	return h.getAll(), nil
+}
+
+// HIncrBy increases integer value of field of hash of key by delta, a field not existing is taken as 0.
+// It returns an error if the value is not an integer.
+func (m *Map) HIncrBy(key string, field string, delta int64) (int64, error) {
+	var rs int64
+	var saved interface{}
+	var err error
+	e := m.updateHash(key, "HIncrBy", true, func(h *chash) bool {
+		old, exist := h.get(field)
+		if !exist {
+			old = int64(0)
+		}
+		v, e := incr(old, int(delta))
+		if e != nil {
+			err = errorx.NewServiceError(fmt.Sprintf("cmap.Map.HIncrBy key=%s field=%s is not integer, cannot execute HIncrBy", key, field), 1)
+			return false
+		}
+		h.set(field, v)
+		rs, saved = Int64(v), v
+		return true
+	}, func() *opRecord {
+		// the result rather than delta, so it replays to the same value
+		return fieldsRecord(aofOpHSet, map[string]interface{}{field: saved})
+	})
+	if e != nil {
+		return 0, e
+	}
+	return rs, err
+}
+
+func (mv2 *MapV2) HSet(key string, field string, value interface{}) (bool, error) {
+	return mv2.getslot(key).HSet(key, field, value)
+}
+func (mv2 *MapV2) HMSet(key string, fields map[string]interface{}) (int, error) {
+	return mv2.getslot(key).HMSet(key, fields)
+}
+func (mv2 *MapV2) HSetNX(key string, field string, value interface{}) (bool, error) {
+	return mv2.getslot(key).HSetNX(key, field, value)
+}
+func (mv2 *MapV2) HGet(key string, field string) (interface{}, bool, error) {
+	return mv2.getslot(key).HGet(key, field)
+}
+func (mv2 *MapV2) HMGet(key string, fields ...string) ([]interface{}, error) {
+	return mv2.getslot(key).HMGet(key, fields...)
+}
+func (mv2 *MapV2) HDel(key string, fields ...string) (int, error) {
+	return mv2.getslot(key).HDel(key, fields...)
+}
+func (mv2 *MapV2) HExists(key string, field string) (bool, error) {
+	return mv2.getslot(key).HExists(key, field)
+}
+func (mv2 *MapV2) HLen(key string) (int, error) {
+	return mv2.getslot(key).HLen(key)
+}
+func (mv2 *MapV2) HKeys(key string) ([]string, error) {
+	return mv2.getslot(key).HKeys(key)
+}
+func (mv2 *MapV2) HGetAll(key string) (map[string]interface{}, error) {
+	return mv2.getslot(key).HGetAll(key)
+}
+func (mv2 *MapV2) HIncrBy(key string, field string, delta int64) (int64, error) {
+	return mv2.getslot(key).HIncrBy(key, field, delta)
+}
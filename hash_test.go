@@ -0,0 +1,220 @@
+package cmap
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHash(t *testing.T) {
+	m := NewMap()
+
+	if added, e := m.HSet("user", "name", "tom"); !added || e != nil {
+		t.Fatalf("want new field but got %v %v", added, e)
+	}
+	if added, _ := m.HSet("user", "name", "jerry"); added {
+		t.Fatalf("existing field should not be added")
+	}
+	if ok, _ := m.HSetNX("user", "name", "tom"); ok {
+		t.Fatalf("HSetNX of existing field should fail")
+	}
+	if ok, _ := m.HSetNX("user", "age", 18); !ok {
+		t.Fatalf("HSetNX of new field should succeed")
+	}
+	if n, _ := m.HMSet("user", map[string]interface{}{"city": "sz", "age": 19}); n != 1 {
+		t.Fatalf("want 1 new field but got %d", n)
+	}
+
+	if v, ok, _ := m.HGet("user", "name"); !ok || v != "jerry" {
+		t.Fatalf("want jerry but got %v", v)
+	}
+	if vs, _ := m.HMGet("user", "name", "missing", "age"); vs[0] != "jerry" || vs[1] != nil || vs[2] != 19 {
+		t.Fatalf("unexpected values %v", vs)
+	}
+	if ok, _ := m.HExists("user", "city"); !ok {
+		t.Fatalf("city should exist")
+	}
+	if n, _ := m.HLen("user"); n != 3 {
+		t.Fatalf("want 3 fields but got %d", n)
+	}
+	keys, _ := m.HKeys("user")
+	sort.Strings(keys)
+	if len(keys) != 3 || keys[0] != "age" || keys[1] != "city" || keys[2] != "name" {
+		t.Fatalf("unexpected fields %v", keys)
+	}
+	all, _ := m.HGetAll("user")
+	all["name"] = "changed"
+	if v, _, _ := m.HGet("user", "name"); v != "jerry" {
+		t.Fatalf("HGetAll should return a copy")
+	}
+	if m.Type("user") != TYPE_HASH {
+		t.Fatalf("want hash but got %s", m.Type("user"))
+	}
+
+	if n, e := m.HIncrBy("user", "age", 2); n != 21 || e != nil {
+		t.Fatalf("want 21 but got %d %v", n, e)
+	}
+	if n, _ := m.HIncrBy("user", "visits", -1); n != -1 {
+		t.Fatalf("want -1 but got %d", n)
+	}
+	if _, e := m.HIncrBy("user", "name", 1); e == nil {
+		t.Fatalf("want error of increasing string")
+	}
+
+	if n, _ := m.HDel("user", "name", "missing"); n != 1 {
+		t.Fatalf("want 1 deleted but got %d", n)
+	}
+	m.HDel("user", "age", "city", "visits")
+	if _, ok := m.Get("user"); ok {
+		t.Fatalf("empty hash should be deleted")
+	}
+	if n, e := m.HDel("user", "name"); n != 0 || e != nil {
+		t.Fatalf("HDel of missing key should do nothing, got %d %v", n, e)
+	}
+	if n, e := m.HMSet("user", nil); n != 0 || e != nil {
+		t.Fatalf("HMSet of no fields should do nothing, got %d %v", n, e)
+	}
+	if _, ok := m.Get("user"); ok {
+		t.Fatalf("HMSet of no fields should not make key")
+	}
+}
+
+func TestHashWrongType(t *testing.T) {
+	m := NewMap()
+	m.Set("string", 1)
+	m.RPush("list", 1)
+	m.HSet("hash", "f", 1)
+
+	if _, e := m.HSet("string", "f", 1); e == nil {
+		t.Fatalf("want wrong type error of HSet")
+	}
+	if _, _, e := m.HGet("list", "f"); e == nil {
+		t.Fatalf("want wrong type error of HGet")
+	}
+	if _, e := m.HIncrBy("list", "f", 1); e == nil {
+		t.Fatalf("want wrong type error of HIncrBy")
+	}
+	if _, e := m.RPush("hash", 1); e == nil {
+		t.Fatalf("want wrong type error of RPush")
+	}
+	if v, _ := m.Get("string"); v != 1 {
+		t.Fatalf("wrong type command should not change key, got %v", v)
+	}
+}
+
+func TestHashTTL(t *testing.T) {
+	m := NewMap()
+	m.HSet("session", "user", 1)
+	m.Expire("session", 100)
+	m.HSet("session", "role", "admin")
+	if ttl := m.TTL("session"); ttl <= 0 || ttl > 100 {
+		t.Fatalf("HSet should keep ttl, got %d", ttl)
+	}
+
+	m.PExpire("session", 1)
+	time.Sleep(2 * time.Millisecond)
+	if n, _ := m.HLen("session"); n != 0 {
+		t.Fatalf("expired hash should be empty, got %d", n)
+	}
+	if added, _ := m.HSet("session", "user", 2); !added || m.TTL("session") != TTL_NO_EXPIRE {
+		t.Fatalf("HSet on expired key should make a new hash without time limit")
+	}
+}
+
+func TestHashInBusyMode(t *testing.T) {
+	m := NewMap()
+
+	var stop = make(chan struct{})
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.ClearExpireKeys()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				if _, e := m.HIncrBy("counter", "n", 1); e != nil {
+					t.Error(e)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-done
+
+	if v, _, _ := m.HGet("counter", "n"); Int64(v) != 4000 {
+		t.Fatalf("want 4000 but got %v", v)
+	}
+}
+
+func TestHashPersistence(t *testing.T) {
+	m := NewMap()
+	m.HMSet("user", map[string]interface{}{"name": "tom", "age": 18})
+	m.Expire("user", 100)
+
+	var buf bytes.Buffer
+	if e := m.SaveSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+	m2 := NewMap()
+	if e := m2.LoadSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+	if v, _, _ := m2.HGet("user", "name"); v != "tom" {
+		t.Fatalf("want tom but got %v", v)
+	}
+	if ttl := m2.TTL("user"); ttl <= 0 || ttl > 100 {
+		t.Fatalf("ttl should be restored, got %d", ttl)
+	}
+
+	path := filepath.Join(t.TempDir(), "cmap.aof")
+	aof := openTestAOF(t, path, FSYNC_ALWAYS)
+	m3 := NewMap()
+	if e := m3.UseAOF(aof); e != nil {
+		t.Fatal(e)
+	}
+	m3.HSet("user", "name", "tom")
+	m3.HIncrBy("user", "age", 18)
+	m3.HDel("user", "name")
+	aof.Close()
+
+	aof2 := openTestAOF(t, path, FSYNC_ALWAYS)
+	defer aof2.Close()
+	m4 := NewMap()
+	if e := m4.UseAOF(aof2); e != nil {
+		t.Fatal(e)
+	}
+	if all, _ := m4.HGetAll("user"); len(all) != 1 || Int64(all["age"]) != 18 {
+		t.Fatalf("unexpected replayed hash %v", all)
+	}
+}
+
+func TestMapV2Hash(t *testing.T) {
+	mv2 := NewMapV2(nil, 8, time.Minute)
+	defer mv2.Clear()
+
+	mv2.HSet("user:1", "name", "tom")
+	mv2.HIncrBy("user:1", "age", 18)
+	if all, _ := mv2.HGetAll("user:1"); len(all) != 2 || all["name"] != "tom" {
+		t.Fatalf("unexpected hash %v", all)
+	}
+	if mv2.Type("user:1") != TYPE_HASH {
+		t.Fatalf("want hash type")
+	}
+}
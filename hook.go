@@ -0,0 +1,181 @@
+package cmap
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// RemoveReason tells why a key-value is removed from a map.
+type RemoveReason int
+
+const (
+	REASON_EXPIRED     RemoveReason = 1 // value is expired, removed by Get, ClearExpireKeys or a new write
+	REASON_EVICTED     RemoveReason = 2 // value is evicted by a bounded map
+	REASON_DELETED     RemoveReason = 3 // value is deleted by Delete or alike
+	REASON_OVERWRITTEN RemoveReason = 4 // value is replaced by a new value
+)
+
+func (r RemoveReason) String() string {
+	switch r {
+	case REASON_EXPIRED:
+		return "expired"
+	case REASON_EVICTED:
+		return "evicted"
+	case REASON_DELETED:
+		return "deleted"
+	case REASON_OVERWRITTEN:
+		return "overwritten"
+	}
+	return "unknown"
+}
+
+// notice describes a change of map, it's collected under map locks and delivered after all locks released.
+// A notice with reason is delivered to OnEvict callbacks, a notice of an event is delivered to watchers.
+type notice struct {
+	key    string
+	value  interface{}
+	reason RemoveReason
+
+	event  EventType
+	offset int64
+}
+
+// eventOf returns the watch event of n
+func (n notice) eventOf() (Event, bool) {
+	var typ = n.event
+	switch n.reason {
+	case REASON_EXPIRED:
+		typ = EVENT_EXPIRE
+	case REASON_EVICTED:
+		typ = EVENT_EVICT
+	case REASON_DELETED:
+		typ = EVENT_DEL
+	}
+	if typ == 0 {
+		return Event{}, false
+	}
+	return Event{Type: typ, Key: n.key, Value: n.value, Offset: n.offset}, true
+}
+
+// hooks saves callbacks registered by OnEvict, OnExpire and watchers registered by Watch
+type hooks struct {
+	// number of callbacks and watchers, make notify cheap when no one listens
+	callbacks int32
+	watching  int32
+
+	l        *sync.RWMutex
+	onEvict  []func(key string, value interface{}, reason RemoveReason)
+	watchers []*watcher
+}
+
+func newHooks() *hooks {
+	return &hooks{
+		l: &sync.RWMutex{},
+	}
+}
+
+func (h *hooks) isEnabled() bool {
+	return atomic.LoadInt32(&h.callbacks) > 0 || atomic.LoadInt32(&h.watching) > 0
+}
+
+func (h *hooks) isWatching() bool {
+	return atomic.LoadInt32(&h.watching) > 0
+}
+
+func (h *hooks) add(f func(key string, value interface{}, reason RemoveReason)) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	h.onEvict = append(h.onEvict, f)
+	atomic.AddInt32(&h.callbacks, 1)
+}
+
+func (h *hooks) addWatcher(w *watcher) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	// copy on write, fire ranges watchers without lock
+	watchers := make([]*watcher, 0, len(h.watchers)+1)
+	watchers = append(watchers, h.watchers...)
+	h.watchers = append(watchers, w)
+	atomic.AddInt32(&h.watching, 1)
+}
+
+func (h *hooks) removeWatcher(w *watcher) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	watchers := make([]*watcher, 0, len(h.watchers))
+	for _, v := range h.watchers {
+		if v != w {
+			watchers = append(watchers, v)
+		}
+	}
+	if len(watchers) != len(h.watchers) {
+		atomic.AddInt32(&h.watching, -1)
+	}
+	h.watchers = watchers
+}
+
+func (h *hooks) fire(notices []notice) {
+	if len(notices) == 0 || !h.isEnabled() {
+		return
+	}
+
+	h.l.RLock()
+	fs := h.onEvict
+	ws := h.watchers
+	h.l.RUnlock()
+
+	for _, n := range notices {
+		if n.reason != 0 {
+			for _, f := range fs {
+				f(n.key, n.value, n.reason)
+			}
+		}
+
+		if len(ws) == 0 {
+			continue
+		}
+		if event, ok := n.eventOf(); ok {
+			for _, w := range ws {
+				w.notify(event)
+			}
+		}
+	}
+}
+
+// OnEvict registers f, which is called when a key-value is removed from map for any reason.
+// f is called after map's locks are released, so it's safe to operate map inside f.
+// Expiration is reported once when the value is dropped from map.m, by Get, ClearExpireKeys or a new write.
+func (m *Map) OnEvict(f func(key string, value interface{}, reason RemoveReason)) {
+	m.hooks.add(f)
+}
+
+// OnExpire registers f, which is called when an expired key-value is removed from map.
+func (m *Map) OnExpire(f func(key string, value interface{})) {
+	m.hooks.add(func(key string, value interface{}, reason RemoveReason) {
+		if reason == REASON_EXPIRED {
+			f(key, value)
+		}
+	})
+}
+
+// removedOf returns notices of old value replaced or deleted in a register.
+// Expiration is reported only by map.m, since every key in dirty will be dropped by map.m too.
+// reason 0 reports expiration only.
+func removedOf(key string, old Value, exist bool, isM bool, reason RemoveReason) []notice {
+	if !exist {
+		return nil
+	}
+	if old.isExpire() {
+		if !isM {
+			return nil
+		}
+		reason = REASON_EXPIRED
+	}
+	if reason == 0 {
+		return nil
+	}
+	return []notice{{key: key, value: old.v, reason: reason, offset: old.offset}}
+}
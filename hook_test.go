@@ -0,0 +1,110 @@
+package cmap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type removedRecord struct {
+	key    string
+	value  interface{}
+	reason RemoveReason
+}
+
+func recordRemoved(m *Map) (func() []removedRecord, *sync.Mutex) {
+	var l = &sync.Mutex{}
+	var records []removedRecord
+	m.OnEvict(func(key string, value interface{}, reason RemoveReason) {
+		l.Lock()
+		records = append(records, removedRecord{key, value, reason})
+		l.Unlock()
+	})
+	return func() []removedRecord {
+		l.Lock()
+		defer l.Unlock()
+		return append([]removedRecord{}, records...)
+	}, l
+}
+
+func TestOnEvict(t *testing.T) {
+	m := NewMap(WithMaxEntries(2), WithEvictionSamples(100))
+	records, _ := recordRemoved(m)
+
+	m.Set("a", 1)
+	m.Set("a", 2)
+	m.Delete("a")
+	m.SetTTL("b", 3, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	m.Get("b")
+
+	m.Set("c", 4)
+	m.Set("d", 5)
+	m.Set("e", 6)
+
+	want := []removedRecord{
+		{"a", 1, REASON_OVERWRITTEN},
+		{"a", 2, REASON_DELETED},
+		{"b", 3, REASON_EXPIRED},
+		{"c", 4, REASON_EVICTED},
+	}
+	got := records()
+	if len(got) != len(want) {
+		t.Fatalf("want %v but got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v but got %v", want[i], got[i])
+		}
+	}
+}
+
+func TestOnExpireClearExpireKeys(t *testing.T) {
+	m := NewMap()
+
+	var l = &sync.Mutex{}
+	var expired = make(map[string]int)
+	m.OnExpire(func(key string, value interface{}) {
+		l.Lock()
+		expired[key]++
+		l.Unlock()
+
+		// operating map inside callback should not dead lock
+		m.Set("callback-"+key, value)
+	})
+
+	for i := 0; i < 100; i++ {
+		m.SetTTL(strconv.Itoa(i), i, 10*time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		m.ClearExpireKeys()
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			m.Get(strconv.Itoa(i))
+		}
+	}()
+	wg.Wait()
+	m.ClearExpireKeys()
+
+	l.Lock()
+	defer l.Unlock()
+	if len(expired) != 100 {
+		t.Fatalf("want 100 expired keys but got %d", len(expired))
+	}
+	for k, n := range expired {
+		if n != 1 {
+			t.Fatalf("key %s expired reported %d times", k, n)
+		}
+	}
+	if _, ok := m.Get("callback-1"); !ok {
+		t.Fatalf("set inside callback fails")
+	}
+}
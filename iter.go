@@ -0,0 +1,168 @@
+package cmap
+
+import (
+	"iter"
+)
+
+// iterEntry is a key-value copied for iterators
+type iterEntry struct {
+	key   string
+	value interface{}
+}
+
+// copyUnexpired copies unexpired key-values of the readable register.
+// Iterators range over the copy, so no lock is held in body of caller's loop.
+func (m *Map) copyUnexpired() []iterEntry {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	l, mp := m.readableWrapedBymodl()
+	l.RLock()
+	defer l.RUnlock()
+
+	var entries = make([]iterEntry, 0, len(mp))
+	for k, v := range mp {
+		if v.isExpire() {
+			continue
+		}
+		entries = append(entries, iterEntry{key: k, value: v.v})
+	}
+	return entries
+}
+
+func rangeEntries(entries []iterEntry, yield func(string, interface{}) bool) bool {
+	for _, e := range entries {
+		if !yield(e.key, e.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// All returns an iterator over unexpired key-values, like:
+//
+//	for k, v := range m.All() {
+//	    ...
+//	}
+//
+// Key-values are copied when iterating starts, changes made in the loop are not seen.
+// Unlike Range, no lock is held in the loop, so map can be read and written in it.
+func (m *Map) All() iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		rangeEntries(m.copyUnexpired(), yield)
+	}
+}
+
+// AllKeys returns an iterator over unexpired keys, see All.
+// It's not named Keys for Keys(pattern) returns a slice of matched keys.
+func (m *Map) AllKeys() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for k, _ := range m.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns an iterator over unexpired values, see All.
+func (m *Map) Values() iter.Seq[interface{}] {
+	return func(yield func(interface{}) bool) {
+		for _, v := range m.All() {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over unexpired key-values of all slots, see Map.All.
+// Slots are copied one by one when the loop reaches them.
+func (mv2 *MapV2) All() iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		for i, _ := range mv2.slots {
+			if !rangeEntries(mv2.slots[i].copyUnexpired(), yield) {
+				return
+			}
+		}
+	}
+}
+
+// AllKeys returns an iterator over unexpired keys of all slots, see Map.AllKeys.
+func (mv2 *MapV2) AllKeys() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for k, _ := range mv2.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns an iterator over unexpired values of all slots, see Map.All.
+func (mv2 *MapV2) Values() iter.Seq[interface{}] {
+	return func(yield func(interface{}) bool) {
+		for _, v := range mv2.All() {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// LAll returns an iterator over index-elements of list of key, elements are copied when iterating starts.
+// An empty iterator is returned if key doesn't exist.
+func (m *Map) LAll(key string) (iter.Seq2[int, interface{}], error) {
+	clist, exist, e := m.listOf(key, "LAll")
+	if e != nil {
+		return nil, e
+	}
+	if !exist {
+		return func(yield func(int, interface{}) bool) {}, nil
+	}
+	return clist.All(), nil
+}
+
+func (mv2 *MapV2) LAll(key string) (iter.Seq2[int, interface{}], error) {
+	return mv2.getslot(key).LAll(key)
+}
+
+// All returns an iterator over unexpired key-values of all slots, see Map.All.
+func (s *SlotMap) All() iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		s.rLock()
+		var slots = make([]*Map, len(s.slots))
+		for i, _ := range s.slots {
+			slots[i] = &s.slots[i]
+		}
+		s.rUnlock()
+
+		for i, _ := range slots {
+			if !rangeEntries(slots[i].copyUnexpired(), yield) {
+				return
+			}
+		}
+	}
+}
+
+// AllKeys returns an iterator over unexpired keys of all slots, see Map.AllKeys.
+func (s *SlotMap) AllKeys() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for k, _ := range s.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns an iterator over unexpired values of all slots, see Map.All.
+func (s *SlotMap) Values() iter.Seq[interface{}] {
+	return func(yield func(interface{}) bool) {
+		for _, v := range s.All() {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package cmap
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAll(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 10; i++ {
+		m.Set("k"+strconv.Itoa(i), i)
+	}
+	m.SetTTL("expired", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	var sum int
+	for k, v := range m.All() {
+		// no lock is held in loop body
+		m.Set(k, v.(int)+1)
+		m.Delete("k9")
+		sum += v.(int)
+	}
+	if sum != 45 {
+		t.Fatalf("want 45 but got %d", sum)
+	}
+
+	var keys []string
+	for k := range m.AllKeys() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) != 9 || keys[0] != "k0" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	var n int
+	for range m.Values() {
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Fatalf("break should stop iterating, got %d", n)
+	}
+
+	m.setBusy()
+	m.Set("busy", 1)
+	if v, _ := m.Get("busy"); v != 1 {
+		t.Fatalf("unexpected busy value")
+	}
+	n = 0
+	for range m.All() {
+		n++
+	}
+	m.setFree2()
+	if n != 10 {
+		t.Fatalf("want 10 keys in busy mode but got %d", n)
+	}
+}
+
+func TestMapV2All(t *testing.T) {
+	mv2 := NewMapV2(nil, 8, time.Minute)
+	defer mv2.Clear()
+
+	for i := 0; i < 100; i++ {
+		mv2.Set("k"+strconv.Itoa(i), i)
+	}
+
+	var seen = make(map[string]bool)
+	for k, v := range mv2.All() {
+		if "k"+strconv.Itoa(v.(int)) != k {
+			t.Fatalf("unexpected value %v of %s", v, k)
+		}
+		seen[k] = true
+		mv2.Delete(k)
+	}
+	if len(seen) != 100 || mv2.Len() != 0 {
+		t.Fatalf("want 100 keys but got %d, %d left", len(seen), mv2.Len())
+	}
+
+	mv2.Set("a", 1)
+	for k := range mv2.AllKeys() {
+		if k != "a" {
+			t.Fatalf("unexpected key %s", k)
+		}
+	}
+	for v := range mv2.Values() {
+		if v != 1 {
+			t.Fatalf("unexpected value %v", v)
+		}
+	}
+}
+
+func TestSlotMapAll(t *testing.T) {
+	s := NewSlotMap(4, false, time.Minute)
+	for i := 0; i < 20; i++ {
+		s.Set("k"+strconv.Itoa(i), i)
+	}
+
+	var n, sum int
+	for _, v := range s.All() {
+		n++
+		sum += v.(int)
+	}
+	if n != 20 || sum != 190 {
+		t.Fatalf("want 20 keys summing 190 but got %d %d", n, sum)
+	}
+	n = 0
+	for range s.AllKeys() {
+		n++
+	}
+	for range s.Values() {
+		n++
+	}
+	if n != 40 {
+		t.Fatalf("want 40 but got %d", n)
+	}
+}
+
+func TestLAll(t *testing.T) {
+	m := NewMap()
+	m.RPush("list", "a", "b", "c")
+
+	elems, e := m.LAll("list")
+	if e != nil {
+		t.Fatal(e)
+	}
+	var got []interface{}
+	for i, v := range elems {
+		if i != len(got) {
+			t.Fatalf("unexpected index %d", i)
+		}
+		// list can be changed in loop body
+		m.RPush("list", "d")
+		got = append(got, v)
+	}
+	if len(got) != 3 || got[2] != "c" {
+		t.Fatalf("unexpected elements %v", got)
+	}
+
+	elems, e = m.LAll("missing")
+	if e != nil {
+		t.Fatal(e)
+	}
+	for range elems {
+		t.Fatalf("missing list should be empty")
+	}
+
+	m.Set("string", 1)
+	if _, e := m.LAll("string"); e == nil {
+		t.Fatalf("want wrong type error")
+	}
+}
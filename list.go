@@ -0,0 +1,275 @@
+package cmap
+
+import (
+	"github.com/fwhezfwhez/errorx"
+)
+
+// ListEnd is an end of list, used by LMove.
+type ListEnd int
+
+const (
+	LIST_LEFT  ListEnd = 0 // head of list
+	LIST_RIGHT ListEnd = 1 // tail of list
+)
+
+// ListPosition tells LInsert where to insert relative to the pivot.
+type ListPosition int
+
+const (
+	LIST_BEFORE ListPosition = 0
+	LIST_AFTER  ListPosition = 1
+)
+
+// push pushes elems to head of list of key if left is true, otherwise to tail, then serves callers blocked on key.
+// A new list without time limit is made if key doesn't exist. It returns length of the list after pushing,
+// counting elements taken by blocked callers.
+// Caller must hold m.listLock.
+func (m *Map) push(key string, command string, left bool, elems []interface{}) (int, error) {
+	clist, exist, e := m.listOf(key, command)
+	if e != nil {
+		return 0, e
+	}
+
+	var n int
+	if exist {
+		m.loggedPush(key, clist, left, elems...)
+		n = clist.LLen()
+	} else {
+		clist = newclist()
+		if left {
+			clist.LPushN(elems...)
+		} else {
+			clist.RPushN(elems...)
+		}
+		m.Set(key, clist)
+		n = len(elems)
+	}
+
+	m.serveBlocked(key)
+	return n, nil
+}
+
+// pop removes and returns the first element of list of key if left is true, otherwise the last.
+// Key is deleted when the list becomes empty.
+// Caller must hold m.listLock.
+func (m *Map) pop(key string, command string, left bool) (interface{}, bool, error) {
+	clist, exist, e := m.listOf(key, command)
+	if e != nil || !exist {
+		return nil, false, e
+	}
+
+	elem, ok := m.loggedPop(key, clist, left)
+	if clist.LLen() == 0 {
+		m.Delete(key)
+	}
+	return elem, ok, nil
+}
+
+// LPush inserts elems at head of list of key one by one like redis LPUSH, so the last elem becomes the first element.
+// A new list without time limit is made if key doesn't exist. It returns length of the list after pushing.
+func (m *Map) LPush(key string, elems ...interface{}) (int, error) {
+	if len(elems) == 0 {
+		return m.LLen(key)
+	}
+
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	return m.push(key, "LPush", true, elems)
+}
+
+// RPop removes and returns the last element of list of key, key is deleted when the list becomes empty.
+func (m *Map) RPop(key string) (interface{}, bool, error) {
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	return m.pop(key, "RPop", false)
+}
+
+// LPopN removes and returns at most count elements from head of list of key, like redis LPOP key count.
+// Elements are popped under one lock, so other list commands don't interleave. Key is deleted when the list becomes empty.
+// It returns false if key doesn't exist.
+func (m *Map) LPopN(key string, count int) ([]interface{}, bool, error) {
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	clist, exist, e := m.listOf(key, "LPopN")
+	if e != nil || !exist {
+		return []interface{}{}, false, e
+	}
+
+	n := clist.LLen()
+	if count < n {
+		n = count
+	}
+	var elems = make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		elem, ok := m.loggedPop(key, clist, true)
+		if !ok {
+			break
+		}
+		elems = append(elems, elem)
+	}
+	if clist.LLen() == 0 {
+		m.Delete(key)
+	}
+	return elems, true, nil
+}
+
+// LIndex returns element of index of list of key, negative index counts from the end of list.
+func (m *Map) LIndex(key string, index int) (interface{}, bool, error) {
+	clist, exist, e := m.listOf(key, "LIndex")
+	if e != nil || !exist {
+		return nil, false, e
+	}
+	elem, ok := clist.LIndex(index)
+	return elem, ok, nil
+}
+
+// LSet sets element of index of list of key, it returns an error if key doesn't exist or index is out of range.
+func (m *Map) LSet(key string, index int, elem interface{}) error {
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	clist, exist, e := m.listOf(key, "LSet")
+	if e != nil {
+		return e
+	}
+	if !exist {
+		return errorx.NewFromStringf("cmap.Map.LSet key=%s doesn't exist", key)
+	}
+
+	var ok bool
+	m.loggedList(key, clist, func() bool {
+		ok = clist.LSet(index, elem)
+		return ok
+	})
+	if !ok {
+		return errorx.NewFromStringf("cmap.Map.LSet key=%s index %d out of range", key, index)
+	}
+	return nil
+}
+
+// LTrim keeps elements of list of key from start to stop only, both inclusive, negative indexes count from the end.
+// Key is deleted when the list becomes empty.
+func (m *Map) LTrim(key string, start int, stop int) error {
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	clist, exist, e := m.listOf(key, "LTrim")
+	if e != nil || !exist {
+		return e
+	}
+
+	m.loggedList(key, clist, func() bool {
+		n := clist.LLen()
+		clist.LTrim(start, stop)
+		return clist.LLen() != n
+	})
+	if clist.LLen() == 0 {
+		m.Delete(key)
+	}
+	return nil
+}
+
+// LRem removes elements equal to elem from list of key, and returns number of removed ones.
+// count > 0 removes the first count ones from head, count < 0 removes the first -count ones from tail, count = 0 removes all.
+// Key is deleted when the list becomes empty.
+func (m *Map) LRem(key string, count int, elem interface{}) (int, error) {
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	clist, exist, e := m.listOf(key, "LRem")
+	if e != nil || !exist {
+		return 0, e
+	}
+
+	var removed int
+	m.loggedList(key, clist, func() bool {
+		removed = clist.LRem(count, elem)
+		return removed > 0
+	})
+	if clist.LLen() == 0 {
+		m.Delete(key)
+	}
+	return removed, nil
+}
+
+// LInsert inserts elem before or after the first element equal to pivot in list of key.
+// It returns length of the list after inserting, -1 if pivot is not found, 0 if key doesn't exist.
+func (m *Map) LInsert(key string, where ListPosition, pivot interface{}, elem interface{}) (int, error) {
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	clist, exist, e := m.listOf(key, "LInsert")
+	if e != nil || !exist {
+		return 0, e
+	}
+
+	var n int
+	m.loggedList(key, clist, func() bool {
+		n = clist.LInsert(where == LIST_BEFORE, pivot, elem)
+		return n != -1
+	})
+	return n, nil
+}
+
+// LMove pops an element from from end of list of src and pushes it to to end of list of dst atomically, like redis LMOVE.
+// src and dst can be the same key to rotate a list. It returns false if src doesn't exist.
+func (m *Map) LMove(src string, dst string, from ListEnd, to ListEnd) (interface{}, bool, error) {
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	return lmove(m, m, src, dst, from, to)
+}
+
+// lmove moves an element from list of src in sm to list of dst in dm.
+// Caller must hold listLock of both maps.
+func lmove(sm *Map, dm *Map, src string, dst string, from ListEnd, to ListEnd) (interface{}, bool, error) {
+	// fail before popping, so nothing is lost
+	if _, _, e := dm.listOf(dst, "LMove"); e != nil {
+		return nil, false, e
+	}
+
+	elem, ok, e := sm.pop(src, "LMove", from == LIST_LEFT)
+	if e != nil || !ok {
+		return nil, false, e
+	}
+	if _, e := dm.push(dst, "LMove", to == LIST_LEFT, []interface{}{elem}); e != nil {
+		return nil, false, e
+	}
+	return elem, true, nil
+}
+
+func (mv2 *MapV2) LPush(key string, elems ...interface{}) (int, error) {
+	return mv2.getslot(key).LPush(key, elems...)
+}
+func (mv2 *MapV2) RPop(key string) (interface{}, bool, error) {
+	return mv2.getslot(key).RPop(key)
+}
+func (mv2 *MapV2) LPopN(key string, count int) ([]interface{}, bool, error) {
+	return mv2.getslot(key).LPopN(key, count)
+}
+func (mv2 *MapV2) LIndex(key string, index int) (interface{}, bool, error) {
+	return mv2.getslot(key).LIndex(key, index)
+}
+func (mv2 *MapV2) LSet(key string, index int, elem interface{}) error {
+	return mv2.getslot(key).LSet(key, index, elem)
+}
+func (mv2 *MapV2) LTrim(key string, start int, stop int) error {
+	return mv2.getslot(key).LTrim(key, start, stop)
+}
+func (mv2 *MapV2) LRem(key string, count int, elem interface{}) (int, error) {
+	return mv2.getslot(key).LRem(key, count, elem)
+}
+func (mv2 *MapV2) LInsert(key string, where ListPosition, pivot interface{}, elem interface{}) (int, error) {
+	return mv2.getslot(key).LInsert(key, where, pivot, elem)
+}
+
+// LMove moves an element between lists atomically, src and dst can be in different slots, see Map.LMove.
+func (mv2 *MapV2) LMove(src string, dst string, from ListEnd, to ListEnd) (interface{}, bool, error) {
+	unlock := mv2.lockLists(src, dst)
+	defer unlock()
+
+	return lmove(mv2.getslot(src), mv2.getslot(dst), src, dst, from, to)
+}
@@ -0,0 +1,299 @@
+package cmap
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func listString(t *testing.T, m interface {
+	LRange(key string, start int, stop int) ([]interface{}, error)
+}, key string) string {
+	elems, e := m.LRange(key, 0, -1)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return fmt.Sprint(elems)
+}
+
+func TestListCommands(t *testing.T) {
+	m := NewMap()
+
+	if n, _ := m.LPush("list", "c", "b", "a"); n != 3 {
+		t.Fatalf("want 3 but got %d", n)
+	}
+	m.RPush("list", "d", "e")
+	if s := listString(t, m, "list"); s != "[a b c d e]" {
+		t.Fatalf("want [a b c d e] but got %s", s)
+	}
+	if elems, _ := m.LRange("list", -2, 100); fmt.Sprint(elems) != "[d e]" {
+		t.Fatalf("want [d e] but got %v", elems)
+	}
+
+	if v, ok, _ := m.RPop("list"); !ok || v != "e" {
+		t.Fatalf("want e but got %v", v)
+	}
+	if v, ok, _ := m.LIndex("list", -1); !ok || v != "d" {
+		t.Fatalf("want d but got %v", v)
+	}
+	if _, ok, _ := m.LIndex("list", 4); ok {
+		t.Fatalf("index out of range should not be found")
+	}
+
+	if e := m.LSet("list", 1, "B"); e != nil {
+		t.Fatal(e)
+	}
+	if e := m.LSet("list", 10, "x"); e == nil {
+		t.Fatalf("index out of range should fail")
+	}
+	if e := m.LSet("missing", 0, "x"); e == nil {
+		t.Fatalf("missing key should fail")
+	}
+
+	if n, _ := m.LInsert("list", LIST_BEFORE, "c", "x"); n != 5 {
+		t.Fatalf("want 5 but got %d", n)
+	}
+	if n, _ := m.LInsert("list", LIST_AFTER, "d", "x"); n != 6 {
+		t.Fatalf("want 6 but got %d", n)
+	}
+	if n, _ := m.LInsert("list", LIST_AFTER, "nothing", "x"); n != -1 {
+		t.Fatalf("want -1 but got %d", n)
+	}
+	if n, _ := m.LInsert("missing", LIST_AFTER, "a", "x"); n != 0 {
+		t.Fatalf("want 0 but got %d", n)
+	}
+	if s := listString(t, m, "list"); s != "[a B x c d x]" {
+		t.Fatalf("want [a B x c d x] but got %s", s)
+	}
+
+	if n, _ := m.LRem("list", -1, "x"); n != 1 {
+		t.Fatalf("want 1 but got %d", n)
+	}
+	if s := listString(t, m, "list"); s != "[a B x c d]" {
+		t.Fatalf("want [a B x c d] but got %s", s)
+	}
+
+	if e := m.LTrim("list", 1, -2); e != nil {
+		t.Fatal(e)
+	}
+	if s := listString(t, m, "list"); s != "[B x c]" {
+		t.Fatalf("want [B x c] but got %s", s)
+	}
+
+	// emptied list is deleted
+	if e := m.LTrim("list", 5, 10); e != nil {
+		t.Fatal(e)
+	}
+	if m.Type("list") != TYPE_NONE {
+		t.Fatalf("empty list should be deleted")
+	}
+	m.RPush("list", "a", "a")
+	m.LRem("list", 0, "a")
+	if m.Type("list") != TYPE_NONE {
+		t.Fatalf("empty list should be deleted")
+	}
+	m.RPush("list", "a")
+	m.RPop("list")
+	if m.Type("list") != TYPE_NONE {
+		t.Fatalf("empty list should be deleted")
+	}
+
+	m.Set("name", "cmap")
+	if _, e := m.LPush("name", "a"); e == nil {
+		t.Fatalf("LPush on string should fail")
+	}
+	if _, _, e := m.RPop("name"); e == nil {
+		t.Fatalf("RPop on string should fail")
+	}
+	if e := m.LTrim("name", 0, 1); e == nil {
+		t.Fatalf("LTrim on string should fail")
+	}
+}
+
+func TestListKeepTTL(t *testing.T) {
+	m := NewMap()
+
+	m.RPushNEX("list", 100, "a", "b", "c")
+	m.LPush("list", "z")
+	m.LSet("list", 0, "y")
+	m.LTrim("list", 0, 1)
+	if ttl := m.TTL("list"); ttl <= 0 {
+		t.Fatalf("list should keep its ttl but got %v", ttl)
+	}
+}
+
+func TestLPopN(t *testing.T) {
+	m := NewMap()
+
+	if elems, ok, e := m.LPopN("list", 2); ok || e != nil || len(elems) != 0 {
+		t.Fatalf("missing list: want false but got %v %v %v", elems, ok, e)
+	}
+	m.RPush("list", "a", "b", "c")
+	if elems, ok, _ := m.LPopN("list", 2); !ok || fmt.Sprint(elems) != "[a b]" {
+		t.Fatalf("want [a b] but got %v", elems)
+	}
+	if elems, ok, _ := m.LPopN("list", 0); !ok || len(elems) != 0 {
+		t.Fatalf("want [] but got %v", elems)
+	}
+	if elems, _, _ := m.LPopN("list", 10); fmt.Sprint(elems) != "[c]" {
+		t.Fatalf("want [c] but got %v", elems)
+	}
+	if m.Type("list") != TYPE_NONE {
+		t.Fatalf("empty list should be deleted")
+	}
+
+	m.Set("name", "cmap")
+	if _, _, e := m.LPopN("name", 1); e == nil {
+		t.Fatalf("LPopN on string should fail")
+	}
+
+	// popped elements are contiguous while others push and pop concurrently
+	for i := 0; i < 1000; i++ {
+		m.RPush("nums", i)
+	}
+	var wg sync.WaitGroup
+	var popped = make(chan []interface{}, 100)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				elems, _, _ := m.LPopN("nums", 10)
+				popped <- elems
+				m.LPop("nums")
+			}
+		}()
+	}
+	wg.Wait()
+	close(popped)
+	for elems := range popped {
+		for j := 1; j < len(elems); j++ {
+			if elems[j].(int) != elems[j-1].(int)+1 {
+				t.Fatalf("elements popped by LPopN should be contiguous, got %v", elems)
+			}
+		}
+	}
+}
+
+func TestLMove(t *testing.T) {
+	m := NewMap()
+
+	m.RPush("src", "a", "b", "c")
+	if v, ok, _ := m.LMove("src", "dst", LIST_RIGHT, LIST_LEFT); !ok || v != "c" {
+		t.Fatalf("want c but got %v", v)
+	}
+	if v, ok, _ := m.LMove("src", "dst", LIST_LEFT, LIST_RIGHT); !ok || v != "a" {
+		t.Fatalf("want a but got %v", v)
+	}
+	if s := listString(t, m, "dst"); s != "[c a]" {
+		t.Fatalf("want [c a] but got %s", s)
+	}
+
+	// rotate
+	m.RPush("src", "c")
+	m.LMove("src", "src", LIST_LEFT, LIST_RIGHT)
+	if s := listString(t, m, "src"); s != "[c b]" {
+		t.Fatalf("want [c b] but got %s", s)
+	}
+
+	// destination of wrong type keeps source untouched
+	m.Set("name", "cmap")
+	if _, _, e := m.LMove("src", "name", LIST_LEFT, LIST_LEFT); e == nil {
+		t.Fatalf("LMove to string should fail")
+	}
+	if n, _ := m.LLen("src"); n != 2 {
+		t.Fatalf("source should be untouched but got %d", n)
+	}
+
+	if _, ok, _ := m.LMove("missing", "dst", LIST_LEFT, LIST_LEFT); ok {
+		t.Fatalf("missing source should not move")
+	}
+
+	m.LMove("src", "dst", LIST_LEFT, LIST_LEFT)
+	m.LMove("src", "dst", LIST_LEFT, LIST_LEFT)
+	if m.Type("src") != TYPE_NONE {
+		t.Fatalf("empty source should be deleted")
+	}
+}
+
+func TestMapV2LMove(t *testing.T) {
+	m := NewMapV2(nil, 8, 5*time.Minute)
+	defer m.Clear()
+
+	// two keys in different slots
+	var a, b = "a", ""
+	for i := 0; ; i++ {
+		b = "b" + strconv.Itoa(i)
+		if m.getslot(a) != m.getslot(b) {
+			break
+		}
+	}
+
+	var elems = make([]interface{}, 100)
+	for i, _ := range elems {
+		elems[i] = i
+	}
+	m.RPush(a, elems...)
+	m.RPush(b, elems...)
+
+	// moving in reversed directions concurrently doesn't deadlock
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			m.LMove(a, b, LIST_LEFT, LIST_RIGHT)
+		}()
+		go func() {
+			defer wg.Done()
+			m.LMove(b, a, LIST_LEFT, LIST_RIGHT)
+		}()
+	}
+	wg.Wait()
+
+	na, _ := m.LLen(a)
+	nb, _ := m.LLen(b)
+	if na+nb != 200 {
+		t.Fatalf("want 200 elements but got %d", na+nb)
+	}
+}
+
+func TestAOFListCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmap.aof")
+
+	aof := openTestAOF(t, path, FSYNC_ALWAYS)
+	m := NewMap()
+	if e := m.UseAOF(aof); e != nil {
+		t.Fatal(e)
+	}
+	m.LPush("list", "c", "b", "a")
+	m.RPush("list", "d", "e", "f")
+	m.RPop("list")
+	m.LSet("list", 0, "A")
+	m.LInsert("list", LIST_AFTER, "A", "x")
+	m.LRem("list", 1, "b")
+	m.LTrim("list", 0, 3)
+	m.LMove("list", "other", LIST_RIGHT, LIST_LEFT)
+	m.RPush("popn", 1, 2, 3)
+	m.LPopN("popn", 2)
+	aof.Close()
+
+	aof2 := openTestAOF(t, path, FSYNC_ALWAYS)
+	defer aof2.Close()
+	m2 := NewMap()
+	if e := m2.UseAOF(aof2); e != nil {
+		t.Fatal(e)
+	}
+	if s := listString(t, m2, "list"); s != "[A x c]" {
+		t.Fatalf("want [A x c] but got %s", s)
+	}
+	if s := listString(t, m2, "other"); s != "[d]" {
+		t.Fatalf("want [d] but got %s", s)
+	}
+	if s := listString(t, m2, "popn"); s != "[3]" {
+		t.Fatalf("want [3] but got %s", s)
+	}
+}
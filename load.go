@@ -0,0 +1,121 @@
+package cmap
+
+import (
+	"github.com/fwhezfwhez/errorx"
+	"sync"
+	"time"
+)
+
+// WithNegativeTTL caches errors of loaders in GetOrLoad for ttl, calls of GetOrLoad in the period return the error without loading.
+// It protects backends from keys always failing or missing, default 0 doesn't cache errors.
+func WithNegativeTTL(ttl time.Duration) MapOption {
+	return func(c *mapConfig) {
+		c.negativeTTL = ttl
+	}
+}
+
+// loadCall is a loading in flight, waiters block on done
+type loadCall struct {
+	done  chan struct{}
+	value interface{}
+	e     error
+}
+
+// negative is an error cached by WithNegativeTTL
+type negative struct {
+	e   error
+	exp int64
+}
+
+// loadGroup coalesces concurrent loads of a key, like singleflight
+type loadGroup struct {
+	l         *sync.Mutex
+	calls     map[string]*loadCall
+	negatives map[string]negative
+}
+
+func newLoadGroup() *loadGroup {
+	return &loadGroup{
+		l:         &sync.Mutex{},
+		calls:     make(map[string]*loadCall),
+		negatives: make(map[string]negative),
+	}
+}
+
+// clearExpired drops expired errors
+func (g *loadGroup) clearExpired() {
+	now := time.Now().UnixNano()
+
+	g.l.Lock()
+	defer g.l.Unlock()
+	for k, n := range g.negatives {
+		if now >= n.exp {
+			delete(g.negatives, k)
+		}
+	}
+}
+
+// GetOrLoad returns value of key, on a miss it calls loader and saves the result with ttl, a negative ttl means no time limit.
+// Concurrent misses of a key share one call of loader, and all of them get its result.
+// Errors of loader are not saved, unless map is made by WithNegativeTTL.
+// A panic of loader is recovered and returned as an error.
+func (m *Map) GetOrLoad(key string, ttl time.Duration, loader func() (interface{}, error)) (interface{}, error) {
+	if v, ok := m.Get(key); ok {
+		return v, nil
+	}
+
+	g := m.loads
+	g.l.Lock()
+	if n, ok := g.negatives[key]; ok {
+		if time.Now().UnixNano() < n.exp {
+			g.l.Unlock()
+			return nil, n.e
+		}
+		delete(g.negatives, key)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.l.Unlock()
+		<-call.done
+		return call.value, call.e
+	}
+	call := &loadCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.l.Unlock()
+
+	// the key might be saved by a load just finished
+	if v, ok := m.Get(key); ok {
+		call.value = v
+	} else {
+		call.value, call.e = m.load(key, ttl, loader)
+	}
+
+	g.l.Lock()
+	delete(g.calls, key)
+	if call.e != nil && m.conf.negativeTTL > 0 {
+		g.negatives[key] = negative{e: call.e, exp: time.Now().Add(m.conf.negativeTTL).UnixNano()}
+	}
+	g.l.Unlock()
+	close(call.done)
+
+	return call.value, call.e
+}
+
+// load calls loader and saves its result
+func (m *Map) load(key string, ttl time.Duration, loader func() (interface{}, error)) (v interface{}, e error) {
+	defer func() {
+		if p := recover(); p != nil {
+			v, e = nil, errorx.NewFromStringf("cmap.GetOrLoad: loader of key '%s' panics: %v", key, p)
+		}
+	}()
+
+	v, e = loader()
+	if e != nil {
+		return nil, e
+	}
+	m.SetTTL(key, v, ttl)
+	return v, nil
+}
+
+func (mv2 *MapV2) GetOrLoad(key string, ttl time.Duration, loader func() (interface{}, error)) (interface{}, error) {
+	return mv2.getslot(key).GetOrLoad(key, ttl, loader)
+}
@@ -0,0 +1,137 @@
+package cmap
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	m := NewMap()
+
+	var calls int32
+	var start = make(chan struct{})
+	loader := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-start
+		return "v", nil
+	}
+
+	var wg sync.WaitGroup
+	var results = make([]interface{}, 100)
+	for i, _ := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, e := m.GetOrLoad("a", time.Minute, loader)
+			if e != nil {
+				t.Errorf("unexpected error %v", e)
+			}
+			results[i] = v
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(start)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("concurrent misses should call loader once, got %d", calls)
+	}
+	for i, _ := range results {
+		if results[i] != "v" {
+			t.Fatalf("waiter %d got %v", i, results[i])
+		}
+	}
+	if v, _ := m.Get("a"); v != "v" {
+		t.Fatalf("loaded value should be saved, got %v", v)
+	}
+	if ttl := m.TTL("a"); ttl <= 0 || ttl > 60 {
+		t.Fatalf("loaded value should expire in a minute, got %d", ttl)
+	}
+
+	// hit doesn't call loader
+	if v, _ := m.GetOrLoad("a", time.Minute, loader); v != "v" || calls != 1 {
+		t.Fatalf("hit should not load, got %v %d", v, calls)
+	}
+
+	// errors are not cached by default
+	var fails int
+	fail := func() (interface{}, error) {
+		fails++
+		return nil, errors.New("not found")
+	}
+	m.GetOrLoad("b", time.Minute, fail)
+	if _, e := m.GetOrLoad("b", time.Minute, fail); e == nil || fails != 2 {
+		t.Fatalf("want error of second load, got %v %d", e, fails)
+	}
+	if _, ok := m.Get("b"); ok {
+		t.Fatalf("error should not save the key")
+	}
+
+	// panics are recovered
+	if _, e := m.GetOrLoad("c", time.Minute, func() (interface{}, error) { panic("boom") }); e == nil {
+		t.Fatalf("panic of loader should be returned as error")
+	}
+	if v, e := m.GetOrLoad("c", -1, func() (interface{}, error) { return 1, nil }); e != nil || v != 1 || m.TTL("c") != -1 {
+		t.Fatalf("key should be loadable after a panic, got %v %v", v, e)
+	}
+}
+
+func TestGetOrLoadNegativeTTL(t *testing.T) {
+	m := NewMap(WithNegativeTTL(100 * time.Millisecond))
+
+	var calls int
+	var ErrNotFound = errors.New("not found")
+	loader := func() (interface{}, error) {
+		calls++
+		return nil, ErrNotFound
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, e := m.GetOrLoad("a", time.Minute, loader); e != ErrNotFound {
+			t.Fatalf("want ErrNotFound but got %v", e)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("error should be cached, loader called %d times", calls)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	m.ClearExpireKeys()
+	if len(m.loads.negatives) != 0 {
+		t.Fatalf("expired errors should be cleared")
+	}
+	m.GetOrLoad("a", time.Minute, loader)
+	if calls != 2 {
+		t.Fatalf("expired error should load again, loader called %d times", calls)
+	}
+}
+
+func TestMapV2GetOrLoad(t *testing.T) {
+	mv2 := NewMapV2(nil, 8, time.Minute)
+	defer mv2.Clear()
+
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mv2.GetOrLoad("a", time.Minute, func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(20 * time.Millisecond)
+				return 1, nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("want 1 call but got %d", calls)
+	}
+	if v, _ := mv2.Get("a"); v != 1 {
+		t.Fatalf("want 1 but got %v", v)
+	}
+}
@@ -14,7 +14,7 @@ import (
 type Value struct {
 	// value
 	v interface{}
-	// value will be expired in exp seconds, -1 means no time limit
+	// unixnano the value will be expired at, -1 means no time limit
 	exp int64
 
 	// Map's offset, when map exec set/delete, offset++
@@ -53,10 +53,7 @@ func (v Value) isExpire() bool {
 		return false
 	}
 
-	if v.exp < time.Now().UnixNano() {
-		return true
-	}
-	return false
+	return time.Now().UnixNano() >= v.exp
 }
 
 // make value readable
@@ -116,6 +113,32 @@ type Map struct {
 
 	// 支持list功能
 	listLock *sync.RWMutex
+
+	// nil when map is not bounded
+	evict *evictor
+
+	// callbacks of OnEvict, OnExpire and watchers
+	hooks *hooks
+
+	conf mapConfig
+
+	// nil when writes are not logged, see UseAOF
+	aof *atomic.Pointer[AOF]
+
+	// loads in flight of GetOrLoad
+	loads *loadGroup
+
+	// callers blocked by BLPop and BRPop
+	blocked *blockQueue
+
+	// items in flight of Reserve
+	queues *reliableQueues
+
+	// callers blocked by BPoll
+	polls *pollNotifier
+
+	// keys in buckets for Scan, nil until the first Scan
+	scans *atomic.Pointer[scanIndex]
 }
 
 // Help viewing map's detail.
@@ -134,8 +157,12 @@ type mapView struct {
 	Del    map[string]interface{}
 }
 
-func newMap() *Map {
-	return &Map{
+func newMap(opts ...MapOption) *Map {
+	return newMapWithConfig(newMapConfig(opts...))
+}
+
+func newMapWithConfig(c mapConfig) *Map {
+	m := &Map{
 		deltal: &sync.RWMutex{},
 		modl:   &sync.RWMutex{},
 		mode:   M_FREE2,
@@ -153,12 +180,35 @@ func newMap() *Map {
 		del: make(map[string]Value),
 
 		listLock: &sync.RWMutex{},
+
+		hooks: newHooks(),
+
+		conf: c,
+
+		aof: &atomic.Pointer[AOF]{},
+
+		loads: newLoadGroup(),
+
+		blocked: newBlockQueue(),
+
+		queues: newReliableQueues(),
+
+		polls: newPollNotifier(),
+
+		scans: &atomic.Pointer[scanIndex]{},
+	}
+
+	if c.bounded() {
+		m.evict = newEvictor(c)
 	}
+	return m
 }
 
 // new a concurrent map
-func NewMap() *Map {
-	return newMap()
+// A bounded map can be made by options:
+//    m := cmap.NewMap(cmap.WithMaxEntries(100000), cmap.WithEvictionPolicy(cmap.EVICT_ALLKEYS_LRU))
+func NewMap(opts ...MapOption) *Map {
+	return newMap(opts...)
 }
 
 // Pause the world
@@ -243,23 +293,47 @@ type incrByType struct {
 	delta  int
 }
 
-func (m *Map) set(key string, value interface{}, seconds int, nx bool, ops ...Op) interface{} {
+func (m *Map) set(key string, value interface{}, exp int64, nx bool, ops ...Op) (interface{}, bool) {
+	return m.setWithOffset(key, value, exp, nx, m.offsetIncr(), ops...)
+}
+
+// setWithOffset works as set, offset is given by caller, like restoring a snapshot
+func (m *Map) setWithOffset(key string, value interface{}, exp int64, nx bool, offset int64, ops ...Op) (interface{}, bool) {
+	ext := time.Now().UnixNano()
+
+	var rs interface{}
+	var ok bool
+	var notices []notice
+	m.logged(key, func() bool {
+		rs, ok, notices = m.doSet(key, value, exp, nx, ext, offset, ops...)
+		return ok
+	})
+	if ok && m.hooks.isWatching() {
+		event := EVENT_SET
+		if len(ops) > 0 {
+			event = EVENT_INCR
+		}
+		notices = append(notices, notice{key: key, value: rs, event: event, offset: offset})
+	}
+	if ok {
+		m.index(key)
+	}
+	m.hooks.fire(notices)
+
+	if ok && m.evict != nil {
+		m.evict.onWrite(key, rs, exp)
+		m.evictIfNeeded(key)
+	}
+	return rs, ok
+}
+
+func (m *Map) doSet(key string, value interface{}, exp int64, nx bool, ext int64, offset int64, ops ...Op) (interface{}, bool, []notice) {
 	var op Op
 	if len(ops) > 0 {
 		op = ops[0]
 		op.enable = true
 	}
 
-	var exp int64
-	if seconds == -1 {
-		exp = -1
-	} else {
-		exp = time.Now().Add(time.Duration(seconds) * time.Second).UnixNano()
-	}
-
-	ext := time.Now().UnixNano()
-	offset := m.offsetIncr()
-
 	// 发生set时，不会出现状态切换
 	m.modl.RLock()
 	defer m.modl.RUnlock()
@@ -268,9 +342,9 @@ func (m *Map) set(key string, value interface{}, seconds int, nx bool, ops ...Op
 	if m.isFree2WrapedBymodl() {
 		// set类型的命令
 		if op.enable == false {
-			setm(m.l, m.m, key, value, ext, offset, exp, nx)
+			ok, old, replaced := setm(m.l, m.m, key, value, ext, offset, exp, nx)
 			setm(m.dl, m.dirty, key, value, ext, offset, exp, nx)
-			return value
+			return value, ok, removedOf(key, old, replaced, true, REASON_OVERWRITTEN)
 		}
 
 		// incrBy类型的命令
@@ -290,7 +364,7 @@ func (m *Map) set(key string, value interface{}, seconds int, nx bool, ops ...Op
 				}
 				return rs, nil
 			}, exp, ext, offset)
-			return rs
+			return rs, rs != nil, nil
 		}
 
 	}
@@ -300,10 +374,10 @@ func (m *Map) set(key string, value interface{}, seconds int, nx bool, ops ...Op
 
 		if op.enable == false {
 			m.deltal.RLock()
-			setm(m.l, m.m, key, value, ext, offset, exp, nx)
+			_, mold, mreplaced := setm(m.l, m.m, key, value, ext, offset, exp, nx)
 			m.deltal.RUnlock()
-			setm(m.dl, m.dirty, key, value, ext, offset, exp, nx)
-			return nil
+			ok, old, replaced := setm(m.dl, m.dirty, key, value, ext, offset, exp, nx)
+			return value, ok, append(removedOf(key, mold, mreplaced, true, 0), removedOf(key, old, replaced, false, REASON_OVERWRITTEN)...)
 		}
 
 		if op.incrBy.enable == true {
@@ -323,15 +397,14 @@ func (m *Map) set(key string, value interface{}, seconds int, nx bool, ops ...Op
 				}
 				return rs, nil
 			}, exp, ext, offset)
-			return rs
+			return rs, rs != nil, nil
 		}
 	}
 
 	if op.enable == false {
-
-		setm(m.dl, m.dirty, key, value, ext, offset, exp, nx)
+		ok, old, replaced := setm(m.dl, m.dirty, key, value, ext, offset, exp, nx)
 		setm(m.wl, m.write, key, value, ext, offset, exp, nx)
-		return nil
+		return value, ok, removedOf(key, old, replaced, false, REASON_OVERWRITTEN)
 	}
 
 	if op.incrBy.enable == true {
@@ -352,11 +425,10 @@ func (m *Map) set(key string, value interface{}, seconds int, nx bool, ops ...Op
 			}
 			return rs, nil
 		}, exp, ext, offset)
-		return rs
+		return rs, rs != nil, nil
 	}
 
-	return nil
-
+	return nil, false, nil
 }
 
 // set,del,setnx,setex will increase map.offset.
@@ -368,12 +440,46 @@ func (m *Map) offsetIncr() int64 {
 	return m.offset
 }
 
+// offsetAtLeast raises map.offset to offset, new operations will have bigger offsets than restored values
+func (m *Map) offsetAtLeast(offset int64) {
+	for {
+		cur := atomic.LoadInt64(&m.offset)
+		if cur >= offset || atomic.CompareAndSwapInt64(&m.offset, cur, offset) {
+			return
+		}
+	}
+}
+
+// expOfSeconds converts seconds to Value.exp, -1 means no time limit
+func expOfSeconds(seconds int) int64 {
+	if seconds == -1 {
+		return -1
+	}
+	return time.Now().Add(time.Duration(seconds) * time.Second).UnixNano()
+}
+
+// expOfDuration converts ttl to Value.exp, a negative ttl means no time limit
+func expOfDuration(ttl time.Duration) int64 {
+	if ttl < 0 {
+		return -1
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
+
+// expOfTime converts deadline to Value.exp, a zero deadline means no time limit
+func expOfTime(deadline time.Time) int64 {
+	if deadline.IsZero() {
+		return -1
+	}
+	return deadline.UnixNano()
+}
+
 // map.SetEX
 // key-value will be put with expired time limit.
 // If seconds are set -1, value will not be expired
 // expired keys will be deleted as soon as calling m.Get(key), or calling m.ClearExpireKeys()
 func (m *Map) SetEx(key string, value interface{}, seconds int) {
-	m.set(key, value, seconds, false)
+	m.set(key, value, expOfSeconds(seconds), false)
 }
 
 // map.SetNx
@@ -385,13 +491,40 @@ func (m *Map) SetNx(key string, value interface{}) {
 // map.SetEXNX
 // If key exist, do nothing, otherwise set key,value into map
 func (m *Map) SetExNx(key string, value interface{}, seconds int) {
-	m.set(key, value, seconds, true)
+	m.set(key, value, expOfSeconds(seconds), true)
+}
+
+// map.SetTTL
+// key-value will be put with expired time limit in nanosecond precision.
+// If ttl < 0, value will not be expired.
+func (m *Map) SetTTL(key string, value interface{}, ttl time.Duration) {
+	m.set(key, value, expOfDuration(ttl), false)
+}
+
+// map.SetNxTTL
+// If key exist, do nothing and return false, otherwise set key,value into map with ttl and return true
+func (m *Map) SetNxTTL(key string, value interface{}, ttl time.Duration) bool {
+	_, ok := m.set(key, value, expOfDuration(ttl), true)
+	return ok
+}
+
+// map.SetExpireAt
+// key-value will be expired at the deadline. If deadline is zero, value will not be expired.
+func (m *Map) SetExpireAt(key string, value interface{}, deadline time.Time) {
+	m.set(key, value, expOfTime(deadline), false)
+}
+
+// map.SetNxExpireAt
+// If key exist, do nothing and return false, otherwise set key,value into map expired at the deadline and return true
+func (m *Map) SetNxExpireAt(key string, value interface{}, deadline time.Time) bool {
+	_, ok := m.set(key, value, expOfTime(deadline), true)
+	return ok
 }
 
 // increase key by 1.
 // If key is not an integer type, will do nothing
 func (m *Map) Incr(key string) int64 {
-	rs := m.set(key, nil, -1, false, Op{
+	rs, _ := m.set(key, nil, -1, false, Op{
 		incrBy: struct {
 			enable bool
 			delta  int
@@ -405,7 +538,7 @@ func (m *Map) Incr(key string) int64 {
 func (m *Map) IncrEx(key string, seconds int) int64 {
 	// 通过get做失效
 	_, _ = m.get(key)
-	rs := m.set(key, nil, seconds, false, Op{
+	rs, _ := m.set(key, nil, expOfSeconds(seconds), false, Op{
 		incrBy: struct {
 			enable bool
 			delta  int
@@ -420,7 +553,7 @@ func (m *Map) IncrBy(key string, n int) int64 {
 	// 通过get做失效
 	_, _ = m.get(key)
 
-	rs := m.set(key, nil, -1, false, Op{
+	rs, _ := m.set(key, nil, -1, false, Op{
 		incrBy: struct {
 			enable bool
 			delta  int
@@ -435,7 +568,7 @@ func (m *Map) IncrByEx(key string, n int, seconds int) int64 {
 	// 通过get做失效
 	_, _ = m.get(key)
 
-	rs := m.set(key, nil, seconds, false, Op{
+	rs, _ := m.set(key, nil, expOfSeconds(seconds), false, Op{
 		incrBy: struct {
 			enable bool
 			delta  int
@@ -450,7 +583,7 @@ func (m *Map) Decr(key string) int64 {
 	// 通过get做失效
 	_, _ = m.get(key)
 
-	rs := m.set(key, nil, -1, false, Op{
+	rs, _ := m.set(key, nil, -1, false, Op{
 		incrBy: struct {
 			enable bool
 			delta  int
@@ -465,7 +598,7 @@ func (m *Map) DecrEx(key string, seconds int) int64 {
 	// 通过get做失效
 	_, _ = m.get(key)
 
-	rs := m.set(key, nil, seconds, false, Op{
+	rs, _ := m.set(key, nil, expOfSeconds(seconds), false, Op{
 		incrBy: struct {
 			enable bool
 			delta  int
@@ -480,7 +613,7 @@ func (m *Map) DecrBy(key string, n int) int64 {
 	// 通过get做失效
 	_, _ = m.get(key)
 
-	rs := m.set(key, nil, -1, false, Op{
+	rs, _ := m.set(key, nil, -1, false, Op{
 		incrBy: struct {
 			enable bool
 			delta  int
@@ -495,7 +628,7 @@ func (m *Map) DecrByEx(key string, n int, seconds int) int64 {
 	// 通过get做失效
 	_, _ = m.get(key)
 
-	rs := m.set(key, nil, seconds, false, Op{
+	rs, _ := m.set(key, nil, expOfSeconds(seconds), false, Op{
 		incrBy: struct {
 			enable bool
 			delta  int
@@ -504,12 +637,141 @@ func (m *Map) DecrByEx(key string, n int, seconds int) int64 {
 
 	return Int64(rs)
 }
+
+// increase key by n with expire ttl in nanosecond precision
+func (m *Map) IncrByTTL(key string, n int, ttl time.Duration) int64 {
+	// 通过get做失效
+	_, _ = m.get(key)
+
+	rs, _ := m.set(key, nil, expOfDuration(ttl), false, Op{
+		incrBy: struct {
+			enable bool
+			delta  int
+		}{enable: true, delta: n},
+	})
+
+	return Int64(rs)
+}
+
+// decrease key by n with expire ttl in nanosecond precision
+func (m *Map) DecrByTTL(key string, n int, ttl time.Duration) int64 {
+	return m.IncrByTTL(key, -n, ttl)
+}
+
+// TTL and PTTL return TTL_NOT_EXIST when key doesn't exist,
+// return TTL_NO_EXPIRE when key exists but has no time limit.
+const (
+	TTL_NOT_EXIST = -2
+	TTL_NO_EXPIRE = -1
+)
+
+// Expire sets a timeout of seconds on key. It returns false if key doesn't exist.
+// A non-positive seconds deletes the key.
+func (m *Map) Expire(key string, seconds int) bool {
+	return m.expire(key, time.Now().Add(time.Duration(seconds)*time.Second).UnixNano())
+}
+
+// PExpire works like Expire, but the timeout is in milliseconds.
+func (m *Map) PExpire(key string, milliseconds int64) bool {
+	return m.expire(key, time.Now().Add(time.Duration(milliseconds)*time.Millisecond).UnixNano())
+}
+
+// ExpireAt sets key expired at the deadline. It returns false if key doesn't exist.
+// A deadline in the past deletes the key.
+func (m *Map) ExpireAt(key string, deadline time.Time) bool {
+	return m.expire(key, deadline.UnixNano())
+}
+
+// Persist removes the time limit of key.
+// It returns false if key doesn't exist or key has no time limit.
+func (m *Map) Persist(key string) bool {
+	_, _, action := m.update(key, func(old Value, exist bool) (Value, int) {
+		if !exist || old.exp == -1 {
+			return old, update_keep
+		}
+		old.exp = -1
+		return old, update_set
+	})
+	return action == update_set
+}
+
+// TTL returns the remaining seconds of key, or TTL_NOT_EXIST, TTL_NO_EXPIRE.
+func (m *Map) TTL(key string) int64 {
+	exp, exist := m.expOf(key)
+	if !exist {
+		return TTL_NOT_EXIST
+	}
+	if exp == -1 {
+		return TTL_NO_EXPIRE
+	}
+	// round like redis does
+	return (exp - time.Now().UnixNano() + int64(time.Second)/2) / int64(time.Second)
+}
+
+// PTTL returns the remaining milliseconds of key, or TTL_NOT_EXIST, TTL_NO_EXPIRE.
+func (m *Map) PTTL(key string) int64 {
+	exp, exist := m.expOf(key)
+	if !exist {
+		return TTL_NOT_EXIST
+	}
+	if exp == -1 {
+		return TTL_NO_EXPIRE
+	}
+	return (exp - time.Now().UnixNano()) / int64(time.Millisecond)
+}
+
+func (m *Map) expire(key string, exp int64) bool {
+	_, exist, _ := m.update(key, func(old Value, exist bool) (Value, int) {
+		if !exist {
+			return old, update_keep
+		}
+		if exp <= time.Now().UnixNano() {
+			return old, update_delete
+		}
+		old.exp = exp
+		return old, update_set
+	})
+	return exist
+}
+
+// expOf returns Value.exp of key in the readable register.
+func (m *Map) expOf(key string) (int64, bool) {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	l, mp := m.l, m.m
+	if !m.isFree2WrapedBymodl() {
+		l, mp = m.dl, m.dirty
+	}
+
+	l.RLock()
+	defer l.RUnlock()
+
+	v, exist := mp[key]
+	if !exist || v.isExpire() {
+		return 0, false
+	}
+	return v.exp, true
+}
+
 func (m *Map) Get(key string) (interface{}, bool) {
-	return m.get(key)
+	v, ok := m.get(key)
+
+	// stats of expired keys are dropped by eviction and ClearExpireKeys, not here, so reads don't wait for writes of stats
+	if ok && m.evict != nil {
+		m.evict.onRead(key)
+	}
+	return v, ok
 }
 
 // If key is expired or not existed, return nil
 func (m *Map) get(key string) (interface{}, bool) {
+	v, ok, notices := m.doGet(key)
+	m.hooks.fire(notices)
+	return v, ok
+}
+
+func (m *Map) doGet(key string) (interface{}, bool, []notice) {
 
 	// Get过程中。
 	// m 必须在mod保护态下，才能get
@@ -526,34 +788,47 @@ func (m *Map) get(key string) (interface{}, bool) {
 
 	// m free时，读取m
 	if m.isFree2WrapedBymodl() {
-		return getFrom(m.l, m.m, key)
+		v, ok, expired, dropped := getFrom(m.l, m.m, key)
+		return v, ok, removedOf(key, expired, dropped, true, REASON_EXPIRED)
 	}
 
 	m.modl.RUnlock()
 	shouldRUnlock = false
-	return getFrom(m.dl, m.dirty, key)
-	//// m繁忙时，读取dirty
-	//if m.isBusyWrapedBymodl() {
-	//	return getFrom(m.dl, m.dirty, key)
-	//}
-	//
-	//// m 处于迁移中,读取dir
-	//if m.isFree1WrapedBymodl() {
-	//	return getFrom(m.dl, m.dirty, key)
-	//}
-
-	return nil, false
+	// m繁忙或迁移中时，读取dirty
+	v, ok, _, _ := getFrom(m.dl, m.dirty, key)
+	return v, ok, nil
 }
 
-// Delete todo, bug delete fail
 func (m *Map) Delete(key string) {
+	m.remove(key, REASON_DELETED)
+}
+
+// remove deletes key and reports it to hooks with reason
+func (m *Map) remove(key string, reason RemoveReason) {
 	offset := m.offsetIncr()
 	ext := time.Now().UnixNano()
 
+	var notices []notice
+	m.logged(key, func() bool {
+		notices = m.doDelete(key, reason, ext, offset)
+		return true
+	})
+	for i := range notices {
+		notices[i].offset = offset
+	}
+	m.unindex(key)
+	m.hooks.fire(notices)
+
+	if m.evict != nil {
+		m.evict.onDelete(key)
+	}
+}
+
+func (m *Map) doDelete(key string, reason RemoveReason, ext int64, offset int64) []notice {
 	m.modl.RLock()
 	defer m.modl.RUnlock()
 	if m.isFree2WrapedBymodl() {
-		deletem(m.l, m.m, key, ext)
+		old, exist := deletem(m.l, m.m, key, ext)
 
 		func(ext int64) {
 			//fmt.Printf("del dir %s \n", key)
@@ -562,28 +837,32 @@ func (m *Map) Delete(key string) {
 			// deletem(m.wl, m.write, key, ext)
 
 		}(ext)
-		return
+		return removedOf(key, old, exist, true, reason)
 	}
 
 	if m.isFree1WrapedBymodl() {
+		var notices []notice
 		// free1时，m1不能提供使用
 		func() {
 			m.deltal.RLock()
-			deletem(m.l, m.m, key, ext)
+			old, exist := deletem(m.l, m.m, key, ext)
 			m.deltal.RUnlock()
+			notices = append(notices, removedOf(key, old, exist, true, 0)...)
 		}()
 		func() {
 			// 	deletem(m.wl, m.write, key, ext)
-			deletem(m.dl, m.dirty, key, ext)
+			old, exist := deletem(m.dl, m.dirty, key, ext)
+			notices = append(notices, removedOf(key, old, exist, false, reason)...)
 		}()
-		return
+		return notices
 	}
 	// deletem(m.wl, m.write, key, ext)
 
 	// busy时，要删除dir, 并且追加命令进del
-	deletem(m.dl, m.dirty, key, ext)
+	old, exist := deletem(m.dl, m.dirty, key, ext)
 
 	setm(m.dll, m.del, key, "waiting-deleted", ext, offset, -1, false)
+	return removedOf(key, old, exist, false, reason)
 }
 
 func (m *Map) Detail() mapView {
@@ -685,27 +964,30 @@ func (m *Map) PrintDetailOf(key string) string {
 	return string(b)
 }
 
-func getFrom(l *sync.RWMutex, m map[string]Value, key string) (interface{}, bool) {
+// getFrom reads key from register m.
+// An expired value is dropped and returned as the third value.
+func getFrom(l *sync.RWMutex, m map[string]Value, key string) (interface{}, bool, Value, bool) {
 	l.RLock()
 	value, ok := m[key]
 	l.RUnlock()
 
 	if !ok {
-		return nil, false
+		return nil, false, Value{}, false
 	}
 
-	if value.exp == -1 {
-		return value.v, true
-	}
-
-	if time.Now().UnixNano() >= value.exp {
+	if value.isExpire() {
+		var dropped bool
 		l.Lock()
-		delete(m, key)
+		// re-check, the key might be refreshed between RUnlock and Lock
+		if value, ok = m[key]; ok && value.isExpire() {
+			delete(m, key)
+			dropped = true
+		}
 		l.Unlock()
-		return nil, false
+		return nil, false, value, dropped
 	}
 
-	return value.v, true
+	return value.v, true, Value{}, false
 }
 
 func (m *Map) setBusy() {
@@ -737,6 +1019,24 @@ func (m *Map) Len() int {
 	return length
 }
 
+// Count returns number of keys not expired. Unlike Keys, it copies nothing, but still takes time of number of keys.
+func (m *Map) Count() int {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	l, mp := m.readableWrapedBymodl()
+	l.RLock()
+	defer l.RUnlock()
+
+	var n int
+	for _, v := range mp {
+		if !v.isExpire() {
+			n++
+		}
+	}
+	return n
+}
+
 // ClearExpireKeys clear expired keys, and it will not influence map write and read.
 // When call m.ClearExpireKeys(), first will set m.mode=M_BUSY.
 // At this moment, operation of write to Map.m is denied and instead data will be writen to Map.write which will sync to Map.m after clear job done.
@@ -759,7 +1059,7 @@ func (m *Map) ClearExpireKeys() int {
 
 	// 置为busy时，读写删自动放行。读取写入删除都切换到对应的模式。m此时将保持不对外不可用
 
-	n := m.clearExpireKeys()
+	n, notices := m.clearExpireKeys()
 
 	m.modl.Lock()
 	m.mode = M_FREE1
@@ -769,13 +1069,12 @@ func (m *Map) ClearExpireKeys() int {
 	m.wl.RLock()
 	for k, v := range m.write {
 		v2, ok := m.m[k]
-		if !ok {
-			m.m[k] = v
-		} else {
-			if v2.LatterThan(v) {
-				continue
-			}
+		if ok && v2.LatterThan(v) {
+			continue
 		}
+		// expired value in m is dropped by this write
+		notices = append(notices, removedOf(k, v2, ok && v2.isExpire(), true, 0)...)
+		m.m[k] = v
 	}
 	m.wl.RUnlock()
 
@@ -791,6 +1090,8 @@ func (m *Map) ClearExpireKeys() int {
 			continue
 		}
 		if v.LatterThan(v2) {
+			// expired value in m is dropped by this deletion
+			notices = append(notices, removedOf(k, v2, v2.isExpire(), true, 0)...)
 			delete(m.m, k)
 		}
 	}
@@ -814,22 +1115,33 @@ func (m *Map) ClearExpireKeys() int {
 	m.wl.Unlock()
 
 	clearExpire(m.dl, m.dirty)
+	m.pruneScanIndex()
 
+	if m.evict != nil {
+		m.evict.clearExpired()
+	}
+	m.loads.clearExpired()
+
+	m.hooks.fire(notices)
 	return n
 }
 
 // Change to busy mode, now dirty provides read, write provides write, del provides delete.
 // After clear expired keys in m, m will change into free auto..ly.
 // in free mode, m.del and m.write will not provides read nor write, m.dirty will not read.
-func (m *Map) clearExpireKeys() int {
+func (m *Map) clearExpireKeys() (int, []notice) {
 	return m.clearExpireKeysWithDepth(-1)
 }
 
 // Change to busy mode, now dirty provides read, write provides write, del provides delete.
 // After clear expired keys in m, m will change into free auto..ly.
 // in free mode, m.del and m.write will not provides read nor write, m.dirty will not read.
-func (m *Map) clearExpireKeysWithDepth(depth int) int {
+func (m *Map) clearExpireKeysWithDepth(depth int) (int, []notice) {
 	var num int
+	var notices []notice
+
+	// only collect notices when someone listens
+	collect := m.hooks.isEnabled()
 
 	// keys should be deleted
 	var shouldDelete = make([]string, 0, len(m.m))
@@ -839,10 +1151,7 @@ func (m *Map) clearExpireKeysWithDepth(depth int) int {
 	var offset int
 L:
 	for k, v := range m.m {
-		if v.exp == -1 {
-			continue
-		}
-		if v.exp < time.Now().UnixNano() {
+		if v.isExpire() {
 			shouldDelete = append(shouldDelete, k)
 
 			// If hit depth of delete times, will stop range
@@ -855,13 +1164,16 @@ L:
 	m.l.RUnlock()
 
 	m.l.Lock()
-	for _, v := range shouldDelete {
-		delete(m.m, v)
+	for _, k := range shouldDelete {
+		if collect {
+			notices = append(notices, removedOf(k, m.m[k], true, true, REASON_EXPIRED)...)
+		}
+		delete(m.m, k)
 		num++
 	}
 
 	m.l.Unlock()
-	return num
+	return num, notices
 }
 
 // MLen
@@ -874,6 +1186,7 @@ func (m *Map) MLen() int {
 
 // range function returns bool value
 // if false,  will stop range process
+// Range may see a changing view while expired keys are being cleared, use Snapshot for a consistent one.
 func (m *Map) Range(f func(key string, value interface{}) bool) {
 	if m.IsFree2() {
 		rangem(m.l, m.m, f)
@@ -920,16 +1233,23 @@ func clearExpire(l *sync.RWMutex, m map[string]Value) int {
 	}
 	l.RUnlock()
 
+	var n int
 	l.Lock()
-	for _, v := range shouldDelete {
-		delete(m, v)
+	for _, k := range shouldDelete {
+		// re-check, the key might be refreshed after scanning
+		if v, ok := m[k]; ok && v.isExpire() {
+			delete(m, k)
+			n++
+		}
 	}
 	l.Unlock()
 
-	return len(shouldDelete)
+	return n
 }
 
-func setm(l *sync.RWMutex, m map[string]Value, key string, value interface{}, ext int64, offset int64, exp int64, nx bool) {
+// setm saves value into register m.
+// It returns whether value is saved, and the old value replaced.
+func setm(l *sync.RWMutex, m map[string]Value, key string, value interface{}, ext int64, offset int64, exp int64, nx bool) (bool, Value, bool) {
 	l.Lock()
 	defer l.Unlock()
 
@@ -945,42 +1265,44 @@ func setm(l *sync.RWMutex, m map[string]Value, key string, value interface{}, ex
 
 	// 当未过期，并且已存在时，nx不操作
 	if exist && !v.isExpire() && nx == true {
-		return
+		return false, Value{}, false
 	}
 
 	// 不存在时，设置新值
 	if !exist {
 		m[key] = newValue
-		return
+		return true, Value{}, false
 	}
 
 	// 已失效时，设置新值
 	if v.isExpire() {
 		m[key] = newValue
-		return
+		return true, v, true
 	}
 
 	// 比新key后执行，则设置新值
 	if v.FormerThan(newValue) {
 		m[key] = newValue
-		return
+		return true, v, true
 	}
 
 	// 否则不操作
-	return
+	return false, Value{}, false
 }
 
-func deletem(l *sync.RWMutex, m map[string]Value, key string, ext int64) {
+// deletem deletes key from register m, and returns the deleted value
+func deletem(l *sync.RWMutex, m map[string]Value, key string, ext int64) (Value, bool) {
 
 	l.RLock()
 	_, exist := m[key]
 	l.RUnlock()
 
 	if !exist {
-		return
+		return Value{}, false
 	}
 
 	l.Lock()
+	v, exist := m[key]
 	delete(m, key)
 	l.Unlock()
 
@@ -994,7 +1316,7 @@ func deletem(l *sync.RWMutex, m map[string]Value, key string, ext int64) {
 	//	delete(m, key)
 	//	return
 	//}
-	return
+	return v, exist
 }
 
 func (m *Map) mirrorOf(key string) (interface{}, bool) {
@@ -1036,8 +1358,8 @@ func casm(l *sync.RWMutex, m map[string]Value, key string, f func(old interface{
 	defer l.Unlock()
 
 	oldv, exist := m[key]
-	if !exist {
-		neww, e := f(oldv.v)
+	if !exist || oldv.isExpire() {
+		neww, e := f(nil)
 		if e != nil {
 			return nil
 		}
@@ -1070,6 +1392,171 @@ func casm(l *sync.RWMutex, m map[string]Value, key string, f func(old interface{
 	return neww
 }
 
+// actions returned by update function
+const (
+	update_keep   = 0 // do nothing
+	update_set    = 1 // save the returned value
+	update_delete = 2 // delete the key
+)
+
+// update reads, modifies and writes key atomically.
+// f is executed once, under lock of the readable register(m in M_FREE2, dirty otherwise), with the unexpired old value.
+// The result is then synchronized to the other registers of current mode,
+// in M_BUSY, new value is appended to write and deletion is appended to del, so that they survive the migration.
+// update returns the old value, whether it existed, and the action f made.
+func (m *Map) update(key string, f func(old Value, exist bool) (Value, int)) (Value, bool, int) {
+	return m.updateAs(key, f, false, nil)
+}
+
+// modify works as update, while a saved value is reported as a write like set does,
+// the replaced value to OnEvict callbacks as REASON_OVERWRITTEN, the new value to watchers as EVENT_SET.
+func (m *Map) modify(key string, f func(old Value, exist bool) (Value, int)) (Value, bool, int) {
+	return m.updateAs(key, f, true, nil)
+}
+
+// record makes the aof record of the change, the whole value is logged if it's nil, see loggedOp.
+func (m *Map) updateAs(key string, f func(old Value, exist bool) (Value, int), write bool, record func() *opRecord) (Value, bool, int) {
+	var nv Value
+	var old Value
+	var exist bool
+	var action int
+	var notices []notice
+	m.loggedOp(key, func() bool {
+		old, exist, nv, action, notices = m.doUpdate(key, f)
+		return action != update_keep
+	}, record)
+	switch action {
+	case update_set:
+		m.index(key)
+	case update_delete:
+		m.unindex(key)
+	}
+	if write && action == update_set {
+		notices = append(notices, removedOf(key, old, exist, true, REASON_OVERWRITTEN)...)
+		if m.hooks.isWatching() {
+			notices = append(notices, notice{key: key, value: nv.v, event: EVENT_SET, offset: nv.offset})
+		}
+	}
+	m.hooks.fire(notices)
+
+	if m.evict != nil {
+		switch action {
+		case update_set:
+			m.evict.onWrite(key, nv.v, nv.exp)
+			m.evictIfNeeded(key)
+		case update_delete:
+			m.evict.onDelete(key)
+		}
+	}
+	return old, exist, action
+}
+
+// doUpdate returns the old value, whether it existed, the new value, the action and notices of update.
+func (m *Map) doUpdate(key string, f func(old Value, exist bool) (Value, int)) (Value, bool, Value, int, []notice) {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	if m.isFree2WrapedBymodl() {
+		old, exist, stale, nv, action := updatem(m.l, m.m, key, f, m.offsetIncr)
+		switch action {
+		case update_set:
+			putm(m.dl, m.dirty, key, nv)
+		case update_delete:
+			deletem(m.dl, m.dirty, key, nv.execAt)
+		}
+		return old, exist, nv, action, updatedOf(key, old, exist, stale, action, true)
+	}
+
+	old, exist, stale, nv, action := updatem(m.dl, m.dirty, key, f, m.offsetIncr)
+
+	if m.isFree1WrapedBymodl() {
+		m.deltal.RLock()
+		switch action {
+		case update_set:
+			putm(m.l, m.m, key, nv)
+		case update_delete:
+			deletem(m.l, m.m, key, nv.execAt)
+		}
+		m.deltal.RUnlock()
+		return old, exist, nv, action, updatedOf(key, old, exist, stale, action, false)
+	}
+
+	switch action {
+	case update_set:
+		putm(m.wl, m.write, key, nv)
+	case update_delete:
+		setm(m.dll, m.del, key, "waiting-deleted", nv.execAt, nv.offset, -1, false)
+	}
+	return old, exist, nv, action, updatedOf(key, old, exist, stale, action, false)
+}
+
+// updatem runs f against key in register m.
+// The new value is stamped by execAt and offsetIncr under lock of m, so updates of a key are ordered the same as they are applied,
+// and putm keeps the order in other registers.
+// It returns the unexpired old value, whether it existed, whether an expired value was found, the new value and the action.
+func updatem(l *sync.RWMutex, m map[string]Value, key string, f func(old Value, exist bool) (Value, int), offsetIncr func() int64) (Value, bool, bool, Value, int) {
+	l.Lock()
+	defer l.Unlock()
+
+	old, exist := m[key]
+	var stale bool
+	if exist && old.isExpire() {
+		stale = true
+	}
+
+	var nv Value
+	var action int
+	if stale {
+		nv, action = f(Value{}, false)
+	} else {
+		nv, action = f(old, exist)
+	}
+
+	if action != update_keep {
+		nv.execAt = time.Now().UnixNano()
+		nv.offset = offsetIncr()
+	}
+
+	switch action {
+	case update_set:
+		m[key] = nv
+	case update_delete:
+		delete(m, key)
+	}
+
+	if stale {
+		return old, false, true, nv, action
+	}
+	return old, exist, false, nv, action
+}
+
+// updatedOf returns notices of an update.
+// When stale, old is the expired value found in register.
+func updatedOf(key string, old Value, exist bool, stale bool, action int, isM bool) []notice {
+	if action == update_keep {
+		return nil
+	}
+	if stale {
+		return removedOf(key, old, true, isM, REASON_EXPIRED)
+	}
+	if action == update_delete {
+		return removedOf(key, old, exist, isM, REASON_DELETED)
+	}
+	return nil
+}
+
+// putm saves v unless a latter value exists
+func putm(l *sync.RWMutex, m map[string]Value, key string, v Value) {
+	l.Lock()
+	defer l.Unlock()
+
+	old, exist := m[key]
+	if exist && !old.isExpire() && old.LatterThan(v) {
+		return
+	}
+	m[key] = v
+}
+
 func (m *Map) RPushEX(key string, elem interface{}, ex int) error {
 	m.listLock.Lock()
 	defer m.listLock.Unlock()
@@ -1082,7 +1569,8 @@ func (m *Map) RPushEX(key string, elem interface{}, ex int) error {
 			return errorx.NewServiceError("cmap.MapV2.RPush key=%s is not list elem, cannot execute RPush", 1)
 		}
 
-		clist.RPush(elem)
+		m.loggedPush(key, clist, false, elem)
+		m.serveBlocked(key)
 		return nil
 	}
 
@@ -1090,6 +1578,7 @@ func (m *Map) RPushEX(key string, elem interface{}, ex int) error {
 
 	clist.RPush(elem)
 	m.SetEx(key, clist, ex)
+	m.serveBlocked(key)
 	return nil
 }
 
@@ -1105,7 +1594,8 @@ func (m *Map) RPushNEX(key string, ex int, elem ...interface{}) error {
 			return errorx.NewServiceError("cmap.MapV2.RPush key=%s is not list elem, cannot execute RPush", 1)
 		}
 
-		clist.RPushN(elem...)
+		m.loggedPush(key, clist, false, elem...)
+		m.serveBlocked(key)
 		return nil
 	}
 
@@ -1113,5 +1603,105 @@ func (m *Map) RPushNEX(key string, ex int, elem ...interface{}) error {
 
 	clist.RPushN(elem...)
 	m.SetEx(key, clist, ex)
+	m.serveBlocked(key)
 	return nil
 }
+
+// RPush appends elems to list of key, a new list without time limit is made if key doesn't exist.
+// It returns length of the list after pushing.
+func (m *Map) RPush(key string, elems ...interface{}) (int, error) {
+	if len(elems) == 0 {
+		return m.LLen(key)
+	}
+
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	return m.push(key, "RPush", false, elems)
+}
+
+// LPop removes and returns the first element of list of key, key is deleted when the list becomes empty.
+func (m *Map) LPop(key string) (interface{}, bool, error) {
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	return m.pop(key, "LPop", true)
+}
+
+// LRange returns elements of list of key between start and stop, both are inclusive.
+// Negative index counts from the end of list, -1 is the last element.
+func (m *Map) LRange(key string, start int, stop int) ([]interface{}, error) {
+	clist, exist, e := m.listOf(key, "LRange")
+	if e != nil || !exist {
+		return []interface{}{}, e
+	}
+
+	length := clist.LLen()
+	if start < 0 {
+		start = length + start
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return []interface{}{}, nil
+	}
+	return clist.LRange(start, stop), nil
+}
+
+// LLen returns length of list of key, 0 if key doesn't exist.
+func (m *Map) LLen(key string) (int, error) {
+	clist, exist, e := m.listOf(key, "LLen")
+	if e != nil || !exist {
+		return 0, e
+	}
+	return clist.LLen(), nil
+}
+
+// listOf returns list of key, an error if key holds a value not list
+func (m *Map) listOf(key string, command string) (*clist, bool, error) {
+	rsi, exist := m.Get(key)
+	if !exist {
+		return nil, false, nil
+	}
+
+	clist, ok := rsi.(*clist)
+	if !ok {
+		return nil, false, errorx.NewServiceError(fmt.Sprintf("cmap.Map.%s key=%s is not list elem, cannot execute %s", command, key, command), 1)
+	}
+	return clist, true, nil
+}
+
+// Type returns type of value of key, TYPE_NONE if key doesn't exist.
+func (m *Map) Type(key string) string {
+	v, exist := m.Get(key)
+	if !exist {
+		return TYPE_NONE
+	}
+	return TypeOf(v)
+}
+
+// Keys returns unexpired keys matching glob pattern, see Watch for pattern syntax.
+func (m *Map) Keys(pattern string) []string {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	l, mp := m.readableWrapedBymodl()
+	l.RLock()
+	defer l.RUnlock()
+
+	var keys = make([]string, 0, 10)
+	for k, v := range mp {
+		if v.isExpire() || !globMatch(pattern, k) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -36,7 +37,6 @@ func TestMap(t *testing.T) {
 	v, _ = m.Get("username")
 	if v != nil {
 		panic("setexapi ircorrect")
-		return
 	}
 
 	m.ClearExpireKeys()
@@ -134,7 +134,6 @@ func TestDelete2(t *testing.T) {
 
 	if len(m.m) != 0 && len(m.dirty) != 0 {
 		panic("del irc")
-		return
 	}
 
 	// 	m.PrintDetail()
@@ -159,14 +158,12 @@ func TestDelete2(t *testing.T) {
 				if v != nil {
 					fmt.Println(m.PrintDetailOf(strconv.Itoa(i)))
 					panic("sd del api irccect")
-					os.Exit(1)
 				}
 
 				v, _ = m.Get(strconv.Itoa(i) + "set")
 				if v.(int) != int(i) {
 					fmt.Println(m.PrintDetailOf(strconv.Itoa(i)))
 					panic("sg del api irccect")
-					os.Exit(1)
 				}
 
 			}(i, setdone)
@@ -628,3 +625,207 @@ func TestConcurrentatomic(t *testing.T) {
 
 	fmt.Println(times)
 }
+
+func TestSetTTL(t *testing.T) {
+	m := NewMap()
+
+	// margins are wide so the test holds under -race and on loaded machines
+	deadline := time.Now().Add(time.Second)
+	m.SetTTL("ttl", "v", 50*time.Millisecond)
+	m.SetExpireAt("deadline", "v", deadline)
+	m.SetTTL("forever", "v", -1)
+
+	if !m.SetNxTTL("nx", 1, 50*time.Millisecond) {
+		t.Fatalf("setnxttl should set a new key")
+	}
+	if m.SetNxTTL("nx", 2, 50*time.Millisecond) {
+		t.Fatalf("setnxttl should not overwrite an existing key")
+	}
+	if rs := m.IncrByTTL("counter", 3, 50*time.Millisecond); rs != 3 {
+		t.Fatalf("incrbyttl want 3 but got %d", rs)
+	}
+
+	if _, ok := m.Get("ttl"); !ok {
+		t.Fatalf("key ttl should exist")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if _, ok := m.Get("ttl"); ok {
+		t.Fatalf("key ttl should be expired")
+	}
+	if _, ok := m.Get("deadline"); !ok {
+		t.Fatalf("key deadline should exist")
+	}
+	if rs := m.IncrByTTL("counter", 3, time.Minute); rs != 3 {
+		t.Fatalf("expired counter should restart, but got %d", rs)
+	}
+
+	time.Sleep(time.Until(deadline) + 200*time.Millisecond)
+	m.ClearExpireKeys()
+	if m.Len() != 2 {
+		t.Fatalf("clear irc, want 2 but got %d", m.Len())
+	}
+	if _, ok := m.Get("forever"); !ok {
+		t.Fatalf("key forever should exist")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	m := NewMap()
+	m.Set("key", "v")
+
+	if rs := m.TTL("key"); rs != TTL_NO_EXPIRE {
+		t.Fatalf("ttl want %d but got %d", TTL_NO_EXPIRE, rs)
+	}
+	if rs := m.TTL("not-exist"); rs != TTL_NOT_EXIST {
+		t.Fatalf("ttl want %d but got %d", TTL_NOT_EXIST, rs)
+	}
+	if m.Expire("not-exist", 10) {
+		t.Fatalf("expire should fail on not existed key")
+	}
+
+	if !m.Expire("key", 10) {
+		t.Fatalf("expire should succeed")
+	}
+	if rs := m.TTL("key"); rs != 10 {
+		t.Fatalf("ttl want 10 but got %d", rs)
+	}
+	if rs := m.PTTL("key"); rs <= 9000 || rs > 10000 {
+		t.Fatalf("pttl want about 10000 but got %d", rs)
+	}
+
+	if !m.Persist("key") {
+		t.Fatalf("persist should succeed")
+	}
+	if m.Persist("key") {
+		t.Fatalf("persist should fail on key without time limit")
+	}
+	if rs := m.TTL("key"); rs != TTL_NO_EXPIRE {
+		t.Fatalf("ttl want %d but got %d", TTL_NO_EXPIRE, rs)
+	}
+
+	if !m.ExpireAt("key", time.Now().Add(-time.Second)) {
+		t.Fatalf("expireat should succeed")
+	}
+	if _, ok := m.Get("key"); ok {
+		t.Fatalf("expireat in the past should delete key")
+	}
+}
+
+func TestExpireInBusyMode(t *testing.T) {
+	m := NewMap()
+	m.Set("expire", "v")
+	m.SetEx("persist", "v", 10)
+
+	m.setBusy()
+	m.PExpire("expire", 50)
+	m.Persist("persist")
+
+	if rs := m.PTTL("expire"); rs <= 0 {
+		t.Fatalf("pttl in busy mode want positive but got %d", rs)
+	}
+	if rs := m.TTL("persist"); rs != TTL_NO_EXPIRE {
+		t.Fatalf("ttl in busy mode want %d but got %d", TTL_NO_EXPIRE, rs)
+	}
+
+	// migrate write into m
+	m.ClearExpireKeys()
+
+	if rs := m.PTTL("expire"); rs <= 0 {
+		t.Fatalf("pttl after migration want positive but got %d", rs)
+	}
+	if rs := m.TTL("persist"); rs != TTL_NO_EXPIRE {
+		t.Fatalf("ttl after migration want %d but got %d", TTL_NO_EXPIRE, rs)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := m.Get("expire"); ok {
+		t.Fatalf("key should be expired")
+	}
+}
+
+func TestList(t *testing.T) {
+	m := NewMap()
+
+	if n, e := m.RPush("list", "a", "b", "c", "d"); e != nil || n != 4 {
+		t.Fatalf("want 4 but got %d, %v", n, e)
+	}
+	if elems, _ := m.LRange("list", 1, -2); len(elems) != 2 || elems[0] != "b" || elems[1] != "c" {
+		t.Fatalf("want [b c] but got %v", elems)
+	}
+	if elems, _ := m.LRange("list", -100, 100); len(elems) != 4 {
+		t.Fatalf("want 4 elements but got %v", elems)
+	}
+	if elems, _ := m.LRange("list", 3, 1); len(elems) != 0 {
+		t.Fatalf("want no element but got %v", elems)
+	}
+
+	for _, want := range []string{"a", "b", "c", "d"} {
+		if v, ok, _ := m.LPop("list"); !ok || v != want {
+			t.Fatalf("want %s but got %v", want, v)
+		}
+	}
+	if m.Type("list") != TYPE_NONE {
+		t.Fatalf("empty list should be deleted")
+	}
+
+	m.Set("string", "a")
+	if _, e := m.RPush("string", "b"); e == nil {
+		t.Fatalf("push to a string should fail")
+	}
+	if _, _, e := m.LPop("string"); e == nil {
+		t.Fatalf("pop from a string should fail")
+	}
+	if m.Type("string") != TYPE_STRING {
+		t.Fatalf("want string but got %s", m.Type("string"))
+	}
+}
+
+func TestKeys(t *testing.T) {
+	m := NewMap()
+	m.Set("user:1", 1)
+	m.Set("user:2", 2)
+	m.Set("order:1", 1)
+	m.SetTTL("user:3", 3, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	keys := m.Keys("user:*")
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "user:1" || keys[1] != "user:2" {
+		t.Fatalf("want [user:1 user:2] but got %v", keys)
+	}
+
+	m.setBusy()
+	m.Set("user:4", 4)
+	if keys := m.Keys("user:*"); len(keys) != 3 {
+		t.Fatalf("want 3 keys in busy mode but got %v", keys)
+	}
+	m.setFree2()
+}
+
+func TestCount(t *testing.T) {
+	m := NewMap()
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.SetTTL("c", 3, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if n := m.Count(); n != 2 {
+		t.Fatalf("expired key should not be counted, want 2 but got %d", n)
+	}
+
+	m.setBusy()
+	m.Set("d", 4)
+	if n := m.Count(); n != 3 {
+		t.Fatalf("want 3 in busy mode but got %d", n)
+	}
+	m.setFree2()
+
+	mv2 := NewMapV2(nil, 8, time.Minute)
+	defer mv2.Clear()
+	for i := 0; i < 100; i++ {
+		mv2.Set(strconv.Itoa(i), i)
+	}
+	if n := mv2.Count(); n != 100 {
+		t.Fatalf("want 100 but got %d", n)
+	}
+}
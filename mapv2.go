@@ -16,19 +16,27 @@ type MapV2 struct {
 	slots []*Map             // slots are all maps. Keys will first get hashed and then decide to read/write which slots
 	len   int
 
-	clear chan struct{} // close mapv2 will send clear to finish mapd goroutine
+	clear   chan struct{} // close mapv2 will send clear to finish mapd goroutine
+	reserve chan struct{} // Reserve sends reserve to start requeuing expired items in mapd goroutine
 
+	// subscribers of Subscribe and PSubscribe
+	ps *pubsub
 }
 
-func NewMapV2(hash func(string) int64, slotNum int, intervald time.Duration) *MapV2 {
+// NewMapV2 new a mapv2 with slotNum slots, expired keys of each slot will be cleared every intervald.
+// opts configure every slot, caps like WithMaxEntries are shared equally by slots.
+func NewMapV2(hash func(string) int64, slotNum int, intervald time.Duration, opts ...MapOption) *MapV2 {
 	var mv2 = &MapV2{
-		hash:  hash,
-		slots: make([]*Map, slotNum, slotNum),
-		clear: make(chan struct{}, 1),
+		hash:    hash,
+		slots:   make([]*Map, slotNum, slotNum),
+		clear:   make(chan struct{}, 1),
+		reserve: make(chan struct{}, 1),
+		ps:      newPubSub(),
 	}
 
+	c := newMapConfig(opts...).perSlot(slotNum)
 	for i, _ := range mv2.slots {
-		mv2.slots[i] = newMap()
+		mv2.slots[i] = newMapWithConfig(c)
 	}
 
 	if mv2.hash == nil {
@@ -66,6 +74,18 @@ func (mv2 *MapV2) SetNx(key string, value interface{}) {
 func (mv2 *MapV2) SetExNx(key string, value interface{}, seconds int) {
 	mv2.getslot(key).SetExNx(key, value, seconds)
 }
+func (mv2 *MapV2) SetTTL(key string, value interface{}, ttl time.Duration) {
+	mv2.getslot(key).SetTTL(key, value, ttl)
+}
+func (mv2 *MapV2) SetNxTTL(key string, value interface{}, ttl time.Duration) bool {
+	return mv2.getslot(key).SetNxTTL(key, value, ttl)
+}
+func (mv2 *MapV2) SetExpireAt(key string, value interface{}, deadline time.Time) {
+	mv2.getslot(key).SetExpireAt(key, value, deadline)
+}
+func (mv2 *MapV2) SetNxExpireAt(key string, value interface{}, deadline time.Time) bool {
+	return mv2.getslot(key).SetNxExpireAt(key, value, deadline)
+}
 func (mv2 *MapV2) Get(key string) (interface{}, bool) {
 
 	n := mv2.hash(key)
@@ -82,6 +102,9 @@ func (mv2 *MapV2) IncrBy(key string, delta int) int64 {
 func (mv2 *MapV2) IncrByEx(key string, delta int, seconds int) int64 {
 	return mv2.getslot(key).IncrByEx(key, delta, seconds)
 }
+func (mv2 *MapV2) IncrByTTL(key string, delta int, ttl time.Duration) int64 {
+	return mv2.getslot(key).IncrByTTL(key, delta, ttl)
+}
 
 func (mv2 *MapV2) Decr(key string) int64 {
 	return mv2.getslot(key).Decr(key)
@@ -92,11 +115,91 @@ func (mv2 *MapV2) DecrBy(key string, delta int) int64 {
 func (mv2 *MapV2) DecrByEx(key string, delta int, seconds int) int64 {
 	return mv2.getslot(key).DecrByEx(key, delta, seconds)
 }
+func (mv2 *MapV2) DecrByTTL(key string, delta int, ttl time.Duration) int64 {
+	return mv2.getslot(key).DecrByTTL(key, delta, ttl)
+}
 
 func (mv2 *MapV2) Delete(key string) {
 	mv2.getslot(key).Delete(key)
 }
 
+func (mv2 *MapV2) Expire(key string, seconds int) bool {
+	return mv2.getslot(key).Expire(key, seconds)
+}
+func (mv2 *MapV2) PExpire(key string, milliseconds int64) bool {
+	return mv2.getslot(key).PExpire(key, milliseconds)
+}
+func (mv2 *MapV2) ExpireAt(key string, deadline time.Time) bool {
+	return mv2.getslot(key).ExpireAt(key, deadline)
+}
+func (mv2 *MapV2) Persist(key string) bool {
+	return mv2.getslot(key).Persist(key)
+}
+func (mv2 *MapV2) TTL(key string) int64 {
+	return mv2.getslot(key).TTL(key)
+}
+func (mv2 *MapV2) PTTL(key string) int64 {
+	return mv2.getslot(key).PTTL(key)
+}
+
+// OnEvict registers f to all slots, see Map.OnEvict
+func (mv2 *MapV2) OnEvict(f func(key string, value interface{}, reason RemoveReason)) {
+	for i, _ := range mv2.slots {
+		mv2.slots[i].OnEvict(f)
+	}
+}
+
+// OnExpire registers f to all slots, see Map.OnExpire
+func (mv2 *MapV2) OnExpire(f func(key string, value interface{})) {
+	for i, _ := range mv2.slots {
+		mv2.slots[i].OnExpire(f)
+	}
+}
+
+func (mv2 *MapV2) RPush(key string, elems ...interface{}) (int, error) {
+	return mv2.getslot(key).RPush(key, elems...)
+}
+func (mv2 *MapV2) LPop(key string) (interface{}, bool, error) {
+	return mv2.getslot(key).LPop(key)
+}
+func (mv2 *MapV2) LRange(key string, start int, stop int) ([]interface{}, error) {
+	return mv2.getslot(key).LRange(key, start, stop)
+}
+func (mv2 *MapV2) LLen(key string) (int, error) {
+	return mv2.getslot(key).LLen(key)
+}
+
+func (mv2 *MapV2) Type(key string) string {
+	return mv2.getslot(key).Type(key)
+}
+
+// Keys returns unexpired keys matching glob pattern of all slots.
+func (mv2 *MapV2) Keys(pattern string) []string {
+	var keys = make([]string, 0, 10)
+	for i, _ := range mv2.slots {
+		keys = append(keys, mv2.slots[i].Keys(pattern)...)
+	}
+	return keys
+}
+
+// Len returns number of keys of all slots, expired keys not cleared yet are counted.
+func (mv2 *MapV2) Len() int {
+	var n int
+	for i, _ := range mv2.slots {
+		n += mv2.slots[i].Len()
+	}
+	return n
+}
+
+// Count returns number of keys not expired of all slots, see Map.Count.
+func (mv2 *MapV2) Count() int {
+	var n int
+	for i, _ := range mv2.slots {
+		n += mv2.slots[i].Count()
+	}
+	return n
+}
+
 func (mv2 *MapV2) getslot(key string) *Map {
 	n := mv2.hash(key)
 
@@ -107,12 +210,41 @@ func (mv2 *MapV2) getslot(key string) *Map {
 // keep
 func (mv2 *MapV2) mapd(interval time.Duration) {
 	go func() {
+		// slots are cleared one by one, 10 seconds apart
+		clear := time.NewTimer(interval)
+		defer clear.Stop()
+		var next int
+
+		// expired items are requeued only while some items are in flight
+		var requeue *time.Ticker
+		var requeueC <-chan time.Time
+		defer func() {
+			if requeue != nil {
+				requeue.Stop()
+			}
+		}()
+
 		for {
 			select {
-			case <-time.After(interval):
-				for i, _ := range mv2.slots {
-					mv2.slots[i].ClearExpireKeys()
-					time.Sleep(10 * time.Second)
+			case <-clear.C:
+				mv2.slots[next].ClearExpireKeys()
+				next++
+				if next < len(mv2.slots) {
+					clear.Reset(10 * time.Second)
+				} else {
+					next = 0
+					clear.Reset(interval)
+				}
+			case <-mv2.reserve:
+				if requeue == nil {
+					requeue = time.NewTicker(requeueInterval)
+					requeueC = requeue.C
+				}
+			case <-requeueC:
+				mv2.RequeueExpired()
+				if mv2.reserved() == 0 {
+					requeue.Stop()
+					requeue, requeueC = nil, nil
 				}
 			case <-mv2.clear:
 				return
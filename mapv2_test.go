@@ -166,7 +166,6 @@ func TestNewMap1(t *testing.T) {
 
 				mv2.PrintDetailOf("hello" + strconv.Itoa(i))
 				panic(fmt.Errorf("nil"))
-				os.Exit(1)
 			}
 			if v.(int) != 5 {
 				panic("bad f")
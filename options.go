@@ -0,0 +1,79 @@
+package cmap
+
+import (
+	"time"
+)
+
+// MapOption configures a Map when calling NewMap or NewMapV2.
+type MapOption func(*mapConfig)
+
+type mapConfig struct {
+	// 0 means no limit
+	maxEntries int
+	// 0 means no limit
+	maxBytes int64
+
+	policy  EvictionPolicy
+	samples int
+	sizer   func(key string, value interface{}) int64
+
+	watchPolicy WatchPolicy
+	watchBuffer int
+
+	pubsubPolicy PubSubPolicy
+	pubsubBuffer int
+
+	codec Codec
+
+	// errors of GetOrLoad are cached for negativeTTL, 0 means no caching
+	negativeTTL time.Duration
+
+	// items reserved more than deadLetterAfter times are moved to list of queue+deadLetterSuffix, 0 means never
+	deadLetterAfter  int
+	deadLetterSuffix string
+}
+
+func newMapConfig(opts ...MapOption) mapConfig {
+	c := mapConfig{
+		policy:  EVICT_ALLKEYS_LRU,
+		samples: defaultEvictionSamples,
+		sizer:   estimateSize,
+
+		watchBuffer: defaultWatchBuffer,
+
+		pubsubBuffer: defaultPubSubBuffer,
+
+		codec: GobCodec{},
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.samples <= 0 {
+		c.samples = defaultEvictionSamples
+	}
+	if c.sizer == nil {
+		c.sizer = estimateSize
+	}
+	if c.codec == nil {
+		c.codec = GobCodec{}
+	}
+	return c
+}
+
+// perSlot divides caps among slotNum slots
+func (c mapConfig) perSlot(slotNum int) mapConfig {
+	if slotNum <= 1 {
+		return c
+	}
+	if c.maxEntries > 0 {
+		c.maxEntries = (c.maxEntries + slotNum - 1) / slotNum
+	}
+	if c.maxBytes > 0 {
+		c.maxBytes = (c.maxBytes + int64(slotNum) - 1) / int64(slotNum)
+	}
+	return c
+}
+
+func (c mapConfig) bounded() bool {
+	return c.maxEntries > 0 || c.maxBytes > 0
+}
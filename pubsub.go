@@ -0,0 +1,386 @@
+package cmap
+
+import (
+	"sort"
+	"sync"
+)
+
+// PubSubPolicy decides what to do when a subscriber's channel is full.
+type PubSubPolicy int
+
+const (
+	PUBSUB_DROP  PubSubPolicy = 0 // drop the message for the subscriber
+	PUBSUB_BLOCK PubSubPolicy = 1 // block the publisher until the subscriber receives the message or closes
+)
+
+const defaultPubSubBuffer = 128
+
+// WithPubSubPolicy sets default policy for slow subscribers of MapV2.Subscribe, default PUBSUB_DROP.
+// A subscription can override it by WithSubscribePolicy.
+func WithPubSubPolicy(policy PubSubPolicy) MapOption {
+	return func(c *mapConfig) {
+		c.pubsubPolicy = policy
+	}
+}
+
+// WithPubSubBuffer sets default buffer size of subscribers' channels, default 128.
+// A subscription can override it by WithSubscribeBuffer.
+func WithPubSubBuffer(n int) MapOption {
+	return func(c *mapConfig) {
+		c.pubsubBuffer = n
+	}
+}
+
+// subscribeConfig configures a subscription, defaults come from the map options
+type subscribeConfig struct {
+	policy PubSubPolicy
+	buffer int
+}
+
+// SubscribeOption configures a subscription made by MapV2.Subscribe and MapV2.PSubscribe.
+type SubscribeOption func(c *subscribeConfig)
+
+// WithSubscribePolicy sets policy of the subscription when its channel is full, instead of WithPubSubPolicy.
+func WithSubscribePolicy(policy PubSubPolicy) SubscribeOption {
+	return func(c *subscribeConfig) {
+		c.policy = policy
+	}
+}
+
+// WithSubscribeBuffer sets buffer size of the subscription's channel, instead of WithPubSubBuffer.
+func WithSubscribeBuffer(n int) SubscribeOption {
+	return func(c *subscribeConfig) {
+		c.buffer = n
+	}
+}
+
+// Message is a message received by a subscriber.
+type Message struct {
+	Channel string
+	// pattern matching Channel if received by PSubscribe, empty if received by Subscribe
+	Pattern string
+	Payload interface{}
+}
+
+// Subscription receives messages of channels and patterns it subscribes, like a redis client in subscribed state.
+type Subscription struct {
+	ps     *pubsub
+	policy PubSubPolicy
+
+	ch   chan Message
+	done chan struct{}
+
+	l      *sync.RWMutex
+	closed bool
+	once   *sync.Once
+
+	// guarded by ps.l
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+func (s *Subscription) send(msg Message) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+
+	if s.closed {
+		return
+	}
+
+	switch s.policy {
+	case PUBSUB_BLOCK:
+		select {
+		case s.ch <- msg:
+		case <-s.done:
+		}
+	default:
+		select {
+		case s.ch <- msg:
+		default:
+		}
+	}
+}
+
+// Messages returns the channel receiving messages, it's closed after Close is called.
+func (s *Subscription) Messages() <-chan Message {
+	return s.ch
+}
+
+// Subscribe subscribes more channels.
+func (s *Subscription) Subscribe(channels ...string) {
+	s.ps.subscribe(s, channels, false)
+}
+
+// PSubscribe subscribes more glob patterns of channels, see Map.Watch for syntax of pattern.
+func (s *Subscription) PSubscribe(patterns ...string) {
+	s.ps.subscribe(s, patterns, true)
+}
+
+// Unsubscribe unsubscribes channels, all subscribed channels if none is given.
+func (s *Subscription) Unsubscribe(channels ...string) {
+	s.ps.unsubscribe(s, channels, false)
+}
+
+// PUnsubscribe unsubscribes patterns, all subscribed patterns if none is given.
+func (s *Subscription) PUnsubscribe(patterns ...string) {
+	s.ps.unsubscribe(s, patterns, true)
+}
+
+// Channels returns subscribed channels, sorted.
+func (s *Subscription) Channels() []string {
+	s.ps.l.RLock()
+	defer s.ps.l.RUnlock()
+	return sortedKeys(s.channels)
+}
+
+// Patterns returns subscribed patterns, sorted.
+func (s *Subscription) Patterns() []string {
+	s.ps.l.RLock()
+	defer s.ps.l.RUnlock()
+	return sortedKeys(s.patterns)
+}
+
+// Count returns number of subscribed channels and patterns.
+func (s *Subscription) Count() int {
+	s.ps.l.RLock()
+	defer s.ps.l.RUnlock()
+	return len(s.channels) + len(s.patterns)
+}
+
+// Close unsubscribes all and closes the channel of Messages.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		// wake up blocked publishers first, then they release s.l
+		close(s.done)
+
+		s.l.Lock()
+		s.closed = true
+		close(s.ch)
+		s.l.Unlock()
+
+		s.ps.unsubscribe(s, nil, false)
+		s.ps.unsubscribe(s, nil, true)
+	})
+}
+
+// pubsub keeps subscribers of channels and patterns
+type pubsub struct {
+	l        *sync.RWMutex
+	channels map[string]map[*Subscription]struct{}
+	patterns map[string]map[*Subscription]struct{}
+	closed   bool
+}
+
+func newPubSub() *pubsub {
+	return &pubsub{
+		l:        &sync.RWMutex{},
+		channels: make(map[string]map[*Subscription]struct{}),
+		patterns: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+func (ps *pubsub) newSubscription(policy PubSubPolicy, buffer int) *Subscription {
+	if buffer < 0 {
+		buffer = 0
+	}
+	s := &Subscription{
+		ps:       ps,
+		policy:   policy,
+		ch:       make(chan Message, buffer),
+		done:     make(chan struct{}),
+		l:        &sync.RWMutex{},
+		once:     &sync.Once{},
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+
+	ps.l.RLock()
+	closed := ps.closed
+	ps.l.RUnlock()
+	if closed {
+		s.Close()
+	}
+	return s
+}
+
+func (ps *pubsub) subscribe(s *Subscription, names []string, pattern bool) {
+	ps.l.Lock()
+	defer ps.l.Unlock()
+
+	s.l.RLock()
+	closed := s.closed
+	s.l.RUnlock()
+	if ps.closed || closed {
+		return
+	}
+
+	all, mine := ps.channels, s.channels
+	if pattern {
+		all, mine = ps.patterns, s.patterns
+	}
+	for _, name := range names {
+		subs, exist := all[name]
+		if !exist {
+			subs = make(map[*Subscription]struct{})
+			all[name] = subs
+		}
+		subs[s] = struct{}{}
+		mine[name] = struct{}{}
+	}
+}
+
+// unsubscribe removes s from names, all names of s if names is empty
+func (ps *pubsub) unsubscribe(s *Subscription, names []string, pattern bool) {
+	ps.l.Lock()
+	defer ps.l.Unlock()
+
+	all, mine := ps.channels, s.channels
+	if pattern {
+		all, mine = ps.patterns, s.patterns
+	}
+	if len(names) == 0 {
+		names = sortedKeys(mine)
+	}
+	for _, name := range names {
+		delete(mine, name)
+		if subs, exist := all[name]; exist {
+			delete(subs, s)
+			if len(subs) == 0 {
+				delete(all, name)
+			}
+		}
+	}
+}
+
+// publish sends msg to subscribers of channel and patterns matching channel.
+// It returns number of receivers, a subscriber matched by both counts twice like redis.
+func (ps *pubsub) publish(channel string, payload interface{}) int {
+	type delivery struct {
+		s   *Subscription
+		msg Message
+	}
+
+	// collect receivers and send without lock, so a blocked publisher doesn't stop others from unsubscribing
+	var deliveries []delivery
+	ps.l.RLock()
+	for s, _ := range ps.channels[channel] {
+		deliveries = append(deliveries, delivery{s, Message{Channel: channel, Payload: payload}})
+	}
+	for pattern, subs := range ps.patterns {
+		if !globMatch(pattern, channel) {
+			continue
+		}
+		for s, _ := range subs {
+			deliveries = append(deliveries, delivery{s, Message{Channel: channel, Pattern: pattern, Payload: payload}})
+		}
+	}
+	ps.l.RUnlock()
+
+	for i, _ := range deliveries {
+		deliveries[i].s.send(deliveries[i].msg)
+	}
+	return len(deliveries)
+}
+
+func (ps *pubsub) close() {
+	ps.l.Lock()
+	ps.closed = true
+	var subs = make(map[*Subscription]struct{})
+	for _, group := range []map[string]map[*Subscription]struct{}{ps.channels, ps.patterns} {
+		for _, members := range group {
+			for s, _ := range members {
+				subs[s] = struct{}{}
+			}
+		}
+	}
+	ps.l.Unlock()
+
+	for s, _ := range subs {
+		s.Close()
+	}
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	var keys = make([]string, 0, len(m))
+	for k, _ := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Publish sends msg to subscribers of channel, and subscribers of patterns matching channel.
+// It returns number of subscribers receiving it, including those dropping it by PUBSUB_DROP.
+// Messages are not saved, a channel without subscribers drops them.
+func (mv2 *MapV2) Publish(channel string, msg interface{}) int {
+	return mv2.ps.publish(channel, msg)
+}
+
+// Subscribe returns a subscription of channels, more can be subscribed by methods of the subscription.
+// opts override WithPubSubPolicy and WithPubSubBuffer of the map for this subscription.
+//
+//	sub := mv2.Subscribe([]string{"news"}, cmap.WithSubscribeBuffer(1024))
+//	defer sub.Close()
+//	for msg := range sub.Messages() {
+//	    fmt.Println(msg.Channel, msg.Payload)
+//	}
+func (mv2 *MapV2) Subscribe(channels []string, opts ...SubscribeOption) *Subscription {
+	s := mv2.newSubscription(opts)
+	s.Subscribe(channels...)
+	return s
+}
+
+// PSubscribe returns a subscription of glob patterns of channels, see Map.Watch for syntax of pattern.
+// opts are like those of Subscribe.
+func (mv2 *MapV2) PSubscribe(patterns []string, opts ...SubscribeOption) *Subscription {
+	s := mv2.newSubscription(opts)
+	s.PSubscribe(patterns...)
+	return s
+}
+
+func (mv2 *MapV2) newSubscription(opts []SubscribeOption) *Subscription {
+	conf := mv2.slots[0].conf
+	var c = subscribeConfig{
+		policy: conf.pubsubPolicy,
+		buffer: conf.pubsubBuffer,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return mv2.ps.newSubscription(c.policy, c.buffer)
+}
+
+// PubSubChannels returns channels having subscribers and matching pattern, sorted, like redis PUBSUB CHANNELS.
+// Subscribers of patterns are not counted, an empty pattern matches all channels.
+func (mv2 *MapV2) PubSubChannels(pattern string) []string {
+	mv2.ps.l.RLock()
+	defer mv2.ps.l.RUnlock()
+
+	var channels = make([]string, 0)
+	for channel, _ := range mv2.ps.channels {
+		if globMatch(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// PubSubNumSub returns number of subscribers of each channel, subscribers of patterns are not counted.
+func (mv2 *MapV2) PubSubNumSub(channels ...string) map[string]int {
+	mv2.ps.l.RLock()
+	defer mv2.ps.l.RUnlock()
+
+	var counts = make(map[string]int, len(channels))
+	for _, channel := range channels {
+		counts[channel] = len(mv2.ps.channels[channel])
+	}
+	return counts
+}
+
+// PubSubNumPat returns number of subscribed patterns, a pattern subscribed by many subscribers counts once.
+func (mv2 *MapV2) PubSubNumPat() int {
+	mv2.ps.l.RLock()
+	defer mv2.ps.l.RUnlock()
+	return len(mv2.ps.patterns)
+}
@@ -0,0 +1,173 @@
+package cmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, sub *Subscription) Message {
+	select {
+	case msg, ok := <-sub.Messages():
+		if !ok {
+			t.Fatalf("subscription is closed")
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("no message received")
+	}
+	return Message{}
+}
+
+func TestPubSub(t *testing.T) {
+	m := NewMapV2(nil, 8, 5*time.Minute)
+	defer m.Close()
+
+	news := m.Subscribe([]string{"news", "sport"})
+	all := m.PSubscribe([]string{"n*"})
+
+	if n := m.Publish("news", "hello"); n != 2 {
+		t.Fatalf("want 2 receivers but got %d", n)
+	}
+	if msg := receive(t, news); msg.Channel != "news" || msg.Pattern != "" || msg.Payload != "hello" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	if msg := receive(t, all); msg.Channel != "news" || msg.Pattern != "n*" || msg.Payload != "hello" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	if n := m.Publish("weather", 1); n != 0 {
+		t.Fatalf("want 0 receivers but got %d", n)
+	}
+
+	// introspection
+	if channels := m.PubSubChannels(""); len(channels) != 2 || channels[0] != "news" || channels[1] != "sport" {
+		t.Fatalf("unexpected channels %v", channels)
+	}
+	if channels := m.PubSubChannels("s*"); len(channels) != 1 {
+		t.Fatalf("unexpected channels %v", channels)
+	}
+	if counts := m.PubSubNumSub("news", "weather"); counts["news"] != 1 || counts["weather"] != 0 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+	if n := m.PubSubNumPat(); n != 1 {
+		t.Fatalf("want 1 pattern but got %d", n)
+	}
+
+	news.Unsubscribe("news")
+	if news.Count() != 1 || news.Channels()[0] != "sport" {
+		t.Fatalf("unexpected channels %v", news.Channels())
+	}
+	news.PSubscribe("s*")
+	if n := m.Publish("sport", "goal"); n != 2 {
+		t.Fatalf("subscriber of channel and pattern counts twice, but got %d", n)
+	}
+	receive(t, news)
+	receive(t, news)
+
+	news.Close()
+	if _, ok := <-news.Messages(); ok {
+		t.Fatalf("channel should be closed")
+	}
+	if channels := m.PubSubChannels(""); len(channels) != 0 {
+		t.Fatalf("closed subscription should be removed but got %v", channels)
+	}
+
+	all.PUnsubscribe()
+	if m.PubSubNumPat() != 0 || all.Count() != 0 {
+		t.Fatalf("all patterns should be unsubscribed")
+	}
+}
+
+func TestPubSubPolicy(t *testing.T) {
+	drop := NewMapV2(nil, 8, 5*time.Minute, WithPubSubBuffer(2))
+	defer drop.Close()
+
+	sub := drop.Subscribe([]string{"c"})
+	for i := 0; i < 5; i++ {
+		drop.Publish("c", i)
+	}
+	// messages beyond buffer are dropped
+	if len(sub.Messages()) != 2 || receive(t, sub).Payload != 0 {
+		t.Fatalf("want first 2 messages kept")
+	}
+
+	block := NewMapV2(nil, 8, 5*time.Minute, WithPubSubBuffer(1), WithPubSubPolicy(PUBSUB_BLOCK))
+	sub = block.Subscribe([]string{"c"})
+
+	var published = make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			block.Publish("c", i)
+		}
+		close(published)
+	}()
+	for i := 0; i < 5; i++ {
+		if msg := receive(t, sub); msg.Payload != i {
+			t.Fatalf("want %d but got %v", i, msg.Payload)
+		}
+	}
+	<-published
+
+	// closing releases a blocked publisher
+	block.Publish("c", "fill")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		block.Close()
+	}()
+	block.Publish("c", "blocked")
+	if _, ok := <-sub.Messages(); !ok {
+		t.Fatalf("buffered message should be received")
+	}
+	if _, ok := <-sub.Messages(); ok {
+		t.Fatalf("channel should be closed by MapV2.Close")
+	}
+	if sub := block.Subscribe([]string{"c"}); sub.Count() != 0 {
+		t.Fatalf("subscribing a closed map should do nothing")
+	}
+}
+
+func TestSubscribeOptions(t *testing.T) {
+	m := NewMapV2(nil, 8, 5*time.Minute, WithPubSubBuffer(1), WithPubSubPolicy(PUBSUB_BLOCK))
+	defer m.Close()
+
+	// overrides the map's blocking policy, so publishing below doesn't block
+	drop := m.Subscribe([]string{"c"}, WithSubscribePolicy(PUBSUB_DROP), WithSubscribeBuffer(3))
+	pattern := m.PSubscribe([]string{"c*"}, WithSubscribeBuffer(8))
+	for i := 0; i < 5; i++ {
+		m.Publish("c", i)
+	}
+	if len(drop.Messages()) != 3 {
+		t.Fatalf("want 3 messages kept but got %d", len(drop.Messages()))
+	}
+	if len(pattern.Messages()) != 5 {
+		t.Fatalf("want 5 messages buffered but got %d", len(pattern.Messages()))
+	}
+
+	// without options the map's defaults apply
+	if sub := m.Subscribe([]string{"d"}); cap(sub.Messages()) != 1 || sub.policy != PUBSUB_BLOCK {
+		t.Fatalf("want defaults of the map but got buffer %d policy %d", cap(sub.Messages()), sub.policy)
+	}
+}
+
+func TestPubSubConcurrent(t *testing.T) {
+	m := NewMapV2(nil, 8, 5*time.Minute, WithPubSubPolicy(PUBSUB_BLOCK))
+	defer m.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				sub := m.PSubscribe([]string{"*"})
+				m.Publish("c", j)
+				sub.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if m.PubSubNumPat() != 0 {
+		t.Fatalf("all subscriptions are closed")
+	}
+}
@@ -0,0 +1,285 @@
+package cmap
+
+import (
+	"container/heap"
+	"github.com/fwhezfwhez/errorx"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// how often MapV2's background goroutine requeues items whose visibility timeout passed
+const requeueInterval = 100 * time.Millisecond
+
+// WithDeadLetter moves an item reserved by Reserve to list of queue+suffix instead of requeuing it,
+// once it has been redelivered n times and still isn't acked. Default 0 requeues items forever.
+func WithDeadLetter(n int, suffix string) MapOption {
+	return func(c *mapConfig) {
+		c.deadLetterAfter = n
+		c.deadLetterSuffix = suffix
+	}
+}
+
+// QueueItem is an item reserved from a queue by Reserve.
+type QueueItem struct {
+	// passed to Ack, every delivery has a new ID
+	ID    string
+	Queue string
+	Value interface{}
+	// times the item has been delivered, 1 for the first delivery
+	Deliveries int
+}
+
+// reservation is an item in flight, invisible until it's acked or its deadline passes
+type reservation struct {
+	item     QueueItem
+	deadline int64
+	// index in reservationHeap
+	index int
+}
+
+// reservationHeap orders reservations by deadline
+type reservationHeap []*reservation
+
+func (h reservationHeap) Len() int           { return len(h) }
+func (h reservationHeap) Less(i, j int) bool { return h[i].deadline < h[j].deadline }
+func (h reservationHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *reservationHeap) Push(x interface{}) {
+	r := x.(*reservation)
+	r.index = len(*h)
+	*h = append(*h, r)
+}
+func (h *reservationHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return r
+}
+
+// redelivery is the number of deliveries of an item requeued to head of its queue
+type redelivery struct {
+	value      interface{}
+	deliveries int
+}
+
+// reliableQueues keeps items in flight and deliveries of requeued items, guarded by Map.listLock
+type reliableQueues struct {
+	seq int64
+
+	inflight  map[string]*reservation
+	deadlines reservationHeap
+
+	// deliveries of items requeued to each queue, the latest last
+	requeued map[string][]redelivery
+
+	// errors requeuing items of each queue or moving them to dead letter list, returned by the next Reserve of the queue
+	failed map[string]error
+}
+
+func newReliableQueues() *reliableQueues {
+	return &reliableQueues{
+		inflight: make(map[string]*reservation),
+		requeued: make(map[string][]redelivery),
+		failed:   make(map[string]error),
+	}
+}
+
+// deliveries returns times value popped from queue has been delivered, taking its record if it was requeued.
+// Records are trimmed to length of the list, for items requeued might be taken by other commands than Reserve.
+func (q *reliableQueues) deliveries(queue string, value interface{}, llen int) int {
+	records := q.requeued[queue]
+	var n int
+	for i := len(records) - 1; i >= 0; i-- {
+		if reflect.DeepEqual(records[i].value, value) {
+			n = records[i].deliveries
+			records = append(records[:i], records[i+1:]...)
+			break
+		}
+	}
+	if len(records) > llen {
+		records = records[len(records)-llen:]
+	}
+	if len(records) == 0 {
+		delete(q.requeued, queue)
+	} else {
+		q.requeued[queue] = records
+	}
+	return n
+}
+
+func (q *reliableQueues) nextID(queue string) string {
+	return queue + "#" + strconv.FormatInt(atomic.AddInt64(&q.seq, 1), 10)
+}
+
+// Reserve takes the first item of queue and hides it for visibility, like a message of SQS.
+// Items are pushed to queue by RPush. The item is pushed back to head of queue if it's not acked by Ack
+// before visibility passes, so every item is delivered at least once.
+// Requeued items are like other elements of the list, LRange sees them and BLPop may take them.
+// It returns false if queue is empty.
+//
+// Deliveries of a requeued item are matched by value when it's reserved again,
+// an item taken by other commands than Reserve loses its count.
+//
+// If an item of queue can't be moved to its dead letter list, for the key holds another type, the item is requeued,
+// and the next Reserve of queue returns the error without reserving. If it can't be requeued either,
+// it stays in flight and is retried later.
+//
+// Items in flight are kept in memory, they are not saved by AOF and snapshots.
+func (m *Map) Reserve(queue string, visibility time.Duration) (QueueItem, bool, error) {
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	m.requeueExpired(time.Now().UnixNano())
+	if e, exist := m.queues.failed[queue]; exist {
+		delete(m.queues.failed, queue)
+		return QueueItem{}, false, e
+	}
+
+	elem, ok, e := m.pop(queue, "Reserve", true)
+	if e != nil || !ok {
+		return QueueItem{}, false, e
+	}
+	var item = QueueItem{
+		ID:    m.queues.nextID(queue),
+		Queue: queue,
+		Value: elem,
+	}
+	if _, exist := m.queues.requeued[queue]; exist {
+		llen, _ := m.LLen(queue)
+		item.Deliveries = m.queues.deliveries(queue, elem, llen)
+	}
+	item.Deliveries++
+
+	r := &reservation{
+		item:     item,
+		deadline: time.Now().Add(visibility).UnixNano(),
+	}
+	m.queues.inflight[item.ID] = r
+	heap.Push(&m.queues.deadlines, r)
+	return item, true, nil
+}
+
+// Ack confirms item of id is done, so it won't be redelivered.
+// It returns false if id isn't in flight, for its visibility has passed or it has been acked.
+func (m *Map) Ack(id string) bool {
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	r, exist := m.queues.inflight[id]
+	if !exist {
+		return false
+	}
+	delete(m.queues.inflight, id)
+	heap.Remove(&m.queues.deadlines, r.index)
+	return true
+}
+
+// RequeueExpired pushes reserved items whose visibility passed back to head of their queues, in order of deadline,
+// or moves them to dead letter list by WithDeadLetter.
+// It's run by MapV2's background goroutine, and by Reserve. It returns number of items requeued or dead.
+func (m *Map) RequeueExpired() int {
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	return m.requeueExpired(time.Now().UnixNano())
+}
+
+// caller must hold m.listLock
+func (m *Map) requeueExpired(now int64) int {
+	var n int
+	var expired []*reservation
+	for m.queues.deadlines.Len() > 0 && m.queues.deadlines[0].deadline <= now {
+		r := heap.Pop(&m.queues.deadlines).(*reservation)
+		delete(m.queues.inflight, r.item.ID)
+		expired = append(expired, r)
+	}
+
+	// pushed from the latest deadline, so the earliest is at head
+	for i := len(expired) - 1; i >= 0; i-- {
+		r := expired[i]
+		item := r.item
+		if m.conf.deadLetterAfter > 0 && item.Deliveries > m.conf.deadLetterAfter {
+			_, e := m.push(item.Queue+m.conf.deadLetterSuffix, "Reserve", false, []interface{}{item.Value})
+			if e == nil {
+				n++
+				continue
+			}
+			// keep the item rather than lose it
+			m.queues.failed[item.Queue] = errorx.Wrap(e)
+		}
+
+		m.queues.requeued[item.Queue] = append(m.queues.requeued[item.Queue], redelivery{value: item.Value, deliveries: item.Deliveries})
+		if _, e := m.push(item.Queue, "Reserve", true, []interface{}{item.Value}); e != nil {
+			records := m.queues.requeued[item.Queue]
+			if len(records) == 1 {
+				delete(m.queues.requeued, item.Queue)
+			} else {
+				m.queues.requeued[item.Queue] = records[:len(records)-1]
+			}
+			m.queues.failed[item.Queue] = errorx.Wrap(e)
+
+			// queue holds another type, retry later
+			r.deadline = now + int64(requeueInterval)
+			m.queues.inflight[item.ID] = r
+			heap.Push(&m.queues.deadlines, r)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// reserved returns number of items in flight
+func (m *Map) reserved() int {
+	m.listLock.Lock()
+	defer m.listLock.Unlock()
+
+	return len(m.queues.inflight)
+}
+
+// Reserve reserves an item of queue, see Map.Reserve.
+// The background goroutine requeues expired items only while some items are in flight.
+func (mv2 *MapV2) Reserve(queue string, visibility time.Duration) (QueueItem, bool, error) {
+	item, ok, e := mv2.getslot(queue).Reserve(queue, visibility)
+	if ok {
+		select {
+		case mv2.reserve <- struct{}{}:
+		default:
+		}
+	}
+	return item, ok, e
+}
+
+// Ack confirms item of id is done, see Map.Ack.
+func (mv2 *MapV2) Ack(id string) bool {
+	i := strings.LastIndexByte(id, '#')
+	if i < 0 {
+		return false
+	}
+	return mv2.getslot(id[:i]).Ack(id)
+}
+
+// reserved returns number of items in flight of all slots
+func (mv2 *MapV2) reserved() int {
+	var n int
+	for i, _ := range mv2.slots {
+		n += mv2.slots[i].reserved()
+	}
+	return n
+}
+
+// RequeueExpired requeues expired reservations of all slots, see Map.RequeueExpired.
+func (mv2 *MapV2) RequeueExpired() int {
+	var n int
+	for i, _ := range mv2.slots {
+		n += mv2.slots[i].RequeueExpired()
+	}
+	return n
+}
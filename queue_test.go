@@ -0,0 +1,188 @@
+package cmap
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReserveAck(t *testing.T) {
+	m := NewMap()
+
+	m.RPush("jobs", "a", "b")
+	a, ok, e := m.Reserve("jobs", time.Minute)
+	if e != nil || !ok || a.Value != "a" || a.Queue != "jobs" || a.Deliveries != 1 {
+		t.Fatalf("unexpected item %+v, %v", a, e)
+	}
+	b, _, _ := m.Reserve("jobs", 10*time.Millisecond)
+	if b.Value != "b" || b.ID == a.ID {
+		t.Fatalf("unexpected item %+v", b)
+	}
+	if _, ok, _ := m.Reserve("jobs", time.Minute); ok {
+		t.Fatalf("reserved items should be invisible")
+	}
+
+	if !m.Ack(a.ID) {
+		t.Fatalf("ack should succeed")
+	}
+	if m.Ack(a.ID) {
+		t.Fatalf("ack twice should fail")
+	}
+
+	// b isn't acked in time
+	time.Sleep(20 * time.Millisecond)
+	b2, ok, _ := m.Reserve("jobs", time.Minute)
+	if !ok || b2.Value != "b" || b2.Deliveries != 2 || b2.ID == b.ID {
+		t.Fatalf("b should be redelivered but got %+v", b2)
+	}
+	if m.Ack(b.ID) {
+		t.Fatalf("ack of an expired delivery should fail")
+	}
+	if !m.Ack(b2.ID) {
+		t.Fatalf("ack should succeed")
+	}
+
+	m.Set("name", "cmap")
+	if _, _, e := m.Reserve("name", time.Minute); e == nil {
+		t.Fatalf("Reserve on string should fail")
+	}
+}
+
+func TestReserveRequeueOrder(t *testing.T) {
+	m := NewMap()
+
+	m.RPush("jobs", 1, 2, 3)
+	m.Reserve("jobs", time.Millisecond)
+	m.Reserve("jobs", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	if n := m.RequeueExpired(); n != 2 {
+		t.Fatalf("want 2 requeued but got %d", n)
+	}
+	// requeued items are delivered first, in order of deadline
+	for _, want := range []int{1, 2, 3} {
+		item, _, _ := m.Reserve("jobs", time.Minute)
+		if item.Value != want {
+			t.Fatalf("want %d but got %v", want, item.Value)
+		}
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	m := NewMap(WithDeadLetter(2, ":dead"))
+
+	m.RPush("jobs", "poison")
+	for i := 1; i <= 3; i++ {
+		item, ok, _ := m.Reserve("jobs", time.Millisecond)
+		if !ok || item.Deliveries != i {
+			t.Fatalf("delivery %d: unexpected item %+v", i, item)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if _, ok, _ := m.Reserve("jobs", time.Minute); ok {
+		t.Fatalf("item redelivered twice should be dead")
+	}
+	if elems, _ := m.LRange("jobs:dead", 0, -1); len(elems) != 1 || elems[0] != "poison" {
+		t.Fatalf("want [poison] but got %v", elems)
+	}
+}
+
+func TestDeadLetterOfWrongType(t *testing.T) {
+	m := NewMap(WithDeadLetter(1, ":dead"))
+	m.Set("jobs:dead", "not a list")
+
+	m.RPush("jobs", "poison")
+	for i := 1; i <= 2; i++ {
+		m.Reserve("jobs", time.Millisecond)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if _, ok, e := m.Reserve("jobs", time.Minute); ok || e == nil {
+		t.Fatalf("Reserve should fail when dead letter key holds a string, got %v %v", ok, e)
+	}
+	// the item is requeued, not lost
+	item, ok, e := m.Reserve("jobs", time.Minute)
+	if !ok || e != nil || item.Value != "poison" || item.Deliveries != 3 {
+		t.Fatalf("unexpected item %+v %v %v", item, ok, e)
+	}
+	if v, _ := m.Get("jobs:dead"); v != "not a list" {
+		t.Fatalf("dead letter key should be untouched, got %v", v)
+	}
+}
+
+func TestMapV2Reserve(t *testing.T) {
+	m := NewMapV2(nil, 8, 5*time.Minute)
+	defer m.Close()
+
+	for i := 0; i < 8; i++ {
+		m.RPush("jobs:"+strconv.Itoa(i), i)
+	}
+	var ids []string
+	for i := 0; i < 8; i++ {
+		item, ok, _ := m.Reserve("jobs:"+strconv.Itoa(i), 50*time.Millisecond)
+		if !ok || item.Value != i {
+			t.Fatalf("unexpected item %+v", item)
+		}
+		ids = append(ids, item.ID)
+	}
+	for _, id := range ids[:4] {
+		if !m.Ack(id) {
+			t.Fatalf("ack %s should succeed", id)
+		}
+	}
+	if m.Ack("no-such-id") {
+		t.Fatalf("ack of unknown id should fail")
+	}
+
+	// un-acked items are requeued by the background goroutine
+	time.Sleep(50*time.Millisecond + 2*requeueInterval)
+	var requeued int
+	for i := 0; i < 8; i++ {
+		n, _ := m.LLen("jobs:" + strconv.Itoa(i))
+		requeued += n
+	}
+	if requeued != 4 {
+		t.Fatalf("want 4 requeued but got %d", requeued)
+	}
+	if n := m.reserved(); n != 0 {
+		t.Fatalf("want nothing in flight but got %d", n)
+	}
+}
+
+func TestRequeuedItemsAreInList(t *testing.T) {
+	m := NewMap()
+
+	m.RPush("jobs", "a", "b")
+	m.Reserve("jobs", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	m.RequeueExpired()
+
+	if elems, _ := m.LRange("jobs", 0, -1); len(elems) != 2 || elems[0] != "a" || elems[1] != "b" {
+		t.Fatalf("want [a b] but got %v", elems)
+	}
+
+	// a blocked caller is served by the requeued item
+	m.Reserve("jobs", time.Millisecond)
+	m.Reserve("jobs", time.Minute)
+	var got = make(chan interface{})
+	go func() {
+		_, elem, _, _ := m.BLPop(context.Background(), time.Second, "jobs")
+		got <- elem
+	}()
+	time.Sleep(10 * time.Millisecond)
+	m.RequeueExpired()
+	if elem := <-got; elem != "a" {
+		t.Fatalf("want a but got %v", elem)
+	}
+
+	// deliveries are counted across requeues
+	m.RPush("jobs", "c")
+	c, _, _ := m.Reserve("jobs", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	c2, _, _ := m.Reserve("jobs", time.Minute)
+	if c.Value != "c" || c2.Value != "c" || c2.Deliveries != 2 {
+		t.Fatalf("unexpected items %+v %+v", c, c2)
+	}
+}
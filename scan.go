@@ -0,0 +1,304 @@
+package cmap
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// buckets a scan index starts with, and shrinks to at least
+const scanIndexMinBuckets = 8
+
+// scanIndex keeps keys of a map in buckets by hash, so Scan visits a few buckets per call instead of all keys.
+// Buckets are a power of two, and grow or shrink with number of keys.
+// It's built by the first Scan of a map, so maps never scanned don't pay for it on writes.
+//
+// It's a superset of keys in the readable register: a key is added after it's written,
+// and removed after it's deleted, once it's found absent under lock of the readable register.
+// So a key being in the map is always in the index, an expired key stays until Scan or ClearExpireKeys meets it.
+type scanIndex struct {
+	l       *sync.RWMutex
+	seed    maphash.Seed
+	buckets [][]string
+	n       int
+}
+
+// newScanIndex returns an index with buckets for about n keys
+func newScanIndex(n int) *scanIndex {
+	size := scanIndexMinBuckets
+	for size < n {
+		size *= 2
+	}
+	return &scanIndex{
+		l:       &sync.RWMutex{},
+		seed:    maphash.MakeSeed(),
+		buckets: make([][]string, size),
+	}
+}
+
+func (x *scanIndex) bucketOf(key string, size int) int {
+	return int(maphash.String(x.seed, key) & uint64(size-1))
+}
+
+func (x *scanIndex) has(key string) bool {
+	for _, k := range x.buckets[x.bucketOf(key, len(x.buckets))] {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *scanIndex) add(key string) {
+	x.l.RLock()
+	exist := x.has(key)
+	x.l.RUnlock()
+	if exist {
+		return
+	}
+
+	x.l.Lock()
+	defer x.l.Unlock()
+
+	if x.has(key) {
+		return
+	}
+	i := x.bucketOf(key, len(x.buckets))
+	x.buckets[i] = append(x.buckets[i], key)
+	x.n++
+	if x.n > len(x.buckets) {
+		x.resize(2 * len(x.buckets))
+	}
+}
+
+// remove drops key, caller must have found key absent under lock of the readable register, and still hold it
+func (x *scanIndex) remove(key string) {
+	x.l.Lock()
+	defer x.l.Unlock()
+
+	i := x.bucketOf(key, len(x.buckets))
+	b := x.buckets[i]
+	for j, _ := range b {
+		if b[j] == key {
+			b[j] = b[len(b)-1]
+			b[len(b)-1] = ""
+			x.buckets[i] = b[:len(b)-1]
+			x.n--
+			break
+		}
+	}
+	if len(x.buckets) > scanIndexMinBuckets && x.n < len(x.buckets)/8 {
+		x.resize(len(x.buckets) / 2)
+	}
+}
+
+// prune drops keys not kept by keep, caller must hold lock of the readable register
+func (x *scanIndex) prune(keep func(key string) bool) {
+	x.l.Lock()
+	defer x.l.Unlock()
+
+	for i, _ := range x.buckets {
+		b := x.buckets[i][:0]
+		for _, k := range x.buckets[i] {
+			if keep(k) {
+				b = append(b, k)
+			}
+		}
+		for j := len(b); j < len(x.buckets[i]); j++ {
+			x.buckets[i][j] = ""
+		}
+		x.n -= len(x.buckets[i]) - len(b)
+		x.buckets[i] = b
+	}
+
+	size := len(x.buckets)
+	for size > scanIndexMinBuckets && x.n < size/2 {
+		size /= 2
+	}
+	if size != len(x.buckets) {
+		x.resize(size)
+	}
+}
+
+func (x *scanIndex) resize(size int) {
+	var buckets = make([][]string, size)
+	for _, b := range x.buckets {
+		for _, k := range b {
+			i := x.bucketOf(k, size)
+			buckets[i] = append(buckets[i], k)
+		}
+	}
+	x.buckets = buckets
+}
+
+// scan calls visit with keys of buckets from cursor, until about count keys or 10*count empty buckets are visited.
+// It returns the cursor of the next bucket, 0 when all buckets are visited.
+//
+// Buckets are visited in reverse binary order of cursor like redis dictScan, so a key present during a full scan
+// is visited at least once even if buckets grow or shrink between calls, though it might be visited twice.
+func (x *scanIndex) scan(cursor uint64, count int, visit func(key string)) uint64 {
+	x.l.RLock()
+	defer x.l.RUnlock()
+
+	mask := uint64(len(x.buckets) - 1)
+	var visited, empty int
+	for {
+		b := x.buckets[cursor&mask]
+		for _, k := range b {
+			visit(k)
+		}
+		visited += len(b)
+		if len(b) == 0 {
+			empty++
+		}
+
+		// increase the reversed cursor
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+
+		if cursor == 0 || visited >= count || empty >= 10*count {
+			return cursor
+		}
+	}
+}
+
+// scanIndexOf returns the scan index of m, it's built from keys of the readable register on the first call.
+func (m *Map) scanIndexOf() *scanIndex {
+	if x := m.scans.Load(); x != nil {
+		return x
+	}
+
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	l, mp := m.readableWrapedBymodl()
+	l.RLock()
+	defer l.RUnlock()
+
+	// published under lock of the readable register, so a key written after is added by its writer
+	x := newScanIndex(len(mp))
+	for k, v := range mp {
+		if !v.isExpire() {
+			i := x.bucketOf(k, len(x.buckets))
+			x.buckets[i] = append(x.buckets[i], k)
+			x.n++
+		}
+	}
+	if !m.scans.CompareAndSwap(nil, x) {
+		return m.scans.Load()
+	}
+	return x
+}
+
+// index adds key written to the scan index, if it's built
+func (m *Map) index(key string) {
+	if x := m.scans.Load(); x != nil {
+		x.add(key)
+	}
+}
+
+// unindex removes key from the scan index if it's built and key is absent from the map
+func (m *Map) unindex(key string) {
+	x := m.scans.Load()
+	if x == nil {
+		return
+	}
+
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	l, mp := m.readableWrapedBymodl()
+	l.RLock()
+	defer l.RUnlock()
+
+	if _, exist := mp[key]; !exist {
+		x.remove(key)
+	}
+}
+
+// pruneScanIndex removes keys absent from the map from the scan index, if it's built
+func (m *Map) pruneScanIndex() {
+	x := m.scans.Load()
+	if x == nil {
+		return
+	}
+
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	l, mp := m.readableWrapedBymodl()
+	l.RLock()
+	defer l.RUnlock()
+
+	x.prune(func(key string) bool {
+		_, exist := mp[key]
+		return exist
+	})
+}
+
+// Scan iterates keys matching glob pattern match incrementally, like redis SCAN.
+// Start a scan with cursor 0, and call Scan with the returned cursor until it's 0.
+// count is a hint of keys visited each call, default 10, fewer keys are returned if some don't match.
+// Each call takes time of about count keys, however large the map is.
+//
+// A key present from start to end of a full scan is returned at least once, modes switched by ClearExpireKeys make no difference.
+// A key might be returned twice if the map shrinks during the scan. A key added or deleted during the scan may be returned or not.
+// Unlike Range, locks are held only within a call.
+// The first Scan of a map indexes all keys, later writes keep the index.
+func (m *Map) Scan(cursor uint64, match string, count int) (uint64, []string) {
+	if count <= 0 {
+		count = 10
+	}
+
+	var keys = make([]string, 0, count)
+	var stale []string
+	x := m.scanIndexOf()
+
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	l, mp := m.readableWrapedBymodl()
+	l.RLock()
+	defer l.RUnlock()
+
+	next := x.scan(cursor, count, func(k string) {
+		v, exist := mp[k]
+		if !exist {
+			stale = append(stale, k)
+			return
+		}
+		if v.isExpire() || !globMatch(match, k) {
+			return
+		}
+		keys = append(keys, k)
+	})
+	for _, k := range stale {
+		x.remove(k)
+	}
+	return next, keys
+}
+
+// Scan iterates keys of all slots incrementally, see Map.Scan.
+// Index of slot is kept in high 32 bits of cursor, and cursor of the slot in low 32 bits.
+func (mv2 *MapV2) Scan(cursor uint64, match string, count int) (uint64, []string) {
+	if count <= 0 {
+		count = 10
+	}
+
+	var keys = make([]string, 0, count)
+	slot, pos := cursor>>32, cursor&math.MaxUint32
+	for slot < uint64(mv2.len) && len(keys) < count {
+		next, ks := mv2.slots[slot].Scan(pos, match, count-len(keys))
+		keys = append(keys, ks...)
+		if next != 0 {
+			return slot<<32 | next, keys
+		}
+		slot, pos = slot+1, 0
+	}
+
+	if slot >= uint64(mv2.len) {
+		return 0, keys
+	}
+	return slot << 32, keys
+}
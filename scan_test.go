@@ -0,0 +1,239 @@
+package cmap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scanAll runs a full scan, f is called between calls
+func scanAll(scan func(uint64, string, int) (uint64, []string), match string, count int, f func()) map[string]int {
+	var seen = make(map[string]int)
+	var cursor uint64
+	for {
+		next, keys := scan(cursor, match, count)
+		for _, k := range keys {
+			seen[k]++
+		}
+		if next == 0 {
+			return seen
+		}
+		cursor = next
+		if f != nil {
+			f()
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 100; i++ {
+		m.Set("user:"+strconv.Itoa(i), i)
+	}
+	m.Set("order:1", 1)
+	m.SetTTL("user:expired", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	var calls int
+	seen := scanAll(m.Scan, "user:*", 7, func() { calls++ })
+	if len(seen) != 100 {
+		t.Fatalf("want 100 keys but got %d", len(seen))
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Fatalf("key %s returned %d times", k, n)
+		}
+	}
+	// a call may overrun count by the rest of its last bucket
+	if calls < 100/(2*7) {
+		t.Fatalf("scan should be incremental, got %d calls", calls)
+	}
+
+	if next, keys := NewMap().Scan(0, "", 10); next != 0 || len(keys) != 0 {
+		t.Fatalf("scan of empty map should end at once, got %d %v", next, keys)
+	}
+}
+
+func TestScanAcrossModes(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 200; i++ {
+		m.Set("stable:"+strconv.Itoa(i), i)
+	}
+
+	var round int
+	seen := scanAll(m.Scan, "", 5, func() {
+		// switch modes, add and delete other keys between calls
+		round++
+		switch round % 3 {
+		case 0:
+			m.setBusy()
+		case 1:
+			m.setFree1()
+		case 2:
+			m.setFree2()
+		}
+		m.Set("tmp:"+strconv.Itoa(round), round)
+		m.Delete("tmp:" + strconv.Itoa(round-1))
+	})
+	m.setFree2()
+
+	for i := 0; i < 200; i++ {
+		if seen["stable:"+strconv.Itoa(i)] == 0 {
+			t.Fatalf("stable:%d is missed", i)
+		}
+	}
+}
+
+func TestScanDuringClearExpireKeys(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 500; i++ {
+		m.Set("stable:"+strconv.Itoa(i), i)
+		m.SetTTL("volatile:"+strconv.Itoa(i), i, time.Millisecond)
+	}
+
+	var stop = make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.ClearExpireKeys()
+			}
+		}
+	}()
+
+	seen := scanAll(m.Scan, "stable:*", 3, nil)
+	close(stop)
+	wg.Wait()
+
+	if len(seen) != 500 {
+		t.Fatalf("want 500 keys but got %d", len(seen))
+	}
+}
+
+func TestMapV2Scan(t *testing.T) {
+	mv2 := NewMapV2(nil, 16, time.Minute)
+	defer mv2.Clear()
+
+	for i := 0; i < 300; i++ {
+		mv2.Set("user:"+strconv.Itoa(i), i)
+	}
+	mv2.Set("order:1", 1)
+
+	seen := scanAll(mv2.Scan, "user:*", 10, func() {
+		mv2.Delete("order:1")
+	})
+	if len(seen) != 300 {
+		t.Fatalf("want 300 keys but got %d", len(seen))
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Fatalf("key %s returned %d times", k, n)
+		}
+	}
+
+	if next, keys := mv2.Scan(uint64(16)<<32, "", 10); next != 0 || len(keys) != 0 {
+		t.Fatalf("cursor out of slots should end, got %d %v", next, keys)
+	}
+}
+
+func TestScanIsBoundedPerCall(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 100000; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	// buckets hold about one key, a call returns about count keys however large the map is
+	var cursor uint64
+	for i := 0; i < 100; i++ {
+		next, keys := m.Scan(cursor, "", 10)
+		if len(keys) > 40 {
+			t.Fatalf("want about 10 keys per call but got %d", len(keys))
+		}
+		cursor = next
+	}
+}
+
+func TestScanWhileResizing(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 300; i++ {
+		m.Set("stable:"+strconv.Itoa(i), i)
+	}
+
+	// grow buckets during the scan, then shrink them
+	var round int
+	seen := scanAll(m.Scan, "stable:*", 5, func() {
+		round++
+		for i := 0; i < 50; i++ {
+			k := "tmp:" + strconv.Itoa(round*50+i)
+			if round < 20 {
+				m.Set(k, i)
+			} else {
+				m.Delete("tmp:" + strconv.Itoa((round-20)*50+i))
+			}
+		}
+	})
+	if len(seen) != 300 {
+		t.Fatalf("want 300 keys but got %d", len(seen))
+	}
+}
+
+func TestScanIndexShrinks(t *testing.T) {
+	m := NewMap()
+	m.Scan(0, "", 10)
+	for i := 0; i < 1000; i++ {
+		m.Set(strconv.Itoa(i), i)
+		m.SetTTL("volatile:"+strconv.Itoa(i), i, time.Millisecond)
+	}
+	for i := 10; i < 1000; i++ {
+		m.Delete(strconv.Itoa(i))
+	}
+	time.Sleep(2 * time.Millisecond)
+	m.ClearExpireKeys()
+
+	x := m.scans.Load()
+	x.l.RLock()
+	n, buckets := x.n, len(x.buckets)
+	x.l.RUnlock()
+	if n != 10 || buckets > 4*scanIndexMinBuckets {
+		t.Fatalf("index should shrink to 10 keys, got %d keys in %d buckets", n, buckets)
+	}
+	if seen := scanAll(m.Scan, "", 10, nil); len(seen) != 10 {
+		t.Fatalf("want 10 keys but got %d", len(seen))
+	}
+}
+
+func TestScanIndexIsLazy(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	if m.scans.Load() != nil {
+		t.Fatalf("index should not be built before Scan")
+	}
+	if seen := scanAll(m.Scan, "", 10, nil); len(seen) != 100 {
+		t.Fatalf("want 100 keys but got %d", len(seen))
+	}
+
+	// deleted keys leave the index without Scan or ClearExpireKeys
+	for i := 0; i < 1000; i++ {
+		key := "churn:" + strconv.Itoa(i)
+		m.Set(key, i)
+		m.Delete(key)
+	}
+	m.Update("updated", func(old interface{}, exists bool) (interface{}, bool) { return 1, true })
+	m.Update("updated", func(old interface{}, exists bool) (interface{}, bool) { return nil, false })
+
+	x := m.scans.Load()
+	x.l.RLock()
+	n := x.n
+	x.l.RUnlock()
+	if n != 100 {
+		t.Fatalf("want 100 keys indexed but got %d", n)
+	}
+}
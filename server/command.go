@@ -0,0 +1,908 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fwhezfwhez/cmap"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// compatible redis version reported by HELLO and INFO, clients decide features by it
+const redisVersion = "7.0.0"
+
+// command is a handler with arity like redis, positive arity is the exact number of arguments including command name,
+// negative arity is the minimal number.
+type command struct {
+	arity int
+	f     func(c *conn, args [][]byte) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"ping":    {-1, ping},
+		"echo":    {2, echo},
+		"quit":    {1, quit},
+		"hello":   {-1, hello},
+		"info":    {-1, info},
+		"command": {-1, commandCmd},
+		"client":  {-2, client},
+		"select":  {2, selectCmd},
+		"dbsize":  {1, dbsize},
+
+		"get":     {2, get},
+		"set":     {-3, set},
+		"getset":  {3, getset},
+		"getdel":  {2, getdel},
+		"getex":   {-2, getex},
+		"del":     {-2, del},
+		"exists":  {-2, exists},
+		"type":    {2, typeCmd},
+		"incr":    {2, incr},
+		"decr":    {2, decr},
+		"incrby":  {3, incrby},
+		"decrby":  {3, decrby},
+		"expire":  {3, expire},
+		"pexpire": {3, pexpire},
+		"persist": {2, persist},
+		"ttl":     {2, ttl},
+		"pttl":    {2, pttl},
+
+		"rpush":  {-3, rpush},
+		"lpop":   {-2, lpop},
+		"lrange": {4, lrange},
+		"llen":   {2, llen},
+
+		"keys": {2, keys},
+		"scan": {-2, scan},
+
+		"subscribe":    {-2, subscribe},
+		"psubscribe":   {-2, psubscribe},
+		"unsubscribe":  {-1, unsubscribe},
+		"punsubscribe": {-1, punsubscribe},
+		"publish":      {3, publish},
+		"pubsub":       {-2, pubsubCmd},
+	}
+}
+
+// commands allowed in RESP2 subscribed state
+var subscribedCommands = map[string]bool{
+	"subscribe":    true,
+	"psubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+}
+
+// commands that might block, they are run without holding conn.wl, see conn.execBlocking
+var blockingCommands = map[string]bool{
+	"publish": true,
+}
+
+const (
+	errSyntax     = "ERR syntax error"
+	errNotInteger = "ERR value is not an integer or out of range"
+	errWrongType  = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	errOverflow   = "ERR increment or decrement would overflow"
+)
+
+func (c *conn) exec(args [][]byte) error {
+	name := strings.ToLower(string(args[0]))
+
+	cmd, ok := commands[name]
+	if !ok {
+		var b strings.Builder
+		for _, arg := range args[1:] {
+			fmt.Fprintf(&b, "'%s' ", arg)
+		}
+		c.w.writeError(fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", args[0], b.String()))
+		return nil
+	}
+
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		c.w.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return nil
+	}
+	if c.subscribed() && c.w.proto == 2 && !subscribedCommands[name] {
+		c.w.writeError(fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", name))
+		return nil
+	}
+	if blockingCommands[name] {
+		return c.execBlocking(cmd, args)
+	}
+	return cmd.f(c, args)
+}
+
+// execBlocking runs cmd releasing c.wl, so messages are still forwarded to c while cmd blocks,
+// a PUBLISH blocked by cmap.PUBSUB_BLOCK might be waiting for c itself.
+// Replies of cmd are buffered, and written after c.wl is held again.
+func (c *conn) execBlocking(cmd command, args [][]byte) error {
+	var buf bytes.Buffer
+	w := c.w
+	c.w = newWriter(&buf)
+	c.w.proto = w.proto
+
+	c.wl.Unlock()
+	e := cmd.f(c, args)
+	c.w.flush()
+	c.wl.Lock()
+
+	c.w = w
+	c.w.w.Write(buf.Bytes())
+	return e
+}
+
+// valueOf converts an argument to value saved in map, canonical integers are saved as int64 so that INCR works
+func valueOf(arg []byte) interface{} {
+	s := string(arg)
+	if n, e := strconv.ParseInt(s, 10, 64); e == nil && strconv.FormatInt(n, 10) == s {
+		return n
+	}
+	return s
+}
+
+// bulkOf formats a value saved in map as bulk string
+func bulkOf(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	return fmt.Sprint(v)
+}
+
+func parseInt(arg []byte) (int64, bool) {
+	n, e := strconv.ParseInt(string(arg), 10, 64)
+	return n, e == nil
+}
+
+func ping(c *conn, args [][]byte) error {
+	if len(args) > 2 {
+		c.w.writeError("ERR wrong number of arguments for 'ping' command")
+		return nil
+	}
+	// in RESP2 subscribed state, pong is replied as a message
+	if c.subscribed() && c.w.proto == 2 {
+		c.w.writeArray(2)
+		c.w.writeBulkString("pong")
+		if len(args) == 2 {
+			c.w.writeBulk(args[1])
+		} else {
+			c.w.writeBulkString("")
+		}
+		return nil
+	}
+	if len(args) == 2 {
+		c.w.writeBulk(args[1])
+		return nil
+	}
+	c.w.writeSimple("PONG")
+	return nil
+}
+
+func echo(c *conn, args [][]byte) error {
+	c.w.writeBulk(args[1])
+	return nil
+}
+
+func quit(c *conn, args [][]byte) error {
+	c.w.writeOK()
+	return errClosed
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func hello(c *conn, args [][]byte) error {
+	proto := c.w.proto
+	if len(args) > 1 {
+		n, ok := parseInt(args[1])
+		if !ok {
+			c.w.writeError("ERR Protocol version is not an integer or out of range")
+			return nil
+		}
+		if n != 2 && n != 3 {
+			c.w.writeError("NOPROTO unsupported protocol version")
+			return nil
+		}
+		proto = int(n)
+	}
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "auth":
+			// no authentication, any credential is accepted
+			if i+2 >= len(args) {
+				c.w.writeError(errSyntax)
+				return nil
+			}
+			i += 2
+		case "setname":
+			if i+1 >= len(args) {
+				c.w.writeError(errSyntax)
+				return nil
+			}
+			c.name = string(args[i+1])
+			i++
+		default:
+			c.w.writeError(errSyntax)
+			return nil
+		}
+	}
+
+	c.w.proto = proto
+	c.w.writeMap(7)
+	c.w.writeBulkString("server")
+	c.w.writeBulkString("redis")
+	c.w.writeBulkString("version")
+	c.w.writeBulkString(redisVersion)
+	c.w.writeBulkString("proto")
+	c.w.writeInt(int64(proto))
+	c.w.writeBulkString("id")
+	c.w.writeInt(c.id)
+	c.w.writeBulkString("mode")
+	c.w.writeBulkString("standalone")
+	c.w.writeBulkString("role")
+	c.w.writeBulkString("master")
+	c.w.writeBulkString("modules")
+	c.w.writeArray(0)
+	return nil
+}
+
+func info(c *conn, args [][]byte) error {
+	s := c.s
+
+	var b strings.Builder
+	b.WriteString("# Server\r\n")
+	fmt.Fprintf(&b, "redis_version:%s\r\n", redisVersion)
+	b.WriteString("redis_mode:standalone\r\n")
+	fmt.Fprintf(&b, "go_version:%s\r\n", runtime.Version())
+	fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int64(time.Since(s.startAt).Seconds()))
+	b.WriteString("\r\n# Clients\r\n")
+	fmt.Fprintf(&b, "connected_clients:%d\r\n", s.connected())
+	fmt.Fprintf(&b, "maxclients:%d\r\n", s.maxConns)
+	b.WriteString("\r\n# Stats\r\n")
+	fmt.Fprintf(&b, "total_connections_received:%d\r\n", atomic.LoadInt64(&s.totalConns))
+	fmt.Fprintf(&b, "total_commands_processed:%d\r\n", atomic.LoadInt64(&s.totalCommands))
+	fmt.Fprintf(&b, "rejected_connections:%d\r\n", atomic.LoadInt64(&s.rejectedConns))
+	b.WriteString("\r\n# Keyspace\r\n")
+	fmt.Fprintf(&b, "db0:keys=%d\r\n", s.m.Len())
+
+	c.w.writeBulkString(b.String())
+	return nil
+}
+
+// COMMAND is sent by redis-cli on start, an empty reply makes it skip command hints
+func commandCmd(c *conn, args [][]byte) error {
+	c.w.writeArray(0)
+	return nil
+}
+
+func client(c *conn, args [][]byte) error {
+	switch strings.ToLower(string(args[1])) {
+	case "setname":
+		if len(args) != 3 {
+			c.w.writeError("ERR wrong number of arguments for 'client|setname' command")
+			return nil
+		}
+		c.name = string(args[2])
+		c.w.writeOK()
+	case "getname":
+		if c.name == "" {
+			c.w.writeNull()
+			return nil
+		}
+		c.w.writeBulkString(c.name)
+	case "id":
+		c.w.writeInt(c.id)
+	case "setinfo":
+		c.w.writeOK()
+	default:
+		c.w.writeError(fmt.Sprintf("ERR unknown subcommand '%s'", args[1]))
+	}
+	return nil
+}
+
+func selectCmd(c *conn, args [][]byte) error {
+	if string(args[1]) != "0" {
+		c.w.writeError("ERR DB index is out of range")
+		return nil
+	}
+	c.w.writeOK()
+	return nil
+}
+
+func dbsize(c *conn, args [][]byte) error {
+	c.w.writeInt(int64(c.s.m.Count()))
+	return nil
+}
+
+func get(c *conn, args [][]byte) error {
+	v, ok := c.s.m.Get(string(args[1]))
+	if !ok {
+		c.w.writeNull()
+		return nil
+	}
+	if cmap.TypeOf(v) != cmap.TYPE_STRING {
+		c.w.writeError(errWrongType)
+		return nil
+	}
+	c.w.writeBulkString(bulkOf(v))
+	return nil
+}
+
+// SET key value [NX] [EX seconds | PX milliseconds]
+func set(c *conn, args [][]byte) error {
+	key := string(args[1])
+	value := valueOf(args[2])
+
+	var nx bool
+	var ttl time.Duration = -1
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			nx = true
+		case "ex", "px":
+			if ttl != -1 || i+1 >= len(args) {
+				c.w.writeError(errSyntax)
+				return nil
+			}
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				c.w.writeError(errNotInteger)
+				return nil
+			}
+			if n <= 0 {
+				c.w.writeError("ERR invalid expire time in 'set' command")
+				return nil
+			}
+			unit := time.Second
+			if strings.ToLower(string(args[i])) == "px" {
+				unit = time.Millisecond
+			}
+			ttl = time.Duration(n) * unit
+			i++
+		default:
+			c.w.writeError(errSyntax)
+			return nil
+		}
+	}
+
+	if nx {
+		if !c.s.m.SetNxTTL(key, value, ttl) {
+			c.w.writeNull()
+			return nil
+		}
+		c.w.writeOK()
+		return nil
+	}
+
+	if ttl == -1 {
+		c.s.m.Set(key, value)
+	} else {
+		c.s.m.SetTTL(key, value, ttl)
+	}
+	c.w.writeOK()
+	return nil
+}
+
+func getset(c *conn, args [][]byte) error {
+	old, ok, e := c.s.m.GetSetString(string(args[1]), valueOf(args[2]))
+	if e != nil {
+		c.w.writeError(errWrongType)
+		return nil
+	}
+	if !ok {
+		c.w.writeNull()
+		return nil
+	}
+	c.w.writeBulkString(bulkOf(old))
+	return nil
+}
+
+func getdel(c *conn, args [][]byte) error {
+	v, ok, e := c.s.m.GetDelString(string(args[1]))
+	if e != nil {
+		c.w.writeError(errWrongType)
+		return nil
+	}
+	if !ok {
+		c.w.writeNull()
+		return nil
+	}
+	c.w.writeBulkString(bulkOf(v))
+	return nil
+}
+
+// GETEX key [EX seconds | PX milliseconds | PERSIST]
+func getex(c *conn, args [][]byte) error {
+	key := string(args[1])
+
+	var ttl time.Duration
+	var set bool
+	switch {
+	case len(args) == 2:
+	case len(args) == 3 && strings.ToLower(string(args[2])) == "persist":
+		ttl, set = -1, true
+	case len(args) == 4 && (strings.ToLower(string(args[2])) == "ex" || strings.ToLower(string(args[2])) == "px"):
+		n, ok := parseInt(args[3])
+		if !ok {
+			c.w.writeError(errNotInteger)
+			return nil
+		}
+		if n <= 0 {
+			c.w.writeError("ERR invalid expire time in 'getex' command")
+			return nil
+		}
+		unit := time.Second
+		if strings.ToLower(string(args[2])) == "px" {
+			unit = time.Millisecond
+		}
+		ttl, set = time.Duration(n)*unit, true
+	default:
+		c.w.writeError(errSyntax)
+		return nil
+	}
+
+	if !set {
+		return get(c, args[:2])
+	}
+	v, ok, e := c.s.m.GetExString(key, ttl)
+	if e != nil {
+		c.w.writeError(errWrongType)
+		return nil
+	}
+	if !ok {
+		c.w.writeNull()
+		return nil
+	}
+	c.w.writeBulkString(bulkOf(v))
+	return nil
+}
+
+func del(c *conn, args [][]byte) error {
+	var n int64
+	for _, arg := range args[1:] {
+		if _, ok := c.s.m.GetDel(string(arg)); ok {
+			n++
+		}
+	}
+	c.w.writeInt(n)
+	return nil
+}
+
+func exists(c *conn, args [][]byte) error {
+	var n int64
+	for _, arg := range args[1:] {
+		if _, ok := c.s.m.Get(string(arg)); ok {
+			n++
+		}
+	}
+	c.w.writeInt(n)
+	return nil
+}
+
+func typeCmd(c *conn, args [][]byte) error {
+	c.w.writeSimple(c.s.m.Type(string(args[1])))
+	return nil
+}
+
+func incrBy(c *conn, key string, delta int64) {
+	n, e := c.s.m.IncrByInt64(key, delta)
+	switch {
+	case e == nil:
+		c.w.writeInt(n)
+	case e == cmap.ErrNotInteger:
+		c.w.writeError(errNotInteger)
+	case e == cmap.ErrIncrOverflow:
+		c.w.writeError(errOverflow)
+	default:
+		c.w.writeError(errWrongType)
+	}
+}
+
+func incr(c *conn, args [][]byte) error {
+	incrBy(c, string(args[1]), 1)
+	return nil
+}
+
+func decr(c *conn, args [][]byte) error {
+	incrBy(c, string(args[1]), -1)
+	return nil
+}
+
+func incrby(c *conn, args [][]byte) error {
+	delta, ok := parseInt(args[2])
+	if !ok {
+		c.w.writeError(errNotInteger)
+		return nil
+	}
+	incrBy(c, string(args[1]), delta)
+	return nil
+}
+
+func decrby(c *conn, args [][]byte) error {
+	delta, ok := parseInt(args[2])
+	if !ok {
+		c.w.writeError(errNotInteger)
+		return nil
+	}
+	if delta == math.MinInt64 {
+		c.w.writeError("ERR decrement would overflow")
+		return nil
+	}
+	incrBy(c, string(args[1]), -delta)
+	return nil
+}
+
+func expire(c *conn, args [][]byte) error {
+	n, ok := parseInt(args[2])
+	if !ok {
+		c.w.writeError(errNotInteger)
+		return nil
+	}
+	if c.s.m.Expire(string(args[1]), int(n)) {
+		c.w.writeInt(1)
+		return nil
+	}
+	c.w.writeInt(0)
+	return nil
+}
+
+func pexpire(c *conn, args [][]byte) error {
+	n, ok := parseInt(args[2])
+	if !ok {
+		c.w.writeError(errNotInteger)
+		return nil
+	}
+	if c.s.m.PExpire(string(args[1]), n) {
+		c.w.writeInt(1)
+		return nil
+	}
+	c.w.writeInt(0)
+	return nil
+}
+
+func persist(c *conn, args [][]byte) error {
+	if c.s.m.Persist(string(args[1])) {
+		c.w.writeInt(1)
+		return nil
+	}
+	c.w.writeInt(0)
+	return nil
+}
+
+func ttl(c *conn, args [][]byte) error {
+	c.w.writeInt(c.s.m.TTL(string(args[1])))
+	return nil
+}
+
+func pttl(c *conn, args [][]byte) error {
+	c.w.writeInt(c.s.m.PTTL(string(args[1])))
+	return nil
+}
+
+func rpush(c *conn, args [][]byte) error {
+	var elems = make([]interface{}, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		elems = append(elems, valueOf(arg))
+	}
+
+	n, e := c.s.m.RPush(string(args[1]), elems...)
+	if e != nil {
+		c.w.writeError(errWrongType)
+		return nil
+	}
+	c.w.writeInt(int64(n))
+	return nil
+}
+
+// LPOP key [count]
+func lpop(c *conn, args [][]byte) error {
+	key := string(args[1])
+
+	if len(args) > 3 {
+		c.w.writeError(errSyntax)
+		return nil
+	}
+	if len(args) == 2 {
+		elem, ok, e := c.s.m.LPop(key)
+		if e != nil {
+			c.w.writeError(errWrongType)
+			return nil
+		}
+		if !ok {
+			c.w.writeNull()
+			return nil
+		}
+		c.w.writeBulkString(bulkOf(elem))
+		return nil
+	}
+
+	count, ok := parseInt(args[2])
+	if !ok || count < 0 {
+		c.w.writeError("ERR value is out of range, must be positive")
+		return nil
+	}
+
+	elems, exist, e := c.s.m.LPopN(key, int(count))
+	if e != nil {
+		c.w.writeError(errWrongType)
+		return nil
+	}
+	if !exist {
+		c.w.writeNullArray()
+		return nil
+	}
+	c.w.writeArray(len(elems))
+	for _, elem := range elems {
+		c.w.writeBulkString(bulkOf(elem))
+	}
+	return nil
+}
+
+func lrange(c *conn, args [][]byte) error {
+	start, ok1 := parseInt(args[2])
+	stop, ok2 := parseInt(args[3])
+	if !ok1 || !ok2 {
+		c.w.writeError(errNotInteger)
+		return nil
+	}
+
+	elems, e := c.s.m.LRange(string(args[1]), int(start), int(stop))
+	if e != nil {
+		c.w.writeError(errWrongType)
+		return nil
+	}
+	c.w.writeArray(len(elems))
+	for _, elem := range elems {
+		c.w.writeBulkString(bulkOf(elem))
+	}
+	return nil
+}
+
+func llen(c *conn, args [][]byte) error {
+	n, e := c.s.m.LLen(string(args[1]))
+	if e != nil {
+		c.w.writeError(errWrongType)
+		return nil
+	}
+	c.w.writeInt(int64(n))
+	return nil
+}
+
+func keys(c *conn, args [][]byte) error {
+	ks := c.s.m.Keys(string(args[1]))
+	c.w.writeArray(len(ks))
+	for _, k := range ks {
+		c.w.writeBulkString(k)
+	}
+	return nil
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+// It iterates keys incrementally by MapV2.Scan, TYPE is filtered after keys are returned like redis.
+func scan(c *conn, args [][]byte) error {
+	cursor, e := strconv.ParseUint(string(args[1]), 10, 64)
+	if e != nil {
+		c.w.writeError("ERR invalid cursor")
+		return nil
+	}
+
+	var pattern, typ string
+	var count = 10
+	for i := 2; i < len(args); i++ {
+		if i+1 >= len(args) {
+			c.w.writeError(errSyntax)
+			return nil
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = string(args[i+1])
+		case "count":
+			n, ok := parseInt(args[i+1])
+			if !ok || n < 1 {
+				c.w.writeError(errSyntax)
+				return nil
+			}
+			count = int(n)
+		case "type":
+			typ = strings.ToLower(string(args[i+1]))
+		default:
+			c.w.writeError(errSyntax)
+			return nil
+		}
+		i++
+	}
+
+	next, keys := c.s.m.Scan(cursor, pattern, count)
+	var ks = make([]string, 0, len(keys))
+	for _, k := range keys {
+		if typ != "" && c.s.m.Type(k) != typ {
+			continue
+		}
+		ks = append(ks, k)
+	}
+
+	c.w.writeArray(2)
+	c.w.writeBulkString(strconv.FormatUint(next, 10))
+	c.w.writeArray(len(ks))
+	for _, k := range ks {
+		c.w.writeBulkString(k)
+	}
+	return nil
+}
+
+// subscription returns subscription of c, it's made on first SUBSCRIBE or PSUBSCRIBE.
+// Messages are forwarded to client by a goroutine until connection is closed.
+func (c *conn) subscription() *cmap.Subscription {
+	if c.sub != nil {
+		return c.sub
+	}
+
+	c.sub = c.s.m.Subscribe(nil)
+	// w is kept, c.w is replaced while a blocking command runs
+	go func(sub *cmap.Subscription, w *writer) {
+		for msg := range sub.Messages() {
+			c.wl.Lock()
+			if msg.Pattern != "" {
+				w.writePush(4)
+				w.writeBulkString("pmessage")
+				w.writeBulkString(msg.Pattern)
+			} else {
+				w.writePush(3)
+				w.writeBulkString("message")
+			}
+			w.writeBulkString(msg.Channel)
+			w.writeBulkString(bulkOf(msg.Payload))
+			w.flush()
+			c.wl.Unlock()
+		}
+	}(c.sub, c.w)
+	return c.sub
+}
+
+// subscribed tells whether c subscribes any channel or pattern
+func (c *conn) subscribed() bool {
+	return c.sub != nil && c.sub.Count() > 0
+}
+
+// writeSubscribed writes a reply of (P)SUBSCRIBE and (P)UNSUBSCRIBE for a channel or pattern
+func (c *conn) writeSubscribed(kind string, name []byte, count int) {
+	c.w.writePush(3)
+	c.w.writeBulkString(kind)
+	if name == nil {
+		c.w.writeNull()
+	} else {
+		c.w.writeBulk(name)
+	}
+	c.w.writeInt(int64(count))
+}
+
+// SUBSCRIBE channel [channel ...]
+func subscribe(c *conn, args [][]byte) error {
+	sub := c.subscription()
+	for _, channel := range args[1:] {
+		sub.Subscribe(string(channel))
+		c.writeSubscribed("subscribe", channel, sub.Count())
+	}
+	return nil
+}
+
+// PSUBSCRIBE pattern [pattern ...]
+func psubscribe(c *conn, args [][]byte) error {
+	sub := c.subscription()
+	for _, pattern := range args[1:] {
+		sub.PSubscribe(string(pattern))
+		c.writeSubscribed("psubscribe", pattern, sub.Count())
+	}
+	return nil
+}
+
+// UNSUBSCRIBE [channel [channel ...]], all channels are unsubscribed if none is given
+func unsubscribe(c *conn, args [][]byte) error {
+	return unsubscribeOf(c, args, false)
+}
+
+// PUNSUBSCRIBE [pattern [pattern ...]], all patterns are unsubscribed if none is given
+func punsubscribe(c *conn, args [][]byte) error {
+	return unsubscribeOf(c, args, true)
+}
+
+func unsubscribeOf(c *conn, args [][]byte, pattern bool) error {
+	kind := "unsubscribe"
+	if pattern {
+		kind = "punsubscribe"
+	}
+
+	var names = args[1:]
+	if len(names) == 0 && c.sub != nil {
+		var subscribed = c.sub.Channels()
+		if pattern {
+			subscribed = c.sub.Patterns()
+		}
+		for _, name := range subscribed {
+			names = append(names, []byte(name))
+		}
+	}
+	if len(names) == 0 {
+		var count int
+		if c.sub != nil {
+			count = c.sub.Count()
+		}
+		c.writeSubscribed(kind, nil, count)
+		return nil
+	}
+
+	sub := c.subscription()
+	for _, name := range names {
+		if pattern {
+			sub.PUnsubscribe(string(name))
+		} else {
+			sub.Unsubscribe(string(name))
+		}
+		c.writeSubscribed(kind, name, sub.Count())
+	}
+	return nil
+}
+
+// PUBLISH channel message
+func publish(c *conn, args [][]byte) error {
+	n := c.s.m.Publish(string(args[1]), string(args[2]))
+	c.w.writeInt(int64(n))
+	return nil
+}
+
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func pubsubCmd(c *conn, args [][]byte) error {
+	switch strings.ToLower(string(args[1])) {
+	case "channels":
+		if len(args) > 3 {
+			c.w.writeError("ERR wrong number of arguments for 'pubsub|channels' command")
+			return nil
+		}
+		var pattern string
+		if len(args) == 3 {
+			pattern = string(args[2])
+		}
+		channels := c.s.m.PubSubChannels(pattern)
+		c.w.writeArray(len(channels))
+		for _, channel := range channels {
+			c.w.writeBulkString(channel)
+		}
+	case "numsub":
+		var channels = make([]string, 0, len(args)-2)
+		for _, arg := range args[2:] {
+			channels = append(channels, string(arg))
+		}
+		counts := c.s.m.PubSubNumSub(channels...)
+		c.w.writeArray(2 * len(channels))
+		for _, channel := range channels {
+			c.w.writeBulkString(channel)
+			c.w.writeInt(int64(counts[channel]))
+		}
+	case "numpat":
+		if len(args) != 2 {
+			c.w.writeError("ERR wrong number of arguments for 'pubsub|numpat' command")
+			return nil
+		}
+		c.w.writeInt(int64(c.s.m.PubSubNumPat()))
+	default:
+		c.w.writeError(fmt.Sprintf("ERR unknown subcommand '%s'", args[1]))
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+const (
+	// the same limits as redis proto-max-bulk-len and max multibulk length
+	maxBulkLen  = 512 * 1024 * 1024
+	maxArrayLen = 1024 * 1024
+	maxInline   = 64 * 1024
+)
+
+// errProtocol is returned by reader when client breaks the protocol, connection should be closed after replying it
+type errProtocol struct {
+	msg string
+}
+
+func (e errProtocol) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+// reader reads commands in RESP array form, or inline form like `SET a 1` typed in telnet
+type reader struct {
+	r *bufio.Reader
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{r: bufio.NewReader(r)}
+}
+
+// buffered tells whether more commands are pipelined
+func (r *reader) buffered() bool {
+	return r.r.Buffered() > 0
+}
+
+func (r *reader) readLine() ([]byte, error) {
+	line, e := r.r.ReadSlice('\n')
+	if e == bufio.ErrBufferFull {
+		return nil, errProtocol{msg: "too big inline request"}
+	}
+	if e != nil {
+		return nil, e
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		// inline commands from telnet may end with only \n
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+	return line[:len(line)-2], nil
+}
+
+// readCommand returns arguments of a command, empty lines return no argument
+func (r *reader) readCommand() ([][]byte, error) {
+	b, e := r.r.Peek(1)
+	if e != nil {
+		return nil, e
+	}
+	if b[0] != '*' {
+		return r.readInline()
+	}
+
+	line, e := r.readLine()
+	if e != nil {
+		return nil, e
+	}
+	n, e := strconv.Atoi(string(line[1:]))
+	if e != nil || n > maxArrayLen {
+		return nil, errProtocol{msg: "invalid multibulk length"}
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	var args = make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		line, e := r.readLine()
+		if e != nil {
+			return nil, e
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol{msg: "expected '$', got '" + string(line) + "'"}
+		}
+		size, e := strconv.Atoi(string(line[1:]))
+		if e != nil || size < 0 || size > maxBulkLen {
+			return nil, errProtocol{msg: "invalid bulk length"}
+		}
+
+		arg := make([]byte, size+2)
+		if _, e := io.ReadFull(r.r, arg); e != nil {
+			return nil, e
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, errProtocol{msg: "bulk string not ended with CRLF"}
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+func (r *reader) readInline() ([][]byte, error) {
+	line, e := r.readLine()
+	if e != nil {
+		return nil, e
+	}
+	if len(line) > maxInline {
+		return nil, errProtocol{msg: "too big inline request"}
+	}
+
+	fields := bytes.Fields(line)
+	var args = make([][]byte, 0, len(fields))
+	for _, f := range fields {
+		// line is reused by bufio
+		args = append(args, append([]byte(nil), f...))
+	}
+	return args, nil
+}
+
+// writer writes replies of RESP2 or RESP3
+type writer struct {
+	w     *bufio.Writer
+	proto int
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{w: bufio.NewWriter(w), proto: 2}
+}
+
+func (w *writer) flush() error {
+	return w.w.Flush()
+}
+
+func (w *writer) writeHead(prefix byte, n int64) {
+	w.w.WriteByte(prefix)
+	w.w.WriteString(strconv.FormatInt(n, 10))
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) writeSimple(s string) {
+	w.w.WriteByte('+')
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) writeOK() {
+	w.writeSimple("OK")
+}
+
+// writeError writes msg as an error, msg should start with an error code like ERR, WRONGTYPE
+func (w *writer) writeError(msg string) {
+	w.w.WriteByte('-')
+	w.w.WriteString(msg)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) writeInt(n int64) {
+	w.writeHead(':', n)
+}
+
+func (w *writer) writeBulk(b []byte) {
+	w.writeHead('$', int64(len(b)))
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) writeBulkString(s string) {
+	w.writeHead('$', int64(len(s)))
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) writeNull() {
+	if w.proto == 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+	w.w.WriteString("$-1\r\n")
+}
+
+func (w *writer) writeNullArray() {
+	if w.proto == 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+	w.w.WriteString("*-1\r\n")
+}
+
+func (w *writer) writeArray(n int) {
+	w.writeHead('*', int64(n))
+}
+
+// writePush writes head of an out-of-band message like pub/sub messages, in RESP2 it's an array
+func (w *writer) writePush(n int) {
+	if w.proto == 3 {
+		w.writeHead('>', int64(n))
+		return
+	}
+	w.writeHead('*', int64(n))
+}
+
+// writeMap writes head of a map of n pairs, in RESP2 it's an array of 2n elements
+func (w *writer) writeMap(n int) {
+	if w.proto == 3 {
+		w.writeHead('%', int64(n))
+		return
+	}
+	w.writeHead('*', int64(2*n))
+}
+
+var errClosed = errors.New("connection closed")
@@ -0,0 +1,272 @@
+// Package server exposes a cmap.MapV2 over redis RESP2/RESP3 protocol,
+// so redis-cli and ordinary redis clients can share an in-process cache.
+//
+//	m := cmap.NewMapV2(nil, 64, 30*time.Minute)
+//	s := server.New(m, server.WithMaxConns(1000))
+//	go s.ListenAndServe(":6380")
+//	...
+//	s.Shutdown(ctx)
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/fwhezfwhez/cmap"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Shutdown.
+var ErrServerClosed = errors.New("server: Server closed")
+
+// Option configures a Server when calling New.
+type Option func(*Server)
+
+// WithMaxConns caps the number of connections, clients beyond it are replied an error and closed.
+// 0 means no limit, which is the default.
+func WithMaxConns(n int) Option {
+	return func(s *Server) {
+		s.maxConns = n
+	}
+}
+
+// Server serves a cmap.MapV2 over RESP.
+type Server struct {
+	m *cmap.MapV2
+
+	maxConns int
+
+	l         *sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+	closing   bool
+	wg        *sync.WaitGroup
+
+	startAt time.Time
+	nextID  int64
+
+	// stats of INFO
+	totalConns    int64
+	totalCommands int64
+	rejectedConns int64
+}
+
+// New returns a server of m.
+func New(m *cmap.MapV2, opts ...Option) *Server {
+	s := &Server{
+		m:         m,
+		l:         &sync.Mutex{},
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*conn]struct{}),
+		wg:        &sync.WaitGroup{},
+		startAt:   time.Now(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ListenAndServe listens on tcp addr and serves connections.
+func (s *Server) ListenAndServe(addr string) error {
+	l, e := net.Listen("tcp", addr)
+	if e != nil {
+		return e
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections of l until Shutdown, it always returns a non-nil error.
+func (s *Server) Serve(l net.Listener) error {
+	s.l.Lock()
+	if s.closing {
+		s.l.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.l.Unlock()
+
+	defer func() {
+		s.l.Lock()
+		delete(s.listeners, l)
+		s.l.Unlock()
+		l.Close()
+	}()
+
+	var delay time.Duration
+	for {
+		nc, e := l.Accept()
+		if e != nil {
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			if ne, ok := e.(net.Error); ok && ne.Timeout() {
+				// back off like net/http does
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return e
+		}
+		delay = 0
+
+		s.accept(nc)
+	}
+}
+
+func (s *Server) isClosing() bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.closing
+}
+
+func (s *Server) accept(nc net.Conn) {
+	atomic.AddInt64(&s.totalConns, 1)
+
+	c := newConn(s, nc, atomic.AddInt64(&s.nextID, 1))
+
+	s.l.Lock()
+	if s.closing {
+		s.l.Unlock()
+		nc.Close()
+		return
+	}
+	if s.maxConns > 0 && len(s.conns) >= s.maxConns {
+		s.l.Unlock()
+		atomic.AddInt64(&s.rejectedConns, 1)
+
+		c.w.writeError("ERR max number of clients reached")
+		c.w.flush()
+		nc.Close()
+		return
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	s.l.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.l.Lock()
+			delete(s.conns, c)
+			s.l.Unlock()
+		}()
+
+		c.serve()
+	}()
+}
+
+// Shutdown stops accepting connections, lets connections finish commands being executed, and then closes them.
+// If ctx is done before that, remaining connections are closed forcibly and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.l.Lock()
+	s.closing = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	// interrupt connections waiting for next command, the one executing a command exits after replying it
+	for c := range s.conns {
+		c.nc.SetReadDeadline(time.Now())
+	}
+	s.l.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.l.Lock()
+		for c := range s.conns {
+			c.nc.Close()
+		}
+		s.l.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// connected returns number of connections
+func (s *Server) connected() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return len(s.conns)
+}
+
+type conn struct {
+	s  *Server
+	nc net.Conn
+	id int64
+
+	r *reader
+	w *writer
+	// serializes replies and messages forwarded to subscribers
+	wl *sync.Mutex
+
+	name string
+
+	// nil until SUBSCRIBE or PSUBSCRIBE
+	sub *cmap.Subscription
+}
+
+func newConn(s *Server, nc net.Conn, id int64) *conn {
+	return &conn{
+		s:  s,
+		nc: nc,
+		id: id,
+		r:  newReader(nc),
+		w:  newWriter(nc),
+		wl: &sync.Mutex{},
+	}
+}
+
+func (c *conn) serve() {
+	defer c.nc.Close()
+	defer func() {
+		if c.sub != nil {
+			c.sub.Close()
+		}
+	}()
+
+	for {
+		args, e := c.r.readCommand()
+		if e != nil {
+			if pe, ok := e.(errProtocol); ok {
+				c.w.writeError("ERR " + pe.Error())
+				c.w.flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		atomic.AddInt64(&c.s.totalCommands, 1)
+		c.wl.Lock()
+		if e := c.exec(args); e == errClosed {
+			c.w.flush()
+			c.wl.Unlock()
+			return
+		}
+
+		// flush once for pipelined commands
+		if !c.r.buffered() {
+			if e := c.w.flush(); e != nil {
+				c.wl.Unlock()
+				return
+			}
+		}
+		c.wl.Unlock()
+	}
+}
@@ -0,0 +1,478 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/fwhezfwhez/cmap"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient is a tiny RESP client for tests, replies are decoded as string, int64, nil, error or []interface{},
+// RESP3 maps and pushes are decoded as []interface{} too
+type testClient struct {
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	nc, e := net.Dial("tcp", addr)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return &testClient{nc: nc, r: bufio.NewReader(nc)}
+}
+
+func (c *testClient) send(args ...string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	c.nc.Write([]byte(b.String()))
+}
+
+func (c *testClient) read(t *testing.T) interface{} {
+	line, e := c.r.ReadString('\n')
+	if e != nil {
+		t.Fatal(e)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return fmt.Errorf("%s", line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '_':
+		return nil
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		if _, e := io.ReadFull(c.r, buf); e != nil {
+			t.Fatal(e)
+		}
+		return string(buf[:n])
+	case '*', '%', '>':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		if line[0] == '%' {
+			n *= 2
+		}
+		var arr = make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			arr = append(arr, c.read(t))
+		}
+		return arr
+	}
+	t.Fatalf("unknown reply %q", line)
+	return nil
+}
+
+func (c *testClient) do(t *testing.T, args ...string) interface{} {
+	c.send(args...)
+	return c.read(t)
+}
+
+func start(t *testing.T, opts ...Option) (*Server, *cmap.MapV2, string) {
+	m := cmap.NewMapV2(nil, 8, 5*time.Minute)
+	s := New(m, opts...)
+
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	go s.Serve(l)
+	return s, m, l.Addr().String()
+}
+
+func stop(s *Server, m *cmap.MapV2) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.Shutdown(ctx)
+	m.Clear()
+}
+
+func TestCommands(t *testing.T) {
+	s, m, addr := start(t)
+	defer stop(s, m)
+
+	c := dial(t, addr)
+	defer c.nc.Close()
+
+	var cases = []struct {
+		args []string
+		want interface{}
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"ping", "hi"}, "hi"},
+		{[]string{"SET", "name", "cmap"}, "OK"},
+		{[]string{"GET", "name"}, "cmap"},
+		{[]string{"GET", "missing"}, nil},
+		{[]string{"SET", "name", "other", "NX"}, nil},
+		{[]string{"SET", "nx", "1", "NX", "EX", "100"}, "OK"},
+		{[]string{"TTL", "nx"}, int64(100)},
+		{[]string{"SET", "px", "1", "PX", "100000"}, "OK"},
+		{[]string{"EXPIRE", "name", "50"}, int64(1)},
+		{[]string{"TTL", "name"}, int64(50)},
+		{[]string{"EXPIRE", "missing", "50"}, int64(0)},
+		{[]string{"TTL", "missing"}, int64(-2)},
+		{[]string{"SET", "count", "10"}, "OK"},
+		{[]string{"INCR", "count"}, int64(11)},
+		{[]string{"INCRBY", "count", "9"}, int64(20)},
+		{[]string{"DECRBY", "count", "5"}, int64(15)},
+		{[]string{"INCR", "new"}, int64(1)},
+		{[]string{"GET", "count"}, "15"},
+		{[]string{"INCR", "name"}, fmt.Errorf(errNotInteger)},
+		{[]string{"SET", "max", "9223372036854775806"}, "OK"},
+		{[]string{"INCR", "max"}, int64(math.MaxInt64)},
+		{[]string{"INCR", "max"}, fmt.Errorf(errOverflow)},
+		{[]string{"GET", "max"}, "9223372036854775807"},
+		{[]string{"DECRBY", "new", "-9223372036854775808"}, fmt.Errorf("ERR decrement would overflow")},
+		{[]string{"DEL", "count", "new", "missing"}, int64(2)},
+		{[]string{"GETSET", "gs", "1"}, nil},
+		{[]string{"GETSET", "gs", "2"}, "1"},
+		{[]string{"GETEX", "gs", "EX", "30"}, "2"},
+		{[]string{"TTL", "gs"}, int64(30)},
+		{[]string{"GETEX", "gs", "PERSIST"}, "2"},
+		{[]string{"TTL", "gs"}, int64(-1)},
+		{[]string{"GETEX", "gs", "EX"}, fmt.Errorf(errSyntax)},
+		{[]string{"GETDEL", "gs"}, "2"},
+		{[]string{"GETDEL", "gs"}, nil},
+		{[]string{"RPUSH", "list", "a", "b", "c"}, int64(3)},
+		{[]string{"LLEN", "list"}, int64(3)},
+		{[]string{"LRANGE", "list", "0", "-1"}, []interface{}{"a", "b", "c"}},
+		{[]string{"LPOP", "list"}, "a"},
+		{[]string{"LPOP", "list", "5"}, []interface{}{"b", "c"}},
+		{[]string{"LPOP", "list"}, nil},
+		{[]string{"LPOP", "list", "2"}, nil},
+		{[]string{"RPUSH", "name", "a"}, fmt.Errorf(errWrongType)},
+		{[]string{"RPUSH", "list2", "a"}, int64(1)},
+		{[]string{"GET", "list2"}, fmt.Errorf(errWrongType)},
+		{[]string{"GETDEL", "list2"}, fmt.Errorf(errWrongType)},
+		{[]string{"GETSET", "list2", "a"}, fmt.Errorf(errWrongType)},
+		{[]string{"GETEX", "list2"}, fmt.Errorf(errWrongType)},
+		{[]string{"GETEX", "list2", "EX", "30"}, fmt.Errorf(errWrongType)},
+		{[]string{"LLEN", "list2"}, int64(1)},
+		{[]string{"TTL", "list2"}, int64(-1)},
+		{[]string{"TYPE", "list2"}, "list"},
+		{[]string{"INCR", "list2"}, fmt.Errorf(errWrongType)},
+		{[]string{"LPOP", "name", "2"}, fmt.Errorf(errWrongType)},
+		{[]string{"GETX", "a"}, fmt.Errorf("ERR unknown command 'GETX', with args beginning with: 'a' ")},
+		{[]string{"GET"}, fmt.Errorf("ERR wrong number of arguments for 'get' command")},
+		{[]string{"SET", "a", "1", "EX", "0"}, fmt.Errorf("ERR invalid expire time in 'set' command")},
+	}
+
+	for _, cs := range cases {
+		got := c.do(t, cs.args...)
+		if fmt.Sprint(got) != fmt.Sprint(cs.want) {
+			t.Fatalf("%v: want %v but got %v", cs.args, cs.want, got)
+		}
+	}
+
+	// values set by clients are visible to go code
+	if v, _ := m.Get("nx"); v != int64(1) {
+		t.Fatalf("canonical integer should be saved as int64 but got %T", v)
+	}
+}
+
+func TestKeysAndScan(t *testing.T) {
+	s, m, addr := start(t)
+	defer stop(s, m)
+
+	for i := 0; i < 20; i++ {
+		m.Set("user:"+strconv.Itoa(i), i)
+	}
+	m.Set("order:1", 1)
+
+	c := dial(t, addr)
+	defer c.nc.Close()
+
+	ks := c.do(t, "KEYS", "user:1*").([]interface{})
+	var got []string
+	for _, k := range ks {
+		got = append(got, k.(string))
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != "user:1,user:10,user:11,user:12,user:13,user:14,user:15,user:16,user:17,user:18,user:19" {
+		t.Fatalf("unexpected keys %v", got)
+	}
+
+	var scanned = make(map[string]bool)
+	var cursor = "0"
+	var calls int
+	for {
+		reply := c.do(t, "SCAN", cursor, "MATCH", "user:*", "COUNT", "5").([]interface{})
+		for _, k := range reply[1].([]interface{}) {
+			scanned[k.(string)] = true
+		}
+		calls++
+		cursor = reply[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(scanned) != 20 || calls < 2 {
+		t.Fatalf("unexpected scan of %d keys in %d calls", len(scanned), calls)
+	}
+
+	if n := c.do(t, "DBSIZE"); n != int64(21) {
+		t.Fatalf("want 21 but got %v", n)
+	}
+}
+
+func TestRESP3(t *testing.T) {
+	s, m, addr := start(t)
+	defer stop(s, m)
+
+	c := dial(t, addr)
+	defer c.nc.Close()
+
+	reply := c.do(t, "HELLO", "3", "SETNAME", "test")
+	hello, ok := reply.([]interface{})
+	if !ok || hello[0] != "server" || hello[5] != int64(3) {
+		t.Fatalf("unexpected hello reply %v", reply)
+	}
+
+	// null is '_' in RESP3
+	c.send("GET", "missing")
+	line, _ := c.r.ReadString('\n')
+	if line != "_\r\n" {
+		t.Fatalf("want RESP3 null but got %q", line)
+	}
+
+	if e, ok := c.do(t, "HELLO", "4").(error); !ok || !strings.HasPrefix(e.Error(), "NOPROTO") {
+		t.Fatalf("want NOPROTO but got %v", e)
+	}
+}
+
+func TestPipelineAndInline(t *testing.T) {
+	s, m, addr := start(t)
+	defer stop(s, m)
+
+	c := dial(t, addr)
+	defer c.nc.Close()
+
+	// commands written at once are replied in order
+	c.nc.Write([]byte("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$4\r\nINCR\r\n$1\r\na\r\nGET a\r\n"))
+	if r := c.read(t); r != "OK" {
+		t.Fatalf("want OK but got %v", r)
+	}
+	if r := c.read(t); r != int64(2) {
+		t.Fatalf("want 2 but got %v", r)
+	}
+	if r := c.read(t); r != "2" {
+		t.Fatalf("want 2 but got %v", r)
+	}
+
+	// protocol error closes connection
+	c.nc.Write([]byte("*1\r\n+PING\r\n"))
+	if r, ok := c.read(t).(error); !ok || !strings.Contains(r.Error(), "Protocol error") {
+		t.Fatalf("want protocol error but got %v", r)
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	s, m, addr := start(t, WithMaxConns(1))
+	defer stop(s, m)
+
+	c1 := dial(t, addr)
+	defer c1.nc.Close()
+	if r := c1.do(t, "PING"); r != "PONG" {
+		t.Fatalf("want PONG but got %v", r)
+	}
+
+	c2 := dial(t, addr)
+	defer c2.nc.Close()
+	if r, ok := c2.read(t).(error); !ok || r.Error() != "ERR max number of clients reached" {
+		t.Fatalf("want max clients error but got %v", r)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	m := cmap.NewMapV2(nil, 8, 5*time.Minute)
+	defer m.Clear()
+	s := New(m)
+
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+
+	c := dial(t, l.Addr().String())
+	defer c.nc.Close()
+	if r := c.do(t, "SET", "a", "1"); r != "OK" {
+		t.Fatalf("want OK but got %v", r)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if e := s.Shutdown(ctx); e != nil {
+		t.Fatalf("idle connections should be closed gracefully, %v", e)
+	}
+	if e := <-served; e != ErrServerClosed {
+		t.Fatalf("want ErrServerClosed but got %v", e)
+	}
+
+	// connection is closed by server
+	c.nc.SetReadDeadline(time.Now().Add(time.Second))
+	if _, e := c.r.ReadByte(); e == nil {
+		t.Fatalf("connection should be closed")
+	}
+	if _, e := net.Dial("tcp", l.Addr().String()); e == nil {
+		t.Fatalf("listener should be closed")
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	s, m, addr := start(t)
+	defer stop(s, m)
+
+	sub := dial(t, addr)
+	defer sub.nc.Close()
+	pub := dial(t, addr)
+	defer pub.nc.Close()
+
+	if r := sub.do(t, "SUBSCRIBE", "news", "sport"); fmt.Sprint(r) != "[subscribe news 1]" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	if r := sub.read(t); fmt.Sprint(r) != "[subscribe sport 2]" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	if r := sub.do(t, "PSUBSCRIBE", "n*"); fmt.Sprint(r) != "[psubscribe n* 3]" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+
+	// only pub/sub commands are allowed in subscribed state of RESP2
+	if r, ok := sub.do(t, "GET", "a").(error); !ok || !strings.Contains(r.Error(), "only (P|S)SUBSCRIBE") {
+		t.Fatalf("want error in subscribed state but got %v", r)
+	}
+	if r := sub.do(t, "PING"); fmt.Sprint(r) != "[pong ]" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+
+	if r := pub.do(t, "PUBLISH", "news", "hello"); r != int64(2) {
+		t.Fatalf("want 2 receivers but got %v", r)
+	}
+	var got []string
+	for i := 0; i < 2; i++ {
+		got = append(got, fmt.Sprint(sub.read(t)))
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != "[message news hello],[pmessage n* news hello]" {
+		t.Fatalf("unexpected messages %v", got)
+	}
+
+	// messages published by go code reach clients
+	m.Publish("sport", 42)
+	if r := sub.read(t); fmt.Sprint(r) != "[message sport 42]" {
+		t.Fatalf("unexpected message %v", r)
+	}
+
+	if r := pub.do(t, "PUBSUB", "CHANNELS"); fmt.Sprint(r) != "[news sport]" {
+		t.Fatalf("unexpected channels %v", r)
+	}
+	if r := pub.do(t, "PUBSUB", "NUMSUB", "news", "none"); fmt.Sprint(r) != "[news 1 none 0]" {
+		t.Fatalf("unexpected counts %v", r)
+	}
+	if r := pub.do(t, "PUBSUB", "NUMPAT"); r != int64(1) {
+		t.Fatalf("want 1 pattern but got %v", r)
+	}
+
+	if r := sub.do(t, "UNSUBSCRIBE"); fmt.Sprint(r) != "[unsubscribe news 2]" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	if r := sub.read(t); fmt.Sprint(r) != "[unsubscribe sport 1]" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	if r := sub.do(t, "PUNSUBSCRIBE", "n*"); fmt.Sprint(r) != "[punsubscribe n* 0]" {
+		t.Fatalf("unexpected reply %v", r)
+	}
+	// back to normal state
+	if r := sub.do(t, "PING"); r != "PONG" {
+		t.Fatalf("want PONG but got %v", r)
+	}
+	if r := pub.do(t, "PUBLISH", "news", "hello"); r != int64(0) {
+		t.Fatalf("want 0 receivers but got %v", r)
+	}
+}
+
+func TestPubSubRESP3(t *testing.T) {
+	s, m, addr := start(t)
+	defer stop(s, m)
+
+	c := dial(t, addr)
+	defer c.nc.Close()
+	c.do(t, "HELLO", "3")
+
+	c.send("SUBSCRIBE", "news")
+	line, _ := c.r.ReadString('\n')
+	if line != ">3\r\n" {
+		t.Fatalf("want RESP3 push but got %q", line)
+	}
+	c.read(t)
+	c.read(t)
+	c.read(t)
+
+	// all commands work in subscribed state of RESP3, even publishing to itself
+	if r := c.do(t, "SET", "a", "1"); r != "OK" {
+		t.Fatalf("want OK but got %v", r)
+	}
+	c.send("PUBLISH", "news", "hello")
+	var replies []string
+	for i := 0; i < 2; i++ {
+		replies = append(replies, fmt.Sprint(c.read(t)))
+	}
+	sort.Strings(replies)
+	if strings.Join(replies, ",") != "1,[message news hello]" {
+		t.Fatalf("unexpected replies %v", replies)
+	}
+}
+
+func TestPublishToItselfBlocking(t *testing.T) {
+	m := cmap.NewMapV2(nil, 8, 5*time.Minute, cmap.WithPubSubPolicy(cmap.PUBSUB_BLOCK), cmap.WithPubSubBuffer(0))
+	defer m.Clear()
+	s := New(m)
+
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	go s.Serve(l)
+	defer stop(s, m)
+
+	c := dial(t, l.Addr().String())
+	defer c.nc.Close()
+	c.do(t, "HELLO", "3")
+	c.do(t, "SUBSCRIBE", "news")
+
+	// PUBLISH waits for c itself to receive the message, it doesn't deadlock
+	c.send("PUBLISH", "news", "hello")
+	var replies []string
+	for i := 0; i < 2; i++ {
+		replies = append(replies, fmt.Sprint(c.read(t)))
+	}
+	sort.Strings(replies)
+	if strings.Join(replies, ",") != "1,[message news hello]" {
+		t.Fatalf("unexpected replies %v", replies)
+	}
+}
@@ -0,0 +1,399 @@
+package cmap
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// cset is the set type made by SAdd, members are unique strings.
+type cset struct {
+	m map[string]struct{}
+	l *sync.RWMutex
+}
+
+func newcset() *cset {
+	return &cset{
+		m: make(map[string]struct{}),
+		l: &sync.RWMutex{},
+	}
+}
+
+// add returns whether member is new
+func (s *cset) add(member string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	_, exist := s.m[member]
+	s.m[member] = struct{}{}
+	return !exist
+}
+
+func (s *cset) rem(member string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	_, exist := s.m[member]
+	delete(s.m, member)
+	return exist
+}
+
+func (s *cset) has(member string) bool {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	_, exist := s.m[member]
+	return exist
+}
+
+func (s *cset) len() int {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	return len(s.m)
+}
+
+// size estimates bytes of members, 24 per member
+func (s *cset) size() int64 {
+	return 24 * int64(s.len())
+}
+
+// members copies all members
+func (s *cset) members() []string {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	var rs = make([]string, 0, len(s.m))
+	for member, _ := range s.m {
+		rs = append(rs, member)
+	}
+	return rs
+}
+
+// pop removes a member, go map ranges from a random position
+func (s *cset) pop() (string, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	for member, _ := range s.m {
+		delete(s.m, member)
+		return member, true
+	}
+	return "", false
+}
+
+// setOf returns set of key, an error if key holds another type.
+func (m *Map) setOf(key string, command string) (*cset, bool, error) {
+	return compoundOf[*cset](m, key, command, TYPE_SET)
+}
+
+// SAdd adds members to set of key, the set is made if key doesn't exist.
+// It returns number of members added, existing members are not counted.
+func (m *Map) SAdd(key string, members ...string) (int, error) {
+	if len(members) == 0 {
+		_, _, e := m.setOf(key, "SAdd")
+		return 0, e
+	}
+
+	var added int
+	e := updateCompound(m, key, "SAdd", TYPE_SET, newcset, func(s *cset) bool {
+		for _, member := range members {
+			if s.add(member) {
+				added++
+			}
+		}
+		return added > 0
+	}, func() *opRecord {
+		return membersRecord(aofOpSAdd, members)
+	})
+	return added, e
+}
+
+// SRem removes members from set of key and returns number of removed ones, key is deleted when the set becomes empty.
+func (m *Map) SRem(key string, members ...string) (int, error) {
+	var removed int
+	e := updateCompound(m, key, "SRem", TYPE_SET, nil, func(s *cset) bool {
+		for _, member := range members {
+			if s.rem(member) {
+				removed++
+			}
+		}
+		return removed > 0
+	}, func() *opRecord {
+		return membersRecord(aofOpSRem, members)
+	})
+	return removed, e
+}
+
+// SIsMember reports whether member is in set of key.
+func (m *Map) SIsMember(key string, member string) (bool, error) {
+	s, exist, e := m.setOf(key, "SIsMember")
+	if e != nil || !exist {
+		return false, e
+	}
+	return s.has(member), nil
+}
+
+// SCard returns number of members of set of key.
+func (m *Map) SCard(key string) (int, error) {
+	s, exist, e := m.setOf(key, "SCard")
+	if e != nil || !exist {
+		return 0, e
+	}
+	return s.len(), nil
+}
+
+// SMembers returns members of set of key in no particular order.
+func (m *Map) SMembers(key string) ([]string, error) {
+	s, exist, e := m.setOf(key, "SMembers")
+	if e != nil || !exist {
+		return []string{}, e
+	}
+	return s.members(), nil
+}
+
+// SPop removes and returns a random member of set of key, key is deleted when the set becomes empty.
+func (m *Map) SPop(key string) (string, bool, error) {
+	var member string
+	var ok bool
+	e := updateCompound(m, key, "SPop", TYPE_SET, nil, func(s *cset) bool {
+		member, ok = s.pop()
+		return ok
+	}, func() *opRecord {
+		return membersRecord(aofOpSRem, []string{member})
+	})
+	return member, ok, e
+}
+
+// SRandMember returns random members of set of key without removing them, like redis SRANDMEMBER.
+// A positive count returns distinct members, at most all of them.
+// A negative count returns -count members, which may repeat.
+func (m *Map) SRandMember(key string, count int) ([]string, error) {
+	members, e := m.SMembers(key)
+	if e != nil || len(members) == 0 || count == 0 {
+		return []string{}, e
+	}
+
+	if count < 0 {
+		var rs = make([]string, -count)
+		for i, _ := range rs {
+			rs[i] = members[rand.Intn(len(members))]
+		}
+		return rs, nil
+	}
+
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	if count < len(members) {
+		members = members[:count]
+	}
+	return members, nil
+}
+
+// setsOf copies members of sets of keys, a key not existing is an empty set.
+// Each set is copied atomically, but not all of them at the same time.
+func setsOf(keys []string, command string, setOf func(key string, command string) (*cset, bool, error)) ([]map[string]struct{}, error) {
+	var sets = make([]map[string]struct{}, len(keys))
+	for i, key := range keys {
+		s, exist, e := setOf(key, command)
+		if e != nil {
+			return nil, e
+		}
+		sets[i] = make(map[string]struct{})
+		if !exist {
+			continue
+		}
+		for _, member := range s.members() {
+			sets[i][member] = struct{}{}
+		}
+	}
+	return sets, nil
+}
+
+const (
+	set_union = 0
+	set_inter = 1
+	set_diff  = 2
+)
+
+// combine computes union, intersection or difference of sets, members are sorted.
+func combine(sets []map[string]struct{}, op int) []string {
+	var rs = make([]string, 0, 10)
+	if len(sets) == 0 {
+		return rs
+	}
+
+	switch op {
+	case set_union:
+		var seen = make(map[string]struct{})
+		for _, s := range sets {
+			for member, _ := range s {
+				if _, ok := seen[member]; !ok {
+					seen[member] = struct{}{}
+					rs = append(rs, member)
+				}
+			}
+		}
+	case set_inter:
+	next:
+		for member, _ := range sets[0] {
+			for _, s := range sets[1:] {
+				if _, ok := s[member]; !ok {
+					continue next
+				}
+			}
+			rs = append(rs, member)
+		}
+	case set_diff:
+	rest:
+		for member, _ := range sets[0] {
+			for _, s := range sets[1:] {
+				if _, ok := s[member]; ok {
+					continue rest
+				}
+			}
+			rs = append(rs, member)
+		}
+	}
+	sort.Strings(rs)
+	return rs
+}
+
+// storeSet saves members as set of dst, dst is overwritten whatever type it holds, and deleted if members is empty.
+func (m *Map) storeSet(dst string, members []string) int {
+	if len(members) == 0 {
+		m.Delete(dst)
+		return 0
+	}
+	s := newcset()
+	for _, member := range members {
+		s.add(member)
+	}
+	m.Set(dst, s)
+	return len(members)
+}
+
+// SUnion returns members in any of sets of keys, sorted.
+func (m *Map) SUnion(keys ...string) ([]string, error) {
+	sets, e := setsOf(keys, "SUnion", m.setOf)
+	if e != nil {
+		return nil, e
+	}
+	return combine(sets, set_union), nil
+}
+
+// SInter returns members in all of sets of keys, sorted.
+func (m *Map) SInter(keys ...string) ([]string, error) {
+	sets, e := setsOf(keys, "SInter", m.setOf)
+	if e != nil {
+		return nil, e
+	}
+	return combine(sets, set_inter), nil
+}
+
+// SDiff returns members of the first set not in any of the others, sorted.
+func (m *Map) SDiff(keys ...string) ([]string, error) {
+	sets, e := setsOf(keys, "SDiff", m.setOf)
+	if e != nil {
+		return nil, e
+	}
+	return combine(sets, set_diff), nil
+}
+
+// SUnionStore saves SUnion of keys to dst and returns number of its members.
+func (m *Map) SUnionStore(dst string, keys ...string) (int, error) {
+	members, e := m.SUnion(keys...)
+	if e != nil {
+		return 0, e
+	}
+	return m.storeSet(dst, members), nil
+}
+
+// SInterStore saves SInter of keys to dst and returns number of its members.
+func (m *Map) SInterStore(dst string, keys ...string) (int, error) {
+	members, e := m.SInter(keys...)
+	if e != nil {
+		return 0, e
+	}
+	return m.storeSet(dst, members), nil
+}
+
+// SDiffStore saves SDiff of keys to dst and returns number of its members.
+func (m *Map) SDiffStore(dst string, keys ...string) (int, error) {
+	members, e := m.SDiff(keys...)
+	if e != nil {
+		return 0, e
+	}
+	return m.storeSet(dst, members), nil
+}
+
+func (mv2 *MapV2) SAdd(key string, members ...string) (int, error) {
+	return mv2.getslot(key).SAdd(key, members...)
+}
+func (mv2 *MapV2) SRem(key string, members ...string) (int, error) {
+	return mv2.getslot(key).SRem(key, members...)
+}
+func (mv2 *MapV2) SIsMember(key string, member string) (bool, error) {
+	return mv2.getslot(key).SIsMember(key, member)
+}
+func (mv2 *MapV2) SCard(key string) (int, error) {
+	return mv2.getslot(key).SCard(key)
+}
+func (mv2 *MapV2) SMembers(key string) ([]string, error) {
+	return mv2.getslot(key).SMembers(key)
+}
+func (mv2 *MapV2) SPop(key string) (string, bool, error) {
+	return mv2.getslot(key).SPop(key)
+}
+func (mv2 *MapV2) SRandMember(key string, count int) ([]string, error) {
+	return mv2.getslot(key).SRandMember(key, count)
+}
+
+// setOf reads set of key from its slot, keys of set algebra can be in different slots
+func (mv2 *MapV2) setOf(key string, command string) (*cset, bool, error) {
+	return mv2.getslot(key).setOf(key, command)
+}
+
+// SUnion returns members in any of sets of keys, keys can be in different slots, see Map.SUnion.
+func (mv2 *MapV2) SUnion(keys ...string) ([]string, error) {
+	sets, e := setsOf(keys, "SUnion", mv2.setOf)
+	if e != nil {
+		return nil, e
+	}
+	return combine(sets, set_union), nil
+}
+
+// SInter returns members in all of sets of keys, keys can be in different slots, see Map.SInter.
+func (mv2 *MapV2) SInter(keys ...string) ([]string, error) {
+	sets, e := setsOf(keys, "SInter", mv2.setOf)
+	if e != nil {
+		return nil, e
+	}
+	return combine(sets, set_inter), nil
+}
+
+// SDiff returns members of the first set not in any of the others, keys can be in different slots, see Map.SDiff.
+func (mv2 *MapV2) SDiff(keys ...string) ([]string, error) {
+	sets, e := setsOf(keys, "SDiff", mv2.setOf)
+	if e != nil {
+		return nil, e
+	}
+	return combine(sets, set_diff), nil
+}
+
+func (mv2 *MapV2) SUnionStore(dst string, keys ...string) (int, error) {
+	members, e := mv2.SUnion(keys...)
+	if e != nil {
+		return 0, e
+	}
+	return mv2.getslot(dst).storeSet(dst, members), nil
+}
+func (mv2 *MapV2) SInterStore(dst string, keys ...string) (int, error) {
+	members, e := mv2.SInter(keys...)
+	if e != nil {
+		return 0, e
+	}
+	return mv2.getslot(dst).storeSet(dst, members), nil
+}
+func (mv2 *MapV2) SDiffStore(dst string, keys ...string) (int, error) {
+	members, e := mv2.SDiff(keys...)
+	if e != nil {
+		return 0, e
+	}
+	return mv2.getslot(dst).storeSet(dst, members), nil
+}
@@ -0,0 +1,250 @@
+package cmap
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSet(t *testing.T) {
+	m := NewMap()
+
+	if n, e := m.SAdd("room", "tom", "jerry", "tom"); n != 2 || e != nil {
+		t.Fatalf("want 2 added but got %d %v", n, e)
+	}
+	if n, _ := m.SAdd("room", "tom", "spike"); n != 1 {
+		t.Fatalf("want 1 added but got %d", n)
+	}
+	if ok, _ := m.SIsMember("room", "spike"); !ok {
+		t.Fatalf("spike should be a member")
+	}
+	if ok, _ := m.SIsMember("missing", "spike"); ok {
+		t.Fatalf("missing set has no member")
+	}
+	if n, _ := m.SCard("room"); n != 3 {
+		t.Fatalf("want 3 but got %d", n)
+	}
+	members, _ := m.SMembers("room")
+	sort.Strings(members)
+	if len(members) != 3 || members[0] != "jerry" || members[1] != "spike" || members[2] != "tom" {
+		t.Fatalf("unexpected members %v", members)
+	}
+	if m.Type("room") != TYPE_SET {
+		t.Fatalf("want set but got %s", m.Type("room"))
+	}
+
+	if rs, _ := m.SRandMember("room", 2); len(rs) != 2 || rs[0] == rs[1] {
+		t.Fatalf("want 2 distinct members but got %v", rs)
+	}
+	if rs, _ := m.SRandMember("room", 10); len(rs) != 3 {
+		t.Fatalf("want all 3 members but got %v", rs)
+	}
+	if rs, _ := m.SRandMember("room", -10); len(rs) != 10 {
+		t.Fatalf("want 10 members but got %v", rs)
+	}
+	if n, _ := m.SCard("room"); n != 3 {
+		t.Fatalf("SRandMember should not remove members")
+	}
+
+	if n, _ := m.SRem("room", "tom", "nobody"); n != 1 {
+		t.Fatalf("want 1 removed but got %d", n)
+	}
+	var popped = make(map[string]bool)
+	for {
+		member, ok, e := m.SPop("room")
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !ok {
+			break
+		}
+		popped[member] = true
+	}
+	if len(popped) != 2 || !popped["jerry"] || !popped["spike"] {
+		t.Fatalf("unexpected popped %v", popped)
+	}
+	if _, ok := m.Get("room"); ok {
+		t.Fatalf("empty set should be deleted")
+	}
+	if n, e := m.SAdd("room"); n != 0 || e != nil {
+		t.Fatalf("SAdd of no members should do nothing")
+	}
+	if _, ok := m.Get("room"); ok {
+		t.Fatalf("SAdd of no members should not make key")
+	}
+
+	m.Set("string", 1)
+	if _, e := m.SAdd("string", "a"); e == nil {
+		t.Fatalf("want wrong type error of SAdd")
+	}
+	if _, e := m.SUnion("string"); e == nil {
+		t.Fatalf("want wrong type error of SUnion")
+	}
+	if _, e := m.HSet("string", "f", 1); e == nil {
+		t.Fatalf("want wrong type error of HSet")
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	m := NewMap()
+	m.SAdd("a", "1", "2", "3")
+	m.SAdd("b", "2", "3", "4")
+	m.SAdd("c", "3", "5")
+
+	cases := []struct {
+		f    func(keys ...string) ([]string, error)
+		keys []string
+		want string
+	}{
+		{m.SUnion, []string{"a", "b", "c"}, "1,2,3,4,5"},
+		{m.SInter, []string{"a", "b", "c"}, "3"},
+		{m.SInter, []string{"a", "b"}, "2,3"},
+		{m.SInter, []string{"a", "missing"}, ""},
+		{m.SDiff, []string{"a", "b"}, "1"},
+		{m.SDiff, []string{"a", "missing"}, "1,2,3"},
+		{m.SUnion, nil, ""},
+	}
+	for i, c := range cases {
+		rs, e := c.f(c.keys...)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if got := strings.Join(rs, ","); got != c.want {
+			t.Fatalf("case %d: want %s but got %s", i, c.want, got)
+		}
+	}
+
+	m.Set("dst", "string")
+	if n, _ := m.SInterStore("dst", "a", "b"); n != 2 {
+		t.Fatalf("want 2 but got %d", n)
+	}
+	if members, _ := m.SMembers("dst"); len(members) != 2 {
+		t.Fatalf("dst should be overwritten by set, got %v", members)
+	}
+	if n, _ := m.SDiffStore("dst", "c", "a"); n != 1 {
+		t.Fatalf("want 1 but got %d", n)
+	}
+	if n, _ := m.SUnionStore("dst", "missing"); n != 0 {
+		t.Fatalf("want 0 but got %d", n)
+	}
+	if _, ok := m.Get("dst"); ok {
+		t.Fatalf("empty result should delete dst")
+	}
+}
+
+func TestSetInBusyMode(t *testing.T) {
+	m := NewMap()
+
+	var stop = make(chan struct{})
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.ClearExpireKeys()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				m.SAdd("online", strconv.Itoa(i*1000+j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	<-done
+
+	if n, _ := m.SCard("online"); n != 1600 {
+		t.Fatalf("want 1600 but got %d", n)
+	}
+}
+
+func TestSetPersistence(t *testing.T) {
+	m := NewMap()
+	m.SAdd("tags", "go", "redis")
+	m.Expire("tags", 100)
+
+	var buf bytes.Buffer
+	if e := m.SaveSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+	m2 := NewMap()
+	if e := m2.LoadSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+	if ok, _ := m2.SIsMember("tags", "redis"); !ok {
+		t.Fatalf("set should be restored")
+	}
+	if ttl := m2.TTL("tags"); ttl <= 0 || ttl > 100 {
+		t.Fatalf("ttl should be restored, got %d", ttl)
+	}
+
+	path := filepath.Join(t.TempDir(), "cmap.aof")
+	aof := openTestAOF(t, path, FSYNC_ALWAYS)
+	m3 := NewMap()
+	if e := m3.UseAOF(aof); e != nil {
+		t.Fatal(e)
+	}
+	m3.SAdd("tags", "go", "redis", "cmap")
+	m3.SRem("tags", "redis")
+	aof.Close()
+
+	aof2 := openTestAOF(t, path, FSYNC_ALWAYS)
+	defer aof2.Close()
+	m4 := NewMap()
+	if e := m4.UseAOF(aof2); e != nil {
+		t.Fatal(e)
+	}
+	if members, _ := m4.SMembers("tags"); len(members) != 2 {
+		t.Fatalf("unexpected replayed set %v", members)
+	}
+
+	if d := m.Snapshot().Diff(m2.Snapshot()); !d.Empty() {
+		t.Fatalf("equal sets should not differ, got %+v", d)
+	}
+}
+
+func TestMapV2SetAlgebra(t *testing.T) {
+	mv2 := NewMapV2(nil, 16, time.Minute)
+	defer mv2.Clear()
+
+	// keys of different slots
+	var keys []string
+	for i := 0; len(keys) < 3; i++ {
+		key := "room:" + strconv.Itoa(i)
+		if len(keys) > 0 && mv2.getslot(key) == mv2.getslot(keys[len(keys)-1]) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	mv2.SAdd(keys[0], "a", "b", "c")
+	mv2.SAdd(keys[1], "b", "c", "d")
+	mv2.SAdd(keys[2], "c")
+
+	if rs, _ := mv2.SInter(keys...); strings.Join(rs, ",") != "c" {
+		t.Fatalf("want c but got %v", rs)
+	}
+	if rs, _ := mv2.SUnion(keys...); strings.Join(rs, ",") != "a,b,c,d" {
+		t.Fatalf("want a,b,c,d but got %v", rs)
+	}
+	if n, _ := mv2.SDiffStore("diff", keys[0], keys[1]); n != 1 {
+		t.Fatalf("want 1 but got %d", n)
+	}
+	if ok, _ := mv2.SIsMember("diff", "a"); !ok {
+		t.Fatalf("a should be in diff")
+	}
+}
@@ -0,0 +1,212 @@
+package cmap
+
+import (
+	"math/rand"
+)
+
+// skiplist orders members of a sorted set by score, then by member, like redis zskiplist.
+// Spans of levels make ranks computed in O(log n).
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+type skiplistLevel struct {
+	forward *skiplistNode
+	// number of nodes from this node to forward, counting forward
+	span int
+}
+
+type skiplistNode struct {
+	member   string
+	score    float64
+	backward *skiplistNode
+	level    []skiplistLevel
+}
+
+type skiplist struct {
+	header *skiplistNode
+	tail   *skiplistNode
+	length int
+	level  int
+}
+
+func newSkiplistNode(level int, score float64, member string) *skiplistNode {
+	return &skiplistNode{
+		member: member,
+		score:  score,
+		level:  make([]skiplistLevel, level),
+	}
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: newSkiplistNode(skiplistMaxLevel, 0, ""),
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// less reports whether node x is before (score, member)
+func (x *skiplistNode) less(score float64, member string) bool {
+	return x.score < score || (x.score == score && x.member < member)
+}
+
+// insert adds a node, caller makes sure member is not in list
+func (sl *skiplist) insert(score float64, member string) *skiplistNode {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = newSkiplistNode(level, score, member)
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+	return x
+}
+
+func (sl *skiplist) deleteNode(x *skiplistNode, update []*skiplistNode) {
+	for i := 0; i < sl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+// delete removes node of (score, member), it returns false if not found
+func (sl *skiplist) delete(score float64, member string) bool {
+	var update = make([]*skiplistNode, skiplistMaxLevel)
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	sl.deleteNode(x, update)
+	return true
+}
+
+// rank returns 1-based rank of (score, member), 0 if not found
+func (sl *skiplist) rank(score float64, member string) int {
+	var rank int
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (x.level[i].forward.less(score, member) || (x.level[i].forward.score == score && x.level[i].forward.member == member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != sl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns node of 1-based rank
+func (sl *skiplist) byRank(rank int) *skiplistNode {
+	var traversed int
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstAfter returns the first node which before returns false for.
+// before must be true for a prefix of the list and false for the rest.
+func (sl *skiplist) firstAfter(before func(x *skiplistNode) bool) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && before(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+// lastBefore returns the last node which notAfter returns true for.
+// notAfter must be true for a prefix of the list and false for the rest.
+func (sl *skiplist) lastBefore(notAfter func(x *skiplistNode) bool) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && notAfter(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	if x == sl.header {
+		return nil
+	}
+	return x
+}
@@ -0,0 +1,64 @@
+package cmap
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestSkiplist(t *testing.T) {
+	sl := newSkiplist()
+	var ref = make(map[string]float64)
+
+	for i := 0; i < 5000; i++ {
+		member := strconv.Itoa(rand.Intn(500))
+		if score, ok := ref[member]; ok && rand.Intn(2) == 0 {
+			if !sl.delete(score, member) {
+				t.Fatalf("delete of %s should succeed", member)
+			}
+			delete(ref, member)
+			continue
+		}
+		if score, ok := ref[member]; ok {
+			sl.delete(score, member)
+		}
+		score := float64(rand.Intn(50))
+		sl.insert(score, member)
+		ref[member] = score
+	}
+
+	var want = make([]Z, 0, len(ref))
+	for member, score := range ref {
+		want = append(want, Z{Member: member, Score: score})
+	}
+	sort.Slice(want, func(i, j int) bool {
+		return want[i].Score < want[j].Score || (want[i].Score == want[j].Score && want[i].Member < want[j].Member)
+	})
+
+	if sl.length != len(want) {
+		t.Fatalf("want length %d but got %d", len(want), sl.length)
+	}
+	x := sl.header.level[0].forward
+	for i, z := range want {
+		if x == nil || x.member != z.Member || x.score != z.Score {
+			t.Fatalf("node %d should be %v", i, z)
+		}
+		if rank := sl.rank(z.Score, z.Member); rank != i+1 {
+			t.Fatalf("rank of %v should be %d but got %d", z, i+1, rank)
+		}
+		if y := sl.byRank(i + 1); y != x {
+			t.Fatalf("node of rank %d should be %v", i+1, z)
+		}
+		if i > 0 && x.backward.member != want[i-1].Member {
+			t.Fatalf("backward of %v is broken", z)
+		}
+		x = x.level[0].forward
+	}
+	if len(want) > 0 && sl.tail.member != want[len(want)-1].Member {
+		t.Fatalf("tail is broken")
+	}
+	if sl.delete(-1, "missing") || sl.rank(-1, "missing") != 0 || sl.byRank(len(want)+1) != nil {
+		t.Fatalf("missing node should not be found")
+	}
+}
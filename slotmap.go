@@ -39,6 +39,9 @@ func NewSlotMap(slotNum int, autoExtend bool, checkIneval time.Duration) *SlotMa
 		l:     &sync.RWMutex{},
 		slots: make([]Map, slotNum, 2*slotNum),
 	}
+	for i, _ := range s.slots {
+		s.slots[i] = *newMap()
+	}
 	if autoExtend {
 		go func() {
 			for {
@@ -164,6 +167,24 @@ func (s *SlotMap) SetEx(key string, value interface{}, seconds int) {
 	slot.SetEx(key, value, seconds)
 	return
 }
+
+func (s *SlotMap) SetTTL(key string, value interface{}, ttl time.Duration) {
+	s.rLock()
+	slot := s.slots[s.hash(key)]
+	s.rUnlock()
+
+	slot.SetTTL(key, value, ttl)
+	return
+}
+
+func (s *SlotMap) SetExpireAt(key string, value interface{}, deadline time.Time) {
+	s.rLock()
+	slot := s.slots[s.hash(key)]
+	s.rUnlock()
+
+	slot.SetExpireAt(key, value, deadline)
+	return
+}
 func (s *SlotMap) Get(key string) (interface{}, bool) {
 	s.rLock()
 	slot := s.slots[s.hash(key)]
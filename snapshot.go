@@ -0,0 +1,757 @@
+package cmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"github.com/fwhezfwhez/errorx"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Snapshot file layout, all integers are varint encoded unless noted:
+//
+//	"CMAP" | version(uint16, big endian)
+//	entry:  0xFE | key | ttl(nanoseconds, -1 means no time limit) | offset | kind(byte) | payload
+//	...
+//	0xFF | crc32(uint32, big endian, IEEE, of all bytes before it)
+//
+// key and payload are written as length + bytes. A KIND_LIST payload is count + encoded elements,
+// a KIND_HASH payload is count + (field + encoded value) sorted by field, a KIND_SET payload is count + sorted members,
+// a KIND_ZSET payload is count + (member + score(float64 bits, big endian)) ordered by score,
+// a KIND_DELAY payload is count + (due(unixnano) + encoded value) ordered by due time.
+const (
+	snapshotMagic   = "CMAP"
+	snapshotVersion = 1
+
+	snapshotOpEntry = 0xFE
+	snapshotOpEOF   = 0xFF
+
+	// a corrupted length should not make reader allocate huge memory
+	maxSnapshotBlob = 1 << 30
+)
+
+// ValueKind tells how a value is saved in a snapshot.
+type ValueKind byte
+
+const (
+	KIND_VALUE ValueKind = 0 // value encoded by Codec
+	KIND_LIST  ValueKind = 1 // list made by RPush, saved as []interface{}
+	KIND_HASH  ValueKind = 2 // hash made by HSet, saved as map[string]interface{}
+	KIND_SET   ValueKind = 3 // set made by SAdd, saved as []string
+	KIND_ZSET  ValueKind = 4 // sorted set made by ZAdd, saved as []Z
+	KIND_DELAY ValueKind = 5 // delay queue made by DelayPush, saved as []DelayItem
+)
+
+// Codec encodes and decodes interface{} values of snapshots.
+type Codec interface {
+	Encode(value interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// WithCodec sets the codec used by SaveSnapshot and LoadSnapshot, default GobCodec.
+func WithCodec(codec Codec) MapOption {
+	return func(c *mapConfig) {
+		c.codec = codec
+	}
+}
+
+// GobCodec is the default codec.
+// Builtin types work out of box, custom types should be registered by gob.Register before saving and loading.
+type GobCodec struct{}
+
+// gob requires interface value to be wrapped in a struct
+type gobValue struct {
+	V interface{}
+}
+
+func (GobCodec) Encode(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if e := gob.NewEncoder(&buf).Encode(gobValue{V: value}); e != nil {
+		return nil, errorx.Wrap(e)
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Decode(data []byte) (interface{}, error) {
+	var gv gobValue
+	if e := gob.NewDecoder(bytes.NewReader(data)).Decode(&gv); e != nil {
+		return nil, errorx.Wrap(e)
+	}
+	return gv.V, nil
+}
+
+// SnapshotEntry is a key-value saved in a snapshot.
+type SnapshotEntry struct {
+	Key string
+	// []interface{} for KIND_LIST, map[string]interface{} for KIND_HASH, []string for KIND_SET, []Z for KIND_ZSET,
+	// []DelayItem for KIND_DELAY
+	Value interface{}
+	Kind  ValueKind
+	// remaining time to live when saved, negative means no time limit
+	TTL time.Duration
+	// map's offset when the value was set
+	Offset int64
+}
+
+// WriteSnapshot writes entries to w in snapshot format.
+func WriteSnapshot(w io.Writer, codec Codec, entries []SnapshotEntry) error {
+	if codec == nil {
+		codec = GobCodec{}
+	}
+
+	bw := bufio.NewWriter(w)
+	crc := crc32.NewIEEE()
+	sw := &snapshotWriter{w: io.MultiWriter(bw, crc)}
+
+	sw.write([]byte(snapshotMagic))
+	sw.write([]byte{snapshotVersion >> 8, snapshotVersion & 0xff})
+
+	for _, entry := range entries {
+		sw.write([]byte{snapshotOpEntry})
+		sw.writeBytes([]byte(entry.Key))
+		sw.writeVarint(int64(entry.TTL))
+		sw.writeVarint(entry.Offset)
+		sw.write([]byte{byte(entry.Kind)})
+
+		if e := sw.writeValue(codec, entry.Key, entry.Kind, entry.Value); e != nil {
+			return errorx.Wrap(e)
+		}
+		if sw.err != nil {
+			return errorx.Wrap(sw.err)
+		}
+	}
+
+	sw.write([]byte{snapshotOpEOF})
+	if sw.err != nil {
+		return errorx.Wrap(sw.err)
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	if _, e := bw.Write(sum[:]); e != nil {
+		return errorx.Wrap(e)
+	}
+	if e := bw.Flush(); e != nil {
+		return errorx.Wrap(e)
+	}
+	return nil
+}
+
+// ReadSnapshot reads all entries from r, the checksum is verified before returning.
+func ReadSnapshot(r io.Reader, codec Codec) ([]SnapshotEntry, error) {
+	if codec == nil {
+		codec = GobCodec{}
+	}
+
+	br := bufio.NewReader(r)
+	sr := &snapshotReader{r: br, crc: crc32.NewIEEE()}
+
+	var header [6]byte
+	if e := sr.read(header[:]); e != nil {
+		return nil, errorx.NewFromStringf("read snapshot header: %s", e.Error())
+	}
+	if string(header[:4]) != snapshotMagic {
+		return nil, errorx.NewFromStringf("not a cmap snapshot")
+	}
+	if version := int(header[4])<<8 | int(header[5]); version != snapshotVersion {
+		return nil, errorx.NewFromStringf("unsupported snapshot version %d", version)
+	}
+
+	var entries = make([]SnapshotEntry, 0, 16)
+	for {
+		op, e := sr.ReadByte()
+		if e != nil {
+			return nil, errorx.NewFromStringf("read snapshot: %s", e.Error())
+		}
+		if op == snapshotOpEOF {
+			break
+		}
+		if op != snapshotOpEntry {
+			return nil, errorx.NewFromStringf("snapshot is corrupted, unknown op 0x%x", op)
+		}
+
+		entry, e := sr.readEntry(codec)
+		if e != nil {
+			return nil, errorx.Wrap(e)
+		}
+		entries = append(entries, entry)
+	}
+
+	want := sr.crc.Sum32()
+	var sum [4]byte
+	if _, e := io.ReadFull(br, sum[:]); e != nil {
+		return nil, errorx.NewFromStringf("read snapshot checksum: %s", e.Error())
+	}
+	if got := binary.BigEndian.Uint32(sum[:]); got != want {
+		return nil, errorx.NewFromStringf("snapshot checksum mismatch, want %08x but got %08x", want, got)
+	}
+	return entries, nil
+}
+
+// snapshotWriter remembers the first error, so callers check it once per entry
+type snapshotWriter struct {
+	w   io.Writer
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	_, sw.err = sw.w.Write(p)
+}
+
+func (sw *snapshotWriter) writeVarint(n int64) {
+	sw.write(sw.buf[:binary.PutVarint(sw.buf[:], n)])
+}
+
+func (sw *snapshotWriter) writeUvarint(n uint64) {
+	sw.write(sw.buf[:binary.PutUvarint(sw.buf[:], n)])
+}
+
+func (sw *snapshotWriter) writeBytes(p []byte) {
+	sw.writeUvarint(uint64(len(p)))
+	sw.write(p)
+}
+
+// writeValue writes value payload of kind
+func (sw *snapshotWriter) writeValue(codec Codec, key string, kind ValueKind, value interface{}) error {
+	switch kind {
+	case KIND_VALUE:
+		data, e := codec.Encode(value)
+		if e != nil {
+			return errorx.Wrap(e)
+		}
+		sw.writeBytes(data)
+	case KIND_LIST:
+		elems, ok := value.([]interface{})
+		if !ok {
+			return errorx.NewFromStringf("snapshot key '%s' of KIND_LIST requires []interface{} value", key)
+		}
+		if e := sw.writeElems(codec, elems); e != nil {
+			return errorx.Wrap(e)
+		}
+	case KIND_HASH:
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return errorx.NewFromStringf("snapshot key '%s' of KIND_HASH requires map[string]interface{} value", key)
+		}
+		if e := sw.writeFields(codec, fields); e != nil {
+			return errorx.Wrap(e)
+		}
+	case KIND_SET:
+		members, ok := value.([]string)
+		if !ok {
+			return errorx.NewFromStringf("snapshot key '%s' of KIND_SET requires []string value", key)
+		}
+		sw.writeMembers(members)
+	case KIND_ZSET:
+		zs, ok := value.([]Z)
+		if !ok {
+			return errorx.NewFromStringf("snapshot key '%s' of KIND_ZSET requires []Z value", key)
+		}
+		sw.writeZs(zs)
+	case KIND_DELAY:
+		items, ok := value.([]DelayItem)
+		if !ok {
+			return errorx.NewFromStringf("snapshot key '%s' of KIND_DELAY requires []DelayItem value", key)
+		}
+		if e := sw.writeDelays(codec, items); e != nil {
+			return errorx.Wrap(e)
+		}
+	default:
+		return errorx.NewFromStringf("snapshot key '%s' has unknown kind %d", key, kind)
+	}
+	return nil
+}
+
+// writeElems writes count and encoded elements
+func (sw *snapshotWriter) writeElems(codec Codec, elems []interface{}) error {
+	sw.writeUvarint(uint64(len(elems)))
+	for _, elem := range elems {
+		data, e := codec.Encode(elem)
+		if e != nil {
+			return errorx.Wrap(e)
+		}
+		sw.writeBytes(data)
+	}
+	return nil
+}
+
+// writeFields writes count and field-values sorted by field
+func (sw *snapshotWriter) writeFields(codec Codec, fields map[string]interface{}) error {
+	var names = make([]string, 0, len(fields))
+	for field, _ := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+
+	sw.writeUvarint(uint64(len(names)))
+	for _, field := range names {
+		data, e := codec.Encode(fields[field])
+		if e != nil {
+			return errorx.Wrap(e)
+		}
+		sw.writeBytes([]byte(field))
+		sw.writeBytes(data)
+	}
+	return nil
+}
+
+// writeMembers writes count and sorted members
+func (sw *snapshotWriter) writeMembers(members []string) {
+	var sorted = make([]string, len(members))
+	copy(sorted, members)
+	sort.Strings(sorted)
+
+	sw.writeUvarint(uint64(len(sorted)))
+	for _, member := range sorted {
+		sw.writeBytes([]byte(member))
+	}
+}
+
+// writeZs writes count and members with scores
+func (sw *snapshotWriter) writeZs(zs []Z) {
+	sw.writeUvarint(uint64(len(zs)))
+	for _, z := range zs {
+		var score [8]byte
+		binary.BigEndian.PutUint64(score[:], math.Float64bits(z.Score))
+		sw.writeBytes([]byte(z.Member))
+		sw.write(score[:])
+	}
+}
+
+// writeDelays writes count and items with due time
+func (sw *snapshotWriter) writeDelays(codec Codec, items []DelayItem) error {
+	sw.writeUvarint(uint64(len(items)))
+	for _, item := range items {
+		data, e := codec.Encode(item.Value)
+		if e != nil {
+			return errorx.Wrap(e)
+		}
+		sw.writeVarint(item.Due.UnixNano())
+		sw.writeBytes(data)
+	}
+	return nil
+}
+
+// snapshotReader sums every byte read except the trailing checksum
+type snapshotReader struct {
+	r interface {
+		io.Reader
+		io.ByteReader
+	}
+	crc hash.Hash32
+}
+
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	b, e := sr.r.ReadByte()
+	if e != nil {
+		return 0, e
+	}
+	sr.crc.Write([]byte{b})
+	return b, nil
+}
+
+func (sr *snapshotReader) read(p []byte) error {
+	if _, e := io.ReadFull(sr.r, p); e != nil {
+		return e
+	}
+	sr.crc.Write(p)
+	return nil
+}
+
+func (sr *snapshotReader) readBytes() ([]byte, error) {
+	n, e := binary.ReadUvarint(sr)
+	if e != nil {
+		return nil, e
+	}
+	if n > maxSnapshotBlob {
+		return nil, errorx.NewFromStringf("snapshot is corrupted, length %d is too big", n)
+	}
+	p := make([]byte, n)
+	if e := sr.read(p); e != nil {
+		return nil, e
+	}
+	return p, nil
+}
+
+// readValue reads value payload of kind
+func (sr *snapshotReader) readValue(codec Codec, key string, kind ValueKind) (interface{}, error) {
+	switch kind {
+	case KIND_VALUE:
+		data, e := sr.readBytes()
+		if e != nil {
+			return nil, e
+		}
+		return codec.Decode(data)
+	case KIND_LIST:
+		return sr.readElems(codec)
+	case KIND_HASH:
+		return sr.readFields(codec)
+	case KIND_SET:
+		return sr.readMembers()
+	case KIND_ZSET:
+		return sr.readZs()
+	case KIND_DELAY:
+		return sr.readDelays(codec)
+	}
+	return nil, errorx.NewFromStringf("snapshot key '%s' has unknown kind %d", key, kind)
+}
+
+// readElems reads count and decoded elements
+func (sr *snapshotReader) readElems(codec Codec) ([]interface{}, error) {
+	n, e := binary.ReadUvarint(sr)
+	if e != nil {
+		return nil, e
+	}
+	if n > maxSnapshotBlob {
+		return nil, errorx.NewFromStringf("snapshot is corrupted, list length %d is too big", n)
+	}
+	var capacity = n
+	if capacity > 1024 {
+		capacity = 1024
+	}
+	var elems = make([]interface{}, 0, capacity)
+	for i := uint64(0); i < n; i++ {
+		data, e := sr.readBytes()
+		if e != nil {
+			return nil, e
+		}
+		elem, e := codec.Decode(data)
+		if e != nil {
+			return nil, e
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+// readFields reads count and decoded field-values
+func (sr *snapshotReader) readFields(codec Codec) (map[string]interface{}, error) {
+	n, e := binary.ReadUvarint(sr)
+	if e != nil {
+		return nil, e
+	}
+	if n > maxSnapshotBlob {
+		return nil, errorx.NewFromStringf("snapshot is corrupted, hash length %d is too big", n)
+	}
+	var capacity = n
+	if capacity > 1024 {
+		capacity = 1024
+	}
+	var fields = make(map[string]interface{}, capacity)
+	for i := uint64(0); i < n; i++ {
+		field, e := sr.readBytes()
+		if e != nil {
+			return nil, e
+		}
+		data, e := sr.readBytes()
+		if e != nil {
+			return nil, e
+		}
+		value, e := codec.Decode(data)
+		if e != nil {
+			return nil, e
+		}
+		fields[string(field)] = value
+	}
+	return fields, nil
+}
+
+// readMembers reads count and members
+func (sr *snapshotReader) readMembers() ([]string, error) {
+	n, e := binary.ReadUvarint(sr)
+	if e != nil {
+		return nil, e
+	}
+	if n > maxSnapshotBlob {
+		return nil, errorx.NewFromStringf("snapshot is corrupted, set length %d is too big", n)
+	}
+	var capacity = n
+	if capacity > 1024 {
+		capacity = 1024
+	}
+	var members = make([]string, 0, capacity)
+	for i := uint64(0); i < n; i++ {
+		member, e := sr.readBytes()
+		if e != nil {
+			return nil, e
+		}
+		members = append(members, string(member))
+	}
+	return members, nil
+}
+
+// readZs reads count and members with scores
+func (sr *snapshotReader) readZs() ([]Z, error) {
+	n, e := binary.ReadUvarint(sr)
+	if e != nil {
+		return nil, e
+	}
+	if n > maxSnapshotBlob {
+		return nil, errorx.NewFromStringf("snapshot is corrupted, zset length %d is too big", n)
+	}
+	var capacity = n
+	if capacity > 1024 {
+		capacity = 1024
+	}
+	var zs = make([]Z, 0, capacity)
+	for i := uint64(0); i < n; i++ {
+		member, e := sr.readBytes()
+		if e != nil {
+			return nil, e
+		}
+		var score [8]byte
+		if e := sr.read(score[:]); e != nil {
+			return nil, e
+		}
+		zs = append(zs, Z{Member: string(member), Score: math.Float64frombits(binary.BigEndian.Uint64(score[:]))})
+	}
+	return zs, nil
+}
+
+// readDelays reads count and decoded items with due time
+func (sr *snapshotReader) readDelays(codec Codec) ([]DelayItem, error) {
+	n, e := binary.ReadUvarint(sr)
+	if e != nil {
+		return nil, e
+	}
+	if n > maxSnapshotBlob {
+		return nil, errorx.NewFromStringf("snapshot is corrupted, delay queue length %d is too big", n)
+	}
+	var capacity = n
+	if capacity > 1024 {
+		capacity = 1024
+	}
+	var items = make([]DelayItem, 0, capacity)
+	for i := uint64(0); i < n; i++ {
+		due, e := binary.ReadVarint(sr)
+		if e != nil {
+			return nil, e
+		}
+		data, e := sr.readBytes()
+		if e != nil {
+			return nil, e
+		}
+		value, e := codec.Decode(data)
+		if e != nil {
+			return nil, e
+		}
+		items = append(items, DelayItem{Value: value, Due: time.Unix(0, due)})
+	}
+	return items, nil
+}
+
+func (sr *snapshotReader) readEntry(codec Codec) (SnapshotEntry, error) {
+	var entry SnapshotEntry
+
+	key, e := sr.readBytes()
+	if e != nil {
+		return entry, e
+	}
+	entry.Key = string(key)
+
+	ttl, e := binary.ReadVarint(sr)
+	if e != nil {
+		return entry, e
+	}
+	entry.TTL = time.Duration(ttl)
+
+	if entry.Offset, e = binary.ReadVarint(sr); e != nil {
+		return entry, e
+	}
+
+	kind, e := sr.ReadByte()
+	if e != nil {
+		return entry, e
+	}
+	entry.Kind = ValueKind(kind)
+
+	if entry.Value, e = sr.readValue(codec, entry.Key, entry.Kind); e != nil {
+		return entry, e
+	}
+	return entry, nil
+}
+
+// SaveSnapshot writes all unexpired keys, values, remaining ttl and offsets to w.
+// Keys are copied at a point of time under read locks and encoded after locks released,
+// so the map keeps serving reads while saving, and writes are blocked only during copying.
+func (m *Map) SaveSnapshot(w io.Writer) error {
+	return WriteSnapshot(w, m.conf.codec, m.copyEntries(time.Now().UnixNano()))
+}
+
+// LoadSnapshot restores keys saved by SaveSnapshot, keys existing in both are overwritten.
+// Nothing is restored if the snapshot is corrupted.
+func (m *Map) LoadSnapshot(r io.Reader) error {
+	entries, e := ReadSnapshot(r, m.conf.codec)
+	if e != nil {
+		return errorx.Wrap(e)
+	}
+	for _, entry := range entries {
+		m.restore(entry)
+	}
+	return nil
+}
+
+// readableWrapedBymodl returns the register serving reads in current mode.
+// Caller must hold m.modl.
+func (m *Map) readableWrapedBymodl() (*sync.RWMutex, map[string]Value) {
+	if m.isFree2WrapedBymodl() {
+		return m.l, m.m
+	}
+	return m.dl, m.dirty
+}
+
+// copyEntries copies unexpired values under read locks
+func (m *Map) copyEntries(now int64) []SnapshotEntry {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	l, mp := m.readableWrapedBymodl()
+	l.RLock()
+	defer l.RUnlock()
+
+	return copyEntries(mp, now)
+}
+
+// copyEntries copies unexpired values of mp, caller must hold lock of mp
+func copyEntries(mp map[string]Value, now int64) []SnapshotEntry {
+	var entries = make([]SnapshotEntry, 0, len(mp))
+	for k, v := range mp {
+		entry := SnapshotEntry{Key: k, Value: v.v, TTL: -1, Offset: v.offset}
+		if v.exp != -1 {
+			if v.exp <= now {
+				continue
+			}
+			entry.TTL = time.Duration(v.exp - now)
+		}
+		entry.Kind, entry.Value = exportValue(v.v)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// exportValue copies a saved value to what snapshots and aof save, compound types are copied to plain ones
+func exportValue(v interface{}) (ValueKind, interface{}) {
+	switch t := v.(type) {
+	case *clist:
+		return KIND_LIST, t.values()
+	case *chash:
+		return KIND_HASH, t.getAll()
+	case *cset:
+		members := t.members()
+		sort.Strings(members)
+		return KIND_SET, members
+	case *czset:
+		return KIND_ZSET, t.all()
+	case *cdelay:
+		return KIND_DELAY, t.all()
+	}
+	return KIND_VALUE, v
+}
+
+// importValue makes a value to save from what exportValue returns
+func importValue(kind ValueKind, value interface{}) interface{} {
+	switch kind {
+	case KIND_LIST:
+		list := newclist()
+		if elems, ok := value.([]interface{}); ok {
+			list.RPushN(elems...)
+		}
+		return list
+	case KIND_HASH:
+		h := newchash()
+		if fields, ok := value.(map[string]interface{}); ok {
+			for field, v := range fields {
+				h.set(field, v)
+			}
+		}
+		return h
+	case KIND_SET:
+		s := newcset()
+		if members, ok := value.([]string); ok {
+			for _, member := range members {
+				s.add(member)
+			}
+		}
+		return s
+	case KIND_ZSET:
+		z := newczset()
+		if zs, ok := value.([]Z); ok {
+			for _, member := range zs {
+				z.add(member.Member, member.Score, 0, false)
+			}
+		}
+		return z
+	case KIND_DELAY:
+		d := newcdelay()
+		if items, ok := value.([]DelayItem); ok {
+			for _, item := range items {
+				d.push(item.Value, item.Due.UnixNano())
+			}
+		}
+		return d
+	}
+	return value
+}
+
+// restore sets a snapshot entry with its saved offset
+func (m *Map) restore(entry SnapshotEntry) {
+	value := importValue(entry.Kind, entry.Value)
+
+	m.offsetAtLeast(entry.Offset)
+	m.setWithOffset(entry.Key, value, expOfDuration(entry.TTL), false, entry.Offset)
+}
+
+// SaveSnapshot writes keys of all slots to w, see Map.SaveSnapshot.
+// All slots are copied at the same point of time.
+func (mv2 *MapV2) SaveSnapshot(w io.Writer) error {
+	return WriteSnapshot(w, mv2.slots[0].conf.codec, mv2.copyEntries(time.Now().UnixNano()))
+}
+
+// copyEntries copies unexpired values of all slots at the same point of time
+func (mv2 *MapV2) copyEntries(now int64) []SnapshotEntry {
+	var locks = make([]*sync.RWMutex, len(mv2.slots))
+	var registers = make([]map[string]Value, len(mv2.slots))
+
+	for i, _ := range mv2.slots {
+		mv2.slots[i].modl.RLock()
+	}
+	for i, _ := range mv2.slots {
+		locks[i], registers[i] = mv2.slots[i].readableWrapedBymodl()
+		locks[i].RLock()
+	}
+
+	var entries []SnapshotEntry
+	for i, _ := range registers {
+		entries = append(entries, copyEntries(registers[i], now)...)
+	}
+
+	for i, _ := range mv2.slots {
+		locks[i].RUnlock()
+		mv2.slots[i].modl.RUnlock()
+	}
+	return entries
+}
+
+// LoadSnapshot restores keys saved by Map.SaveSnapshot or MapV2.SaveSnapshot.
+// Keys are hashed again, so slot number can differ from the saving one.
+func (mv2 *MapV2) LoadSnapshot(r io.Reader) error {
+	entries, e := ReadSnapshot(r, mv2.slots[0].conf.codec)
+	if e != nil {
+		return errorx.Wrap(e)
+	}
+	for _, entry := range entries {
+		mv2.getslot(entry.Key).restore(entry)
+	}
+	return nil
+}
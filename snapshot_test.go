@@ -0,0 +1,186 @@
+package cmap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type snapshotUser struct {
+	Name string
+	Age  int
+}
+
+func init() {
+	gob.Register(snapshotUser{})
+}
+
+func TestSnapshot(t *testing.T) {
+	m := NewMap()
+	m.Set("name", "cmap")
+	m.Set("count", 10)
+	m.Set("user", snapshotUser{Name: "tom", Age: 18})
+	m.SetTTL("session", "abc", time.Hour)
+	m.SetTTL("expired", "abc", time.Millisecond)
+	m.RPushEX("list", "a", 100)
+	m.RPushEX("list", 2, 100)
+
+	time.Sleep(2 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if e := m.SaveSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+
+	m2 := NewMap()
+	if e := m2.LoadSnapshot(bytes.NewReader(buf.Bytes())); e != nil {
+		t.Fatal(e)
+	}
+
+	if v, _ := m2.Get("name"); v != "cmap" {
+		t.Fatalf("want cmap but got %v", v)
+	}
+	if v, _ := m2.Get("count"); v != 10 {
+		t.Fatalf("want 10 but got %v", v)
+	}
+	if v, _ := m2.Get("user"); v != (snapshotUser{Name: "tom", Age: 18}) {
+		t.Fatalf("want user tom but got %v", v)
+	}
+	if _, ok := m2.Get("expired"); ok {
+		t.Fatalf("expired key should not be saved")
+	}
+	if ttl := m2.PTTL("session"); ttl <= 0 || ttl > time.Hour.Milliseconds() {
+		t.Fatalf("ttl of session should be kept but got %d", ttl)
+	}
+	if ttl := m2.TTL("name"); ttl != TTL_NO_EXPIRE {
+		t.Fatalf("name should have no time limit but got %d", ttl)
+	}
+
+	v, _ := m2.Get("list")
+	list, ok := v.(*clist)
+	if !ok {
+		t.Fatalf("list should be restored as list but got %T", v)
+	}
+	if elems := list.LRange(0, 10); len(elems) != 2 || elems[0] != "a" || elems[1] != 2 {
+		t.Fatalf("want [a 2] but got %v", elems)
+	}
+
+	// new operations have bigger offsets than restored values
+	if m2.offset < m.offset-1 {
+		t.Fatalf("offset should be restored, want at least %d but got %d", m.offset-1, m2.offset)
+	}
+}
+
+func TestSnapshotCorrupted(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 10; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	var buf bytes.Buffer
+	if e := m.SaveSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+
+	data := buf.Bytes()
+	data[len(data)/2] ^= 0xff
+
+	m2 := NewMap()
+	if e := m2.LoadSnapshot(bytes.NewReader(data)); e == nil {
+		t.Fatalf("corrupted snapshot should fail")
+	}
+	if m2.Len() != 0 {
+		t.Fatalf("nothing should be restored from a corrupted snapshot")
+	}
+
+	if e := m2.LoadSnapshot(bytes.NewReader(data[:len(data)-2])); e == nil {
+		t.Fatalf("truncated snapshot should fail")
+	}
+	if e := m2.LoadSnapshot(bytes.NewReader([]byte("REDIS0009"))); e == nil {
+		t.Fatalf("not a cmap snapshot should fail")
+	}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Decode(data []byte) (interface{}, error) {
+	var v interface{}
+	e := json.Unmarshal(data, &v)
+	return v, e
+}
+
+func TestSnapshotCodec(t *testing.T) {
+	m := NewMap(WithCodec(jsonCodec{}))
+	m.Set("user", map[string]interface{}{"name": "tom"})
+
+	var buf bytes.Buffer
+	if e := m.SaveSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+
+	entries, e := ReadSnapshot(bytes.NewReader(buf.Bytes()), jsonCodec{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 1 || entries[0].Key != "user" {
+		t.Fatalf("want 1 entry of user but got %v", entries)
+	}
+	if user, ok := entries[0].Value.(map[string]interface{}); !ok || user["name"] != "tom" {
+		t.Fatalf("want user tom but got %v", entries[0].Value)
+	}
+}
+
+func TestSnapshotInBusyMode(t *testing.T) {
+	m := NewMap()
+	m.Set("a", 1)
+
+	m.setBusy()
+	m.Set("b", 2)
+
+	var buf bytes.Buffer
+	if e := m.SaveSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+	m.setFree2()
+
+	entries, e := ReadSnapshot(&buf, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("want 2 entries in busy mode but got %d", len(entries))
+	}
+}
+
+func TestMapV2Snapshot(t *testing.T) {
+	m := NewMapV2(nil, 8, 5*time.Minute)
+	defer m.Clear()
+
+	for i := 0; i < 100; i++ {
+		m.SetEx(strconv.Itoa(i), i, 100)
+	}
+
+	var buf bytes.Buffer
+	if e := m.SaveSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+
+	// slot number can differ from the saving map
+	m2 := NewMapV2(nil, 3, 5*time.Minute)
+	defer m2.Clear()
+	if e := m2.LoadSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := m2.Get(strconv.Itoa(i)); !ok || v != i {
+			t.Fatalf("want %d but got %v", i, v)
+		}
+	}
+}
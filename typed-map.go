@@ -0,0 +1,426 @@
+package cmap
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// typedValue is the generic counterpart of Value.
+type typedValue[V any] struct {
+	// value
+	v V
+	// unixnano the value will be expired at, -1 means no time limit
+	exp int64
+
+	// TypedMap's offset, when map exec set/delete, offset++
+	offset int64
+	// generated time when a value is set, unixnano
+	execAt int64
+}
+
+// v is latter than v2 in time
+func (v typedValue[V]) latterThan(v2 typedValue[V]) bool {
+	if v.execAt > v2.execAt {
+		return true
+	}
+
+	if v.execAt < v2.execAt {
+		return false
+	}
+
+	return v.offset > v2.offset
+}
+
+// v is former than v2 in time
+func (v typedValue[V]) formerThan(v2 typedValue[V]) bool {
+	if v.execAt < v2.execAt {
+		return true
+	}
+	if v.execAt > v2.execAt {
+		return false
+	}
+	return v.offset < v2.offset
+}
+
+func (v typedValue[V]) isExpire() bool {
+	if v.exp == -1 {
+		return false
+	}
+
+	return time.Now().UnixNano() >= v.exp
+}
+
+// TypedMap is the type-parameterized version of Map.
+// It shares the same m, dirty, write, del registers and the same M_FREE1/M_FREE2/M_BUSY mode machine with Map,
+// while keys are any comparable type and values are typed, so no type assertion is needed after Get.
+//
+//    m := cmap.NewTypedMap[int64, User]()
+//    m.SetEx(user.Id, user, 60)
+//    u, ok := m.Get(user.Id)
+type TypedMap[K comparable, V any] struct {
+	clearing int32
+
+	deltal *sync.RWMutex // 在增量复制时的锁
+
+	modl *sync.RWMutex
+	mode int
+
+	l *sync.RWMutex
+	m map[K]typedValue[V]
+
+	dl    *sync.RWMutex
+	dirty map[K]typedValue[V]
+
+	wl    *sync.RWMutex
+	write map[K]typedValue[V]
+
+	dll    *sync.RWMutex
+	offset int64
+	del    map[K]typedValue[V]
+}
+
+// new a concurrent typed map
+func NewTypedMap[K comparable, V any]() *TypedMap[K, V] {
+	return &TypedMap[K, V]{
+		deltal: &sync.RWMutex{},
+		modl:   &sync.RWMutex{},
+		mode:   M_FREE2,
+
+		l: &sync.RWMutex{},
+		m: make(map[K]typedValue[V]),
+
+		dl:    &sync.RWMutex{},
+		dirty: make(map[K]typedValue[V]),
+
+		wl:    &sync.RWMutex{},
+		write: make(map[K]typedValue[V]),
+
+		dll: &sync.RWMutex{},
+		del: make(map[K]typedValue[V]),
+	}
+}
+
+func (m *TypedMap[K, V]) IsBusy() bool {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	return m.mode == M_BUSY
+}
+
+func (m *TypedMap[K, V]) IsFree1() bool {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	return m.mode == M_FREE1
+}
+
+func (m *TypedMap[K, V]) IsFree2() bool {
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	return m.mode == M_FREE2
+}
+
+// set,del,setnx,setex will increase map.offset.
+// When offset reaches max int64 value, will be back to 0
+func (m *TypedMap[K, V]) offsetIncr() int64 {
+	atomic.CompareAndSwapInt64(&m.offset, math.MaxInt64-10000, 0)
+	return atomic.AddInt64(&m.offset, 1)
+}
+
+// map.Set
+func (m *TypedMap[K, V]) Set(key K, value V) {
+	m.set(key, value, -1, false)
+}
+
+// map.SetEx
+// key-value will be put with expired time limit.
+// If seconds are set -1, value will not be expired
+func (m *TypedMap[K, V]) SetEx(key K, value V, seconds int) {
+	m.set(key, value, expOfSeconds(seconds), false)
+}
+
+// map.SetNx
+// If key exist, do nothing, otherwise set key,value into map
+func (m *TypedMap[K, V]) SetNx(key K, value V) {
+	m.set(key, value, -1, true)
+}
+
+// map.SetExNx
+// If key exist, do nothing, otherwise set key,value into map with expired time limit
+func (m *TypedMap[K, V]) SetExNx(key K, value V, seconds int) {
+	m.set(key, value, expOfSeconds(seconds), true)
+}
+
+// map.SetTTL
+// key-value will be put with expired time limit in nanosecond precision.
+// If ttl < 0, value will not be expired.
+func (m *TypedMap[K, V]) SetTTL(key K, value V, ttl time.Duration) {
+	m.set(key, value, expOfDuration(ttl), false)
+}
+
+// map.SetExpireAt
+// key-value will be expired at the deadline. If deadline is zero, value will not be expired.
+func (m *TypedMap[K, V]) SetExpireAt(key K, value V, deadline time.Time) {
+	m.set(key, value, expOfTime(deadline), false)
+}
+
+func (m *TypedMap[K, V]) set(key K, value V, exp int64, nx bool) {
+	ext := time.Now().UnixNano()
+	offset := m.offsetIncr()
+
+	newValue := typedValue[V]{
+		v:   value,
+		exp: exp,
+
+		execAt: ext,
+		offset: offset,
+	}
+
+	// 发生set时，不会出现状态切换
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	// free2 时，写入m，写入dir
+	if m.mode == M_FREE2 {
+		typedSetm(m.l, m.m, key, newValue, nx)
+		typedSetm(m.dl, m.dirty, key, newValue, nx)
+		return
+	}
+
+	// 同步中时，写dir，阻塞m
+	if m.mode == M_FREE1 {
+		m.deltal.RLock()
+		typedSetm(m.l, m.m, key, newValue, nx)
+		m.deltal.RUnlock()
+		typedSetm(m.dl, m.dirty, key, newValue, nx)
+		return
+	}
+
+	// busy时，写入dir和write
+	typedSetm(m.dl, m.dirty, key, newValue, nx)
+	typedSetm(m.wl, m.write, key, newValue, nx)
+}
+
+// If key is expired or not existed, return zero value and false
+func (m *TypedMap[K, V]) Get(key K) (V, bool) {
+	m.modl.RLock()
+
+	// m free时，读取m
+	if m.mode == M_FREE2 {
+		defer m.modl.RUnlock()
+		return typedGetFrom(m.l, m.m, key)
+	}
+
+	// m 繁忙或迁移中时，读取dirty
+	m.modl.RUnlock()
+	return typedGetFrom(m.dl, m.dirty, key)
+}
+
+func (m *TypedMap[K, V]) Delete(key K) {
+	offset := m.offsetIncr()
+	ext := time.Now().UnixNano()
+
+	m.modl.RLock()
+	defer m.modl.RUnlock()
+
+	if m.mode == M_FREE2 {
+		typedDeletem(m.l, m.m, key)
+		typedDeletem(m.dl, m.dirty, key)
+		return
+	}
+
+	if m.mode == M_FREE1 {
+		m.deltal.RLock()
+		typedDeletem(m.l, m.m, key)
+		m.deltal.RUnlock()
+		typedDeletem(m.dl, m.dirty, key)
+		return
+	}
+
+	// busy时，要删除dir, 并且追加命令进del
+	typedDeletem(m.dl, m.dirty, key)
+	typedSetm(m.dll, m.del, key, typedValue[V]{exp: -1, execAt: ext, offset: offset}, false)
+}
+
+// Returns TypedMap.m real length, not m.dirty or m.write.
+func (m *TypedMap[K, V]) Len() int {
+	m.l.RLock()
+	length := len(m.m)
+	m.l.RUnlock()
+	return length
+}
+
+// range function returns bool value
+// if false,  will stop range process
+func (m *TypedMap[K, V]) Range(f func(key K, value V) bool) {
+	m.modl.RLock()
+	if m.mode == M_FREE2 {
+		m.modl.RUnlock()
+		typedRangem(m.l, m.m, f)
+		return
+	}
+	m.modl.RUnlock()
+	typedRangem(m.dl, m.dirty, f)
+}
+
+// ClearExpireKeys clear expired keys, and it will not influence map write and read.
+// It works exactly like Map.ClearExpireKeys.
+func (m *TypedMap[K, V]) ClearExpireKeys() int {
+	// 利用atomic，确保高并发下，只会有一个ClearExpireKeys被执行
+	v := atomic.AddInt32(&m.clearing, 1)
+	defer atomic.AddInt32(&m.clearing, -1)
+
+	if v != 1 {
+		return 0
+	}
+
+	m.modl.Lock()
+	m.mode = M_BUSY
+	m.modl.Unlock()
+
+	n := typedClearExpire(m.l, m.m)
+
+	m.modl.Lock()
+	m.mode = M_FREE1
+
+	m.deltal.Lock()
+	m.l.Lock()
+
+	// sync written operation from TypedMap.write
+	m.wl.RLock()
+	for k, v := range m.write {
+		v2, ok := m.m[k]
+		if ok && v2.latterThan(v) {
+			continue
+		}
+		m.m[k] = v
+	}
+	m.wl.RUnlock()
+
+	// sync deleted operation from TypedMap.del
+	m.dll.RLock()
+	for k, v := range m.del {
+		v2, ok := m.m[k]
+		if !ok {
+			continue
+		}
+		if v.latterThan(v2) {
+			delete(m.m, k)
+		}
+	}
+	m.dll.RUnlock()
+
+	m.l.Unlock()
+	m.deltal.Unlock()
+
+	m.mode = M_FREE2
+	m.modl.Unlock()
+
+	// 进入free2时，清理write和del,dir
+	m.dll.Lock()
+	m.del = make(map[K]typedValue[V])
+	m.dll.Unlock()
+
+	m.wl.Lock()
+	m.write = make(map[K]typedValue[V])
+	m.wl.Unlock()
+
+	typedClearExpire(m.dl, m.dirty)
+
+	return n
+}
+
+func typedGetFrom[K comparable, V any](l *sync.RWMutex, m map[K]typedValue[V], key K) (V, bool) {
+	var zero V
+
+	l.RLock()
+	value, ok := m[key]
+	l.RUnlock()
+
+	if !ok {
+		return zero, false
+	}
+
+	if value.isExpire() {
+		l.Lock()
+		// re-check, the key might be refreshed between RUnlock and Lock
+		if value, ok = m[key]; ok && value.isExpire() {
+			delete(m, key)
+		}
+		l.Unlock()
+		return zero, false
+	}
+
+	return value.v, true
+}
+
+func typedSetm[K comparable, V any](l *sync.RWMutex, m map[K]typedValue[V], key K, newValue typedValue[V], nx bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	v, exist := m[key]
+
+	// 不存在或已失效时，设置新值
+	if !exist || v.isExpire() {
+		m[key] = newValue
+		return
+	}
+
+	// 当未过期，并且已存在时，nx不操作
+	if nx {
+		return
+	}
+
+	// 比新key后执行，则设置新值
+	if v.formerThan(newValue) {
+		m[key] = newValue
+	}
+}
+
+func typedDeletem[K comparable, V any](l *sync.RWMutex, m map[K]typedValue[V], key K) {
+	l.Lock()
+	delete(m, key)
+	l.Unlock()
+}
+
+func typedRangem[K comparable, V any](l *sync.RWMutex, m map[K]typedValue[V], f func(key K, value V) bool) {
+	l.RLock()
+	defer l.RUnlock()
+
+	for k, v := range m {
+		if v.isExpire() {
+			continue
+		}
+		if !f(k, v.v) {
+			break
+		}
+	}
+}
+
+func typedClearExpire[K comparable, V any](l *sync.RWMutex, m map[K]typedValue[V]) int {
+	var shouldDelete = make([]K, 0, 10)
+
+	l.RLock()
+	for k, v := range m {
+		if v.isExpire() {
+			shouldDelete = append(shouldDelete, k)
+		}
+	}
+	l.RUnlock()
+
+	var n int
+	l.Lock()
+	for _, k := range shouldDelete {
+		// re-check, the key might be refreshed after scanning
+		if v, ok := m[k]; ok && v.isExpire() {
+			delete(m, k)
+			n++
+		}
+	}
+	l.Unlock()
+
+	return n
+}
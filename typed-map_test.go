@@ -0,0 +1,96 @@
+package cmap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTypedMap(t *testing.T) {
+	m := NewTypedMap[int64, string]()
+	m.Set(1, "fengtao")
+
+	v, ok := m.Get(1)
+	if !ok || v != "fengtao" {
+		t.Fatalf("set api incorrect, got %v %v", v, ok)
+	}
+
+	m.SetEx(2, "ex", 1)
+	m.SetExNx(2, "xxx", 9)
+	if v, _ = m.Get(2); v != "ex" {
+		t.Fatalf("setexnx api incorrect, got %v", v)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if _, ok = m.Get(2); ok {
+		t.Fatalf("setex api incorrect, key should be expired")
+	}
+
+	m.Delete(1)
+	if _, ok = m.Get(1); ok {
+		t.Fatalf("delete api incorrect")
+	}
+}
+
+func TestTypedMapClearExpireKeys(t *testing.T) {
+	m := NewTypedMap[string, int]()
+
+	for i := 0; i < 1000; i++ {
+		m.SetEx("ex-"+strconv.Itoa(i), i, 1)
+		m.Set("key-"+strconv.Itoa(i), i)
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		m.ClearExpireKeys()
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			m.Set("key-"+strconv.Itoa(i), i+1)
+			m.Delete("ex-" + strconv.Itoa(i))
+		}
+	}()
+	wg.Wait()
+	m.ClearExpireKeys()
+
+	if m.Len() != 1000 {
+		t.Fatalf("clear irc, want 1000 but got %d", m.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		v, ok := m.Get("key-" + strconv.Itoa(i))
+		if !ok || v != i+1 {
+			t.Fatalf("busy write lost, key-%d got %v %v", i, v, ok)
+		}
+	}
+}
+
+func TestTypedMapV2(t *testing.T) {
+	m := NewTypedMapV2[int64, string](nil, 8, 5*time.Minute)
+	defer m.Clear()
+
+	for i := int64(0); i < 100; i++ {
+		m.Set(i, strconv.FormatInt(i, 10))
+	}
+
+	var n int
+	m.Range(func(key int64, value string) bool {
+		if strconv.FormatInt(key, 10) != value {
+			t.Fatalf("range got %d=%s", key, value)
+		}
+		n++
+		return true
+	})
+	if n != 100 {
+		t.Fatalf("range want 100 but got %d", n)
+	}
+
+	m.Delete(10)
+	if _, ok := m.Get(10); ok {
+		t.Fatalf("delete api incorrect")
+	}
+}
@@ -0,0 +1,131 @@
+package cmap
+
+import (
+	"fmt"
+	"time"
+)
+
+// TypedMapV2 is the type-parameterized version of MapV2, a combination of <hash, TypedMap>.
+// Keys will first get hashed and then decide to read/write which slot.
+type TypedMapV2[K comparable, V any] struct {
+	hash  func(K) int64 // default hash is crc16 mechanism
+	slots []*TypedMap[K, V]
+	len   int
+
+	clear chan struct{} // close mapv2 will send clear to finish mapd goroutine
+}
+
+// NewTypedMapV2 new a typed mapv2 with slotNum slots, expired keys of each slot will be cleared every intervald.
+// If hash is nil, keys will be hashed by crc16 of its string form.
+func NewTypedMapV2[K comparable, V any](hash func(K) int64, slotNum int, intervald time.Duration) *TypedMapV2[K, V] {
+	var mv2 = &TypedMapV2[K, V]{
+		hash:  hash,
+		slots: make([]*TypedMap[K, V], slotNum, slotNum),
+		len:   slotNum,
+		clear: make(chan struct{}, 1),
+	}
+
+	for i, _ := range mv2.slots {
+		mv2.slots[i] = NewTypedMap[K, V]()
+	}
+
+	if mv2.hash == nil {
+		mv2.hash = defaultTypedHash[K]
+	}
+
+	mv2.mapd(intervald)
+	return mv2
+}
+
+func defaultTypedHash[K comparable](key K) int64 {
+	switch k := any(key).(type) {
+	case string:
+		return int64(UsMBCRC16([]byte(k)))
+	case int:
+		return int64(k & 0x7fffffff)
+	case int64:
+		return k & 0x7fffffff
+	case int32:
+		return int64(k & 0x7fffffff)
+	case uint:
+		return int64(k & 0x7fffffff)
+	case uint64:
+		return int64(k & 0x7fffffff)
+	case uint32:
+		return int64(k & 0x7fffffff)
+	}
+	return int64(UsMBCRC16([]byte(fmt.Sprintf("%v", key))))
+}
+
+func (mv2 *TypedMapV2[K, V]) Clear() {
+	mv2.clear <- struct{}{}
+}
+
+func (mv2 *TypedMapV2[K, V]) getslot(key K) *TypedMap[K, V] {
+	return mv2.slots[mv2.hash(key)%int64(mv2.len)]
+}
+
+func (mv2 *TypedMapV2[K, V]) Set(key K, value V) {
+	mv2.getslot(key).Set(key, value)
+}
+
+func (mv2 *TypedMapV2[K, V]) SetEx(key K, value V, seconds int) {
+	mv2.getslot(key).SetEx(key, value, seconds)
+}
+
+func (mv2 *TypedMapV2[K, V]) SetNx(key K, value V) {
+	mv2.getslot(key).SetNx(key, value)
+}
+
+func (mv2 *TypedMapV2[K, V]) SetExNx(key K, value V, seconds int) {
+	mv2.getslot(key).SetExNx(key, value, seconds)
+}
+
+func (mv2 *TypedMapV2[K, V]) SetTTL(key K, value V, ttl time.Duration) {
+	mv2.getslot(key).SetTTL(key, value, ttl)
+}
+
+func (mv2 *TypedMapV2[K, V]) SetExpireAt(key K, value V, deadline time.Time) {
+	mv2.getslot(key).SetExpireAt(key, value, deadline)
+}
+
+func (mv2 *TypedMapV2[K, V]) Get(key K) (V, bool) {
+	return mv2.getslot(key).Get(key)
+}
+
+func (mv2 *TypedMapV2[K, V]) Delete(key K) {
+	mv2.getslot(key).Delete(key)
+}
+
+// range all slots, if f returns false, will stop range process
+func (mv2 *TypedMapV2[K, V]) Range(f func(key K, value V) bool) {
+	var stop bool
+	for i, _ := range mv2.slots {
+		mv2.slots[i].Range(func(key K, value V) bool {
+			if !f(key, value) {
+				stop = true
+				return false
+			}
+			return true
+		})
+		if stop {
+			return
+		}
+	}
+}
+
+// keep
+func (mv2 *TypedMapV2[K, V]) mapd(interval time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-time.After(interval):
+				for i, _ := range mv2.slots {
+					mv2.slots[i].ClearExpireKeys()
+				}
+			case <-mv2.clear:
+				return
+			}
+		}
+	}()
+}
@@ -1,6 +1,9 @@
 package cmap
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 var (
 	aucCRCHi = []byte{
@@ -139,3 +142,125 @@ func Int64(i interface{}) int64 {
 		return 0
 	}
 }
+
+// Types of value returned by Type, the same as redis TYPE command.
+const (
+	TYPE_NONE   = "none"
+	TYPE_STRING = "string"
+	TYPE_LIST   = "list"
+	TYPE_HASH   = "hash"
+	TYPE_SET    = "set"
+	TYPE_ZSET   = "zset"
+	TYPE_DELAY  = "delay"
+)
+
+// TypeOf returns type of a value got from the map, like Type does for a key. Values not compound are all TYPE_STRING.
+// It lets callers holding a value check its type without looking the key up again.
+func TypeOf(v interface{}) string {
+	switch v.(type) {
+	case *clist:
+		return TYPE_LIST
+	case *chash:
+		return TYPE_HASH
+	case *cset:
+		return TYPE_SET
+	case *czset:
+		return TYPE_ZSET
+	case *cdelay:
+		return TYPE_DELAY
+	}
+	return TYPE_STRING
+}
+
+// globMatch reports whether key matches redis-style glob pattern.
+// Pattern supports '*', '?', '[abc]', '[^a]', '[a-z]' and '\\' escaping, an empty pattern matches all keys.
+func globMatch(pattern string, key string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// merge continuous '*'
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if globMatch(pattern, key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+		case '[':
+			if len(key) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				// no closing ']', treat '[' as a normal char
+				if key[0] != '[' {
+					return false
+				}
+				pattern, key = pattern[1:], key[1:]
+				continue
+			}
+			class := pattern[1 : end+1]
+			pattern = pattern[end+2:]
+
+			negative := false
+			if len(class) > 0 && class[0] == '^' {
+				negative = true
+				class = class[1:]
+			}
+
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if class[i] == '\\' && i+1 < len(class) {
+					i++
+					if class[i] == key[0] {
+						matched = true
+					}
+					continue
+				}
+				if i+2 < len(class) && class[i+1] == '-' {
+					lo, hi := class[i], class[i+2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					if key[0] >= lo && key[0] <= hi {
+						matched = true
+					}
+					i += 2
+					continue
+				}
+				if class[i] == key[0] {
+					matched = true
+				}
+			}
+			if matched == negative {
+				return false
+			}
+			key = key[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(key) == 0 || pattern[0] != key[0] {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+		}
+	}
+	return len(key) == 0
+}
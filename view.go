@@ -0,0 +1,184 @@
+package cmap
+
+import (
+	"io"
+	"iter"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Snapshot is an immutable view of a map at a point of time, made by Map.Snapshot or MapV2.Snapshot.
+// Expired keys are not in it, and it never changes however the map changes, so it can be iterated, saved and diffed freely.
+// Values are not deep copied, pointers saved in map still point to the same objects.
+// Lists, hashes, sets, zsets and delay queues are returned as fresh copies, changing them doesn't change the snapshot.
+type Snapshot struct {
+	at    time.Time
+	codec Codec
+
+	// sorted by key
+	entries []SnapshotEntry
+	index   map[string]int
+}
+
+func newSnapshot(at time.Time, codec Codec, entries []SnapshotEntry) *Snapshot {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+
+	s := &Snapshot{
+		at:      at,
+		codec:   codec,
+		entries: entries,
+		index:   make(map[string]int, len(entries)),
+	}
+	for i, _ := range entries {
+		s.index[entries[i].Key] = i
+	}
+	return s
+}
+
+// Snapshot copies unexpired keys at a point of time.
+// Unlike Range, the copy is made under lock of mode, so ClearExpireKeys switching modes makes no difference.
+// Lists are copied as []interface{}.
+func (m *Map) Snapshot() *Snapshot {
+	now := time.Now()
+	return newSnapshot(now, m.conf.codec, m.copyEntries(now.UnixNano()))
+}
+
+// Snapshot copies unexpired keys of all slots at the same point of time, see Map.Snapshot.
+func (mv2 *MapV2) Snapshot() *Snapshot {
+	now := time.Now()
+	return newSnapshot(now, mv2.slots[0].conf.codec, mv2.copyEntries(now.UnixNano()))
+}
+
+// At returns when the snapshot was made.
+func (s *Snapshot) At() time.Time {
+	return s.at
+}
+
+// Len returns number of keys.
+func (s *Snapshot) Len() int {
+	return len(s.entries)
+}
+
+// Get returns value of key, a copy of []interface{} for a list, see SnapshotEntry for other compound types.
+func (s *Snapshot) Get(key string) (interface{}, bool) {
+	entry, ok := s.Entry(key)
+	return entry.Value, ok
+}
+
+// Entry returns key, value, remaining ttl and offset of key at the time of snapshot.
+func (s *Snapshot) Entry(key string) (SnapshotEntry, bool) {
+	i, ok := s.index[key]
+	if !ok {
+		return SnapshotEntry{}, false
+	}
+	return s.entries[i].clone(), true
+}
+
+// Entries returns a copy of all entries sorted by key.
+func (s *Snapshot) Entries() []SnapshotEntry {
+	var entries = make([]SnapshotEntry, len(s.entries))
+	for i, _ := range s.entries {
+		entries[i] = s.entries[i].clone()
+	}
+	return entries
+}
+
+// clone copies the slice or map holding elements of a compound kind, so callers can't change the snapshot
+func (e SnapshotEntry) clone() SnapshotEntry {
+	if e.Kind == KIND_VALUE {
+		return e
+	}
+	switch v := e.Value.(type) {
+	case []interface{}:
+		e.Value = slices.Clone(v)
+	case map[string]interface{}:
+		e.Value = maps.Clone(v)
+	case []string:
+		e.Value = slices.Clone(v)
+	case []Z:
+		e.Value = slices.Clone(v)
+	case []DelayItem:
+		e.Value = slices.Clone(v)
+	}
+	return e
+}
+
+// All returns an iterator over key-values sorted by key, compound values are copied like Get.
+func (s *Snapshot) All() iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		for i, _ := range s.entries {
+			if !yield(s.entries[i].Key, s.entries[i].clone().Value) {
+				return
+			}
+		}
+	}
+}
+
+// AllKeys returns an iterator over keys sorted.
+func (s *Snapshot) AllKeys() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for i, _ := range s.entries {
+			if !yield(s.entries[i].Key) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns an iterator over values sorted by key.
+func (s *Snapshot) Values() iter.Seq[interface{}] {
+	return func(yield func(interface{}) bool) {
+		for i, _ := range s.entries {
+			if !yield(s.entries[i].clone().Value) {
+				return
+			}
+		}
+	}
+}
+
+// Save writes the snapshot to w by codec of the map, it can be loaded by LoadSnapshot and read by ReadSnapshot.
+func (s *Snapshot) Save(w io.Writer) error {
+	return WriteSnapshot(w, s.codec, s.entries)
+}
+
+// SnapshotDiff is keys changed between two snapshots, all sorted.
+type SnapshotDiff struct {
+	Added   []string
+	Removed []string
+	// values differ, changes of ttl only are not counted
+	Changed []string
+}
+
+// Empty reports whether nothing changed.
+func (d SnapshotDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Diff compares s with a newer snapshot, values are compared by reflect.DeepEqual.
+func (s *Snapshot) Diff(newer *Snapshot) SnapshotDiff {
+	var d SnapshotDiff
+
+	olds, news := s.entries, newer.entries
+	var i, j int
+	for i < len(olds) || j < len(news) {
+		switch {
+		case j >= len(news) || (i < len(olds) && olds[i].Key < news[j].Key):
+			d.Removed = append(d.Removed, olds[i].Key)
+			i++
+		case i >= len(olds) || news[j].Key < olds[i].Key:
+			d.Added = append(d.Added, news[j].Key)
+			j++
+		default:
+			if olds[i].Kind != news[j].Kind || !reflect.DeepEqual(olds[i].Value, news[j].Value) {
+				d.Changed = append(d.Changed, news[j].Key)
+			}
+			i, j = i+1, j+1
+		}
+	}
+	return d
+}
@@ -0,0 +1,182 @@
+package cmap
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotView(t *testing.T) {
+	m := NewMap()
+	m.Set("a", 1)
+	m.SetEx("b", "b", 100)
+	m.RPush("list", 1, 2)
+	m.SetTTL("expired", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	s := m.Snapshot()
+
+	// later changes are not seen
+	m.Set("a", 2)
+	m.Set("c", 3)
+	m.RPush("list", 3)
+
+	if s.Len() != 3 {
+		t.Fatalf("want 3 keys but got %d", s.Len())
+	}
+	if v, _ := s.Get("a"); v != 1 {
+		t.Fatalf("want 1 but got %v", v)
+	}
+	if _, ok := s.Get("expired"); ok {
+		t.Fatalf("expired key should not be in snapshot")
+	}
+	if v, _ := s.Get("list"); len(v.([]interface{})) != 2 {
+		t.Fatalf("list should be copied, got %v", v)
+	}
+	if entry, _ := s.Entry("b"); entry.TTL <= 99*time.Second || entry.TTL > 100*time.Second {
+		t.Fatalf("unexpected ttl %v", entry.TTL)
+	}
+
+	var keys []string
+	for k := range s.AllKeys() {
+		keys = append(keys, k)
+	}
+	if len(keys) != 3 || keys[0] != "a" || keys[1] != "b" || keys[2] != "list" {
+		t.Fatalf("keys should be sorted, got %v", keys)
+	}
+
+	var buf bytes.Buffer
+	if e := s.Save(&buf); e != nil {
+		t.Fatal(e)
+	}
+	m2 := NewMap()
+	if e := m2.LoadSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+	if v, _ := m2.Get("a"); v != 1 {
+		t.Fatalf("want 1 but got %v", v)
+	}
+	if n, _ := m2.LLen("list"); n != 2 {
+		t.Fatalf("want 2 but got %d", n)
+	}
+
+	d := s.Diff(m.Snapshot())
+	if len(d.Added) != 1 || d.Added[0] != "c" || len(d.Removed) != 0 || len(d.Changed) != 2 || d.Changed[0] != "a" || d.Changed[1] != "list" {
+		t.Fatalf("unexpected diff %+v", d)
+	}
+	m.Delete("b")
+	if d := s.Diff(m.Snapshot()); len(d.Removed) != 1 || d.Removed[0] != "b" {
+		t.Fatalf("unexpected diff %+v", d)
+	}
+	if d := s.Diff(s); !d.Empty() {
+		t.Fatalf("snapshot should equal itself, got %+v", d)
+	}
+}
+
+func TestSnapshotViewIsImmutable(t *testing.T) {
+	m := NewMap()
+	m.RPush("list", "a", "b")
+	m.HSet("hash", "f", 1)
+	m.SAdd("set", "x")
+	s := m.Snapshot()
+
+	v, _ := s.Get("list")
+	v.([]interface{})[0] = "changed"
+	entry, _ := s.Entry("hash")
+	entry.Value.(map[string]interface{})["f"] = "changed"
+	for _, e := range s.Entries() {
+		if members, ok := e.Value.([]string); ok {
+			members[0] = "changed"
+		}
+	}
+	for _, v := range s.All() {
+		if elems, ok := v.([]interface{}); ok {
+			elems[1] = "changed"
+		}
+	}
+
+	if v, _ := s.Get("list"); fmt.Sprint(v) != "[a b]" {
+		t.Fatalf("list in snapshot should not change, got %v", v)
+	}
+	if v, _ := s.Get("hash"); fmt.Sprint(v) != "map[f:1]" {
+		t.Fatalf("hash in snapshot should not change, got %v", v)
+	}
+	if v, _ := s.Get("set"); fmt.Sprint(v) != "[x]" {
+		t.Fatalf("set in snapshot should not change, got %v", v)
+	}
+}
+
+func TestSnapshotViewDuringClearExpireKeys(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 1000; i++ {
+		m.Set("stable:"+strconv.Itoa(i), i)
+	}
+
+	var stop = make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.ClearExpireKeys()
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				m.SetTTL("volatile:"+strconv.Itoa(i), i, time.Microsecond)
+			}
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		var stable int
+		for k, _ := range m.Snapshot().All() {
+			if k[0] == 's' {
+				stable++
+			}
+		}
+		if stable != 1000 {
+			t.Fatalf("want 1000 stable keys but got %d", stable)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestMapV2SnapshotView(t *testing.T) {
+	mv2 := NewMapV2(nil, 8, time.Minute)
+	defer mv2.Clear()
+
+	for i := 0; i < 100; i++ {
+		mv2.Set("k"+strconv.Itoa(i), i)
+	}
+	s := mv2.Snapshot()
+	mv2.Delete("k0")
+
+	if s.Len() != 100 {
+		t.Fatalf("want 100 keys but got %d", s.Len())
+	}
+	var sum int
+	for v := range s.Values() {
+		sum += v.(int)
+	}
+	if sum != 4950 {
+		t.Fatalf("want 4950 but got %d", sum)
+	}
+	if d := s.Diff(mv2.Snapshot()); len(d.Removed) != 1 || d.Removed[0] != "k0" {
+		t.Fatalf("unexpected diff %+v", d)
+	}
+}
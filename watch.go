@@ -0,0 +1,180 @@
+package cmap
+
+import (
+	"sync"
+)
+
+// EventType is type of a change watched by Watch.
+type EventType int
+
+const (
+	EVENT_SET    EventType = 1 // key is set by Set, SetEx, SetNx...
+	EVENT_DEL    EventType = 2 // key is deleted
+	EVENT_EXPIRE EventType = 3 // key is expired
+	EVENT_INCR   EventType = 4 // key is increased or decreased by Incr, IncrBy, Decr...
+	EVENT_EVICT  EventType = 5 // key is evicted by a bounded map
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EVENT_SET:
+		return "set"
+	case EVENT_DEL:
+		return "del"
+	case EVENT_EXPIRE:
+		return "expire"
+	case EVENT_INCR:
+		return "incr"
+	case EVENT_EVICT:
+		return "evict"
+	}
+	return "unknown"
+}
+
+// Event is a change of a key.
+// Events are sent after map's locks are released, events of a key might arrive out of order under concurrency,
+// use Offset to tell which is latter.
+type Event struct {
+	Type EventType
+	Key  string
+	// new value for set and incr, removed value for del, expire and evict
+	Value interface{}
+	// map's offset when the change happened
+	Offset int64
+}
+
+// WatchPolicy decides what to do when a watcher's channel is full.
+type WatchPolicy int
+
+const (
+	WATCH_DROP       WatchPolicy = 0 // drop the event
+	WATCH_BLOCK      WatchPolicy = 1 // block the writer until the watcher receives the event or cancels
+	WATCH_DISCONNECT WatchPolicy = 2 // close the watcher's channel and remove the watcher
+)
+
+const defaultWatchBuffer = 128
+
+// WithWatchPolicy sets policy for slow watchers, default WATCH_DROP.
+func WithWatchPolicy(policy WatchPolicy) MapOption {
+	return func(c *mapConfig) {
+		c.watchPolicy = policy
+	}
+}
+
+// WithWatchBuffer sets buffer size of channels returned by Watch, default 128.
+func WithWatchBuffer(n int) MapOption {
+	return func(c *mapConfig) {
+		c.watchBuffer = n
+	}
+}
+
+type watcher struct {
+	pattern string
+	policy  WatchPolicy
+
+	ch   chan Event
+	done chan struct{}
+
+	l      *sync.RWMutex
+	closed bool
+	once   *sync.Once
+
+	// removes watcher from maps
+	detach func()
+}
+
+func newWatcher(pattern string, policy WatchPolicy, buffer int) *watcher {
+	if buffer < 0 {
+		buffer = 0
+	}
+	return &watcher{
+		pattern: pattern,
+		policy:  policy,
+		ch:      make(chan Event, buffer),
+		done:    make(chan struct{}),
+		l:       &sync.RWMutex{},
+		once:    &sync.Once{},
+		detach:  func() {},
+	}
+}
+
+func (w *watcher) notify(e Event) {
+	if !globMatch(w.pattern, e.Key) {
+		return
+	}
+
+	w.l.RLock()
+	if w.closed {
+		w.l.RUnlock()
+		return
+	}
+
+	switch w.policy {
+	case WATCH_BLOCK:
+		select {
+		case w.ch <- e:
+		case <-w.done:
+		}
+	case WATCH_DISCONNECT:
+		select {
+		case w.ch <- e:
+		default:
+			w.l.RUnlock()
+			w.close()
+			return
+		}
+	default:
+		select {
+		case w.ch <- e:
+		default:
+		}
+	}
+	w.l.RUnlock()
+}
+
+func (w *watcher) close() {
+	w.once.Do(func() {
+		// wake up blocked senders first, then they release w.l
+		close(w.done)
+
+		w.l.Lock()
+		w.closed = true
+		close(w.ch)
+		w.l.Unlock()
+
+		w.detach()
+	})
+}
+
+// Watch subscribes changes of keys matching glob pattern, like redis keyspace notifications.
+// Pattern supports '*', '?', '[abc]', '[^a]', '[a-z]' and '\' escaping, an empty pattern matches all keys.
+// The returned channel is closed after cancel is called, or the watcher is disconnected by WATCH_DISCONNECT policy.
+//
+//	events, cancel := m.Watch("user:*")
+//	defer cancel()
+//	for e := range events {
+//	    fmt.Println(e.Type, e.Key, e.Value)
+//	}
+func (m *Map) Watch(pattern string) (<-chan Event, func()) {
+	w := newWatcher(pattern, m.conf.watchPolicy, m.conf.watchBuffer)
+	w.detach = func() {
+		m.hooks.removeWatcher(w)
+	}
+	m.hooks.addWatcher(w)
+	return w.ch, w.close
+}
+
+// Watch subscribes changes of keys matching glob pattern in all slots, see Map.Watch.
+func (mv2 *MapV2) Watch(pattern string) (<-chan Event, func()) {
+	c := mv2.slots[0].conf
+	w := newWatcher(pattern, c.watchPolicy, c.watchBuffer)
+	w.detach = func() {
+		for i, _ := range mv2.slots {
+			mv2.slots[i].hooks.removeWatcher(w)
+		}
+	}
+	for i, _ := range mv2.slots {
+		mv2.slots[i].hooks.addWatcher(w)
+	}
+	return w.ch, w.close
+}
@@ -0,0 +1,186 @@
+package cmap
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func recvEvent(t *testing.T, events <-chan Event) Event {
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatalf("events closed unexpectedly")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+	}
+	return Event{}
+}
+
+func TestWatch(t *testing.T) {
+	m := NewMap()
+	events, cancel := m.Watch("user:*")
+	defer cancel()
+
+	m.Set("user:1", "tom")
+	m.Set("order:1", "ignored")
+	m.Incr("user:count")
+	m.Delete("user:1")
+	m.SetEx("user:2", "jack", 1)
+
+	var want = []struct {
+		typ   EventType
+		key   string
+		value interface{}
+	}{
+		{EVENT_SET, "user:1", "tom"},
+		{EVENT_INCR, "user:count", 1},
+		{EVENT_DEL, "user:1", "tom"},
+		{EVENT_SET, "user:2", "jack"},
+	}
+
+	var offset int64
+	for _, w := range want {
+		e := recvEvent(t, events)
+		if e.Type != w.typ || e.Key != w.key || e.Value != w.value {
+			t.Fatalf("want %s %s %v but got %s %s %v", w.typ, w.key, w.value, e.Type, e.Key, e.Value)
+		}
+		if e.Offset <= offset {
+			t.Fatalf("offset should increase, %d after %d", e.Offset, offset)
+		}
+		offset = e.Offset
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	m.ClearExpireKeys()
+
+	e := recvEvent(t, events)
+	if e.Type != EVENT_EXPIRE || e.Key != "user:2" || e.Value != "jack" {
+		t.Fatalf("want expire user:2 but got %s %s %v", e.Type, e.Key, e.Value)
+	}
+}
+
+func TestWatchCancel(t *testing.T) {
+	m := NewMap()
+	events, cancel := m.Watch("")
+	cancel()
+	cancel()
+
+	m.Set("a", 1)
+	if _, ok := <-events; ok {
+		t.Fatalf("events should be closed after cancel")
+	}
+	if m.hooks.isWatching() {
+		t.Fatalf("watcher should be removed after cancel")
+	}
+}
+
+func TestWatchPolicy(t *testing.T) {
+	m := NewMap(WithWatchBuffer(2))
+	events, cancel := m.Watch("*")
+	defer cancel()
+
+	for i := 0; i < 10; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	if len(events) != 2 {
+		t.Fatalf("want 2 buffered events but got %d", len(events))
+	}
+
+	m2 := NewMap(WithWatchBuffer(2), WithWatchPolicy(WATCH_DISCONNECT))
+	events2, cancel2 := m2.Watch("*")
+	defer cancel2()
+
+	for i := 0; i < 10; i++ {
+		m2.Set(strconv.Itoa(i), i)
+	}
+	var n int
+	for range events2 {
+		n++
+	}
+	if n != 2 {
+		t.Fatalf("want 2 events before disconnected but got %d", n)
+	}
+	if m2.hooks.isWatching() {
+		t.Fatalf("disconnected watcher should be removed")
+	}
+
+	m3 := NewMap(WithWatchBuffer(0), WithWatchPolicy(WATCH_BLOCK))
+	events3, cancel3 := m3.Watch("*")
+	defer cancel3()
+
+	go func() {
+		for i := 0; i < 10; i++ {
+			m3.Set(strconv.Itoa(i), i)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		e := recvEvent(t, events3)
+		if e.Key != strconv.Itoa(i) {
+			t.Fatalf("want key %d but got %s", i, e.Key)
+		}
+	}
+}
+
+func TestMapV2Watch(t *testing.T) {
+	m := NewMapV2(nil, 8, 5*time.Minute)
+	defer m.Clear()
+
+	events, cancel := m.Watch("k[0-4]")
+
+	for i := 0; i < 10; i++ {
+		m.Set("k"+strconv.Itoa(i), i)
+	}
+
+	var got = make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		got[recvEvent(t, events).Key] = true
+	}
+	if len(got) != 5 {
+		t.Fatalf("want 5 keys but got %v", got)
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event %s %s", e.Type, e.Key)
+	default:
+	}
+
+	cancel()
+	for i, _ := range m.slots {
+		if m.slots[i].hooks.isWatching() {
+			t.Fatalf("watcher should be removed from all slots")
+		}
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	var cases = []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"", "any", true},
+		{"*", "any", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbc", false},
+	}
+	for _, c := range cases {
+		if globMatch(c.pattern, c.key) != c.match {
+			t.Fatalf("globMatch(%q, %q) should be %v", c.pattern, c.key, c.match)
+		}
+	}
+}
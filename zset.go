@@ -0,0 +1,681 @@
+package cmap
+
+import (
+	"fmt"
+	"github.com/fwhezfwhez/errorx"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Z is a member of a sorted set with its score.
+type Z struct {
+	Member string
+	Score  float64
+}
+
+// ZAddFlag changes how ZAdd works, flags can be combined by '|', like options of redis ZADD.
+type ZAddFlag int
+
+const (
+	ZADD_NX ZAddFlag = 1 << iota // only add new members, never update existing ones
+	ZADD_XX                      // only update existing members, never add new ones
+	ZADD_GT                      // only update existing members when new score is greater
+	ZADD_LT                      // only update existing members when new score is less
+	ZADD_CH                      // ZAdd returns number of members added and updated, rather than added only
+)
+
+func (flags ZAddFlag) validate() error {
+	if flags&ZADD_NX != 0 && flags&ZADD_XX != 0 {
+		return errorx.NewFromString("cmap: XX and NX options at the same time are not compatible")
+	}
+	if flags&ZADD_NX != 0 && flags&(ZADD_GT|ZADD_LT) != 0 || flags&ZADD_GT != 0 && flags&ZADD_LT != 0 {
+		return errorx.NewFromString("cmap: GT, LT, and/or NX options at the same time are not compatible")
+	}
+	return nil
+}
+
+// czset is the sorted set type made by ZAdd.
+// dict finds score of a member, and zsl orders members by score then member.
+type czset struct {
+	dict map[string]float64
+	zsl  *skiplist
+	l    *sync.RWMutex
+}
+
+func newczset() *czset {
+	return &czset{
+		dict: make(map[string]float64),
+		zsl:  newSkiplist(),
+		l:    &sync.RWMutex{},
+	}
+}
+
+func (z *czset) len() int {
+	z.l.RLock()
+	defer z.l.RUnlock()
+	return len(z.dict)
+}
+
+// size estimates bytes of members, 64 per member for the dict entry and skiplist node
+func (z *czset) size() int64 {
+	return 64 * int64(z.len())
+}
+
+// add sets score of member by flags, incr adds score to the current one.
+// It returns the score saved, whether member is added, whether it's updated, and false if flags abort it.
+func (z *czset) add(member string, score float64, flags ZAddFlag, incr bool) (float64, bool, bool, bool) {
+	z.l.Lock()
+	defer z.l.Unlock()
+
+	cur, exist := z.dict[member]
+	if !exist {
+		if flags&ZADD_XX != 0 {
+			return 0, false, false, false
+		}
+		z.dict[member] = score
+		z.zsl.insert(score, member)
+		return score, true, false, true
+	}
+
+	if flags&ZADD_NX != 0 {
+		return cur, false, false, false
+	}
+	if incr {
+		score += cur
+	}
+	if flags&ZADD_GT != 0 && score <= cur || flags&ZADD_LT != 0 && score >= cur {
+		return cur, false, false, false
+	}
+	if score == cur {
+		return cur, false, false, true
+	}
+	z.zsl.delete(cur, member)
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+	return score, false, true, true
+}
+
+func (z *czset) rem(member string) bool {
+	z.l.Lock()
+	defer z.l.Unlock()
+	score, exist := z.dict[member]
+	if !exist {
+		return false
+	}
+	delete(z.dict, member)
+	z.zsl.delete(score, member)
+	return true
+}
+
+func (z *czset) score(member string) (float64, bool) {
+	z.l.RLock()
+	defer z.l.RUnlock()
+	score, exist := z.dict[member]
+	return score, exist
+}
+
+// rank returns 0-based rank of member, counted from the highest score if rev is true
+func (z *czset) rank(member string, rev bool) (int, bool) {
+	z.l.RLock()
+	defer z.l.RUnlock()
+	score, exist := z.dict[member]
+	if !exist {
+		return 0, false
+	}
+	rank := z.zsl.rank(score, member)
+	if rev {
+		return z.zsl.length - rank, true
+	}
+	return rank - 1, true
+}
+
+// rangeByRank returns members of 0-based ranks start to stop, negative ranks count from the end
+func (z *czset) rangeByRank(start int, stop int, rev bool) []Z {
+	z.l.RLock()
+	defer z.l.RUnlock()
+
+	length := z.zsl.length
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return []Z{}
+	}
+
+	var rs = make([]Z, 0, stop-start+1)
+	var x *skiplistNode
+	if rev {
+		x = z.zsl.byRank(length - start)
+	} else {
+		x = z.zsl.byRank(start + 1)
+	}
+	for i := start; i <= stop; i++ {
+		rs = append(rs, Z{Member: x.member, Score: x.score})
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return rs
+}
+
+// walk visits nodes from first forward, or backward if rev is true, while in returns true.
+// offset nodes are skipped and at most count nodes are visited, count < 0 means no limit.
+func walk(first *skiplistNode, rev bool, offset int, count int, in func(x *skiplistNode) bool, f func(x *skiplistNode)) {
+	x := first
+	for ; x != nil && offset > 0 && in(x); offset-- {
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	for ; x != nil && count != 0 && in(x); count-- {
+		f(x)
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+}
+
+// scoreRange is a range of scores parsed from redis syntax like "(1", "5", "-inf", "+inf"
+type scoreRange struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func parseScoreBound(s string) (float64, bool, error) {
+	var ex bool
+	if strings.HasPrefix(s, "(") {
+		s, ex = s[1:], true
+	}
+	f, e := strconv.ParseFloat(s, 64)
+	if e != nil || math.IsNaN(f) {
+		return 0, false, errorx.NewFromString("cmap: min or max is not a float")
+	}
+	return f, ex, nil
+}
+
+func parseScoreRange(min string, max string) (scoreRange, error) {
+	var r scoreRange
+	var e error
+	if r.min, r.minex, e = parseScoreBound(min); e != nil {
+		return r, e
+	}
+	if r.max, r.maxex, e = parseScoreBound(max); e != nil {
+		return r, e
+	}
+	return r, nil
+}
+
+func (r scoreRange) gteMin(score float64) bool {
+	if r.minex {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+func (r scoreRange) lteMax(score float64) bool {
+	if r.maxex {
+		return score < r.max
+	}
+	return score <= r.max
+}
+
+// lexRange is a range of members parsed from redis syntax like "[a", "(b", "-", "+"
+type lexRange struct {
+	min, max     string
+	minex, maxex bool
+	// -1 for "-", 1 for "+", 0 for a member
+	mininf, maxinf int
+}
+
+func parseLexBound(s string) (string, bool, int, error) {
+	switch {
+	case s == "-":
+		return "", false, -1, nil
+	case s == "+":
+		return "", false, 1, nil
+	case strings.HasPrefix(s, "["):
+		return s[1:], false, 0, nil
+	case strings.HasPrefix(s, "("):
+		return s[1:], true, 0, nil
+	}
+	return "", false, 0, errorx.NewFromString("cmap: min or max not valid string range item")
+}
+
+func parseLexRange(min string, max string) (lexRange, error) {
+	var r lexRange
+	var e error
+	if r.min, r.minex, r.mininf, e = parseLexBound(min); e != nil {
+		return r, e
+	}
+	if r.max, r.maxex, r.maxinf, e = parseLexBound(max); e != nil {
+		return r, e
+	}
+	return r, nil
+}
+
+func (r lexRange) gteMin(member string) bool {
+	switch r.mininf {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+	if r.minex {
+		return member > r.min
+	}
+	return member >= r.min
+}
+
+func (r lexRange) lteMax(member string) bool {
+	switch r.maxinf {
+	case -1:
+		return false
+	case 1:
+		return true
+	}
+	if r.maxex {
+		return member < r.max
+	}
+	return member <= r.max
+}
+
+// rangeBy returns members in range of gteMin and lteMax in order, or reversed order
+func (z *czset) rangeBy(gteMin func(x *skiplistNode) bool, lteMax func(x *skiplistNode) bool, rev bool, offset int, count int) []Z {
+	z.l.RLock()
+	defer z.l.RUnlock()
+
+	var rs = make([]Z, 0, 10)
+	if rev {
+		last := z.zsl.lastBefore(lteMax)
+		walk(last, true, offset, count, gteMin, func(x *skiplistNode) {
+			rs = append(rs, Z{Member: x.member, Score: x.score})
+		})
+		return rs
+	}
+
+	first := z.zsl.firstAfter(func(x *skiplistNode) bool { return !gteMin(x) })
+	walk(first, false, offset, count, lteMax, func(x *skiplistNode) {
+		rs = append(rs, Z{Member: x.member, Score: x.score})
+	})
+	return rs
+}
+
+func (z *czset) rangeByScore(r scoreRange, rev bool, offset int, count int) []Z {
+	return z.rangeBy(func(x *skiplistNode) bool { return r.gteMin(x.score) }, func(x *skiplistNode) bool { return r.lteMax(x.score) }, rev, offset, count)
+}
+
+func (z *czset) rangeByLex(r lexRange, rev bool, offset int, count int) []Z {
+	return z.rangeBy(func(x *skiplistNode) bool { return r.gteMin(x.member) }, func(x *skiplistNode) bool { return r.lteMax(x.member) }, rev, offset, count)
+}
+
+// count returns number of members in score range by their ranks
+func (z *czset) count(r scoreRange) int {
+	z.l.RLock()
+	defer z.l.RUnlock()
+
+	first := z.zsl.firstAfter(func(x *skiplistNode) bool { return !r.gteMin(x.score) })
+	if first == nil || !r.lteMax(first.score) {
+		return 0
+	}
+	last := z.zsl.lastBefore(func(x *skiplistNode) bool { return r.lteMax(x.score) })
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+// pop removes count members of the lowest scores, or the highest if max is true
+func (z *czset) pop(count int, max bool) []Z {
+	z.l.Lock()
+	defer z.l.Unlock()
+
+	var rs = make([]Z, 0, 1)
+	for ; count > 0 && z.zsl.length > 0; count-- {
+		x := z.zsl.header.level[0].forward
+		if max {
+			x = z.zsl.tail
+		}
+		rs = append(rs, Z{Member: x.member, Score: x.score})
+		z.zsl.delete(x.score, x.member)
+		delete(z.dict, x.member)
+	}
+	return rs
+}
+
+// all copies members in order
+func (z *czset) all() []Z {
+	z.l.RLock()
+	defer z.l.RUnlock()
+
+	var rs = make([]Z, 0, z.zsl.length)
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		rs = append(rs, Z{Member: x.member, Score: x.score})
+	}
+	return rs
+}
+
+func (z *czset) String() string {
+	return fmt.Sprintf("czset len=%d", z.len())
+}
+
+// zsetOf returns sorted set of key, an error if key holds another type.
+func (m *Map) zsetOf(key string, command string) (*czset, bool, error) {
+	return compoundOf[*czset](m, key, command, TYPE_ZSET)
+}
+
+// ZAdd adds members to sorted set of key or updates their scores by flags, the sorted set is made if key doesn't exist.
+// It returns number of members added, or added and updated if flags has ZADD_CH.
+// Time limit of key is kept.
+func (m *Map) ZAdd(key string, flags ZAddFlag, members ...Z) (int, error) {
+	if e := flags.validate(); e != nil {
+		return 0, e
+	}
+	for _, z := range members {
+		if math.IsNaN(z.Score) {
+			return 0, errorx.NewFromString("cmap: score is not a number (NaN)")
+		}
+	}
+	if len(members) == 0 {
+		_, _, e := m.zsetOf(key, "ZAdd")
+		return 0, e
+	}
+
+	var n int
+	var changed []Z
+	e := updateCompound(m, key, "ZAdd", TYPE_ZSET, newczset, func(z *czset) bool {
+		for _, member := range members {
+			score, added, updated, _ := z.add(member.Member, member.Score, flags, false)
+			if added || (updated && flags&ZADD_CH != 0) {
+				n++
+			}
+			if added || updated {
+				changed = append(changed, Z{Member: member.Member, Score: score})
+			}
+		}
+		return len(changed) > 0
+	}, func() *opRecord {
+		// scores saved rather than flags, so it replays to the same scores
+		return zsRecord(aofOpZAdd, changed)
+	})
+	return n, e
+}
+
+// ZAddIncr works as ZAdd with INCR option, it adds delta to score of member and returns the new score.
+// It returns false if flags abort the operation.
+func (m *Map) ZAddIncr(key string, flags ZAddFlag, member string, delta float64) (float64, bool, error) {
+	if e := flags.validate(); e != nil {
+		return 0, false, e
+	}
+	if math.IsNaN(delta) {
+		return 0, false, errorx.NewFromString("cmap: score is not a number (NaN)")
+	}
+
+	var score float64
+	var ok bool
+	var err error
+	e := updateCompound(m, key, "ZAddIncr", TYPE_ZSET, newczset, func(z *czset) bool {
+		if cur, exist := z.score(member); exist && math.IsNaN(cur+delta) {
+			err = errorx.NewFromString("cmap: resulting score is not a number (NaN)")
+			return false
+		}
+		var added, updated bool
+		score, added, updated, ok = z.add(member, delta, flags, true)
+		return added || updated
+	}, func() *opRecord {
+		return zsRecord(aofOpZAdd, []Z{{Member: member, Score: score}})
+	})
+	if e != nil {
+		return 0, false, e
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return score, ok, nil
+}
+
+// ZIncrBy adds delta to score of member and returns the new score, a member not existing is added with delta.
+func (m *Map) ZIncrBy(key string, delta float64, member string) (float64, error) {
+	score, _, e := m.ZAddIncr(key, 0, member, delta)
+	return score, e
+}
+
+// ZRem removes members from sorted set of key and returns number of removed ones, key is deleted when it becomes empty.
+func (m *Map) ZRem(key string, members ...string) (int, error) {
+	var removed int
+	e := updateCompound(m, key, "ZRem", TYPE_ZSET, nil, func(z *czset) bool {
+		for _, member := range members {
+			if z.rem(member) {
+				removed++
+			}
+		}
+		return removed > 0
+	}, func() *opRecord {
+		return membersRecord(aofOpZRem, members)
+	})
+	return removed, e
+}
+
+// ZScore returns score of member.
+func (m *Map) ZScore(key string, member string) (float64, bool, error) {
+	z, exist, e := m.zsetOf(key, "ZScore")
+	if e != nil || !exist {
+		return 0, false, e
+	}
+	score, ok := z.score(member)
+	return score, ok, nil
+}
+
+// ZCard returns number of members of sorted set of key.
+func (m *Map) ZCard(key string) (int, error) {
+	z, exist, e := m.zsetOf(key, "ZCard")
+	if e != nil || !exist {
+		return 0, e
+	}
+	return z.len(), nil
+}
+
+// ZRank returns 0-based rank of member ordered by score from low to high.
+func (m *Map) ZRank(key string, member string) (int, bool, error) {
+	z, exist, e := m.zsetOf(key, "ZRank")
+	if e != nil || !exist {
+		return 0, false, e
+	}
+	rank, ok := z.rank(member, false)
+	return rank, ok, nil
+}
+
+// ZRevRank returns 0-based rank of member ordered by score from high to low.
+func (m *Map) ZRevRank(key string, member string) (int, bool, error) {
+	z, exist, e := m.zsetOf(key, "ZRevRank")
+	if e != nil || !exist {
+		return 0, false, e
+	}
+	rank, ok := z.rank(member, true)
+	return rank, ok, nil
+}
+
+// ZRange returns members of ranks start to stop ordered by score from low to high, both inclusive.
+// Negative ranks count from the end, -1 is the last member.
+func (m *Map) ZRange(key string, start int, stop int) ([]Z, error) {
+	z, exist, e := m.zsetOf(key, "ZRange")
+	if e != nil || !exist {
+		return []Z{}, e
+	}
+	return z.rangeByRank(start, stop, false), nil
+}
+
+// ZRevRange works as ZRange, members are ordered from high to low.
+func (m *Map) ZRevRange(key string, start int, stop int) ([]Z, error) {
+	z, exist, e := m.zsetOf(key, "ZRevRange")
+	if e != nil || !exist {
+		return []Z{}, e
+	}
+	return z.rangeByRank(start, stop, true), nil
+}
+
+// ZRangeByScore returns members with scores between min and max ordered from low to high.
+// min and max are in redis syntax, "(" prefix means exclusive, "-inf" and "+inf" are infinities.
+// offset and count work like LIMIT, a negative count returns all members after offset.
+func (m *Map) ZRangeByScore(key string, min string, max string, offset int, count int) ([]Z, error) {
+	r, e := parseScoreRange(min, max)
+	if e != nil {
+		return nil, e
+	}
+	z, exist, e := m.zsetOf(key, "ZRangeByScore")
+	if e != nil || !exist {
+		return []Z{}, e
+	}
+	return z.rangeByScore(r, false, offset, count), nil
+}
+
+// ZRevRangeByScore works as ZRangeByScore, members are ordered from high to low, and max comes before min like redis.
+func (m *Map) ZRevRangeByScore(key string, max string, min string, offset int, count int) ([]Z, error) {
+	r, e := parseScoreRange(min, max)
+	if e != nil {
+		return nil, e
+	}
+	z, exist, e := m.zsetOf(key, "ZRevRangeByScore")
+	if e != nil || !exist {
+		return []Z{}, e
+	}
+	return z.rangeByScore(r, true, offset, count), nil
+}
+
+// ZRangeByLex returns members between min and max ordered by member, all members should have the same score like redis requires.
+// min and max are in redis syntax, "[" prefix means inclusive, "(" exclusive, "-" and "+" are infinities.
+func (m *Map) ZRangeByLex(key string, min string, max string, offset int, count int) ([]string, error) {
+	r, e := parseLexRange(min, max)
+	if e != nil {
+		return nil, e
+	}
+	z, exist, e := m.zsetOf(key, "ZRangeByLex")
+	if e != nil || !exist {
+		return []string{}, e
+	}
+	return membersOf(z.rangeByLex(r, false, offset, count)), nil
+}
+
+// ZRevRangeByLex works as ZRangeByLex in reversed order, and max comes before min like redis.
+func (m *Map) ZRevRangeByLex(key string, max string, min string, offset int, count int) ([]string, error) {
+	r, e := parseLexRange(min, max)
+	if e != nil {
+		return nil, e
+	}
+	z, exist, e := m.zsetOf(key, "ZRevRangeByLex")
+	if e != nil || !exist {
+		return []string{}, e
+	}
+	return membersOf(z.rangeByLex(r, true, offset, count)), nil
+}
+
+func membersOf(zs []Z) []string {
+	var members = make([]string, len(zs))
+	for i, _ := range zs {
+		members[i] = zs[i].Member
+	}
+	return members
+}
+
+// ZCount returns number of members with scores between min and max, see ZRangeByScore.
+func (m *Map) ZCount(key string, min string, max string) (int, error) {
+	r, e := parseScoreRange(min, max)
+	if e != nil {
+		return 0, e
+	}
+	z, exist, e := m.zsetOf(key, "ZCount")
+	if e != nil || !exist {
+		return 0, e
+	}
+	return z.count(r), nil
+}
+
+// ZPopMin removes and returns count members of the lowest scores, key is deleted when it becomes empty.
+func (m *Map) ZPopMin(key string, count int) ([]Z, error) {
+	return m.zpop(key, "ZPopMin", count, false)
+}
+
+// ZPopMax removes and returns count members of the highest scores, key is deleted when it becomes empty.
+func (m *Map) ZPopMax(key string, count int) ([]Z, error) {
+	return m.zpop(key, "ZPopMax", count, true)
+}
+
+func (m *Map) zpop(key string, command string, count int, max bool) ([]Z, error) {
+	var rs = []Z{}
+	e := updateCompound(m, key, command, TYPE_ZSET, nil, func(z *czset) bool {
+		rs = z.pop(count, max)
+		return len(rs) > 0
+	}, func() *opRecord {
+		var members = make([]string, len(rs))
+		for i, _ := range rs {
+			members[i] = rs[i].Member
+		}
+		return membersRecord(aofOpZRem, members)
+	})
+	return rs, e
+}
+
+func (mv2 *MapV2) ZAdd(key string, flags ZAddFlag, members ...Z) (int, error) {
+	return mv2.getslot(key).ZAdd(key, flags, members...)
+}
+func (mv2 *MapV2) ZAddIncr(key string, flags ZAddFlag, member string, delta float64) (float64, bool, error) {
+	return mv2.getslot(key).ZAddIncr(key, flags, member, delta)
+}
+func (mv2 *MapV2) ZIncrBy(key string, delta float64, member string) (float64, error) {
+	return mv2.getslot(key).ZIncrBy(key, delta, member)
+}
+func (mv2 *MapV2) ZRem(key string, members ...string) (int, error) {
+	return mv2.getslot(key).ZRem(key, members...)
+}
+func (mv2 *MapV2) ZScore(key string, member string) (float64, bool, error) {
+	return mv2.getslot(key).ZScore(key, member)
+}
+func (mv2 *MapV2) ZCard(key string) (int, error) {
+	return mv2.getslot(key).ZCard(key)
+}
+func (mv2 *MapV2) ZRank(key string, member string) (int, bool, error) {
+	return mv2.getslot(key).ZRank(key, member)
+}
+func (mv2 *MapV2) ZRevRank(key string, member string) (int, bool, error) {
+	return mv2.getslot(key).ZRevRank(key, member)
+}
+func (mv2 *MapV2) ZRange(key string, start int, stop int) ([]Z, error) {
+	return mv2.getslot(key).ZRange(key, start, stop)
+}
+func (mv2 *MapV2) ZRevRange(key string, start int, stop int) ([]Z, error) {
+	return mv2.getslot(key).ZRevRange(key, start, stop)
+}
+func (mv2 *MapV2) ZRangeByScore(key string, min string, max string, offset int, count int) ([]Z, error) {
+	return mv2.getslot(key).ZRangeByScore(key, min, max, offset, count)
+}
+func (mv2 *MapV2) ZRevRangeByScore(key string, max string, min string, offset int, count int) ([]Z, error) {
+	return mv2.getslot(key).ZRevRangeByScore(key, max, min, offset, count)
+}
+func (mv2 *MapV2) ZRangeByLex(key string, min string, max string, offset int, count int) ([]string, error) {
+	return mv2.getslot(key).ZRangeByLex(key, min, max, offset, count)
+}
+func (mv2 *MapV2) ZRevRangeByLex(key string, max string, min string, offset int, count int) ([]string, error) {
+	return mv2.getslot(key).ZRevRangeByLex(key, max, min, offset, count)
+}
+func (mv2 *MapV2) ZCount(key string, min string, max string) (int, error) {
+	return mv2.getslot(key).ZCount(key, min, max)
+}
+func (mv2 *MapV2) ZPopMin(key string, count int) ([]Z, error) {
+	return mv2.getslot(key).ZPopMin(key, count)
+}
+func (mv2 *MapV2) ZPopMax(key string, count int) ([]Z, error) {
+	return mv2.getslot(key).ZPopMax(key, count)
+}
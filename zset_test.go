@@ -0,0 +1,312 @@
+package cmap
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func zsOf(pairs ...interface{}) []Z {
+	var zs []Z
+	for i := 0; i < len(pairs); i += 2 {
+		zs = append(zs, Z{Member: pairs[i].(string), Score: float64(pairs[i+1].(int))})
+	}
+	return zs
+}
+
+func sameZs(a []Z, b []Z) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, _ := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestZAdd(t *testing.T) {
+	m := NewMap()
+
+	if n, e := m.ZAdd("rank", 0, zsOf("a", 1, "b", 2, "c", 3)...); n != 3 || e != nil {
+		t.Fatalf("want 3 added but got %d %v", n, e)
+	}
+	if n, _ := m.ZAdd("rank", 0, zsOf("a", 5, "d", 4)...); n != 1 {
+		t.Fatalf("want 1 added but got %d", n)
+	}
+	if n, _ := m.ZAdd("rank", ZADD_CH, zsOf("a", 6, "b", 2, "e", 0)...); n != 2 {
+		t.Fatalf("want 2 changed but got %d", n)
+	}
+	if n, _ := m.ZAdd("rank", ZADD_NX, zsOf("a", 0, "f", 0)...); n != 1 {
+		t.Fatalf("NX should only add f, got %d", n)
+	}
+	if score, _, _ := m.ZScore("rank", "a"); score != 6 {
+		t.Fatalf("NX should not update a, got %v", score)
+	}
+	if n, _ := m.ZAdd("rank", ZADD_XX|ZADD_CH, zsOf("a", 7, "g", 0)...); n != 1 {
+		t.Fatalf("XX should only update a, got %d", n)
+	}
+	if _, ok, _ := m.ZScore("rank", "g"); ok {
+		t.Fatalf("XX should not add g")
+	}
+	m.ZAdd("rank", ZADD_GT, zsOf("a", 1, "b", 10)...)
+	m.ZAdd("rank", ZADD_LT, zsOf("c", 5, "d", 1)...)
+	if got, _ := m.ZRange("rank", 0, -1); !sameZs(got, zsOf("e", 0, "f", 0, "d", 1, "c", 3, "a", 7, "b", 10)) {
+		t.Fatalf("unexpected zset %v", got)
+	}
+
+	if _, e := m.ZAdd("rank", ZADD_NX|ZADD_XX, zsOf("a", 1)...); e == nil {
+		t.Fatalf("want error of NX and XX")
+	}
+	if _, e := m.ZAdd("rank", ZADD_GT|ZADD_LT, zsOf("a", 1)...); e == nil {
+		t.Fatalf("want error of GT and LT")
+	}
+	if _, e := m.ZAdd("rank", 0, Z{Member: "nan", Score: math.NaN()}); e == nil {
+		t.Fatalf("want error of NaN score")
+	}
+
+	if score, _ := m.ZIncrBy("rank", 2.5, "a"); score != 9.5 {
+		t.Fatalf("want 9.5 but got %v", score)
+	}
+	if score, _ := m.ZIncrBy("rank", 2, "new"); score != 2 {
+		t.Fatalf("want 2 but got %v", score)
+	}
+	if _, ok, _ := m.ZAddIncr("rank", ZADD_GT, "a", -1); ok {
+		t.Fatalf("GT should abort decreasing")
+	}
+	if _, ok, _ := m.ZAddIncr("rank", ZADD_XX, "missing", 1); ok {
+		t.Fatalf("XX should abort adding")
+	}
+	m.ZAdd("rank", 0, Z{Member: "inf", Score: math.Inf(1)})
+	if _, _, e := m.ZAddIncr("rank", 0, "inf", math.Inf(-1)); e == nil {
+		t.Fatalf("want error of NaN result")
+	}
+
+	m.Set("string", 1)
+	if _, e := m.ZAdd("string", 0, zsOf("a", 1)...); e == nil {
+		t.Fatalf("want wrong type error")
+	}
+	if _, _, e := m.ZScore("string", "a"); e == nil {
+		t.Fatalf("want wrong type error")
+	}
+	if m.Type("rank") != TYPE_ZSET {
+		t.Fatalf("want zset but got %s", m.Type("rank"))
+	}
+}
+
+func TestZRange(t *testing.T) {
+	m := NewMap()
+	m.ZAdd("rank", 0, zsOf("a", 1, "b", 2, "c", 3, "d", 4, "e", 5)...)
+
+	if n, _ := m.ZCard("rank"); n != 5 {
+		t.Fatalf("want 5 but got %d", n)
+	}
+	if rank, ok, _ := m.ZRank("rank", "b"); !ok || rank != 1 {
+		t.Fatalf("want rank 1 but got %d", rank)
+	}
+	if rank, ok, _ := m.ZRevRank("rank", "b"); !ok || rank != 3 {
+		t.Fatalf("want rev rank 3 but got %d", rank)
+	}
+	if _, ok, _ := m.ZRank("rank", "missing"); ok {
+		t.Fatalf("missing member has no rank")
+	}
+
+	rangeCases := []struct {
+		got  func() ([]Z, error)
+		want []Z
+	}{
+		{func() ([]Z, error) { return m.ZRange("rank", 1, 2) }, zsOf("b", 2, "c", 3)},
+		{func() ([]Z, error) { return m.ZRange("rank", -2, 100) }, zsOf("d", 4, "e", 5)},
+		{func() ([]Z, error) { return m.ZRange("rank", 3, 1) }, zsOf()},
+		{func() ([]Z, error) { return m.ZRevRange("rank", 0, 1) }, zsOf("e", 5, "d", 4)},
+		{func() ([]Z, error) { return m.ZRangeByScore("rank", "2", "4", 0, -1) }, zsOf("b", 2, "c", 3, "d", 4)},
+		{func() ([]Z, error) { return m.ZRangeByScore("rank", "(2", "(4", 0, -1) }, zsOf("c", 3)},
+		{func() ([]Z, error) { return m.ZRangeByScore("rank", "-inf", "+inf", 1, 2) }, zsOf("b", 2, "c", 3)},
+		{func() ([]Z, error) { return m.ZRangeByScore("rank", "6", "+inf", 0, -1) }, zsOf()},
+		{func() ([]Z, error) { return m.ZRevRangeByScore("rank", "+inf", "(3", 0, -1) }, zsOf("e", 5, "d", 4)},
+		{func() ([]Z, error) { return m.ZRevRangeByScore("rank", "4", "-inf", 1, 2) }, zsOf("c", 3, "b", 2)},
+		{func() ([]Z, error) { return m.ZRange("missing", 0, -1) }, zsOf()},
+	}
+	for i, c := range rangeCases {
+		got, e := c.got()
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !sameZs(got, c.want) {
+			t.Fatalf("case %d: want %v but got %v", i, c.want, got)
+		}
+	}
+
+	countCases := map[[2]string]int{
+		{"-inf", "+inf"}: 5,
+		{"2", "4"}:       3,
+		{"(2", "4"}:      2,
+		{"(2", "(3"}:     0,
+		{"10", "20"}:     0,
+		{"4", "2"}:       0,
+	}
+	for r, want := range countCases {
+		if n, _ := m.ZCount("rank", r[0], r[1]); n != want {
+			t.Fatalf("count of %v should be %d but got %d", r, want, n)
+		}
+	}
+	if _, e := m.ZCount("rank", "x", "1"); e == nil {
+		t.Fatalf("want error of invalid bound")
+	}
+
+	m.ZAdd("lex", 0, zsOf("a", 0, "b", 0, "c", 0, "d", 0)...)
+	lexCases := []struct {
+		got  func() ([]string, error)
+		want string
+	}{
+		{func() ([]string, error) { return m.ZRangeByLex("lex", "-", "+", 0, -1) }, "a,b,c,d"},
+		{func() ([]string, error) { return m.ZRangeByLex("lex", "[b", "(d", 0, -1) }, "b,c"},
+		{func() ([]string, error) { return m.ZRangeByLex("lex", "(a", "+", 1, 1) }, "c"},
+		{func() ([]string, error) { return m.ZRevRangeByLex("lex", "+", "[b", 0, -1) }, "d,c,b"},
+	}
+	for i, c := range lexCases {
+		got, e := c.got()
+		if e != nil {
+			t.Fatal(e)
+		}
+		if s := strings.Join(got, ","); s != c.want {
+			t.Fatalf("lex case %d: want %s but got %s", i, c.want, s)
+		}
+	}
+	if _, e := m.ZRangeByLex("lex", "a", "+", 0, -1); e == nil {
+		t.Fatalf("want error of invalid lex bound")
+	}
+}
+
+func TestZPopAndRem(t *testing.T) {
+	m := NewMap()
+	m.ZAdd("rank", 0, zsOf("a", 1, "b", 2, "c", 3, "d", 4)...)
+	m.Expire("rank", 100)
+
+	if got, _ := m.ZPopMin("rank", 1); !sameZs(got, zsOf("a", 1)) {
+		t.Fatalf("unexpected ZPopMin %v", got)
+	}
+	if got, _ := m.ZPopMax("rank", 2); !sameZs(got, zsOf("d", 4, "c", 3)) {
+		t.Fatalf("unexpected ZPopMax %v", got)
+	}
+	if ttl := m.TTL("rank"); ttl <= 0 || ttl > 100 {
+		t.Fatalf("ttl should be kept, got %d", ttl)
+	}
+	if n, _ := m.ZRem("rank", "b", "missing"); n != 1 {
+		t.Fatalf("want 1 removed but got %d", n)
+	}
+	if _, ok := m.Get("rank"); ok {
+		t.Fatalf("empty zset should be deleted")
+	}
+	if got, e := m.ZPopMin("rank", 1); len(got) != 0 || e != nil {
+		t.Fatalf("pop of missing key should return nothing")
+	}
+
+	m.ZAdd("expiring", 0, zsOf("a", 1)...)
+	m.PExpire("expiring", 1)
+	time.Sleep(2 * time.Millisecond)
+	if n, _ := m.ZCard("expiring"); n != 0 {
+		t.Fatalf("expired zset should be empty")
+	}
+}
+
+func TestZSetInBusyMode(t *testing.T) {
+	m := NewMap()
+
+	var stop = make(chan struct{})
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.ClearExpireKeys()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				m.ZIncrBy("board", 1, "player:"+strconv.Itoa(j%10))
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	<-done
+
+	all, _ := m.ZRange("board", 0, -1)
+	if len(all) != 10 {
+		t.Fatalf("want 10 players but got %d", len(all))
+	}
+	for _, z := range all {
+		if z.Score != 160 {
+			t.Fatalf("want 160 but got %v", z)
+		}
+	}
+}
+
+func TestZSetPersistence(t *testing.T) {
+	m := NewMap()
+	m.ZAdd("rank", 0, zsOf("a", 1, "b", 2)...)
+	m.ZAdd("rank", 0, Z{Member: "inf", Score: math.Inf(-1)})
+
+	var buf bytes.Buffer
+	if e := m.SaveSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+	m2 := NewMap()
+	if e := m2.LoadSnapshot(&buf); e != nil {
+		t.Fatal(e)
+	}
+	want, _ := m.ZRange("rank", 0, -1)
+	if got, _ := m2.ZRange("rank", 0, -1); !sameZs(got, want) {
+		t.Fatalf("want %v but got %v", want, got)
+	}
+
+	path := filepath.Join(t.TempDir(), "cmap.aof")
+	aof := openTestAOF(t, path, FSYNC_ALWAYS)
+	m3 := NewMap()
+	if e := m3.UseAOF(aof); e != nil {
+		t.Fatal(e)
+	}
+	m3.ZAdd("rank", 0, zsOf("a", 1, "b", 2)...)
+	m3.ZIncrBy("rank", 5, "a")
+	m3.ZPopMax("rank", 1)
+	aof.Close()
+
+	aof2 := openTestAOF(t, path, FSYNC_ALWAYS)
+	defer aof2.Close()
+	m4 := NewMap()
+	if e := m4.UseAOF(aof2); e != nil {
+		t.Fatal(e)
+	}
+	if got, _ := m4.ZRange("rank", 0, -1); !sameZs(got, zsOf("b", 2)) {
+		t.Fatalf("unexpected replayed zset %v", got)
+	}
+}
+
+func TestMapV2ZSet(t *testing.T) {
+	mv2 := NewMapV2(nil, 8, time.Minute)
+	defer mv2.Clear()
+
+	mv2.ZAdd("board", 0, zsOf("a", 3, "b", 1)...)
+	if got, _ := mv2.ZRevRange("board", 0, 0); !sameZs(got, zsOf("a", 3)) {
+		t.Fatalf("unexpected top %v", got)
+	}
+	if mv2.Type("board") != TYPE_ZSET {
+		t.Fatalf("want zset type")
+	}
+}